@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+)
+
+var (
+	createBranch = flag.String("create_branch",
+		"",
+		"if non-empty, name of the auto-update branch (text/template, see prData) to amend. If there is no open pull request from this branch, gokr-amend creates the branch and opens the pull request itself instead of amending the pull request of the CI environment")
+
+	createBase = flag.String("create_base",
+		"main",
+		"base branch of pull requests created with -create_branch")
+
+	createTitle = flag.String("create_title",
+		"auto-update {{.Date}}",
+		"title (text/template, see prData) of pull requests created with -create_branch, also used as commit message")
+
+	createBody = flag.String("create_body",
+		"",
+		"body (text/template, see prData) of pull requests created with -create_branch")
+
+	createLabels = flag.String("create_labels",
+		"",
+		"comma-separated list of labels to add to pull requests created with -create_branch")
+
+	createReviewers = flag.String("create_reviewers",
+		"",
+		"comma-separated list of GitHub users to request reviews from on pull requests created with -create_branch")
+
+	commitAuthor = flag.String("commit_author",
+		"gokrazy-bot <gokrazy-bot@users.noreply.github.com>",
+		"author (Name <email>) of commits created with -create_branch, and committer of amended commits. With -signing_key, the email address must belong to the account of the key")
+)
+
+// prData is available in the -create_branch, -create_title and -create_body
+// templates.
+type prData struct {
+	Date   string // YYYY-MM-DD
+	Base   string
+	Branch string // empty in the -create_branch template
+	Files  []string
+}
+
+func execTemplate(name, text string, data *prData) (string, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// commitIdentity returns the name and email address of -commit_author.
+func commitIdentity() (name, email string) {
+	name = *commitAuthor
+	if i := strings.Index(name, " <"); i > -1 {
+		name, email = name[:i], strings.TrimSuffix(name[i+len(" <"):], ">")
+	}
+	return name, email
+}
+
+// openPullRequest returns the open pull request from branch, or nil if there
+// is none.
+func openPullRequest(ctx context.Context, client *github.Client, owner, repo, branch string) (*github.PullRequest, error) {
+	prs, _, err := client.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{
+		State: "open",
+		Head:  owner + ":" + branch,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(prs) == 0 {
+		return nil, nil
+	}
+	return prs[0], nil
+}
+
+// createPullRequest commits files on top of the -create_base branch to a new
+// branch and opens a pull request from it.
+func createPullRequest(ctx context.Context, client *github.Client, owner, repo, branch string, files []string, data *prData) (*github.PullRequest, error) {
+	title, err := execTemplate("create_title", *createTitle, data)
+	if err != nil {
+		return nil, err
+	}
+	body, err := execTemplate("create_body", *createBody, data)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "gokr-amend")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	checkout := filepath.Join(dir, "checkout")
+
+	clone := exec.CommandContext(ctx,
+		"git",
+		"clone",
+		"--branch="+*createBase,
+		"--depth=1",
+		"https://github.com/"+owner+"/"+repo,
+		checkout)
+	clone.Env = gitEnv()
+	clone.Stdout = os.Stdout
+	clone.Stderr = os.Stderr
+	if err := clone.Run(); err != nil {
+		return nil, fmt.Errorf("%v: %v", clone.Args, err)
+	}
+
+	git := func(args ...string) error {
+		log.Printf("git %v", args)
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = checkout
+		cmd.Env = gitEnv()
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%v: %v", cmd.Args, err)
+		}
+		return nil
+	}
+	if err := git("checkout", "-b", branch); err != nil {
+		return nil, err
+	}
+	rsync := exec.CommandContext(ctx,
+		"rsync",
+		append(append([]string{
+			"--delete",
+			"-av",
+		}, files...),
+			checkout)...)
+	rsync.Stdout = os.Stdout
+	rsync.Stderr = os.Stderr
+	if err := rsync.Run(); err != nil {
+		return nil, fmt.Errorf("%v: %v", rsync.Args, err)
+	}
+	name, email := commitIdentity()
+	if err := git("config", "user.email", email); err != nil {
+		return nil, err
+	}
+	if err := git("config", "user.name", name); err != nil {
+		return nil, err
+	}
+	if err := configureSigning(ctx, git, dir); err != nil {
+		return nil, err
+	}
+	if err := git("add", "."); err != nil {
+		return nil, err
+	}
+	if err := git("commit", "-a", "-m", title); err != nil {
+		return nil, err
+	}
+	if err := git("push", "origin", branch); err != nil {
+		return nil, err
+	}
+
+	pr, _, err := client.PullRequests.Create(ctx, owner, repo, &github.NewPullRequest{
+		Title: github.String(title),
+		Head:  github.String(branch),
+		Base:  github.String(*createBase),
+		Body:  github.String(body),
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("created pull request %s", pr.GetHTMLURL())
+
+	if labels := splitList(*createLabels); len(labels) > 0 {
+		if _, _, err := client.Issues.AddLabelsToIssue(ctx, owner, repo, pr.GetNumber(), labels); err != nil {
+			return nil, err
+		}
+	}
+	if reviewers := splitList(*createReviewers); len(reviewers) > 0 {
+		if _, _, err := client.PullRequests.RequestReviewers(ctx, owner, repo, pr.GetNumber(), github.ReviewersRequest{
+			Reviewers: reviewers,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return pr, nil
+}
+
+// createOrFind returns the open pull request from the -create_branch branch,
+// creating it if there is none. created reports whether files were committed
+// to a new pull request already. If there is no pull request and files equal
+// the -create_base branch, it returns a nil pull request.
+func createOrFind(ctx context.Context, client *github.Client, owner, repo string, files []string) (_ *github.PullRequest, created bool, _ error) {
+	data := &prData{
+		Date:  time.Now().UTC().Format("2006-01-02"),
+		Base:  *createBase,
+		Files: files,
+	}
+	branch, err := execTemplate("create_branch", *createBranch, data)
+	if err != nil {
+		return nil, false, err
+	}
+	data.Branch = branch
+	pr, err := openPullRequest(ctx, client, owner, repo, branch)
+	if err != nil {
+		return nil, false, err
+	}
+	if pr != nil {
+		log.Printf("amending open pull request %d from %s", pr.GetNumber(), branch)
+		return pr, false, nil
+	}
+	unchanged, err := upToDate(ctx, client, owner, repo, *createBase, files)
+	if err != nil {
+		return nil, false, err
+	}
+	if unchanged {
+		log.Printf("all files equal %s, not opening a pull request", *createBase)
+		return nil, false, nil
+	}
+	pr, err = createPullRequest(ctx, client, owner, repo, branch, files, data)
+	if err != nil {
+		return nil, false, err
+	}
+	return pr, true, nil
+}
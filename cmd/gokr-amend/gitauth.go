@@ -0,0 +1,23 @@
+package main
+
+import (
+	"encoding/base64"
+	"os"
+)
+
+// basicAuth returns the HTTP Authorization header value for authToken, which
+// GitHub accepts for git and Git LFS requests.
+func basicAuth() string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte("x-access-token:"+authToken))
+}
+
+// gitEnv returns the environment for git commands accessing the GitHub
+// repository. The token is passed as an HTTP header via GIT_CONFIG_* (git 2.31
+// or newer) instead of in the remote URL, which git prints in error messages
+// and which ends up in the command arguments.
+func gitEnv() []string {
+	return append(os.Environ(),
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.https://github.com/.extraheader",
+		"GIT_CONFIG_VALUE_0=Authorization: "+basicAuth())
+}
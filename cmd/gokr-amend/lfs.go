@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v35/github"
+)
+
+var lfsThreshold = flag.Int64("lfs_threshold",
+	50<<20,
+	"with -git_data_api or -verified_commits, files of at least this many bytes are uploaded to Git LFS (and added to .gitattributes), like files matching an LFS pattern of .gitattributes. 0 disables the size threshold")
+
+const lfsMediaType = "application/vnd.git-lfs+json"
+
+// lfsPointer returns the Git LFS pointer file for content.
+func lfsPointer(oid string, size int) []byte {
+	return []byte(fmt.Sprintf("version https://git-lfs.github.com/spec/v1\noid sha256:%s\nsize %d\n", oid, size))
+}
+
+// lfsPatterns returns the patterns of .gitattributes which select the lfs
+// filter.
+func lfsPatterns(gitattributes string) []string {
+	var patterns []string
+	for _, line := range strings.Split(gitattributes, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		for _, attr := range fields[1:] {
+			if attr == "filter=lfs" {
+				patterns = append(patterns, fields[0])
+			}
+		}
+	}
+	return patterns
+}
+
+// matchAttr returns whether the repository path p matches the .gitattributes
+// pattern: patterns without a slash match in every directory.
+func matchAttr(pattern, p string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(p))
+		return ok
+	}
+	ok, _ := path.Match(strings.TrimPrefix(pattern, "/"), p)
+	return ok
+}
+
+type lfsObject struct {
+	OID     string `json:"oid"`
+	Size    int    `json:"size"`
+	Actions map[string]struct {
+		Href   string            `json:"href"`
+		Header map[string]string `json:"header"`
+	} `json:"actions,omitempty"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// lfsRequest sends an HTTP request with body and header to the Git LFS API
+// and decodes the JSON response into v, unless v is nil.
+func lfsRequest(ctx context.Context, method, url string, header map[string]string, body []byte, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, val := range header {
+		req.Header.Set(k, val)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: unexpected HTTP status code: got %d (%s), want 2xx", method, req.URL.Host, resp.StatusCode, string(b))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(b, v)
+}
+
+// lfsUpload uploads content to the Git LFS storage of owner/repo using the
+// batch API, unless it is stored already.
+func lfsUpload(ctx context.Context, owner, repo, oid string, content []byte) error {
+	auth := basicAuth()
+	batch, err := json.Marshal(map[string]interface{}{
+		"operation": "upload",
+		"transfers": []string{"basic"},
+		"objects":   []lfsObject{{OID: oid, Size: len(content)}},
+	})
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Objects []lfsObject `json:"objects"`
+	}
+	if err := lfsRequest(ctx, http.MethodPost, "https://github.com/"+owner+"/"+repo+".git/info/lfs/objects/batch", map[string]string{
+		"Accept":        lfsMediaType,
+		"Content-Type":  lfsMediaType,
+		"Authorization": auth,
+	}, batch, &resp); err != nil {
+		return err
+	}
+	if got, want := len(resp.Objects), 1; got != want {
+		return fmt.Errorf("LFS batch: unexpected number of objects: got %d, want %d", got, want)
+	}
+	obj := resp.Objects[0]
+	if obj.Error != nil {
+		return fmt.Errorf("LFS batch: %s (code %d)", obj.Error.Message, obj.Error.Code)
+	}
+	upload, ok := obj.Actions["upload"]
+	if !ok {
+		return nil // already stored
+	}
+	if err := lfsRequest(ctx, http.MethodPut, upload.Href, upload.Header, content, nil); err != nil {
+		return err
+	}
+	if verify, ok := obj.Actions["verify"]; ok {
+		header := map[string]string{
+			"Accept":       lfsMediaType,
+			"Content-Type": lfsMediaType,
+		}
+		for k, v := range verify.Header {
+			header[k] = v
+		}
+		b, err := json.Marshal(lfsObject{OID: oid, Size: len(content)})
+		if err != nil {
+			return err
+		}
+		if err := lfsRequest(ctx, http.MethodPost, verify.Href, header, b, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeInLFS replaces files which match an LFS pattern of the .gitattributes
+// of tree, or exceed -lfs_threshold, with LFS pointer files after uploading
+// their content. Paths newly stored in LFS are added to .gitattributes.
+func storeInLFS(ctx context.Context, client *github.Client, owner, repo string, tree *github.Tree, files map[string]localFile) error {
+	var gitattributes string
+	if f, ok := files[".gitattributes"]; ok {
+		gitattributes = string(f.content)
+	} else {
+		for _, e := range tree.Entries {
+			if e.GetPath() != ".gitattributes" {
+				continue
+			}
+			b, _, err := client.Git.GetBlobRaw(ctx, owner, repo, e.GetSHA())
+			if err != nil {
+				return err
+			}
+			gitattributes = string(b)
+		}
+	}
+	patterns := lfsPatterns(gitattributes)
+
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	var added []string
+	for _, p := range paths {
+		f := files[p]
+		if f.mode == "120000" || p == ".gitattributes" {
+			continue
+		}
+		matched := false
+		for _, pattern := range patterns {
+			if matchAttr(pattern, p) {
+				matched = true
+				break
+			}
+		}
+		if !matched && (*lfsThreshold == 0 || int64(len(f.content)) < *lfsThreshold) {
+			continue
+		}
+		sum := sha256.Sum256(f.content)
+		oid := hex.EncodeToString(sum[:])
+		log.Printf("storing %s (%d bytes) in Git LFS as %s", p, len(f.content), oid)
+		if err := lfsUpload(ctx, owner, repo, oid, f.content); err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		files[p] = localFile{mode: f.mode, content: lfsPointer(oid, len(f.content))}
+		if !matched {
+			added = append(added, p)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	if gitattributes != "" && !strings.HasSuffix(gitattributes, "\n") {
+		gitattributes += "\n"
+	}
+	for _, p := range added {
+		gitattributes += "/" + p + " filter=lfs diff=lfs merge=lfs -text\n"
+	}
+	files[".gitattributes"] = localFile{mode: "100644", content: []byte(gitattributes)}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gokrazy/autoupdate/internal/githubclient"
+	"github.com/google/go-github/v35/github"
+)
+
+var (
+	signingKey = flag.String("signing_key",
+		"",
+		"if non-empty, path to the private key (see -signing_format) with which to sign the commits gokr-amend creates using git. Alternatively, the key can be passed in the GOKR_SIGNING_KEY environment variable")
+
+	signingFormat = flag.String("signing_format",
+		"ssh",
+		"format of the -signing_key: ssh or openpgp")
+
+	verifiedCommits = flag.Bool("verified_commits",
+		false,
+		"create the commit via the GitHub GraphQL API (createCommitOnBranch), which GitHub signs and marks as verified. As the API cannot replace commits, the changes are added as a new commit on top of the pull request head instead of amending it, and file modes are not preserved")
+
+	verifiedMessage = flag.String("verified_message",
+		"update build results",
+		"commit message of -verified_commits commits")
+)
+
+// configureSigning configures the git repository (using git) to sign commits
+// with -signing_key or GOKR_SIGNING_KEY, if any. Key material is stored in
+// dir.
+func configureSigning(ctx context.Context, git func(args ...string) error, dir string) error {
+	key := *signingKey
+	if key == "" {
+		material := os.Getenv("GOKR_SIGNING_KEY")
+		if material == "" {
+			return nil // signing not configured
+		}
+		key = filepath.Join(dir, "signing_key")
+		if err := ioutil.WriteFile(key, []byte(strings.TrimSpace(material)+"\n"), 0600); err != nil {
+			return err
+		}
+	}
+
+	switch *signingFormat {
+	case "ssh":
+		if err := git("config", "gpg.format", "ssh"); err != nil {
+			return err
+		}
+		if err := git("config", "user.signingkey", key); err != nil {
+			return err
+		}
+
+	case "openpgp":
+		// Import the key into a keyring of our own, not the user’s.
+		gnupgHome := filepath.Join(dir, "gnupg")
+		if err := os.Mkdir(gnupgHome, 0700); err != nil {
+			return err
+		}
+		if err := os.Setenv("GNUPGHOME", gnupgHome); err != nil {
+			return err
+		}
+		if out, err := exec.CommandContext(ctx, "gpg", "--batch", "--import", key).CombinedOutput(); err != nil {
+			return fmt.Errorf("gpg --import: %v\n%s", err, out)
+		}
+		out, err := exec.CommandContext(ctx, "gpg", "--batch", "--with-colons", "--list-secret-keys").Output()
+		if err != nil {
+			return fmt.Errorf("gpg --list-secret-keys: %v", err)
+		}
+		var fingerprint string
+		for _, line := range strings.Split(string(out), "\n") {
+			if fields := strings.Split(line, ":"); fields[0] == "fpr" && len(fields) > 9 {
+				fingerprint = fields[9]
+				break
+			}
+		}
+		if fingerprint == "" {
+			return fmt.Errorf("no secret key found in %s", key)
+		}
+		if err := git("config", "gpg.format", "openpgp"); err != nil {
+			return err
+		}
+		if err := git("config", "user.signingkey", fingerprint); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("invalid -signing_format=%q, expected ssh or openpgp", *signingFormat)
+	}
+	return git("config", "commit.gpgsign", "true")
+}
+
+const createCommitMutation = `mutation($input: CreateCommitOnBranchInput!) {
+  createCommitOnBranch(input: $input) {
+    commit { oid }
+  }
+}`
+
+// commitVerified adds a commit with the files of args on top of branch via
+// the GraphQL API, so that GitHub signs it. It returns whether the files
+// differed from the head commit.
+func commitVerified(ctx context.Context, client *github.Client, owner, repo, branch string, args []string) (bool, error) {
+	files, dirs, err := collectFiles(args)
+	if err != nil {
+		return false, err
+	}
+	head, tree, err := branchTree(ctx, client, owner, repo, branch)
+	if err != nil {
+		return false, err
+	}
+	if err := storeInLFS(ctx, client, owner, repo, tree, files); err != nil {
+		return false, err
+	}
+	changed, deleted := diffTree(tree, files, dirs)
+	if len(changed)+len(deleted) == 0 {
+		return false, nil
+	}
+
+	additions := make([]map[string]string, 0, len(changed))
+	for _, p := range changed {
+		additions = append(additions, map[string]string{
+			"path":     p,
+			"contents": base64.StdEncoding.EncodeToString(files[p].content),
+		})
+	}
+	deletions := make([]map[string]string, 0, len(deleted))
+	for _, p := range deleted {
+		deletions = append(deletions, map[string]string{"path": p})
+	}
+	input := map[string]interface{}{
+		"branch": map[string]string{
+			"repositoryNameWithOwner": owner + "/" + repo,
+			"branchName":              branch,
+		},
+		"expectedHeadOid": head.GetSHA(),
+		"message":         map[string]string{"headline": *verifiedMessage},
+		"fileChanges": map[string]interface{}{
+			"additions": additions,
+			"deletions": deletions,
+		},
+	}
+	var data struct {
+		CreateCommitOnBranch struct {
+			Commit struct {
+				OID string `json:"oid"`
+			} `json:"commit"`
+		} `json:"createCommitOnBranch"`
+	}
+	if err := githubclient.GraphQL(ctx, client, createCommitMutation, map[string]interface{}{"input": input}, &data); err != nil {
+		return false, err
+	}
+	log.Printf("committed %s on %s", data.CreateCommitOnBranch.Commit.OID, branch)
+	return true, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v35/github"
+)
+
+var gitDataAPI = flag.Bool("git_data_api",
+	false,
+	"amend the pull request via the GitHub Git Data API instead of git clone and git push: only changed files are uploaded (as blobs), and all changes become one tree, replacing the pull request head commit. Like rsync --delete, files missing from a directory argument are deleted")
+
+// gitBlobSHA returns the git object ID of a blob with content b, so that
+// unchanged files need not be uploaded.
+func gitBlobSHA(b []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(b))
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// localFile is a file to be committed, by repository path.
+type localFile struct {
+	mode    string // git file mode
+	content []byte
+}
+
+// collectFiles returns the files which rsync would copy from args into the
+// repository root, and the directories (repository paths, "" for the root)
+// whose contents args replace.
+func collectFiles(args []string) (map[string]localFile, []string, error) {
+	files := make(map[string]localFile)
+	var dirs []string
+	add := func(src, dst string, info fs.FileInfo) error {
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(src)
+			if err != nil {
+				return err
+			}
+			files[dst] = localFile{mode: "120000", content: []byte(target)}
+		case info.Mode().IsRegular():
+			b, err := ioutil.ReadFile(src)
+			if err != nil {
+				return err
+			}
+			mode := "100644"
+			if info.Mode()&0111 != 0 {
+				mode = "100755"
+			}
+			files[dst] = localFile{mode: mode, content: b}
+		}
+		return nil
+	}
+	for _, arg := range args {
+		info, err := os.Lstat(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !info.IsDir() {
+			if err := add(arg, filepath.Base(arg), info); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		// Like rsync, a trailing slash copies the contents of the
+		// directory, not the directory itself.
+		prefix := ""
+		if !strings.HasSuffix(arg, "/") {
+			prefix = filepath.Base(arg)
+		}
+		dirs = append(dirs, prefix)
+		err = filepath.Walk(arg, func(src string, info fs.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(arg, src)
+			if err != nil {
+				return err
+			}
+			return add(src, path.Join(prefix, filepath.ToSlash(rel)), info)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return files, dirs, nil
+}
+
+// inDir returns whether the repository path p is located in dir.
+func inDir(p, dir string) bool {
+	return dir == "" || strings.HasPrefix(p, dir+"/")
+}
+
+// branchTree returns the head commit of branch and its recursive tree.
+func branchTree(ctx context.Context, client *github.Client, owner, repo, branch string) (*github.Commit, *github.Tree, error) {
+	ref, _, err := client.Git.GetRef(ctx, owner, repo, "heads/"+branch)
+	if err != nil {
+		return nil, nil, err
+	}
+	head, _, err := client.Git.GetCommit(ctx, owner, repo, ref.GetObject().GetSHA())
+	if err != nil {
+		return nil, nil, err
+	}
+	tree, _, err := client.Git.GetTree(ctx, owner, repo, head.GetTree().GetSHA(), true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tree.GetTruncated() {
+		return nil, nil, fmt.Errorf("tree %s of %s too large to list", tree.GetSHA(), branch)
+	}
+	return head, tree, nil
+}
+
+// diffTree returns which of files differ from tree (sorted by path), and
+// which blobs of tree within dirs are missing from files.
+func diffTree(tree *github.Tree, files map[string]localFile, dirs []string) (changed, deleted []string) {
+	existing := make(map[string]*github.TreeEntry)
+	for _, e := range tree.Entries {
+		existing[e.GetPath()] = e
+	}
+	for p, f := range files {
+		if e, ok := existing[p]; ok && e.GetSHA() == gitBlobSHA(f.content) && e.GetMode() == f.mode {
+			continue // unchanged
+		}
+		changed = append(changed, p)
+	}
+	sort.Strings(changed)
+	for _, e := range tree.Entries {
+		if e.GetType() != "blob" {
+			continue
+		}
+		if _, ok := files[e.GetPath()]; ok {
+			continue
+		}
+		for _, dir := range dirs {
+			if inDir(e.GetPath(), dir) {
+				deleted = append(deleted, e.GetPath())
+				break
+			}
+		}
+	}
+	return changed, deleted
+}
+
+// upToDate returns whether branch already contains the files of args, by
+// comparing content hashes, so that neither a commit nor a pull request is
+// needed.
+func upToDate(ctx context.Context, client *github.Client, owner, repo, branch string, args []string) (bool, error) {
+	files, dirs, err := collectFiles(args)
+	if err != nil {
+		return false, err
+	}
+	_, tree, err := branchTree(ctx, client, owner, repo, branch)
+	if err != nil {
+		return false, err
+	}
+	changed, deleted := diffTree(tree, files, dirs)
+	return len(changed)+len(deleted) == 0, nil
+}
+
+// amendViaAPI replaces the head commit of branch with a commit containing the
+// files of args, like updatePullRequest does using git. It returns whether the
+// files differed from the head commit.
+func amendViaAPI(ctx context.Context, client *github.Client, owner, repo, branch string, args []string) (bool, error) {
+	files, dirs, err := collectFiles(args)
+	if err != nil {
+		return false, err
+	}
+	head, tree, err := branchTree(ctx, client, owner, repo, branch)
+	if err != nil {
+		return false, err
+	}
+	if err := storeInLFS(ctx, client, owner, repo, tree, files); err != nil {
+		return false, err
+	}
+	changed, deleted := diffTree(tree, files, dirs)
+	if len(changed)+len(deleted) == 0 {
+		return false, nil
+	}
+
+	var entries []*github.TreeEntry
+	for _, p := range changed {
+		f := files[p]
+		blob, _, err := client.Git.CreateBlob(ctx, owner, repo, &github.Blob{
+			Content:  github.String(base64.StdEncoding.EncodeToString(f.content)),
+			Encoding: github.String("base64"),
+		})
+		if err != nil {
+			return false, err
+		}
+		log.Printf("uploaded %s (%d bytes)", p, len(f.content))
+		entries = append(entries, &github.TreeEntry{
+			Path: github.String(p),
+			Mode: github.String(f.mode),
+			Type: github.String("blob"),
+			SHA:  blob.SHA,
+		})
+	}
+	for _, p := range deleted {
+		log.Printf("deleting %s", p)
+		// An entry without SHA and content deletes the file.
+		entries = append(entries, &github.TreeEntry{
+			Path: github.String(p),
+			Mode: github.String("100644"),
+			Type: github.String("blob"),
+		})
+	}
+
+	newTree, _, err := client.Git.CreateTree(ctx, owner, repo, tree.GetSHA(), entries)
+	if err != nil {
+		return false, err
+	}
+	// Like git commit --amend --no-edit.
+	amended, _, err := client.Git.CreateCommit(ctx, owner, repo, &github.Commit{
+		Message: head.Message,
+		Author:  head.Author,
+		Tree:    newTree,
+		Parents: head.Parents,
+	})
+	if err != nil {
+		return false, err
+	}
+	if _, _, err := client.Git.UpdateRef(ctx, owner, repo, &github.Reference{
+		Ref:    github.String("refs/heads/" + branch),
+		Object: &github.GitObject{SHA: amended.SHA},
+	}, true); err != nil {
+		return false, err
+	}
+	log.Printf("amended %s: %s", branch, amended.GetSHA())
+	return true, nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/history"
+	"github.com/google/go-github/v35/github"
+)
+
+var (
+	listen = flag.String("listen",
+		"",
+		"if non-empty, [host]:port on which the status API is served: /api/status, /api/components, /api/boottests and /api/pullrequests (all JSON), and Prometheus metrics at /metrics. /api/leader fails with HTTP status 503 on a standby instance (see -lease)")
+
+	historyDB = flag.String("history_db",
+		"",
+		"if non-empty, path to the gokr-boot -history_db SQLite database from which /api/boottests lists the completed boot tests. Requires gokr-autoupdated to be built with -tags sqlite (requires cgo)")
+
+	bootQueueURL = flag.String("boot_queue_url",
+		"",
+		"if non-empty, URL of the /api/queue endpoint of gokr-boot serve (e.g. http://localhost:8037/api/queue), from which /api/boottests lists the queued and running boot tests")
+
+	pendingRepos = flag.String("pending_repos",
+		"",
+		"if non-empty, comma-separated list of owner/repo whose open pull requests carrying -pending_label /api/pullrequests lists. Also enables the GitHub API quota metrics")
+
+	pendingLabel = flag.String("pending_label",
+		"",
+		"label of the pull requests listed by /api/pullrequests, e.g. the -require_label of gokr-boot. Empty lists all open pull requests")
+)
+
+// completedTests is the maximum number of completed boot tests listed.
+const completedTests = 50
+
+// pullRequestCacheTTL is how long the pull requests listed by
+// /api/pullrequests are cached, to not exhaust the GitHub rate limit.
+const pullRequestCacheTTL = time.Minute
+
+// bootTest describes a queued or running boot test.
+type bootTest struct {
+	Repo      string `json:"repo"`
+	PR        int    `json:"pr"`
+	Hosts     string `json:"hosts,omitempty"`
+	Commenter string `json:"commenter,omitempty"`
+}
+
+// bootTests is the /api/boottests response.
+type bootTests struct {
+	Queued    []bootTest       `json:"queued"`
+	Running   *bootTest        `json:"running"`
+	Completed []history.Record `json:"completed"` // newest first
+	Errors    []string         `json:"errors,omitempty"`
+}
+
+// pullRequest is an entry of the /api/pullrequests response.
+type pullRequest struct {
+	Repo    string    `json:"repo"`
+	Number  int       `json:"number"`
+	Title   string    `json:"title"`
+	URL     string    `json:"url"`
+	Labels  []string  `json:"labels"`
+	Updated time.Time `json:"updated"`
+}
+
+// componentError is an entry of the errors of the /api/status response.
+type componentError struct {
+	Component string    `json:"component"`
+	Time      time.Time `json:"time"`
+	Error     string    `json:"error"`
+	Output    []string  `json:"output,omitempty"`
+}
+
+type api struct {
+	st     *state
+	client *github.Client // nil without -pending_repos
+	leader int32          // 1 if this instance runs the components, accessed atomically
+
+	mu        sync.Mutex
+	prs       []pullRequest
+	prsErr    error
+	prsCached time.Time
+}
+
+func (a *api) setLeader(leader bool) {
+	var v int32
+	if leader {
+		v = 1
+	}
+	atomic.StoreInt32(&a.leader, v)
+}
+
+func (a *api) isLeader() bool {
+	return atomic.LoadInt32(&a.leader) == 1
+}
+
+func (a *api) components() map[string]componentState {
+	a.st.mu.Lock()
+	defer a.st.mu.Unlock()
+	components := make(map[string]componentState, len(a.st.Components))
+	for name, cs := range a.st.Components {
+		components[name] = *cs
+	}
+	return components
+}
+
+// errors returns the last error of every component whose last run failed,
+// most recent first.
+func (a *api) errors() []componentError {
+	var errs []componentError
+	for name, cs := range a.components() {
+		if cs.LastError == "" {
+			continue
+		}
+		errs = append(errs, componentError{
+			Component: name,
+			Time:      cs.LastEnd,
+			Error:     cs.LastError,
+			Output:    cs.Output,
+		})
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Time.After(errs[j].Time) })
+	return errs
+}
+
+func (a *api) bootTests(ctx context.Context) bootTests {
+	var bt bootTests
+	if *bootQueueURL != "" {
+		if err := getJSON(ctx, *bootQueueURL, &bt); err != nil {
+			bt.Errors = append(bt.Errors, fmt.Sprintf("boot queue: %v", err))
+		}
+	}
+	if *historyDB != "" {
+		records, err := completed()
+		if err != nil {
+			bt.Errors = append(bt.Errors, fmt.Sprintf("history: %v", err))
+		}
+		bt.Completed = records
+	}
+	return bt
+}
+
+// completed returns the most recent completedTests records of -history_db,
+// newest first.
+func completed() ([]history.Record, error) {
+	db, err := history.OpenDB(*historyDB)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	records, err := db.Select(history.Query{Since: time.Now().Add(-7 * 24 * time.Hour)})
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > completedTests {
+		records = records[len(records)-completedTests:]
+	}
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	return records, nil
+}
+
+func getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected HTTP status code: got %d (%s), want %d", got, strings.TrimSpace(string(b)), want)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// pullRequests returns the open pull requests of -pending_repos carrying
+// -pending_label, cached for pullRequestCacheTTL.
+func (a *api) pullRequests(ctx context.Context) ([]pullRequest, error) {
+	if a.client == nil {
+		return nil, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if time.Since(a.prsCached) < pullRequestCacheTTL {
+		return a.prs, a.prsErr
+	}
+	query := "is:pr is:open"
+	for _, slug := range strings.Split(*pendingRepos, ",") {
+		query += " repo:" + strings.TrimSpace(slug)
+	}
+	if *pendingLabel != "" {
+		query += fmt.Sprintf(" label:%q", *pendingLabel)
+	}
+	a.prs, a.prsErr = nil, nil
+	opts := &github.SearchOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		result, resp, err := a.client.Search.Issues(ctx, query, opts)
+		if err != nil {
+			a.prsErr = err
+			break
+		}
+		for _, issue := range result.Issues {
+			pr := pullRequest{
+				Repo:    strings.TrimPrefix(issue.GetRepositoryURL(), "https://api.github.com/repos/"),
+				Number:  issue.GetNumber(),
+				Title:   issue.GetTitle(),
+				URL:     issue.GetHTMLURL(),
+				Labels:  []string{},
+				Updated: issue.GetUpdatedAt(),
+			}
+			for _, l := range issue.Labels {
+				pr.Labels = append(pr.Labels, l.GetName())
+			}
+			a.prs = append(a.prs, pr)
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	a.prsCached = time.Now()
+	return a.prs, a.prsErr
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("encoding response: %v", err)
+	}
+}
+
+func (a *api) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", a.metricsHandler())
+	mux.HandleFunc("/api/leader", func(w http.ResponseWriter, r *http.Request) {
+		// Load balancers can route webhook deliveries to the leader by
+		// checking this endpoint.
+		if !a.isLeader() {
+			http.Error(w, "standby", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, true)
+	})
+	mux.HandleFunc("/api/components", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, a.components())
+	})
+	mux.HandleFunc("/api/boottests", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, a.bootTests(r.Context()))
+	})
+	mux.HandleFunc("/api/pullrequests", func(w http.ResponseWriter, r *http.Request) {
+		prs, err := a.pullRequests(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, prs)
+	})
+	mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
+		status := struct {
+			Components   map[string]componentState `json:"components"`
+			BootTests    bootTests                 `json:"boot_tests"`
+			PullRequests []pullRequest             `json:"pull_requests"`
+			PullErr      string                    `json:"pull_requests_error,omitempty"`
+			Errors       []componentError          `json:"errors"`
+		}{
+			Components: a.components(),
+			BootTests:  a.bootTests(r.Context()),
+			Errors:     a.errors(),
+		}
+		prs, err := a.pullRequests(r.Context())
+		if err != nil {
+			status.PullErr = err.Error()
+		}
+		status.PullRequests = prs
+		writeJSON(w, status)
+	})
+	return mux
+}
+
+// serveAPI serves the status API on -listen until ctx is cancelled.
+func serveAPI(ctx context.Context, a *api) {
+	srv := &http.Server{
+		Addr:    *listen,
+		Handler: a.handler(),
+	}
+	go func() {
+		<-ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+	log.Printf("serving the status API on %s", *listen)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
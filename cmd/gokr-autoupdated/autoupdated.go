@@ -0,0 +1,174 @@
+// gokr-autoupdated is a long-running service combining the autoupdate
+// pipeline: it runs the upstream watchers (gokr-watch), webhook-driven boot
+// testing (gokr-boot serve), auto-merging (gokr-merge) and fleet publishing
+// (gokr-boot publish, gokr-fleet-update) as configured in one file, restarting
+// long-running components when they exit and running periodic components on
+// their schedule. The schedule and the outcome of each component survive
+// restarts in the -state file, and can be observed via the status API
+// (-listen). Two instances sharing a -lease run the components on one of them
+// at a time, taking over if the other one fails.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/cienv"
+	"github.com/gokrazy/autoupdate/internal/githubclient"
+	"github.com/gokrazy/autoupdate/internal/redact"
+	"github.com/gokrazy/autoupdate/internal/sqlite"
+)
+
+var (
+	configPath = flag.String("config",
+		"",
+		"path to the JSON configuration listing the components to run, see loadConfig")
+
+	statePath = flag.String("state",
+		"",
+		"if non-empty, file in which the state of all components (last run, last error) is persisted, so that periodic components keep their schedule across restarts")
+)
+
+// component is a pipeline step the daemon runs.
+type component struct {
+	// Name identifies the component in logs and in the state.
+	Name string `json:"name"`
+
+	// Command is the program to run and its arguments, e.g.
+	// ["gokr-boot", "serve", "-require_label=please-boot"].
+	Command []string `json:"command"`
+
+	// Env lists additional KEY=value environment variables for Command,
+	// which inherits the environment of the daemon (e.g. GITHUB_TOKEN).
+	Env []string `json:"env,omitempty"`
+
+	// Interval, if non-empty, makes the component periodic: Command is
+	// started this long after its previous start. Otherwise, the component
+	// is long-running and restarted RestartDelay after it exits.
+	Interval string `json:"interval,omitempty"`
+
+	// RestartDelay is how long to wait before restarting a long-running
+	// component. Defaults to 1m.
+	RestartDelay string `json:"restart_delay,omitempty"`
+
+	interval     time.Duration
+	restartDelay time.Duration
+}
+
+// config is the configuration of the daemon.
+type config struct {
+	Components []*component `json:"components"`
+}
+
+// loadConfig reads the configuration from the JSON file at path, e.g.:
+//
+//	{"components": [
+//	  {"name": "watch", "command": ["gokr-watch", "-config=/etc/autoupdate/watches.json"]},
+//	  {"name": "boot", "command": ["gokr-boot", "serve", "-require_label=please-boot", "-set_label=boot-tested"]},
+//	  {"name": "publish", "command": ["gokr-fleet-update", "-inventory=/etc/autoupdate/fleet.json", "-image_dir=/var/lib/autoupdate/images"], "interval": "24h"}
+//	]}
+func loadConfig(path string) (*config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	seen := make(map[string]bool)
+	for _, c := range cfg.Components {
+		if c.Name == "" {
+			return nil, fmt.Errorf("%s: component without name", path)
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("%s: duplicate component %s", path, c.Name)
+		}
+		seen[c.Name] = true
+		if len(c.Command) == 0 {
+			return nil, fmt.Errorf("%s: component %s: command is empty", path, c.Name)
+		}
+		if c.Interval != "" {
+			if c.interval, err = time.ParseDuration(c.Interval); err != nil {
+				return nil, fmt.Errorf("%s: component %s: %v", path, c.Name, err)
+			}
+		}
+		c.restartDelay = time.Minute
+		if c.RestartDelay != "" {
+			if c.restartDelay, err = time.ParseDuration(c.RestartDelay); err != nil {
+				return nil, fmt.Errorf("%s: component %s: %v", path, c.Name, err)
+			}
+		}
+	}
+	if len(cfg.Components) == 0 {
+		return nil, fmt.Errorf("%s: no components configured", path)
+	}
+	return &cfg, nil
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	log.SetOutput(redact.NewWriter(os.Stderr))
+
+	if *configPath == "" {
+		log.Fatal("-config is a required flag")
+	}
+	if *historyDB != "" {
+		if err := sqlite.Available(); err != nil {
+			log.Fatalf("-history_db: %v", err)
+		}
+	}
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	st, err := loadState(*statePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Cancel the context on SIGINT/SIGTERM, which is passed on to the
+	// components.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	a := &api{st: st}
+	if *listen != "" {
+		if *pendingRepos != "" {
+			a.client = githubclient.New(cienv.MustGetAuthToken())
+		}
+		go serveAPI(ctx, a)
+	}
+
+	if *leasePath == "" {
+		a.setLeader(true)
+		runComponents(ctx, st, cfg)
+		return
+	}
+	if err := lead(ctx, a, st, cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runComponents supervises the components of cfg until ctx is cancelled.
+func runComponents(ctx context.Context, st *state, cfg *config) {
+	var wg sync.WaitGroup
+	for _, c := range cfg.Components {
+		wg.Add(1)
+		go func(c *component) {
+			defer wg.Done()
+			supervise(ctx, st, c)
+		}(c)
+	}
+	wg.Wait()
+}
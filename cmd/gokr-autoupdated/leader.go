@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/lease"
+)
+
+var (
+	leasePath = flag.String("lease",
+		"",
+		"if non-empty, path to a lease file on storage shared with another gokr-autoupdated instance (e.g. NFS): only the instance holding the lease runs the components, so that boot tests are not run twice, and the other instance takes over once the lease expires. Share -state and the gokr-boot -queue_db between the instances, too")
+
+	leaseTTL = flag.Duration("lease_ttl",
+		time.Minute,
+		"how long the lease (-lease) is valid without renewal. The leader renews it every third of -lease_ttl")
+
+	leaseHolder = flag.String("lease_holder",
+		"",
+		"identifies this instance in the -lease file (default: hostname and process ID)")
+)
+
+// lead runs the components of cfg whenever this instance holds the -lease,
+// until ctx is cancelled.
+func lead(ctx context.Context, a *api, st *state, cfg *config) error {
+	holder := *leaseHolder
+	if holder == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		holder = fmt.Sprintf("%s/%d", hostname, os.Getpid())
+	}
+	l := &lease.Lease{
+		Path:   *leasePath,
+		Holder: holder,
+		TTL:    *leaseTTL,
+	}
+	renew := *leaseTTL / 3
+	defer func() {
+		if err := l.Release(); err != nil {
+			log.Printf("releasing lease: %v", err)
+		}
+	}()
+	for {
+		log.Printf("waiting for lease %s as %s", *leasePath, holder)
+		for {
+			ok, err := l.TryAcquire()
+			if err != nil {
+				log.Printf("acquiring lease: %v", err)
+			}
+			if ok {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(renew):
+			}
+		}
+		log.Printf("acquired lease, running components")
+		if err := st.reload(); err != nil {
+			return err
+		}
+		a.setLeader(true)
+		leaderCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			runComponents(leaderCtx, st, cfg)
+		}()
+
+		renewed := time.Now()
+		ticker := time.NewTicker(renew)
+	Renew:
+		for {
+			select {
+			case <-ctx.Done():
+				break Renew
+			case <-ticker.C:
+			}
+			ok, err := l.TryAcquire()
+			if err != nil {
+				log.Printf("renewing lease: %v", err)
+				// Stop before the lease expires and another
+				// instance takes over.
+				if time.Since(renewed) < *leaseTTL-renew {
+					continue
+				}
+			}
+			if !ok {
+				log.Printf("lost lease, stopping components")
+				break Renew
+			}
+			renewed = time.Now()
+		}
+		ticker.Stop()
+		a.setLeader(false)
+		cancel()
+		<-done
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/history"
+)
+
+// utilizationWindow is the time window over which the bakery utilization is
+// computed.
+const utilizationWindow = time.Hour
+
+// metricsWriter writes metrics in the Prometheus text exposition format.
+type metricsWriter struct {
+	bytes.Buffer
+}
+
+// metric writes the HELP and TYPE lines of a metric.
+func (w *metricsWriter) metric(name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes one sample of a metric. labels alternates label names and
+// values.
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		var pairs []string
+		for i := 0; i+1 < len(labels); i += 2 {
+			// %q escapes backslashes, quotes and newlines like the
+			// exposition format requires.
+			pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+		}
+		w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	fmt.Fprintf(w, " %g\n", value)
+}
+
+func timestamp(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+func (a *api) writeComponentMetrics(w *metricsWriter) {
+	components := a.components()
+	var names []string
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.metric("gokr_autoupdate_component_runs_total", "counter", "Finished runs of each component, by outcome.")
+	for _, name := range names {
+		cs := components[name]
+		finished := cs.Runs
+		if cs.Running {
+			finished--
+		}
+		w.sample("gokr_autoupdate_component_runs_total", float64(finished-cs.Failures), "component", name, "outcome", "success")
+		w.sample("gokr_autoupdate_component_runs_total", float64(cs.Failures), "component", name, "outcome", "failure")
+	}
+	w.metric("gokr_autoupdate_component_running", "gauge", "Whether the component is currently running.")
+	for _, name := range names {
+		running := 0.0
+		if components[name].Running {
+			running = 1
+		}
+		w.sample("gokr_autoupdate_component_running", running, "component", name)
+	}
+	w.metric("gokr_autoupdate_component_last_start_timestamp_seconds", "gauge", "When the component was last started.")
+	for _, name := range names {
+		w.sample("gokr_autoupdate_component_last_start_timestamp_seconds", timestamp(components[name].LastStart), "component", name)
+	}
+	w.metric("gokr_autoupdate_component_last_success_timestamp_seconds", "gauge", "When a run of the component last finished successfully. Alert on its age to detect watchers lagging behind.")
+	for _, name := range names {
+		w.sample("gokr_autoupdate_component_last_success_timestamp_seconds", timestamp(components[name].LastSuccess), "component", name)
+	}
+}
+
+func (a *api) writeBootMetrics(w *metricsWriter, r *http.Request) error {
+	if *bootQueueURL != "" {
+		var bt bootTests
+		if err := getJSON(r.Context(), *bootQueueURL, &bt); err != nil {
+			return fmt.Errorf("boot queue: %v", err)
+		}
+		running := 0.0
+		if bt.Running != nil {
+			running = 1
+		}
+		w.metric("gokr_autoupdate_boot_queue_depth", "gauge", "Boot tests waiting in the gokr-boot serve queue.")
+		w.sample("gokr_autoupdate_boot_queue_depth", float64(len(bt.Queued)))
+		w.metric("gokr_autoupdate_boot_tests_running", "gauge", "Boot tests in progress.")
+		w.sample("gokr_autoupdate_boot_tests_running", running)
+	}
+	if *historyDB != "" {
+		db, err := history.OpenDB(*historyDB)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		total, err := db.Stats(time.Time{})
+		if err != nil {
+			return err
+		}
+		recent, err := db.Stats(time.Now().Add(-utilizationWindow))
+		if err != nil {
+			return err
+		}
+		w.metric("gokr_autoupdate_boot_tests_total", "counter", "Completed boot tests, by bakery and result.")
+		for _, s := range total {
+			w.sample("gokr_autoupdate_boot_tests_total", float64(s.Count), "host", s.Host, "result", s.Result)
+		}
+		busy := make(map[string]float64)
+		for _, s := range total {
+			busy[s.Host] = 0 // report idle bakeries, too
+		}
+		for _, s := range recent {
+			busy[s.Host] += s.Seconds
+		}
+		var hosts []string
+		for host := range busy {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		w.metric("gokr_autoupdate_bakery_utilization_ratio", "gauge", fmt.Sprintf("Fraction of the last %v each bakery spent boot testing.", utilizationWindow))
+		for _, host := range hosts {
+			w.sample("gokr_autoupdate_bakery_utilization_ratio", busy[host]/utilizationWindow.Seconds(), "host", host)
+		}
+	}
+	return nil
+}
+
+func (a *api) writeGitHubMetrics(w *metricsWriter, r *http.Request) error {
+	if a.client == nil {
+		return nil
+	}
+	limits, _, err := a.client.RateLimits(r.Context())
+	if err != nil {
+		return fmt.Errorf("GitHub rate limits: %v", err)
+	}
+	w.metric("gokr_autoupdate_github_quota_remaining", "gauge", "Remaining GitHub API requests in the current rate limit window.")
+	w.sample("gokr_autoupdate_github_quota_remaining", float64(limits.GetCore().Remaining), "resource", "core")
+	w.sample("gokr_autoupdate_github_quota_remaining", float64(limits.GetSearch().Remaining), "resource", "search")
+	w.metric("gokr_autoupdate_github_quota_limit", "gauge", "GitHub API requests allowed per rate limit window.")
+	w.sample("gokr_autoupdate_github_quota_limit", float64(limits.GetCore().Limit), "resource", "core")
+	w.sample("gokr_autoupdate_github_quota_limit", float64(limits.GetSearch().Limit), "resource", "search")
+	return nil
+}
+
+// metricsHandler serves the metrics in the Prometheus text exposition format.
+// Sources which cannot be queried are left out (and counted in
+// gokr_autoupdate_scrape_errors), so that the remaining metrics are still
+// available for alerting.
+func (a *api) metricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var mw metricsWriter
+		a.writeComponentMetrics(&mw)
+		errors := 0
+		for _, fn := range []func(*metricsWriter, *http.Request) error{
+			a.writeBootMetrics,
+			a.writeGitHubMetrics,
+		} {
+			if err := fn(&mw, r); err != nil {
+				log.Printf("metrics: %v", err)
+				errors++
+			}
+		}
+		mw.metric("gokr_autoupdate_scrape_errors", "gauge", "Metric sources which could not be queried in this scrape.")
+		mw.sample("gokr_autoupdate_scrape_errors", float64(errors))
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(mw.Bytes())
+	})
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/redact"
+	"github.com/google/renameio/v2"
+)
+
+// outputLines is how many of the last output lines of a run are kept in the
+// state, to explain failures.
+const outputLines = 20
+
+// componentState is the persisted state of a component.
+type componentState struct {
+	Running     bool      `json:"running"`
+	Runs        int       `json:"runs"`
+	Failures    int       `json:"failures"`
+	LastStart   time.Time `json:"last_start,omitempty"`
+	LastEnd     time.Time `json:"last_end,omitempty"`
+	LastSuccess time.Time `json:"last_success,omitempty"` // end of the last successful run
+	LastError   string    `json:"last_error,omitempty"`
+	Output      []string  `json:"output,omitempty"` // last lines of the last run
+}
+
+// state holds the state of all components, persisted to path (if non-empty)
+// whenever it changes.
+type state struct {
+	path string
+
+	mu         sync.Mutex
+	Components map[string]*componentState `json:"components"`
+}
+
+func loadState(path string) (*state, error) {
+	st := &state{
+		path:       path,
+		Components: make(map[string]*componentState),
+	}
+	if err := st.reload(); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// reload replaces the state with the content of the state file, e.g. when
+// taking over from another daemon instance (see -lease).
+func (st *state) reload() error {
+	if st.path == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(st.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var loaded struct {
+		Components map[string]*componentState `json:"components"`
+	}
+	if err := json.Unmarshal(b, &loaded); err != nil {
+		return fmt.Errorf("%s: %v", st.path, err)
+	}
+	if loaded.Components == nil {
+		loaded.Components = make(map[string]*componentState)
+	}
+	for _, cs := range loaded.Components {
+		cs.Running = false // the process which ran it is gone
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.Components = loaded.Components
+	return nil
+}
+
+// update calls fn with the state of the named component and persists the
+// result.
+func (st *state) update(name string, fn func(cs *componentState)) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	cs, ok := st.Components[name]
+	if !ok {
+		cs = &componentState{}
+		st.Components[name] = cs
+	}
+	fn(cs)
+	if st.path == "" {
+		return
+	}
+	b, err := json.MarshalIndent(st, "", "\t")
+	if err != nil {
+		log.Printf("persisting state: %v", err)
+		return
+	}
+	if err := renameio.WriteFile(st.path, b, 0644); err != nil {
+		log.Printf("persisting state: %v", err)
+	}
+}
+
+// get returns a copy of the state of the named component.
+func (st *state) get(name string) componentState {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if cs, ok := st.Components[name]; ok {
+		return *cs
+	}
+	return componentState{}
+}
+
+// tailWriter writes lines to the log, prefixed with the component name, and
+// keeps the last outputLines lines.
+type tailWriter struct {
+	name string
+
+	mu      sync.Mutex
+	partial string
+	lines   []string
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial += string(p)
+	for {
+		idx := strings.IndexByte(w.partial, '\n')
+		if idx == -1 {
+			break
+		}
+		line := w.partial[:idx]
+		w.partial = w.partial[idx+1:]
+		log.Printf("[%s] %s", w.name, line)
+		w.lines = append(w.lines, redact.String(line))
+		if len(w.lines) > outputLines {
+			w.lines = w.lines[len(w.lines)-outputLines:]
+		}
+	}
+	return len(p), nil
+}
+
+// run runs the command of c until it exits, or until ctx is cancelled, in
+// which case it is sent SIGTERM.
+func run(ctx context.Context, st *state, c *component) {
+	out := &tailWriter{name: c.Name}
+	cmd := exec.Command(c.Command[0], c.Command[1:]...)
+	cmd.Env = append(os.Environ(), c.Env...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	st.update(c.Name, func(cs *componentState) {
+		cs.Running = true
+		cs.Runs++
+		cs.LastStart = time.Now()
+	})
+	log.Printf("%s: starting %q", c.Name, c.Command)
+	err := cmd.Start()
+	if err == nil {
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				cmd.Process.Signal(syscall.SIGTERM)
+			case <-done:
+			}
+		}()
+		err = cmd.Wait()
+		close(done)
+	}
+	if err != nil {
+		log.Printf("%s: %v", c.Name, err)
+	}
+	st.update(c.Name, func(cs *componentState) {
+		cs.Running = false
+		cs.LastEnd = time.Now()
+		cs.Output = out.lines
+		if err != nil {
+			cs.Failures++
+			cs.LastError = redact.String(err.Error())
+		} else {
+			cs.LastSuccess = cs.LastEnd
+			cs.LastError = ""
+		}
+	})
+}
+
+// supervise runs c until ctx is cancelled: periodic components every
+// interval (measured from the previous start, also across restarts of the
+// daemon), long-running components right away and again restartDelay after
+// they exit.
+func supervise(ctx context.Context, st *state, c *component) {
+	for first := true; ctx.Err() == nil; first = false {
+		var wait time.Duration
+		if c.interval > 0 {
+			if last := st.get(c.Name).LastStart; !last.IsZero() {
+				wait = time.Until(last.Add(c.interval))
+			}
+		} else if !first {
+			wait = c.restartDelay
+		}
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+		run(ctx, st, c)
+	}
+}
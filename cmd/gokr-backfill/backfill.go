@@ -0,0 +1,204 @@
+// gokr-backfill imports the results of past boot tests from the comments which
+// gokr-boot left on pull requests into the gokr-boot -history_db, so that
+// regression baselines and flake statistics do not start out empty. It also
+// migrates the history files which earlier versions of gokr-boot wrote.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gokrazy/autoupdate/internal/cienv"
+	"github.com/gokrazy/autoupdate/internal/githubclient"
+	"github.com/gokrazy/autoupdate/internal/history"
+	"github.com/google/go-github/v35/github"
+
+	// gokr-backfill always needs SQLite, unlike gokr-boot (see package
+	// sqlite), so it links the driver regardless of build tags. It
+	// requires cgo.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	historyDB = flag.String("history_db",
+		"",
+		"path to the gokr-boot -history_db SQLite database to insert the imported results into")
+
+	historyPath = flag.String("history",
+		"",
+		"if non-empty, path to a JSON-lines history file written by earlier versions of gokr-boot -history, whose results are imported in addition to (or, with -comments=false, instead of) the pull request comments")
+
+	comments = flag.Bool("comments",
+		true,
+		"import the results of the boot test comments on the pull requests of the repository")
+
+	author = flag.String("author",
+		"",
+		"if non-empty, only import comments by this GitHub user (defaults to the GitHub user from the environment, or the user of the auth token)")
+)
+
+var (
+	successRe = regexp.MustCompile(`^Boot test successful, find the log at (\S+)`)
+	issueRe   = regexp.MustCompile(`/issues/(\d+)$`)
+)
+
+// recordKey identifies r for de-duplication: by its log URL where it has one,
+// otherwise by when and where it ran.
+func recordKey(r history.Record) string {
+	if r.LogURL != "" {
+		return r.LogURL
+	}
+	return fmt.Sprintf("%s %s %d", r.Repo, r.Host, r.Time.UnixNano())
+}
+
+// existing returns the keys (see recordKey) of the records which are already
+// present in db, so that running gokr-backfill repeatedly does not import
+// duplicates.
+func existing(db *history.DB) (map[string]bool, error) {
+	records, err := db.Select(history.Query{})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, r := range records {
+		seen[recordKey(r)] = true
+	}
+	return seen, nil
+}
+
+// migrate returns the records of the history file at path which are not in
+// seen.
+func migrate(path string, seen map[string]bool) ([]history.Record, error) {
+	all, err := history.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	var records []history.Record
+	for _, r := range all {
+		if seen[recordKey(r)] {
+			continue
+		}
+		records = append(records, r)
+		seen[recordKey(r)] = true
+	}
+	return records, nil
+}
+
+func backfill(ctx context.Context, client *github.Client, owner, repo, author string, seen map[string]bool) ([]history.Record, error) {
+	var records []history.Record
+	opts := &github.IssueListCommentsOptions{
+		Sort:        github.String("created"),
+		Direction:   github.String("asc"),
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		// Issue number 0 lists the comments of all issues in the repository.
+		comments, resp, err := client.Issues.ListComments(ctx, owner, repo, 0, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range comments {
+			if c.GetUser().GetLogin() != author {
+				continue
+			}
+			matches := successRe.FindStringSubmatch(c.GetBody())
+			if matches == nil || seen[matches[1]] {
+				continue
+			}
+			var pr int
+			if m := issueRe.FindStringSubmatch(c.GetIssueURL()); m != nil {
+				pr, _ = strconv.Atoi(m[1])
+			}
+			records = append(records, history.Record{
+				Time:   c.GetCreatedAt(),
+				Repo:   owner + "/" + repo,
+				PR:     pr,
+				Result: "success",
+				LogURL: matches[1],
+			})
+			seen[matches[1]] = true
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return records, nil
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	if *historyDB == "" {
+		log.Fatal("-history_db is a required flag")
+	}
+
+	db, err := history.OpenDB(*historyDB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	seen, err := existing(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var records []history.Record
+	if *historyPath != "" {
+		migrated, err := migrate(*historyPath, seen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("migrating %d results from %s", len(migrated), *historyPath)
+		records = append(records, migrated...)
+	}
+
+	if *comments {
+		imported, err := importComments(seen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		records = append(records, imported...)
+	}
+
+	if err := db.Insert(records...); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("imported %d results into %s", len(records), *historyDB)
+}
+
+// importComments returns the results of the boot test comments on the pull
+// requests of the repository from the CI environment which are not in seen.
+// Unlike migrating history files, it requires the CI environment.
+func importComments(seen map[string]bool) ([]history.Record, error) {
+	slug := cienv.MustGetSlug()
+	parts := strings.Split(slug, "/")
+	if got, want := len(parts), 2; got != want {
+		return nil, fmt.Errorf("unexpected number of /-separated parts in %q: got %d, want %d", slug, got, want)
+	}
+
+	ctx := context.Background()
+
+	client := githubclient.New(cienv.MustGetAuthToken())
+
+	if *author == "" {
+		*author = cienv.GithubUser()
+	}
+	if *author == "" {
+		// Personal access tokens belong to a user, GITHUB_TOKEN does not.
+		user, _, err := client.Users.Get(ctx, "")
+		if err != nil {
+			return nil, fmt.Errorf("-author not specified and the user of the token unknown: %v", err)
+		}
+		*author = user.GetLogin()
+	}
+
+	return backfill(ctx, client, parts[0], parts[1], *author, seen)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/history"
+)
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2021, 3, 1, 12, 0, 0, 123456789, time.UTC)
+	records := []history.Record{
+		{Time: start, Repo: "gokrazy/gokrazy", PR: 1, Host: "pi4", Result: "success", LogURL: "https://gist.github.com/1"},
+		{Time: start.Add(time.Minute), Repo: "gokrazy/gokrazy", PR: 2, Host: "pi4", Result: "failure", Error: "timeout"},
+		{Time: start.Add(2 * time.Minute), Repo: "gokrazy/gokrazy", PR: 2, Host: "pi5", Result: "failure"},
+	}
+	var lines []string
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(b))
+	}
+	fn := filepath.Join(dir, "history.json")
+	if err := ioutil.WriteFile(fn, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := history.OpenDB(filepath.Join(dir, "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// The first record was already imported from the pull request comments.
+	if err := db.Insert(records[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Migrating twice must not import duplicates.
+	for i, want := range []int{2, 0} {
+		seen, err := existing(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		migrated, err := migrate(fn, seen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(migrated); got != want {
+			t.Fatalf("migration %d: migrated %d records, want %d", i, got, want)
+		}
+		if err := db.Insert(migrated...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := db.Select(history.Query{Repo: "gokrazy/gokrazy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(records) {
+		t.Fatalf("Select() returned %d records, want %d", len(got), len(records))
+	}
+	for i, r := range got {
+		want := records[i]
+		if !r.Time.Equal(want.Time) || r.Host != want.Host || r.Result != want.Result || r.Error != want.Error || r.LogURL != want.LogURL {
+			t.Errorf("record %d = %+v, want %+v", i, r, want)
+		}
+	}
+}
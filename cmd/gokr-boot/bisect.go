@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/redact"
+	"github.com/gokrazy/autoupdate/pkg/boottest"
+	"github.com/google/go-github/v35/github"
+)
+
+var (
+	bisectRepo = flag.String("bisect_repo",
+		"",
+		"owner/repo (e.g. gokrazy/kernel) whose commits bisect boot tests")
+
+	bisectPackage = flag.String("bisect_package",
+		"",
+		"Go package of -bisect_repo which bisect pins to each tested commit using gok add, e.g. github.com/gokrazy/kernel")
+
+	bisectGood = flag.String("good",
+		"",
+		"commit of -bisect_repo which is known to boot")
+
+	bisectBad = flag.String("bad",
+		"",
+		"commit of -bisect_repo which is known to not boot")
+
+	bisectIssue = flag.Int("bisect_issue",
+		0,
+		"if non-zero, number of the -bisect_repo issue or pull request on which bisect comments with the first failing commit")
+)
+
+type bisectStep struct {
+	commit *github.RepositoryCommit
+	err    error // nil if the commit booted successfully
+}
+
+// firstCommitLine returns the subject of the commit message of c.
+func firstCommitLine(c *github.RepositoryCommit) string {
+	msg := c.GetCommit().GetMessage()
+	if idx := strings.IndexByte(msg, '\n'); idx > -1 {
+		msg = msg[:idx]
+	}
+	return msg
+}
+
+// bisectComment formats the result of a bisection for posting on GitHub.
+func bisectComment(owner, repo, host string, first *github.RepositoryCommit, steps []bisectStep) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Bisected the boot failure of %s..%s on %s: the first failing commit is %s (%s)\n\n",
+		*bisectGood, *bisectBad, host, first.GetSHA(), firstCommitLine(first))
+	b.WriteString("Tested commits:\n\n")
+	for _, step := range steps {
+		result := "boots"
+		if step.err != nil {
+			result = "fails: " + strings.SplitN(step.err.Error(), "\n", 2)[0]
+		}
+		fmt.Fprintf(&b, "* https://github.com/%s/%s/commit/%s %s\n", owner, repo, step.commit.GetSHA(), result)
+	}
+	return b.String()
+}
+
+// bisectTest boot tests sha on host after pinning -bisect_package to it.
+func bisectTest(ctx context.Context, bt *boottest.BootTester, host, sha string) error {
+	if err := bt.AddPackage(ctx, *bisectPackage+"@"+sha); err != nil {
+		return err
+	}
+	// Subtract a second to ensure the gokrazy build timestamp is different
+	// (UNIX timestamps use seconds as their granularity).
+	newer := strconv.FormatInt(time.Now().Unix()-1, 10)
+	_, err := bt.Test(ctx, host, newer)
+	return err
+}
+
+// bisect finds the first commit between -good and -bad of -bisect_repo which
+// fails to boot on -hostname by boot testing intermediate commits (binary
+// search). The instance is left with -bisect_package pinned to the last
+// tested commit.
+func bisect(ctx context.Context) {
+	requireFlags("bisect_repo", "bisect_package", "good", "bad", "hostname")
+	parts := strings.Split(*bisectRepo, "/")
+	if got, want := len(parts), 2; got != want {
+		log.Fatalf("unexpected number of /-separated parts in %q: got %d, want %d", *bisectRepo, got, want)
+	}
+	owner, repo := parts[0], parts[1]
+	loadCredentials()
+
+	bt := newBootTester()
+	client := newClient()
+
+	// CompareCommits lists the commits reachable from -bad but not from
+	// -good, oldest first, i.e. the last one is -bad itself.
+	comparison, _, err := client.Repositories.CompareCommits(ctx, owner, repo, *bisectGood, *bisectBad)
+	if err != nil {
+		log.Fatal(err)
+	}
+	commits := comparison.Commits
+	if len(commits) == 0 {
+		log.Fatalf("no commits between %s and %s", *bisectGood, *bisectBad)
+	}
+
+	if _, err := bt.UseBakeries(ctx, *bisectRepo); err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		// Release the bakeries even if ctx was cancelled.
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := bt.ReleaseBakeries(ctx); err != nil {
+			log.Printf("releasing bakeries: %v", err)
+		}
+	}()
+
+	// Invariant: commits[good] boots (good == -1 stands for -good), and
+	// commits[bad] does not.
+	good, bad := -1, len(commits)-1
+	var steps []bisectStep
+	for bad-good > 1 {
+		mid := good + (bad-good)/2
+		c := commits[mid]
+		log.Printf("bisect: testing %s (%d commits left)", c.GetSHA(), bad-good-1)
+		err := bisectTest(ctx, bt, *hostname, c.GetSHA())
+		if ctx.Err() != nil {
+			if err := bt.Cancel(context.Background(), *hostname); err != nil {
+				log.Printf("aborting boot test: %v", err)
+			}
+			if err := bt.ReleaseBakeries(context.Background()); err != nil {
+				log.Printf("releasing bakeries: %v", err)
+			}
+			log.Fatal(ctx.Err())
+		}
+		steps = append(steps, bisectStep{commit: c, err: redact.Error(err)})
+		if err != nil {
+			log.Printf("bisect: %s fails: %v", c.GetSHA(), err)
+			bad = mid
+		} else {
+			log.Printf("bisect: %s boots", c.GetSHA())
+			good = mid
+		}
+	}
+
+	first := commits[bad]
+	log.Printf("bisect: first failing commit is %s (%s)", first.GetSHA(), firstCommitLine(first))
+	if *bisectIssue == 0 {
+		return
+	}
+	body := bisectComment(owner, repo, *hostname, first, steps)
+	if _, _, err := client.Issues.CreateComment(ctx, owner, repo, *bisectIssue, &github.IssueComment{
+		Body: github.String(redact.String(body)),
+	}); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -2,24 +2,27 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gokrazy/autoupdate/internal/cienv"
-	"github.com/gokrazy/internal/config"
+	"github.com/gokrazy/autoupdate/internal/forge"
+	"github.com/gokrazy/autoupdate/internal/githubclient"
+	"github.com/gokrazy/autoupdate/internal/history"
+	"github.com/gokrazy/autoupdate/internal/notify"
+	"github.com/gokrazy/autoupdate/internal/redact"
+	"github.com/gokrazy/autoupdate/internal/sqlite"
+	"github.com/gokrazy/autoupdate/pkg/boottest"
 	"github.com/google/go-github/v35/github"
-	"github.com/google/renameio/v2"
 )
 
 var (
@@ -31,225 +34,687 @@ var (
 		"",
 		"name of the required label before the PR will be tested")
 
+	failureLabel = flag.String("failure_label",
+		"",
+		"if non-empty, name of a GitHub label to set on the pull request when the boot test fails. -require_label is left in place, and a later successful boot test removes the label again")
+
 	booteryURL = flag.String("bootery_url",
 		"",
 		"/testboot URL to send boot images to")
 
+	booterySPKIPin = flag.String("bootery_spki_pin",
+		"",
+		"if non-empty, comma-separated list of base64-encoded SHA-256 hashes of public keys (e.g. from openssl x509 -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64), one of which the https:// -bootery_url certificate (or a CA of its chain) must have. A pinned leaf key is accepted even if self-signed")
+
+	booteryProxy = flag.String("bootery_proxy",
+		"",
+		"if non-empty, URL of the proxy through which to connect to the bootery (e.g. http://proxy.example.net:3128). By default, HTTPS_PROXY, HTTP_PROXY and NO_PROXY from the environment are honored")
+
+	booteryDialTimeout = flag.Duration("bootery_dial_timeout",
+		0,
+		"if non-zero, how long connecting to the bootery (or the proxy) may take (default 30s)")
+
+	booteryTLSHandshakeTimeout = flag.Duration("bootery_tls_handshake_timeout",
+		0,
+		"if non-zero, how long the TLS handshake with an https:// bootery may take (default 10s)")
+
+	booteryResponseHeaderTimeout = flag.Duration("bootery_response_header_timeout",
+		0,
+		"if non-zero, how long the bootery may take to respond after an upload. The bootery responds to boot tests once the device booted, so choose a generous value, e.g. 15m")
+
+	requireChecksumEcho = flag.Bool("require_checksum_echo",
+		false,
+		"fail uploads if the bootery does not confirm the SHA-256 checksum of the received image (older booteries do not), instead of logging a warning")
+
+	forceIPFamily = flag.String("force_ip_family",
+		"",
+		"if non-empty, connect to the bootery (or -bootery_proxy) only over IPv4 (4) or IPv6 (6), e.g. for hosts whose IPv4 connectivity is tunnelled (DS-Lite). By default, both are tried (happy eyeballs)")
+
 	updateRootFlag = flag.Bool("update_root",
 		false,
 		"update bakery root file system, too? required for gokrazy/kernel with loadable kernel modules")
+
+	captureDiagnostics = flag.Bool("capture_diagnostics",
+		false,
+		"on boot test failure, ask the bootery to hold the device and capture diagnostics (serial console state, sysrq dump) before resetting it")
+
+	historyPath = flag.String("history",
+		"",
+		"no longer supported: use -history_db instead, into which gokr-backfill -history imports existing history files")
+
+	historyDB = flag.String("history_db",
+		"",
+		"if non-empty, path to an SQLite database in which the result of each boot test is recorded, and which the history subcommand queries. Requires gokr-boot to be built with -tags sqlite (requires cgo)")
+
+	skipTested = flag.Bool("skip_tested",
+		false,
+		"skip the boot test if the pull request head commit already has a successful -status_context commit status")
+
+	statusContext = flag.String("status_context",
+		"gokr-boot",
+		"context of the GitHub commit status which is set on the pull request head commit after a successful boot test")
+
+	cacheDir = flag.String("cache_dir",
+		"",
+		"if non-empty, directory in which to cache built boot/root images (e.g. a CI cache directory). Images are re-used when neither the instance config nor the pinned package versions changed")
+
+	deltaRoot = flag.Bool("delta_root",
+		false,
+		"upload only those chunks of the root file system which the bootery does not already have from the previous root image. Falls back to a full upload if the bootery does not support delta uploads")
+
+	streamImages = flag.Bool("stream",
+		false,
+		"stream images from gok straight to the bootery instead of writing them to temporary files first. Saves disk space on small CI runners, but is incompatible with -cache_dir and -delta_root, which need random access to the images")
+
+	board = flag.String("board",
+		"",
+		"if non-empty, board profile whose kernel package, firmware package and serial console are used when building images. One of "+strings.Join(boottest.Boards(), ", "))
+
+	arch = flag.String("arch",
+		"",
+		"if non-empty, target architecture to build for (arm64, armhf or amd64). Defaults to the architecture of -board")
+
+	applianceDir = flag.String("appliance_dir",
+		"",
+		"if non-empty, path to a checkout of a gokrazy appliance module (typically the pull request’s repository), which is added to the instance and packed as the primary application instead of only testing the fixed bakery package set")
+
+	applianceProbes = flag.String("appliance_probes",
+		"",
+		"comma-separated list of URLs which must return HTTP 200 after the appliance booted. {hostname} is replaced with the hostname of the device, e.g. http://{hostname}:8080/healthz")
+
+	probeTimeout = flag.Duration("probe_timeout",
+		2*time.Minute,
+		"how long to wait for each -appliance_probes URL to return HTTP 200")
+
+	verifyServices = flag.Bool("verify_services",
+		false,
+		"after booting, verify via the gokrazy status API that all packages of the instance are running and not crash-looping")
+
+	servicesSettle = flag.Duration("services_settle",
+		30*time.Second,
+		"how long services need to keep running after boot to be considered healthy by -verify_services")
+
+	artifactDir = flag.String("artifact_dir",
+		"",
+		"if non-empty, directory into which the boot/root images and the gok output are saved when building or boot testing fails, so that the failure can be reproduced locally")
+
+	artifactHook = flag.String("artifact_hook",
+		"",
+		"if non-empty, command which is run with the -artifact_dir subdirectory of the failed host as its only argument, e.g. to upload CI artifacts")
+
+	signingKeyEnv = flag.String("signing_key_env",
+		"",
+		"if non-empty, name of an environment variable holding a base64-encoded ed25519 private key (or seed) with which to sign images. The detached signature is uploaded alongside each image, so that a bootery configured with the public key refuses to flash unsigned or tampered images")
+
+	kernelDir = flag.String("kernel_dir",
+		"",
+		"if non-empty, local working copy of the kernel module to build the images with instead of the pinned version, e.g. to boot test uncommitted kernel changes")
+
+	firmwareDir = flag.String("firmware_dir",
+		"",
+		"if non-empty, local working copy of the firmware module to build the images with instead of the pinned version")
+
+	cmdlineExtra = flag.String("cmdline_extra",
+		"",
+		"if non-empty, parameters to append to the kernel command line in the boot image (e.g. loglevel=7 ignore_loglevel), for debugging boot failures. Requires mtools")
+
+	qemu = flag.String("qemu",
+		"",
+		"if non-empty, qemu-system binary (e.g. qemu-system-aarch64) in which to boot test instead of on the bakeries of -bootery_url")
+
+	qemuFirmware = flag.String("qemu_firmware",
+		"",
+		"path to the UEFI firmware (e.g. QEMU_EFI.fd or OVMF.fd) with which -qemu boots")
+
+	qemuBootTimeout = flag.Duration("qemu_boot_timeout",
+		5*time.Minute,
+		"how long to wait for the gokrazy web interface to come up in -qemu")
+
+	keepAlive = flag.Duration("keepalive",
+		time.Minute,
+		"interval in which to log progress during long builds, uploads and boots, so that CI providers do not kill the job for lack of output. 0 disables")
+
+	uploadProgress = flag.Duration("upload_progress",
+		0,
+		"if non-zero, interval in which to log the progress of image uploads (bytes sent, percentage, rate and estimated time left), e.g. 10s. Defaults to -keepalive")
+
+	sbom = flag.Bool("sbom",
+		false,
+		"attach a CycloneDX SBOM of the Go modules (including kernel and firmware) of the image to the boot log gist. build writes it to -image_dir/sbom.cdx.json, from which report picks it up")
+
+	leaseWait = flag.Duration("lease_wait",
+		0,
+		"if non-zero, acquire a lease on the bakeries before using them, waiting up to this long while a concurrent run holds it")
+
+	leaseTTL = flag.Duration("lease_ttl",
+		2*time.Minute,
+		"how long the bakery lease (see -lease_wait) outlives gokr-boot if it stops renewing it, e.g. because it crashed")
+
+	discoveryTimeout = flag.Duration("discovery_timeout",
+		0,
+		"if non-zero, query the devices and features of the bootery before uploading, waiting up to this long for the bootery and its devices to become available")
 )
 
-func createGist(ctx context.Context, client *github.Client, log string) (string, error) {
-	filename := "boot-log-" + time.Now().Format(time.RFC3339)
-	gist, _, err := client.Gists.Create(ctx,
-		&github.Gist{
-			Description: github.String("gokrazy boot log"),
-			Public:      github.Bool(false),
-			Files: map[github.GistFilename]github.GistFile{
-				github.GistFilename(filename): {Content: github.String(log)},
-			},
-		})
-	if err != nil {
-		return "", err
-	}
-	return *gist.HTMLURL, nil
+// stringList is a flag.Value for flags which can be specified repeatedly.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, " ") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
-func writeImages(hostname string) (boot string, root string, _ error) {
-	log.Printf("writeImages(%s)", hostname)
-	bootf, err := ioutil.TempFile("", "gokr-boot")
+var packerArgs stringList
+
+func init() {
+	flag.Var(&packerArgs, "packer_arg",
+		"argument to append verbatim to the gok overwrite command line which builds the images (e.g. an experimental flag). Can be specified repeatedly")
+}
+
+// createGist uploads the boot log, and attachments (file name → content, e.g.
+// the SBOM of the image), to a secret gist (or what the forge offers instead,
+// see forge.Forge.Paste).
+func createGist(ctx context.Context, f forge.Forge, log string, attachments map[string]string) (string, error) {
+	scanned, findings := redact.Scan(redact.String(log), patterns)
+	filename, content, err := sealLog("boot-log-"+time.Now().Format(time.RFC3339), scanned)
 	if err != nil {
-		return "", "", err
+		return "", err
 	}
-	bootf.Close()
-	rootf, err := ioutil.TempFile("", "gokr-root")
-	if err != nil {
-		return "", "", err
+	files := map[string]string{
+		filename: content,
 	}
-	rootf.Close()
-	// Inject the hostname into the instance config.
-	cfg, err := config.ReadFromFile()
-	if err != nil {
-		return "", "", err
+	for name, content := range attachments {
+		files[name] = content
 	}
-	cfg.Hostname = hostname
-	b, err := cfg.FormatForFile()
+	gistURL, err := f.Paste(ctx, "gokrazy boot log", files)
 	if err != nil {
-		return "", "", err
-	}
-	if err := renameio.WriteFile(config.InstanceConfigPath(), b, 0644); err != nil {
-		return "", "", err
+		return "", err
 	}
-	cmd := exec.Command("gok",
-		"overwrite",
-		"--boot="+bootf.Name(),
-		"--root="+rootf.Name())
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return bootf.Name(), rootf.Name(), cmd.Run()
+	recordFindings(gistURL, findings)
+	return gistURL, nil
 }
 
-func useBakeries(booteryURL, slug string) ([]string, error) {
-	u, err := url.Parse(booteryURL)
+// createBuildGist uploads the output of a failed image build to a secret gist.
+func createBuildGist(ctx context.Context, f forge.Forge, output string) (string, error) {
+	scanned, findings := redact.Scan(redact.String(output), patterns)
+	filename, content, err := sealLog("build-log-"+time.Now().Format(time.RFC3339), scanned)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	v := u.Query()
-	v.Set("slug", slug)
-	u.RawQuery = v.Encode()
-	req, err := http.NewRequest(http.MethodPut, u.String(), nil)
+	gistURL, err := f.Paste(ctx, "gokrazy build log", map[string]string{
+		filename: content,
+	})
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	req.Header.Set("Content-Type", "application/octet-stream")
-	resp, err := http.DefaultClient.Do(req)
+	recordFindings(gistURL, findings)
+	return gistURL, nil
+}
+
+func ensureLabel(ctx context.Context, f forge.Forge, owner, repo string, issueNum int, label string) error {
+	labels, err := f.Labels(ctx, owner, repo, issueNum)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	for _, l := range labels {
+		if l == label {
+			return nil
+		}
 	}
-	if got, want := resp.StatusCode, http.StatusOK; got != want {
-		b, _ := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected HTTP status code: got %d (%s), want %d", got, strings.TrimSpace(string(b)), want)
+	return fmt.Errorf("label %q not found on issue %d", label, issueNum)
+}
+
+// recordResult records the boot test result of host in the -history_db, and
+// posts it to the -result_webhook. All are informational
+// and do not fail the boot test.
+func recordResult(ctx context.Context, owner, repo string, issueNum int, headSHA, host, result, logURL, bootLog string, duration time.Duration, uploads []boottest.UploadStats, testErr error) {
+	rec := history.Record{
+		Time:   time.Now(),
+		Repo:   owner + "/" + repo,
+		PR:     issueNum,
+		Commit: headSHA,
+		Host:   host,
+		Result: result,
+		LogURL: logURL,
+
+		DurationSeconds: duration.Seconds(),
+		BootLog:         redact.String(bootLog),
 	}
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	if testErr != nil {
+		rec.Error = redact.String(testErr.Error())
 	}
-	var useReply struct {
-		Hosts []string `json:"hosts"`
+	if *historyDB != "" {
+		if err := insertResult(*historyDB, rec); err != nil {
+			log.Printf("recording result in history database: %v", err)
+		}
 	}
-	if err := json.Unmarshal(b, &useReply); err != nil {
-		return nil, err
+	if *resultWebhook != "" {
+		if err := postResult(ctx, rec, duration, uploads, redact.Error(testErr)); err != nil {
+			log.Printf("posting result to webhook: %v", err)
+		}
 	}
-	return useReply.Hosts, nil
 }
 
-func releaseBakeries(booteryURL string) error {
-	req, err := http.NewRequest(http.MethodPut, booteryURL, nil)
-	if err != nil {
-		return err
+// cancelled cleans up after gokr-boot was interrupted during the boot test of
+// hostname: the bootery aborts the boot test and the commit status records the
+// cancellation.
+func cancelled(bt *boottest.BootTester, f forge.Forge, owner, repo, headSHA, hostname string) {
+	log.Printf("cancelled, aborting boot test of %s", hostname)
+	// The main context is done, so use a fresh one for cleaning up.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := bt.Cancel(ctx, hostname); err != nil {
+		log.Printf("aborting boot test: %v", err)
 	}
-	req.Header.Set("Content-Type", "application/octet-stream")
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
+	if err := setStatus(ctx, f, owner, repo, headSHA, *statusContext, "error", "boot test cancelled", ""); err != nil {
+		log.Printf("setting commit status: %v", err)
+	}
+}
+
+// updateLabels marks the pull request as tested by setting -set_label and
+// removing -require_label and -failure_label (if present, as a boot test
+// triggered by a comment does not need -require_label).
+func updateLabels(ctx context.Context, f forge.Forge, owner, repo string, issueNum int) error {
+	if err := f.AddLabel(ctx, owner, repo, issueNum, *setLabel); err != nil {
 		return err
 	}
-	if got, want := resp.StatusCode, http.StatusOK; got != want {
-		b, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected HTTP status code: got %d (%s), want %d", got, strings.TrimSpace(string(b)), want)
+	for _, label := range []string{*failureLabel, *requireLabel} {
+		if label == "" {
+			continue
+		}
+		if err := ensureLabel(ctx, f, owner, repo, issueNum, label); err != nil {
+			continue // not present
+		}
+		if err := f.RemoveLabel(ctx, owner, repo, issueNum, label); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func streamTo(img, booteryURL, hostname, newer string) (string, error) {
-	f, err := os.Open(img)
-	if err != nil {
-		return "", err
+func addComment(ctx context.Context, f forge.Forge, owner, repo string, issueNum int, gistURL, details string) error {
+	body := fmt.Sprintf("Boot test successful, find the log%s at %s%s", logNote(), gistURL, redactionNote(gistURL))
+	if details != "" {
+		body += "\n\n" + details
 	}
-	defer f.Close()
-	u, err := url.Parse(booteryURL)
-	if err != nil {
-		return "", err
+	_, err := f.Comment(ctx, owner, repo, issueNum, redact.String(body))
+	return err
+}
+
+// failureGist uploads the log of the failed boot test of host to a gist and
+// returns its URL and a sentence for the pull request comment. For build
+// failures, the log is the output of gok, otherwise the error. The device
+// diagnostics, if any, are attached as a separate file.
+func failureGist(ctx context.Context, f forge.Forge, host string, testErr error, diag string) (gistURL, body string, _ error) {
+	var buildErr *boottest.BuildError
+	if errors.As(testErr, &buildErr) {
+		gistURL, err := createBuildGist(ctx, f, buildErr.Output)
+		if err != nil {
+			return "", "", err
+		}
+		return gistURL, fmt.Sprintf("Building the images for %s failed (%v), find the build log%s at %s%s", host, buildErr.Err, logNote(), gistURL, redactionNote(gistURL)), nil
 	}
-	v := u.Query()
-	v.Set("hostname", hostname)
-	if newer != "" {
-		v.Set("boot-newer", newer)
+	content := fmt.Sprintf("boot test on %s failed: %v\n", host, testErr)
+	var (
+		attachments map[string]string
+		findings    []redact.Finding
+	)
+	what := "the log"
+	if diag != "" {
+		// Sealed and scanned like the log, as the serial console may
+		// show secrets, too.
+		var scanned string
+		scanned, findings = redact.Scan(redact.String(diag), patterns)
+		filename, sealed, err := sealLog("diagnostics-"+host, scanned)
+		if err != nil {
+			return "", "", err
+		}
+		attachments = map[string]string{filename: sealed}
+		what = "the log and the device diagnostics"
 	}
-	u.RawQuery = v.Encode()
-	req, err := http.NewRequest(http.MethodPut, u.String(), f)
+	gistURL, err := createGist(ctx, f, content, attachments)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
-	req.Header.Set("Content-Type", "application/octet-stream")
-	resp, err := http.DefaultClient.Do(req)
+	recordFindings(gistURL, findings)
+	return gistURL, fmt.Sprintf("Boot test on %s failed (%v), find %s%s at %s%s", host, testErr, what, logNote(), gistURL, redactionNote(gistURL)), nil
+}
+
+// reportFailure posts the failure of the boot test of host to the pull request
+// and sets -failure_label. It returns the URL of the gist holding the log.
+func reportFailure(ctx context.Context, f forge.Forge, owner, repo string, issueNum int, host string, testErr error, diag string) (string, error) {
+	gistURL, body, err := failureGist(ctx, f, host, testErr, diag)
 	if err != nil {
 		return "", err
 	}
-	if got, want := resp.StatusCode, http.StatusOK; got != want {
-		b, _ := ioutil.ReadAll(resp.Body)
-		return "", fmt.Errorf("unexpected HTTP status code: got %d (%s), want %d", got, strings.TrimSpace(string(b)), want)
+	if _, err := f.Comment(ctx, owner, repo, issueNum, redact.String(body)); err != nil {
+		return "", err
+	}
+	if *failureLabel != "" {
+		if err := f.AddLabel(ctx, owner, repo, issueNum, *failureLabel); err != nil {
+			return "", err
+		}
 	}
-	b, err := ioutil.ReadAll(resp.Body)
-	return string(b), err
+	return gistURL, nil
 }
 
-func testBoot(bootImg, booteryURL, hostname, newer string) (string, error) {
-	return streamTo(bootImg, booteryURL, hostname, newer)
+func headCommit(ctx context.Context, f forge.Forge, owner, repo string, issueNum int) (string, error) {
+	pr, err := f.PullRequest(ctx, owner, repo, issueNum)
+	if err != nil {
+		return "", err
+	}
+	return pr.HeadSHA, nil
 }
 
-func updateRoot(rootImg, booteryURL, hostname string) (string, error) {
-	return streamTo(rootImg, strings.TrimSuffix(booteryURL, "/testboot")+"/updateroot", hostname, "")
+// baseBranch returns the branch into which the pull request is to be merged.
+func baseBranch(ctx context.Context, f forge.Forge, owner, repo string, issueNum int) (string, error) {
+	pr, err := f.PullRequest(ctx, owner, repo, issueNum)
+	if err != nil {
+		return "", err
+	}
+	return pr.BaseRef, nil
 }
 
-func ensureLabel(ctx context.Context, client *github.Client, owner, repo string, issueNum int, label string) error {
-	labels, _, err := client.Issues.ListLabelsByIssue(ctx, owner, repo, issueNum, nil)
+// alreadyTested returns whether the specified commit carries a successful
+// commit status with the specified context, i.e. whether a previous gokr-boot
+// run already tested this exact commit.
+func alreadyTested(ctx context.Context, f forge.Forge, owner, repo, sha, contextName string) (bool, error) {
+	statuses, err := f.Statuses(ctx, owner, repo, sha)
 	if err != nil {
-		return err
+		return false, err
 	}
-	for _, l := range labels {
-		if *l.Name == label {
-			return nil
+	for _, st := range statuses {
+		if st.Context == contextName && st.State == "success" {
+			return true, nil
 		}
 	}
-	return fmt.Errorf("label %q not found on issue %d", label, issueNum)
+	return false, nil
 }
 
-func addLabel(ctx context.Context, client *github.Client, owner, repo string, issueNum int, label string) error {
-	_, _, err := client.Issues.AddLabelsToIssue(ctx, owner, repo, issueNum, []string{label})
-	return err
+func setStatus(ctx context.Context, f forge.Forge, owner, repo, sha, contextName, state, description, targetURL string) error {
+	return f.SetStatus(ctx, owner, repo, sha, forge.Status{
+		Context:     contextName,
+		State:       state,
+		Description: description,
+		TargetURL:   targetURL,
+	})
 }
 
-func removeLabel(ctx context.Context, client *github.Client, owner, repo string, issueNum int, label string) error {
-	_, err := client.Issues.RemoveLabelForIssue(ctx, owner, repo, issueNum, label)
-	return err
+var (
+	authToken         string
+	slug              string
+	travisPullRequest string
+)
+
+func init() {
+	cienv.RegisterFlags()
 }
 
-func addComment(ctx context.Context, client *github.Client, owner, repo string, issueNum int, gistURL string) error {
-	_, _, err := client.Issues.CreateComment(ctx, owner, repo, issueNum, &github.IssueComment{
-		Body: github.String(fmt.Sprintf("Boot test successful, find the log at %s", gistURL)),
-	})
-	return err
+// loadCredentials reads the GitHub credentials from the CI environment, for
+// subcommands which do not operate on the pull request of the CI environment.
+func loadCredentials() {
+	authToken = cienv.MustGetAuthToken()
 }
 
-func testBoot1(hostname, newer string) (string, error) {
-	bootImg, rootImg, err := writeImages(hostname)
-	if err != nil {
-		return "", err
+// loadCIEnv reads the GitHub credentials and the pull request from the CI
+// environment. Only subcommands which talk to GitHub need them.
+func loadCIEnv() {
+	loadCredentials()
+	slug = cienv.MustGetSlug()
+	travisPullRequest = cienv.MustResolvePullRequest(context.Background(), newForge(newClient()), slug)
+}
+
+func newBootTester() *boottest.BootTester {
+	if *booteryURL == "" && *qemu == "" {
+		log.Fatal("-bootery_url is a required flag")
 	}
-	defer os.Remove(bootImg)
-	defer os.Remove(rootImg)
 
-	if *updateRootFlag {
-		log.Printf("updating root file system")
-		if _, err := updateRoot(rootImg, *booteryURL, hostname); err != nil {
-			return "", errors.New(strings.Replace(err.Error(), *booteryURL, "<bootery_url>", -1))
+	opts := boottest.Options{
+		BooteryURL:         *booteryURL,
+		QEMU:               *qemu,
+		QEMUFirmware:       *qemuFirmware,
+		QEMUBootTimeout:    *qemuBootTimeout,
+		UpdateRoot:         *updateRootFlag,
+		DeltaRoot:          *deltaRoot,
+		Stream:             *streamImages,
+		CacheDir:           *cacheDir,
+		CaptureDiagnostics: *captureDiagnostics,
+		Board:              *board,
+		Arch:               *arch,
+		ApplianceDir:       *applianceDir,
+		ProbeTimeout:       *probeTimeout,
+		VerifyServices:     *verifyServices,
+		ServicesSettle:     *servicesSettle,
+		ArtifactDir:        *artifactDir,
+		ArtifactHook:       *artifactHook,
+		LeaseWait:          *leaseWait,
+		LeaseTTL:           *leaseTTL,
+		DiscoveryTimeout:   *discoveryTimeout,
+		RootManifest:       *rootfsManifests != "",
+		SBOM:               *sbom,
+		Provenance:         *provenance,
+		KeepAlive:          *keepAlive,
+		ProgressInterval:   *uploadProgress,
+		PackerArgs:         packerArgs,
+		KernelDir:          *kernelDir,
+		FirmwareDir:        *firmwareDir,
+		Kernels:            kernelList(),
+		CmdlineExtra:       *cmdlineExtra,
+
+		BooteryProxy:          *booteryProxy,
+		DialTimeout:           *booteryDialTimeout,
+		TLSHandshakeTimeout:   *booteryTLSHandshakeTimeout,
+		ResponseHeaderTimeout: *booteryResponseHeaderTimeout,
+		ForceIPFamily:         *forceIPFamily,
+		RequireChecksumEcho:   *requireChecksumEcho,
+	}
+	if slug != "" {
+		opts.LeaseHolder = slug + "#" + travisPullRequest
+	}
+	if *hooks != "" {
+		var err error
+		opts.Hooks, err = parseHooks(*hooks)
+		if err != nil {
+			log.Fatal(err)
 		}
+		if slug != "" {
+			opts.HookData = map[string]string{
+				"repository":   slug,
+				"pull_request": travisPullRequest,
+			}
+		}
+	}
+	if *junitXML != "" || *phaseStatuses {
+		opts.OnPhase = onPhase
+	}
+	if *applianceDir != "" && *applianceProbes != "" {
+		opts.ApplianceProbes = strings.Split(*applianceProbes, ",")
+	}
+	if *booterySPKIPin != "" {
+		opts.BooterySPKIPins = strings.Split(*booterySPKIPin, ",")
+	}
+	if *uploadRateLimit != "" {
+		var err error
+		opts.UploadRateLimit, err = parseByteRate(*uploadRateLimit)
+		if err != nil {
+			log.Fatalf("-upload_rate_limit: %v", err)
+		}
+	}
+	if *signingKeyEnv != "" {
+		var err error
+		opts.SigningKey, err = boottest.LoadSigningKey(*signingKeyEnv)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	bt, err := boottest.New(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return bt
+}
+
+// pullRequest returns a GitHub client and the owner, repository and number of
+// the pull request from the CI environment.
+func pullRequest() (_ *github.Client, owner, repo string, issueNum int) {
+	parts := strings.Split(slug, "/")
+	if got, want := len(parts), 2; got != want {
+		log.Fatalf("unexpected number of /-separated parts in %q: got %d, want %d", slug, got, want)
 	}
 
-	log.Printf("testing boot file system")
-	bootlog, err := testBoot(bootImg, strings.TrimSuffix(*booteryURL, "/testboot")+"/testboot1"+fmt.Sprintf("?update_root=%v", *updateRootFlag), hostname, newer)
+	i, err := strconv.ParseInt(travisPullRequest, 0, 64)
 	if err != nil {
-		return "", errors.New(strings.Replace(err.Error(), *booteryURL, "<bootery_url>", -1))
+		log.Fatalf("could not parse TRAVIS_PULL_REQUEST=%q as number: %v", os.Getenv("TRAVIS_PULL_REQUEST"), err)
 	}
-	return bootlog, nil
+
+	return newClient(), parts[0], parts[1], int(i)
 }
 
-var (
-	githubUser        = cienv.MustGetGithubUser()
-	authToken         = cienv.MustGetAuthToken()
-	slug              = cienv.MustGetSlug()
-	travisPullRequest = cienv.MustGetPullRequest()
-)
+func newClient() *github.Client {
+	return githubclient.New(authToken)
+}
+
+// newForge returns the -forge hosting the pull request. Subcommands which rely
+// on GitHub-only features require -forge=github, in which case client is used.
+func newForge(client *github.Client) forge.Forge {
+	if forge.IsGitHub() {
+		return forge.NewGitHub(client)
+	}
+	f, err := forge.FromFlags(authToken)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return f
+}
+
+// subcommands maps subcommand names to their implementation and whether they
+// talk to GitHub (and hence need the CI environment).
+var subcommands = map[string]struct {
+	run    func(ctx context.Context)
+	github bool
+	forge  bool // whether forges other than GitHub are supported (-forge)
+}{
+	"test":   {test, true, true},
+	"build":  {build, false, true},
+	"upload": {upload, false, true},
+	"report": {report, true, true},
+	"status": {status, true, true},
+	// watch and serve read the GitHub credentials themselves: they find
+	// pull requests instead of taking one from the CI environment.
+	"watch":   {watch, false, false},
+	"serve":   {serve, false, false},
+	"history": {showHistory, false, true},
+	"bisect":  {bisect, false, false},
+	"publish": {publish, false, false},
+}
 
 func main() {
+	// The subcommand defaults to test, which runs all phases in one go, for
+	// compatibility with existing CI configurations.
+	name := "test"
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		name = os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 	flag.Parse()
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	log.SetOutput(redact.NewWriter(os.Stderr))
 
-	if *booteryURL == "" {
-		log.Fatal("-bootery_url is a required flag")
+	if *historyPath != "" {
+		log.Fatalf("-history is no longer supported: import %s with gokr-backfill -history=%s -history_db=<path>, then pass -history_db instead", *historyPath, *historyPath)
+	}
+
+	sub, ok := subcommands[name]
+	if !ok {
+		log.Fatalf("unknown subcommand %q, expected one of test, build, upload, report, status, watch, serve, history, bisect, publish", name)
+	}
+
+	if *historyDB != "" || name == "serve" {
+		if err := sqlite.Available(); err != nil {
+			log.Fatalf("-history_db and serve use SQLite: %v", err)
+		}
 	}
 
+	if !sub.forge && !forge.IsGitHub() {
+		log.Fatalf("subcommand %s requires -forge=github, use the test, build, upload, report, status and history subcommands with other forges", name)
+	}
+
+	if sub.github {
+		loadCIEnv()
+	}
+
+	if err := registerSecrets(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := parseLogRecipients(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := loadSecretPatterns(); err != nil {
+		log.Fatal(err)
+	}
+
+	if *notifyConfig != "" {
+		if err := setupNotifiers(*notifyConfig); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Cancel the context on SIGINT/SIGTERM (e.g. when the CI job is aborted),
+	// which kills gok and aborts in-flight uploads.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sub.run(ctx)
+	writeJUnit()
+}
+
+// test runs all phases: it builds images for, boot tests and reports on every
+// bakery of the repository.
+func test(ctx context.Context) {
+	requireLabelFlags()
+	requireGitHubFlags("paths_include", "paths_exclude", "device_rules", "phase_statuses", "deployments", "downstream_repos")
+	bt := newBootTester()
+	client, owner, repo, issueNum := pullRequest()
+	var req testRequest
+	if number, action, headSHA := cienv.PullRequestEvent(); number == issueNum && forge.IsGitHub() {
+		// Only boot test the commit the workflow was triggered for.
+		req.sha = headSHA
+		if action == "synchronize" {
+			pr, _, err := client.PullRequests.Get(ctx, owner, repo, issueNum)
+			if err != nil {
+				fatal(err)
+			}
+			if err := revokeForkOverride(ctx, client, owner, repo, pr); err != nil {
+				fatal(err)
+			}
+		}
+	}
+	if err := testPullRequest(ctx, bt, client, owner, repo, issueNum, req); err != nil {
+		if ctx.Err() != nil {
+			os.Exit(1)
+		}
+		fatal(err)
+	}
+}
+
+// requireGitHubFlags exits if one of the specified flags, which enable
+// GitHub-only features, is set while -forge selects another forge.
+func requireGitHubFlags(names ...string) {
+	if forge.IsGitHub() {
+		return
+	}
+	for _, name := range names {
+		f := flag.Lookup(name)
+		if f.Value.String() != f.DefValue {
+			log.Fatalf("-%s requires -forge=github", name)
+		}
+	}
+}
+
+func requireLabelFlags() {
 	if *requireLabel == "" {
 		log.Fatal("-require_label is a required flag")
 	}
@@ -257,71 +722,344 @@ func main() {
 	if *setLabel == "" {
 		log.Fatal("-set_label is a required flag")
 	}
+}
 
-	parts := strings.Split(slug, "/")
-	if got, want := len(parts), 2; got != want {
-		log.Fatalf("unexpected number of /-separated parts in %q: got %d, want %d", slug, got, want)
+// testRequest restricts a boot test. The zero value tests a pull request
+// carrying -require_label on all bakeries.
+type testRequest struct {
+	hosts   []string // if non-empty, the hostnames to test on
+	comment bool     // requested by a /testboot comment, no label required
+	sha     string   // if non-empty, the head commit which was requested
+}
+
+// checkHead returns an error wrapping errSkipped if the head commit of the
+// pull request moved from want to got since the boot test was requested, so
+// that only the requested commit is boot tested. A /testboot comment approves
+// the commits its author saw, so new commits need another comment.
+func checkHead(want, got string, comment bool) error {
+	if want == "" || want == got {
+		return nil
 	}
+	if comment {
+		return fmt.Errorf("%w: the pull request head moved from %s to %s since the %s comment, comment %s again to boot test the new commits", errSkipped, want, got, testbootCommand, testbootCommand)
+	}
+	return fmt.Errorf("%w: the pull request head moved from %s to %s since the boot test was requested, the new head is boot tested separately", errSkipped, want, got)
+}
 
-	i, err := strconv.ParseInt(travisPullRequest, 0, 64)
+// testPullRequest boot tests the specified pull request on every bakery of the
+// repository and reports the result on the -forge.
+func testPullRequest(ctx context.Context, bt *boottest.BootTester, client *github.Client, owner, repo string, issueNum int, req testRequest) error {
+	slug := owner + "/" + repo
+	f := newForge(client)
+
+	if req.comment {
+		log.Printf("boot test requested by comment, not checking label %q", *requireLabel)
+	} else if err := ensureLabel(ctx, f, owner, repo, issueNum, *requireLabel); err != nil {
+		log.Println(err.Error())
+		return errSkipped
+	}
+
+	pr, err := f.PullRequest(ctx, owner, repo, issueNum)
 	if err != nil {
-		log.Fatalf("could not parse TRAVIS_PULL_REQUEST=%q as number: %v", os.Getenv("TRAVIS_PULL_REQUEST"), err)
+		return err
+	}
+	headSHA := pr.HeadSHA
+	if err := checkHead(req.sha, headSHA, req.comment); err != nil {
+		return err
 	}
-	issueNum := int(i)
 
-	client := github.NewClient(&http.Client{
-		Transport: &github.BasicAuthTransport{
-			Username: githubUser,
-			Password: authToken,
-		},
-	})
+	// A /testboot comment is only accepted from owners, members and
+	// collaborators of the repository, which authorizes the boot test, too.
+	if !req.comment {
+		if !forge.IsGitHub() {
+			log.Printf("-forge=%s: not checking whether the pull request comes from a fork, -require_label authorizes the boot test", f.Name())
+		} else if err := authorizeFork(ctx, client, owner, repo, issueNum); err != nil {
+			return err
+		}
+	}
 
-	ctx := context.Background()
+	skip, markerHosts, err := markers(ctx, client, owner, repo, pr)
+	if err != nil {
+		return err
+	}
+	if !req.comment {
+		// An explicit /testboot comment overrides the markers.
+		if skip {
+			return fmt.Errorf("%w: [skip boot] marker in the head commit message or pull request description", errSkipped)
+		}
+		if len(markerHosts) > 0 {
+			log.Printf("boot testing only on %q, as requested by a [boot: …] marker", markerHosts)
+			req.hosts = markerHosts
+		}
+	}
 
-	if err := ensureLabel(ctx, client, parts[0], parts[1], issueNum, *requireLabel); err != nil {
-		// Exit with exit code 0 if there is nothing to do.
-		log.Println(err.Error())
-		return
+	relevant, err := relevantChange(ctx, client, owner, repo, issueNum)
+	if err != nil {
+		return err
+	}
+	if !relevant {
+		// Do not block branch protection rules which require the status.
+		if err := setStatus(ctx, f, owner, repo, headSHA, *statusContext, "success", "boot test not needed, no relevant file changed", ""); err != nil {
+			return err
+		}
+		return errIrrelevant
+	}
+
+	if *skipTested {
+		tested, err := alreadyTested(ctx, f, owner, repo, headSHA, *statusContext)
+		if err != nil {
+			return err
+		}
+		if tested {
+			// Return early to not occupy the bakery with a boot test whose
+			// result is already known.
+			if err := updateLabels(ctx, f, owner, repo, issueNum); err != nil {
+				return err
+			}
+			return fmt.Errorf("%w: commit %s already has a successful %q status", errSkipped, headSHA, *statusContext)
+		}
+	}
+
+	// Evaluate the device rules before powering on the bakeries, which is
+	// unnecessary if no device needs to be tested.
+	var (
+		ruleHosts map[string]bool
+		allHosts  = true
+	)
+	if *deviceRules != "" {
+		rules, err := readDeviceRules(*deviceRules)
+		if err != nil {
+			return err
+		}
+		changed, err := changedFiles(ctx, client, owner, repo, issueNum)
+		if err != nil {
+			return err
+		}
+		ruleHosts, allHosts = rules.requiredHosts(changed)
+		if !allHosts && len(ruleHosts) == 0 {
+			if err := setStatus(ctx, f, owner, repo, headSHA, *statusContext, "success", "boot test not needed, no device rule requires one", ""); err != nil {
+				return err
+			}
+			return fmt.Errorf("%w: the changed files only match device rules without hosts", errSkipped)
+		}
+	}
+
+	if *applianceDir != "" {
+		if err := bt.AddAppliance(ctx); err != nil {
+			return err
+		}
 	}
 
 	// Subtract a second to ensure the gokrazy build timestamp is different
 	// (UNIX timestamps use seconds as their granularity).
 	newer := strconv.FormatInt(time.Now().Unix()-1, 10)
 
+	// The checks above authorized headSHA, not commits pushed since.
+	latest, err := f.PullRequest(ctx, owner, repo, issueNum)
+	if err != nil {
+		return err
+	}
+	if err := checkHead(headSHA, latest.HeadSHA, req.comment); err != nil {
+		return err
+	}
+
 	// Power on bakeries and expand slug into hostnames
-	booteryBase := strings.TrimSuffix(*booteryURL, "/testboot")
-	hosts, err := useBakeries(booteryBase+"/usebakeries", slug)
+	hosts, err := bt.UseBakeries(ctx, slug)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer func() {
-		if err := releaseBakeries(booteryBase + "/releasebakeries"); err != nil {
-			log.Fatal(err)
+		// Release the bakeries even if ctx was cancelled.
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := bt.ReleaseBakeries(ctx); err != nil {
+			log.Printf("releasing bakeries: %v", err)
 		}
 	}()
 
+	// Check the requested hostnames against all bakeries, so that a typo is
+	// reported instead of silently testing fewer (or no) hosts.
+	if err := checkRequested(req.hosts, hosts); err != nil {
+		return err
+	}
+
+	if !allHosts {
+		required := filterHosts(hosts, ruleHosts)
+		if len(required) == 0 {
+			var names []string
+			for host := range ruleHosts {
+				names = append(names, host)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("the device rules require %q, none of which is a bakery of %s (bakeries: %q)", names, slug, hosts)
+		}
+		hosts = required
+	}
+
+	if len(req.hosts) > 0 {
+		var requested []string
+		for _, host := range hosts {
+			for _, h := range req.hosts {
+				if h == host {
+					requested = append(requested, host)
+					break
+				}
+			}
+		}
+		if len(requested) == 0 {
+			return fmt.Errorf("none of the requested hosts %q needs to be tested according to the device rules (hosts to test: %q)", req.hosts, hosts)
+		}
+		hosts = requested
+	}
+
 	log.Printf("updating hosts %q", hosts)
+	if *phaseStatuses {
+		statuses.start(client, owner, repo, headSHA, hosts)
+		defer statuses.finish()
+	}
+	var (
+		gistURL  string
+		rows     []matrixRow // with -kernels
+		firstErr error       // with -kernels
+	)
 	for _, host := range hosts {
-		bootlog, err := testBoot1(host, newer)
+		start := time.Now()
+		dep := startDeployment(ctx, client, owner, repo, headSHA, host)
+		result, err := bt.Test(ctx, host, newer)
+		var sizeReport string
+		if err == nil && result.Sizes != nil && *imageSizes != "" {
+			sizeReport, err = checkImageSizes(ctx, client, owner, repo, issueNum, host, result.Sizes)
+		}
 		if err != nil {
-			log.Fatal(err)
+			if ctx.Err() != nil {
+				cancelled(bt, f, owner, repo, headSHA, host)
+				dep.update("error", "", "boot test cancelled")
+				return ctx.Err()
+			}
+			var diag string
+			if *captureDiagnostics {
+				d, err := bt.Diagnostics(ctx, host)
+				if err != nil {
+					log.Printf("capturing diagnostics of %s: %v", host, err)
+				} else {
+					log.Printf("diagnostics of %s:\n%s", host, d)
+					diag = d
+				}
+			}
+			if *kernels != "" {
+				// Test the remaining kernels, the comment aggregates
+				// the results.
+				logURL, _, rerr := failureGist(ctx, f, host, err, diag)
+				if rerr != nil {
+					log.Printf("uploading failure log: %v", rerr)
+				}
+				dep.update("failure", logURL, "boot test failed")
+				rows = append(rows, matrixRow{host: host, kernel: bt.Kernel(host), err: err, logURL: logURL})
+				recordResult(ctx, owner, repo, issueNum, headSHA, host, "failure", logURL, "", time.Since(start), bt.Uploads(), err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			// Reporting is best effort, the test failure is the error.
+			logURL, rerr := reportFailure(ctx, f, owner, repo, issueNum, host, err, diag)
+			if rerr != nil {
+				log.Printf("reporting failure: %v", rerr)
+			}
+			dep.update("failure", logURL, "boot test failed")
+			recordResult(ctx, owner, repo, issueNum, headSHA, host, "failure", logURL, "", time.Since(start), bt.Uploads(), err)
+			notifyResult(ctx, notify.Message{
+				Success: false,
+				Text:    fmt.Sprintf("%s#%d: boot test on %s failed: %v", slug, issueNum, host, err),
+				URL:     pr.URL,
+			})
+			return err
 		}
 
-		gistURL, err := createGist(ctx, client, bootlog)
+		attachments := make(map[string]string)
+		if len(result.SBOM) > 0 {
+			attachments["sbom.cdx.json"] = string(result.SBOM)
+		}
+		if *provenance {
+			src := provenanceSource(owner, repo, headSHA, fmt.Sprintf("refs/pull/%d/head", issueNum))
+			files, err := provenanceFiles(ctx, bt, host, result.Digests, src)
+			if err != nil {
+				dep.update("error", "", "generating provenance failed")
+				return err
+			}
+			for name, content := range files {
+				attachments[name] = content
+			}
+		}
+		gistURL, err = createGist(ctx, f, result.BootLog, attachments)
 		if err != nil {
-			log.Fatal(err)
+			dep.update("error", "", "uploading the boot log failed")
+			return err
 		}
+		dep.update("success", gistURL, "boot test successful")
 
-		if err := addComment(ctx, client, parts[0], parts[1], issueNum, gistURL); err != nil {
-			log.Fatal(err)
+		details := result.Services
+		if sizeReport != "" {
+			if details != "" {
+				details += "\n\n"
+			}
+			details += sizeReport
+		}
+		if result.RootFiles != nil {
+			diff, err := rootfsDiff(ctx, client, owner, repo, issueNum, host, result.RootFiles)
+			if err != nil {
+				// The diff is informational, the boot test succeeded.
+				log.Printf("comparing root file system: %v", err)
+			} else if diff != "" {
+				if details != "" {
+					details += "\n\n"
+				}
+				details += diff
+			}
+		}
+
+		if *kernels != "" {
+			rows = append(rows, matrixRow{host: host, kernel: bt.Kernel(host), logURL: gistURL, details: details})
+		} else if err := addComment(ctx, f, owner, repo, issueNum, gistURL, details); err != nil {
+			return err
 		}
+
+		recordResult(ctx, owner, repo, issueNum, headSHA, host, "success", gistURL, result.BootLog, time.Since(start), bt.Uploads(), nil)
 	}
 
-	if err := addLabel(ctx, client, parts[0], parts[1], issueNum, *setLabel); err != nil {
-		log.Fatal(err)
+	if *kernels != "" {
+		if err := addMatrixComment(ctx, f, owner, repo, issueNum, rows); err != nil {
+			return err
+		}
+		if firstErr != nil {
+			if *failureLabel != "" {
+				if err := f.AddLabel(ctx, owner, repo, issueNum, *failureLabel); err != nil {
+					log.Printf("setting failure label: %v", err)
+				}
+			}
+			notifyResult(ctx, notify.Message{
+				Success: false,
+				Text:    fmt.Sprintf("%s#%d: boot test failed: %v", slug, issueNum, firstErr),
+				URL:     pr.URL,
+			})
+			return firstErr
+		}
 	}
 
-	if err := removeLabel(ctx, client, parts[0], parts[1], issueNum, *requireLabel); err != nil {
-		log.Fatal(err)
+	if err := setStatus(ctx, f, owner, repo, headSHA, *statusContext, "success", "boot test successful", gistURL); err != nil {
+		return err
+	}
+
+	if err := updateLabels(ctx, f, owner, repo, issueNum); err != nil {
+		return err
 	}
+
+	triggerDownstream(ctx, client, owner, repo, issueNum, headSHA)
+
+	notifyResult(ctx, notify.Message{
+		Success: true,
+		Text:    fmt.Sprintf("%s#%d: boot test successful on %q", slug, issueNum, hosts),
+		URL:     gistURL,
+	})
+	return nil
 }
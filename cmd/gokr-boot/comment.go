@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gokrazy/autoupdate/internal/redact"
+	"github.com/google/go-github/v35/github"
+)
+
+// testbootCommand is the command with which pull request comments request a
+// boot test, optionally followed by the hostnames to test on.
+const testbootCommand = "/testboot"
+
+// parseTestboot returns the hostnames requested by a /testboot line in body
+// (none means all bakeries), and whether body contains such a line.
+func parseTestboot(body string) (hosts []string, ok bool) {
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == testbootCommand {
+			return fields[1:], true
+		}
+	}
+	return nil, false
+}
+
+// checkRequested returns an error naming the valid hostnames if any of the
+// requested hostnames is not one of bakeries.
+func checkRequested(requested, bakeries []string) error {
+	valid := make(map[string]bool)
+	for _, host := range bakeries {
+		valid[host] = true
+	}
+	var unknown []string
+	for _, host := range requested {
+		if !valid[host] {
+			unknown = append(unknown, host)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown hostnames %q requested, valid hostnames: %q", unknown, bakeries)
+	}
+	return nil
+}
+
+// commentAuthorized returns whether the author of a comment with the specified
+// author association (see the GitHub API) may request boot tests. Boot tests
+// run the pull request code on the bakeries, so drive-by users may not.
+func commentAuthorized(association string) bool {
+	switch association {
+	case "OWNER", "MEMBER", "COLLABORATOR":
+		return true
+	}
+	return false
+}
+
+// wantsTestComment returns the hostnames requested by ev, and whether ev is an
+// authorized /testboot command on a pull request.
+func wantsTestComment(ev *github.IssueCommentEvent) ([]string, bool) {
+	if ev.GetAction() != "created" || !ev.GetIssue().IsPullRequest() {
+		return nil, false
+	}
+	hosts, ok := parseTestboot(ev.GetComment().GetBody())
+	if !ok {
+		return nil, false
+	}
+	if !commentAuthorized(ev.GetComment().GetAuthorAssociation()) {
+		return nil, false
+	}
+	return hosts, true
+}
+
+// acknowledgeComment reacts to the comment with 👀 to signal that the boot
+// test was queued.
+func acknowledgeComment(ctx context.Context, client *github.Client, owner, repo string, commentID int64) error {
+	_, _, err := client.Reactions.CreateIssueCommentReaction(ctx, owner, repo, commentID, "eyes")
+	return err
+}
+
+// replyToComment answers the /testboot comment of ref with the result of the
+// boot test.
+func replyToComment(ctx context.Context, client *github.Client, ref pullRequestRef, testErr error) error {
+	result := "Boot test successful."
+	if errors.Is(testErr, errSkipped) {
+		result = fmt.Sprintf("Boot test skipped: %v", testErr)
+	} else if testErr != nil {
+		result = fmt.Sprintf("Boot test failed: %v", testErr)
+	}
+	body := fmt.Sprintf("@%s in reply to %s: %s", ref.commenter, ref.commentURL, result)
+	_, _, err := client.Issues.CreateComment(ctx, ref.owner, ref.repo, ref.issueNum, &github.IssueComment{
+		Body: github.String(redact.String(body)),
+	})
+	return err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTestboot(t *testing.T) {
+	for _, tt := range []struct {
+		body   string
+		want   []string
+		wantOK bool
+	}{
+		{body: "/testboot", want: []string{}, wantOK: true},
+		{body: "/testboot pi4 pi5", want: []string{"pi4", "pi5"}, wantOK: true},
+		{body: "looks good!\n  /testboot  pi5 \nthanks", want: []string{"pi5"}, wantOK: true},
+		{body: "please run /testboot pi5"},
+		{body: "/testbootpi5"},
+		{body: "LGTM"},
+		{body: ""},
+	} {
+		got, ok := parseTestboot(tt.body)
+		if ok != tt.wantOK || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseTestboot(%q) = %q, %v, want %q, %v", tt.body, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestCheckRequested(t *testing.T) {
+	bakeries := []string{"pi4", "pi5"}
+	for _, tt := range []struct {
+		requested []string
+		wantErr   string
+	}{
+		{requested: nil},
+		{requested: []string{"pi5"}},
+		{requested: []string{"pi4", "pi5"}},
+		{requested: []string{"pi6"}, wantErr: `unknown hostnames ["pi6"] requested, valid hostnames: ["pi4" "pi5"]`},
+		{requested: []string{"pi5", "pii4"}, wantErr: `unknown hostnames ["pii4"]`},
+	} {
+		err := checkRequested(tt.requested, bakeries)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("checkRequested(%q): %v", tt.requested, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("checkRequested(%q): got error %v, want error containing %q", tt.requested, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckHead(t *testing.T) {
+	for _, tt := range []struct {
+		want, got string
+		comment   bool
+		wantErr   string
+	}{
+		{want: "", got: "abc"},
+		{want: "abc", got: "abc"},
+		{want: "abc", got: "def", wantErr: "moved from abc to def since the boot test was requested"},
+		{want: "abc", got: "def", comment: true, wantErr: "comment /testboot again"},
+	} {
+		err := checkHead(tt.want, tt.got, tt.comment)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("checkHead(%q, %q, %v): %v", tt.want, tt.got, tt.comment, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !errors.Is(err, errSkipped) {
+			t.Errorf("checkHead(%q, %q, %v): got error %v, want skipped error containing %q", tt.want, tt.got, tt.comment, err, tt.wantErr)
+		}
+	}
+}
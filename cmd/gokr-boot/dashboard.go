@@ -0,0 +1,220 @@
+package main
+
+import (
+	"database/sql"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/history"
+)
+
+// chartDays is the number of days shown in the pass rate chart of each device.
+const chartDays = 14
+
+var dashboardTmpl = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"duration": func(seconds float64) time.Duration {
+		return time.Duration(seconds * float64(time.Second)).Round(time.Second)
+	},
+	"short": func(commit string) string {
+		if len(commit) > 12 {
+			return commit[:12]
+		}
+		return commit
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gokr-boot</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { padding: 0.2em 0.6em; text-align: left; }
+.success { color: #1a7f37; }
+.failure { color: #cf222e; }
+svg rect.success { fill: #1a7f37; }
+svg rect.failure { fill: #cf222e; }
+svg rect.none { fill: #ddd; }
+</style>
+</head>
+<body>
+{{ range .Repos }}
+<h1>{{ .Name }}</h1>
+{{ range .Devices }}
+<h2>{{ .Host }}: {{ printf "%.0f" .PassRate }}% passed ({{ .Runs }} runs)</h2>
+<svg width="{{ $.ChartWidth }}" height="40">
+{{ range $i, $d := .Days }}
+<rect x="{{ $d.X }}" y="{{ $d.Y }}" width="10" height="{{ $d.Height }}" class="{{ $d.Class }}"><title>{{ $d.Label }}</title></rect>
+{{ end }}
+</svg>
+<table>
+<tr><th>time</th><th>PR</th><th>commit</th><th>result</th><th>duration</th><th>log</th></tr>
+{{ range .Records }}
+<tr>
+<td>{{ .Time.Format "2006-01-02 15:04:05" }}</td>
+<td>{{ if .PR }}<a href="https://github.com/{{ .Repo }}/pull/{{ .PR }}">#{{ .PR }}</a>{{ end }}</td>
+<td><code>{{ short .Commit }}</code></td>
+<td class="{{ .Result }}" title="{{ .Error }}">{{ .Result }}</td>
+<td>{{ duration .DurationSeconds }}</td>
+<td><a href="log?id={{ .ID }}">inline</a>{{ if .LogURL }} <a href="{{ .LogURL }}">gist</a>{{ end }}</td>
+</tr>
+{{ end }}
+</table>
+{{ end }}
+{{ else }}
+<p>No boot tests within the last {{ .Since }}.</p>
+{{ end }}
+</body>
+</html>
+`))
+
+type dashboardDay struct {
+	X, Y, Height int
+	Class        string
+	Label        string
+}
+
+type dashboardDevice struct {
+	Host     string
+	Runs     int
+	PassRate float64
+	Days     []dashboardDay
+	Records  []history.Record // newest first
+}
+
+type dashboardRepo struct {
+	Name    string
+	Devices []*dashboardDevice
+}
+
+// passRateChart returns one bar per day, whose height is the pass rate of the
+// boot tests in records on that day.
+func passRateChart(records []history.Record, now time.Time) []dashboardDay {
+	type counts struct{ runs, passed int }
+	perDay := make([]counts, chartDays)
+	today := now.Truncate(24 * time.Hour)
+	for _, r := range records {
+		idx := chartDays - 1 - int(today.Sub(r.Time.Truncate(24*time.Hour))/(24*time.Hour))
+		if idx < 0 || idx >= chartDays {
+			continue
+		}
+		perDay[idx].runs++
+		if r.Result == "success" {
+			perDay[idx].passed++
+		}
+	}
+	days := make([]dashboardDay, chartDays)
+	for i, c := range perDay {
+		date := today.Add(-time.Duration(chartDays-1-i) * 24 * time.Hour).Format("2006-01-02")
+		d := dashboardDay{X: i * 12, Y: 38, Height: 2, Class: "none", Label: date + ": no runs"}
+		if c.runs > 0 {
+			d.Height = 4 + 36*c.passed/c.runs
+			d.Y = 40 - d.Height
+			d.Class = "success"
+			if c.passed < c.runs {
+				d.Class = "failure"
+			}
+			d.Label = date + ": " + strconv.Itoa(c.passed) + "/" + strconv.Itoa(c.runs) + " passed"
+		}
+		days[i] = d
+	}
+	return days
+}
+
+// dashboardHandler serves an overview of the boot tests recorded in the
+// -history_db within -history_since, grouped by repository and device, and
+// their boot logs.
+func dashboardHandler(dbPath string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		db, err := history.OpenDB(dbPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer db.Close()
+		now := time.Now()
+		records, err := db.Select(history.Query{Since: now.Add(-*historySince)})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		repos := make(map[string]map[string]*dashboardDevice)
+		for i := len(records) - 1; i >= 0; i-- {
+			rec := records[i]
+			devices, ok := repos[rec.Repo]
+			if !ok {
+				devices = make(map[string]*dashboardDevice)
+				repos[rec.Repo] = devices
+			}
+			dev, ok := devices[rec.Host]
+			if !ok {
+				dev = &dashboardDevice{Host: rec.Host}
+				devices[rec.Host] = dev
+			}
+			dev.Records = append(dev.Records, rec)
+		}
+		var data struct {
+			Repos      []dashboardRepo
+			ChartWidth int
+			Since      time.Duration
+		}
+		data.ChartWidth = chartDays * 12
+		data.Since = *historySince
+		for name, devices := range repos {
+			repo := dashboardRepo{Name: name}
+			for _, dev := range devices {
+				var passed int
+				for _, rec := range dev.Records {
+					if rec.Result == "success" {
+						passed++
+					}
+				}
+				dev.Runs = len(dev.Records)
+				dev.PassRate = 100 * float64(passed) / float64(dev.Runs)
+				dev.Days = passRateChart(dev.Records, now)
+				repo.Devices = append(repo.Devices, dev)
+			}
+			sort.Slice(repo.Devices, func(i, j int) bool {
+				return repo.Devices[i].Host < repo.Devices[j].Host
+			})
+			data.Repos = append(data.Repos, repo)
+		}
+		sort.Slice(data.Repos, func(i, j int) bool {
+			return data.Repos[i].Name < data.Repos[j].Name
+		})
+		if err := dashboardTmpl.Execute(w, data); err != nil {
+			log.Printf("rendering dashboard: %v", err)
+		}
+	})
+	mux.HandleFunc("/log", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.FormValue("id"), 0, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		db, err := history.OpenDB(dbPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer db.Close()
+		bootlog, err := db.BootLog(id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "no boot log recorded", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(bootlog))
+	})
+	return mux
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+)
+
+var (
+	deployments = flag.Bool("deployments",
+		false,
+		"model the boot test on each bakery as a GitHub deployment to the environment -deployment_environment_prefix<hostname>, for the environment timeline and protection rules")
+
+	deploymentEnvironmentPrefix = flag.String("deployment_environment_prefix",
+		"bakery-",
+		"prefix of the GitHub environment names of -deployments")
+)
+
+// deployment is a GitHub deployment of a boot test to one bakery. Deployments
+// are informational, so errors are only logged. A nil *deployment does
+// nothing.
+type deployment struct {
+	client      *github.Client
+	owner, repo string
+	id          int64
+}
+
+// startDeployment creates an in-progress deployment of sha to hostname if
+// -deployments is set.
+func startDeployment(ctx context.Context, client *github.Client, owner, repo, sha, hostname string) *deployment {
+	if !*deployments {
+		return nil
+	}
+	d, _, err := client.Repositories.CreateDeployment(ctx, owner, repo, &github.DeploymentRequest{
+		Ref:         github.String(sha),
+		Task:        github.String("boottest"),
+		AutoMerge:   github.Bool(false),
+		Environment: github.String(*deploymentEnvironmentPrefix + hostname),
+		Description: github.String("gokrazy boot test"),
+		// The boot test is what other checks wait for, not vice versa.
+		RequiredContexts:      &[]string{},
+		TransientEnvironment:  github.Bool(false),
+		ProductionEnvironment: github.Bool(false),
+	})
+	if err != nil {
+		log.Printf("creating deployment to %s: %v", hostname, err)
+		return nil
+	}
+	dep := &deployment{client: client, owner: owner, repo: repo, id: d.GetID()}
+	dep.update("in_progress", "", "boot testing")
+	return dep
+}
+
+// update sets the state (in_progress, success, failure or error) of d.
+func (d *deployment) update(state, logURL, description string) {
+	if d == nil {
+		return
+	}
+	// The main context might be cancelled already.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req := &github.DeploymentStatusRequest{
+		State:       github.String(state),
+		Description: github.String(description),
+	}
+	if logURL != "" {
+		req.LogURL = github.String(logURL)
+	}
+	if _, _, err := d.client.Repositories.CreateDeploymentStatus(ctx, d.owner, d.repo, d.id, req); err != nil {
+		log.Printf("setting deployment status: %v", err)
+	}
+}
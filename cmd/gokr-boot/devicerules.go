@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/google/go-github/v35/github"
+)
+
+var deviceRules = flag.String("device_rules",
+	"",
+	"if non-empty, path to a JSON file mapping changed paths to the hosts which need to be boot tested, e.g. {\"rules\": [{\"path\": \"config/pi5/\", \"hosts\": [\"pi5\"]}]}. Changes to paths matching no rule require all hosts")
+
+type deviceRule struct {
+	// Path is a path prefix, relative to the repository root.
+	Path string `json:"path"`
+
+	// Hosts lists the hostnames which need to be boot tested when a file
+	// below Path changes.
+	Hosts []string `json:"hosts"`
+}
+
+type deviceRuleConfig struct {
+	Rules []deviceRule `json:"rules"`
+}
+
+func readDeviceRules(path string) (*deviceRuleConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg deviceRuleConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func changedFiles(ctx context.Context, client *github.Client, owner, repo string, issueNum int) ([]string, error) {
+	var files []string
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := client.PullRequests.ListFiles(ctx, owner, repo, issueNum, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range page {
+			files = append(files, f.GetFilename())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return files, nil
+}
+
+// requiredHosts returns the hosts which need to be boot tested for the
+// specified changed files, or all=true if a changed file matches no rule and
+// therefore requires all hosts.
+func (c *deviceRuleConfig) requiredHosts(changed []string) (required map[string]bool, all bool) {
+	required = make(map[string]bool)
+	for _, fn := range changed {
+		matched := false
+		for _, rule := range c.Rules {
+			if !strings.HasPrefix(fn, rule.Path) {
+				continue
+			}
+			matched = true
+			for _, host := range rule.Hosts {
+				required[host] = true
+			}
+		}
+		if !matched {
+			log.Printf("%s matches no device rule, testing all hosts", fn)
+			return nil, true
+		}
+	}
+	return required, false
+}
+
+// filterHosts returns the hosts which are in required, in the order of hosts.
+func filterHosts(hosts []string, required map[string]bool) []string {
+	var result []string
+	for _, host := range hosts {
+		if required[host] {
+			result = append(result, host)
+		}
+	}
+	return result
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRequiredHosts(t *testing.T) {
+	rules := &deviceRuleConfig{
+		Rules: []deviceRule{
+			{Path: "config/pi5/", Hosts: []string{"pi5"}},
+			{Path: "config/pi4/", Hosts: []string{"pi4", "pi4b"}},
+			{Path: "docs/"},
+		},
+	}
+	bakeries := []string{"pi4", "pi4b", "pi5"}
+	for _, tt := range []struct {
+		name    string
+		changed []string
+		want    []string
+		all     bool
+	}{
+		{
+			name:    "single rule",
+			changed: []string{"config/pi5/config.json"},
+			want:    []string{"pi5"},
+		},
+		{
+			name:    "several rules",
+			changed: []string{"config/pi5/config.json", "config/pi4/config.json"},
+			want:    []string{"pi4", "pi4b", "pi5"},
+		},
+		{
+			name:    "unmatched file requires all hosts",
+			changed: []string{"config/pi5/config.json", "go.mod"},
+			all:     true,
+		},
+		{
+			name:    "rule without hosts",
+			changed: []string{"docs/README.md"},
+		},
+		{
+			name: "no changes",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			required, all := rules.requiredHosts(tt.changed)
+			if all != tt.all {
+				t.Fatalf("requiredHosts(%q): all = %v, want %v", tt.changed, all, tt.all)
+			}
+			if all {
+				return
+			}
+			if got := filterHosts(bakeries, required); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requiredHosts(%q) = %q, want %q", tt.changed, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v35/github"
+	"golang.org/x/mod/modfile"
+)
+
+var (
+	downstreamRepos = flag.String("downstream_repos",
+		"",
+		"comma-separated list of dependent repositories in which to trigger a follow-up test after a successful boot test: owner/repo sends a repository_dispatch event (see -downstream_event), owner/repo:workflow.yml[@ref] dispatches the workflow (on the default branch unless @ref is given) with the inputs module, version and pull_request")
+
+	downstreamEvent = flag.String("downstream_event",
+		"gokrazy-boot-tested",
+		"event type of the repository_dispatch events sent to -downstream_repos")
+)
+
+// testedModule describes the boot tested pull request head to downstream
+// repositories, e.g. for go get module@version.
+type testedModule struct {
+	Module      string `json:"module,omitempty"` // empty if there is no go.mod
+	Version     string `json:"version"`          // commit hash
+	PullRequest string `json:"pull_request"`     // owner/repo#number
+}
+
+// modulePath returns the module path declared in the go.mod file of owner/repo
+// at ref, or "" if there is none.
+func modulePath(ctx context.Context, client *github.Client, owner, repo, ref string) string {
+	f, _, _, err := client.Repositories.GetContents(ctx, owner, repo, "go.mod", &github.RepositoryContentGetOptions{Ref: ref})
+	if err != nil {
+		log.Printf("reading go.mod of %s/%s: %v", owner, repo, err)
+		return ""
+	}
+	content, err := f.GetContent()
+	if err != nil {
+		log.Printf("reading go.mod of %s/%s: %v", owner, repo, err)
+		return ""
+	}
+	return modfile.ModulePath([]byte(content))
+}
+
+// triggerDownstream triggers the -downstream_repos follow-up tests of the boot
+// tested commit headSHA. Failures are only logged, the boot test succeeded.
+func triggerDownstream(ctx context.Context, client *github.Client, owner, repo string, issueNum int, headSHA string) {
+	if *downstreamRepos == "" {
+		return
+	}
+	tested := testedModule{
+		Module:      modulePath(ctx, client, owner, repo, headSHA),
+		Version:     headSHA,
+		PullRequest: owner + "/" + repo + "#" + strconv.Itoa(issueNum),
+	}
+	for _, target := range strings.Split(*downstreamRepos, ",") {
+		if err := dispatch(ctx, client, target, tested); err != nil {
+			log.Printf("triggering %s: %v", target, err)
+			continue
+		}
+		log.Printf("triggered follow-up test in %s", target)
+	}
+}
+
+func dispatch(ctx context.Context, client *github.Client, target string, tested testedModule) error {
+	target, workflow, isWorkflow := strings.Cut(target, ":")
+	owner, repo, ok := strings.Cut(target, "/")
+	if !ok {
+		return fmt.Errorf("malformed repository %q, expected owner/repo", target)
+	}
+	if !isWorkflow {
+		b, err := json.Marshal(tested)
+		if err != nil {
+			return err
+		}
+		payload := json.RawMessage(b)
+		_, _, err = client.Repositories.Dispatch(ctx, owner, repo, github.DispatchRequestOptions{
+			EventType:     *downstreamEvent,
+			ClientPayload: &payload,
+		})
+		return err
+	}
+	workflow, ref, hasRef := strings.Cut(workflow, "@")
+	if !hasRef {
+		r, _, err := client.Repositories.Get(ctx, owner, repo)
+		if err != nil {
+			return err
+		}
+		ref = r.GetDefaultBranch()
+	}
+	_, err := client.Actions.CreateWorkflowDispatchEventByFileName(ctx, owner, repo, workflow, github.CreateWorkflowDispatchEventRequest{
+		Ref: ref,
+		Inputs: map[string]interface{}{
+			"module":       tested.Module,
+			"version":      tested.Version,
+			"pull_request": tested.PullRequest,
+		},
+	})
+	return err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+var logRecipients = flag.String("log_recipients",
+	"",
+	"if non-empty, comma-separated list of age recipients (age1…) to whom boot and build logs are encrypted before uploading them, so that only the holders of the identities can read them (age -d -i key.txt). The SBOM and provenance are uploaded unencrypted")
+
+// recipients are the parsed -log_recipients.
+var recipients []age.Recipient
+
+// parseLogRecipients parses -log_recipients, so that invalid recipients are
+// reported before boot testing instead of when uploading the logs.
+func parseLogRecipients() error {
+	if *logRecipients == "" {
+		return nil
+	}
+	var err error
+	recipients, err = age.ParseRecipients(strings.NewReader(strings.ReplaceAll(*logRecipients, ",", "\n")))
+	if err != nil {
+		return fmt.Errorf("-log_recipients: %v", err)
+	}
+	return nil
+}
+
+// sealLog encrypts content (named filename) to -log_recipients, if set. It
+// returns the file name and contents to upload.
+func sealLog(filename, content string) (string, string, error) {
+	if len(recipients) == 0 {
+		return filename, content, nil
+	}
+	var buf bytes.Buffer
+	// ASCII armor keeps the log displayable as text by the forge.
+	aw := armor.NewWriter(&buf)
+	w, err := age.Encrypt(aw, recipients...)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		return "", "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", "", err
+	}
+	if err := aw.Close(); err != nil {
+		return "", "", err
+	}
+	return filename + ".age", buf.String(), nil
+}
+
+// logNote returns a remark for comments linking to logs.
+func logNote() string {
+	if len(recipients) == 0 {
+		return ""
+	}
+	return " (age-encrypted)"
+}
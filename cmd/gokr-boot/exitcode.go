@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"github.com/gokrazy/autoupdate/pkg/boottest"
+)
+
+// Exit codes of the test, build, upload and report subcommands, so that CI
+// pipelines can branch on the kind of failure (e.g. retry only infrastructure
+// errors). Invalid flags and interruptions exit with exit code 1. The status
+// subcommand keeps its own exit codes.
+const (
+	exitSuccess      = 0
+	exitSkipped      = 2 // the pull request does not carry -require_label
+	exitBuildFailure = 3 // gok failed to build the images
+	exitBootFailure  = 4 // the images did not boot, or failed the probes
+	exitInfraError   = 5 // bootery, GitHub or other infrastructure error
+)
+
+// errSkipped is returned by testPullRequest when the pull request does not
+// carry -require_label.
+var errSkipped = errors.New("pull request not labeled for boot testing, skipping")
+
+// exitCode returns the exit code for err.
+func exitCode(err error) int {
+	var (
+		buildErr *boottest.BuildError
+		bootErr  *boottest.BootError
+	)
+	switch {
+	case err == nil:
+		return exitSuccess
+	case errors.Is(err, errSkipped):
+		return exitSkipped
+	case errors.As(err, &buildErr):
+		return exitBuildFailure
+	case errors.As(err, &bootErr):
+		return exitBootFailure
+	default:
+		return exitInfraError
+	}
+}
+
+// fatal logs err and exits with the exit code for its kind, after writing the
+// -junit_xml report.
+func fatal(err error) {
+	log.Output(2, err.Error())
+	writeJUnit()
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/go-github/v35/github"
+)
+
+var (
+	trustedUsers = flag.String("trusted_users",
+		"",
+		"comma-separated list of GitHub users whose pull requests from forks are boot tested without -fork_override_label, in addition to users with write permission on the repository")
+
+	forkOverrideLabel = flag.String("fork_override_label",
+		"",
+		"name of a GitHub label with which maintainers allow boot testing a pull request from a fork by an untrusted author. Without it, such pull requests are never boot tested. The label approves the commits it was applied to: pushing new commits removes it")
+)
+
+// authorizeFork returns an error wrapping errSkipped if pr comes from a fork
+// and neither its author is trusted (-trusted_users or write permission on
+// the repository) nor a maintainer applied -fork_override_label. Boot tests
+// run the pull request code on hardware in the bakery’s network.
+func authorizeFork(ctx context.Context, client *github.Client, owner, repo string, issueNum int) error {
+	pr, _, err := client.PullRequests.Get(ctx, owner, repo, issueNum)
+	if err != nil {
+		return err
+	}
+	if pr.GetHead().GetRepo().GetFullName() == pr.GetBase().GetRepo().GetFullName() {
+		return nil
+	}
+	author := pr.GetUser().GetLogin()
+	for _, u := range strings.Split(*trustedUsers, ",") {
+		if u != "" && strings.EqualFold(u, author) {
+			return nil
+		}
+	}
+	level, _, err := client.Repositories.GetPermissionLevel(ctx, owner, repo, author)
+	if err != nil {
+		return err
+	}
+	switch level.GetPermission() {
+	case "admin", "maintain", "write":
+		return nil
+	}
+	if *forkOverrideLabel != "" {
+		for _, l := range pr.Labels {
+			if l.GetName() == *forkOverrideLabel {
+				log.Printf("pull request from fork by untrusted %s, allowed by label %q", author, *forkOverrideLabel)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: pull request comes from a fork by untrusted user %s (permission %q), which requires label %q", errSkipped, author, level.GetPermission(), *forkOverrideLabel)
+}
+
+// revokeForkOverride removes -fork_override_label from pr if it comes from a
+// fork. It is called when new commits were pushed: maintainers approve the
+// commits they reviewed, not whatever the author pushes afterwards.
+func revokeForkOverride(ctx context.Context, client *github.Client, owner, repo string, pr *github.PullRequest) error {
+	if *forkOverrideLabel == "" || pr.GetHead().GetRepo().GetFullName() == pr.GetBase().GetRepo().GetFullName() {
+		return nil
+	}
+	for _, l := range pr.Labels {
+		if l.GetName() != *forkOverrideLabel {
+			continue
+		}
+		log.Printf("new commits pushed to pull request %s/%s#%d from a fork, removing label %q", owner, repo, pr.GetNumber(), *forkOverrideLabel)
+		_, err := client.Issues.RemoveLabelForIssue(ctx, owner, repo, pr.GetNumber(), *forkOverrideLabel)
+		return err
+	}
+	return nil
+}
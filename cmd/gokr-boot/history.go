@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/history"
+)
+
+var (
+	historyRepo = flag.String("history_repo",
+		"",
+		"if non-empty, history only shows results of this owner/repo")
+
+	historySince = flag.Duration("history_since",
+		30*24*time.Hour,
+		"history only shows results of boot tests which ran within this duration")
+)
+
+func insertResult(path string, rec history.Record) error {
+	db, err := history.OpenDB(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Insert(rec)
+}
+
+// showHistory prints the -history_db results matching -history_repo,
+// -hostname and -history_since, followed by the failure rate of each host.
+func showHistory(ctx context.Context) {
+	requireFlags("history_db")
+	db, err := history.OpenDB(*historyDB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	records, err := db.Select(history.Query{
+		Repo:  *historyRepo,
+		Host:  *hostname,
+		Since: time.Now().Add(-*historySince),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	type stats struct {
+		runs, failures int
+		duration       float64
+	}
+	byHost := make(map[string]*stats)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "TIME\tREPO\tPR\tCOMMIT\tHOST\tRESULT\tDURATION\tLOG\n")
+	for _, r := range records {
+		commit := r.Commit
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			r.Time.Format(time.RFC3339), r.Repo, r.PR, commit, r.Host, r.Result,
+			time.Duration(r.DurationSeconds*float64(time.Second)).Round(time.Second), r.LogURL)
+		s, ok := byHost[r.Host]
+		if !ok {
+			s = &stats{}
+			byHost[r.Host] = s
+		}
+		s.runs++
+		s.duration += r.DurationSeconds
+		if r.Result != "success" {
+			s.failures++
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		log.Fatal(err)
+	}
+
+	hosts := make([]string, 0, len(byHost))
+	for host := range byHost {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	fmt.Println()
+	tw = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "HOST\tRUNS\tFAILURES\tFAILURE RATE\tMEAN DURATION\n")
+	for _, host := range hosts {
+		s := byHost[host]
+		mean := time.Duration(s.duration / float64(s.runs) * float64(time.Second))
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f%%\t%s\n",
+			host, s.runs, s.failures, 100*float64(s.failures)/float64(s.runs), mean.Round(time.Second))
+	}
+	if err := tw.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var hooks = flag.String("hooks",
+	"",
+	"if non-empty, comma-separated list of hook=command, where hook is one of pre-build, post-build, pre-upload, post-test or on-failure. Each command is run with a JSON description of the boot test (hostname, image paths, boot log, error, repository and pull request) on stdin, e.g. to add extra files to the image or to notify internal systems. Failing pre-build, post-build and pre-upload hooks fail the boot test")
+
+// parseHooks parses the -hooks flag value s.
+func parseHooks(s string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		hook, command, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok || command == "" {
+			return nil, fmt.Errorf("-hooks: %q is not of the form hook=command", item)
+		}
+		result[hook] = command
+	}
+	return result, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/redact"
+	"github.com/google/renameio/v2"
+)
+
+var junitXML = flag.String("junit_xml",
+	"",
+	"if non-empty, path to which a JUnit XML report is written on exit, with one test suite per device and one test case per phase (build, boot, verify)")
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+
+	elapsed time.Duration
+}
+
+type junitTestSuites struct {
+	XMLName    xml.Name          `xml:"testsuites"`
+	TestSuites []*junitTestSuite `xml:"testsuite"`
+}
+
+// junitReport collects the phases reported by boottest.Options.OnPhase.
+type junitReport struct {
+	mu     sync.Mutex
+	suites []*junitTestSuite
+}
+
+var junit junitReport
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// failureType describes the kind of err for CI systems which group failures.
+func failureType(err error) string {
+	switch exitCode(err) {
+	case exitBuildFailure:
+		return "build failure"
+	case exitBootFailure:
+		return "boot failure"
+	default:
+		return "infrastructure error"
+	}
+}
+
+func (r *junitReport) record(hostname, phase string, duration time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var suite *junitTestSuite
+	for _, s := range r.suites {
+		if s.Name == hostname {
+			suite = s
+			break
+		}
+	}
+	if suite == nil {
+		suite = &junitTestSuite{Name: hostname}
+		r.suites = append(r.suites, suite)
+	}
+	tc := junitTestCase{
+		Name:      phase,
+		ClassName: hostname,
+		Time:      seconds(duration),
+	}
+	if err != nil {
+		text := redact.String(err.Error())
+		tc.Failure = &junitFailure{
+			Message: strings.SplitN(text, "\n", 2)[0],
+			Type:    failureType(err),
+			Text:    text,
+		}
+		suite.Failures++
+	}
+	suite.Tests++
+	suite.TestCases = append(suite.TestCases, tc)
+	suite.elapsed += duration
+	suite.Time = seconds(suite.elapsed)
+}
+
+// writeJUnit writes the -junit_xml report, if requested. A run which did not
+// test anything (e.g. because it was skipped) results in an empty report.
+func writeJUnit() {
+	if *junitXML == "" {
+		return
+	}
+	junit.mu.Lock()
+	defer junit.mu.Unlock()
+	b, err := xml.MarshalIndent(&junitTestSuites{TestSuites: junit.suites}, "", "\t")
+	if err != nil {
+		log.Printf("writing JUnit report: %v", err)
+		return
+	}
+	b = append([]byte(xml.Header), append(b, '\n')...)
+	if err := renameio.WriteFile(*junitXML, b, 0644); err != nil {
+		log.Printf("writing JUnit report: %v", err)
+	}
+}
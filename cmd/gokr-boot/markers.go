@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/gokrazy/autoupdate/internal/forge"
+	"github.com/google/go-github/v35/github"
+)
+
+var (
+	skipMarker  = regexp.MustCompile(`(?i)\[(skip boot|boot skip)\]`)
+	scopeMarker = regexp.MustCompile(`(?i)\[boot:\s*([^\]]*)\]`)
+)
+
+// parseMarkers returns whether text (a commit message or pull request
+// description) contains a [skip boot] marker, and the hostnames of a
+// [boot: rpi5 only] or [boot: rpi4, rpi5] marker.
+func parseMarkers(text string) (skip bool, hosts []string) {
+	if skipMarker.MatchString(text) {
+		return true, nil
+	}
+	for _, m := range scopeMarker.FindAllStringSubmatch(text, -1) {
+		for _, f := range strings.FieldsFunc(m[1], func(r rune) bool { return r == ',' || r == ' ' }) {
+			if strings.EqualFold(f, "only") {
+				continue
+			}
+			hosts = append(hosts, f)
+		}
+	}
+	return false, hosts
+}
+
+// markers returns the markers (see parseMarkers) of the head commit message
+// (only on GitHub) and the description of pr.
+func markers(ctx context.Context, client *github.Client, owner, repo string, pr *forge.PullRequest) (skip bool, hosts []string, _ error) {
+	if !forge.IsGitHub() {
+		// Other forges do not expose the head commit message.
+		skip, hosts = parseMarkers(pr.Body)
+		return skip, hosts, nil
+	}
+	commit, _, err := client.Repositories.GetCommit(ctx, owner, repo, pr.HeadSHA)
+	if err != nil {
+		return false, nil, err
+	}
+	skip, hosts = parseMarkers(commit.GetCommit().GetMessage() + "\n" + pr.Body)
+	return skip, hosts, nil
+}
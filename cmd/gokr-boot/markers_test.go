@@ -0,0 +1,28 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMarkers(t *testing.T) {
+	for _, tt := range []struct {
+		text      string
+		wantSkip  bool
+		wantHosts []string
+	}{
+		{text: "update README"},
+		{text: "update README [skip boot]", wantSkip: true},
+		{text: "[Boot Skip] typo", wantSkip: true},
+		{text: "[skip boot] [boot: rpi5]", wantSkip: true},
+		{text: "tune config.txt [boot: rpi5 only]", wantHosts: []string{"rpi5"}},
+		{text: "[boot: rpi4, rpi5]", wantHosts: []string{"rpi4", "rpi5"}},
+		{text: "[BOOT:rpi4]\n\nalso [boot: rpi5]", wantHosts: []string{"rpi4", "rpi5"}},
+		{text: "[skip ci]"},
+	} {
+		skip, hosts := parseMarkers(tt.text)
+		if skip != tt.wantSkip || !reflect.DeepEqual(hosts, tt.wantHosts) {
+			t.Errorf("parseMarkers(%q) = %v, %q, want %v, %q", tt.text, skip, hosts, tt.wantSkip, tt.wantHosts)
+		}
+	}
+}
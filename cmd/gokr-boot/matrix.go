@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/gokrazy/autoupdate/internal/forge"
+	"github.com/gokrazy/autoupdate/internal/redact"
+)
+
+var kernels = flag.String("kernels",
+	"",
+	"if non-empty, comma-separated list of kernel packages (e.g. github.com/gokrazy/kernel,github.com/gokrazy/kernel.rpi) to boot test in one run, each on the bakeries whose board matches, or on the bakery given as package=hostname. The results are aggregated in one pull request comment")
+
+func kernelList() []string {
+	if *kernels == "" {
+		return nil
+	}
+	return strings.Split(*kernels, ",")
+}
+
+// matrixRow is the result of the boot test of one host with -kernels.
+type matrixRow struct {
+	host    string
+	kernel  string
+	err     error
+	logURL  string
+	details string // Markdown, for successful boot tests
+}
+
+// matrixComment returns a pull request comment with a table of the results of
+// all hosts, followed by the details of each host.
+func matrixComment(rows []matrixRow) string {
+	var b strings.Builder
+	b.WriteString("| Device | Kernel | Result | Log |\n")
+	b.WriteString("|---|---|---|---|\n")
+	for _, r := range rows {
+		result := "✅ success"
+		if r.err != nil {
+			msg := strings.SplitN(r.err.Error(), "\n", 2)[0]
+			result = "❌ " + strings.ReplaceAll(msg, "|", `\|`)
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | [log](%s) |\n", r.host, r.kernel, result, r.logURL)
+	}
+	for _, r := range rows {
+		if r.details == "" {
+			continue
+		}
+		fmt.Fprintf(&b, "\n### %s\n\n%s\n", r.host, r.details)
+	}
+	return b.String()
+}
+
+func addMatrixComment(ctx context.Context, f forge.Forge, owner, repo string, issueNum int, rows []matrixRow) error {
+	_, err := f.Comment(ctx, owner, repo, issueNum, redact.String(matrixComment(rows)))
+	return err
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/gokrazy/autoupdate/internal/notify"
+	"github.com/gokrazy/autoupdate/internal/redact"
+)
+
+var notifyConfig = flag.String("notify_config",
+	"",
+	"if non-empty, path to a JSON file configuring chat notification backends for boot test results, e.g. {\"slack\": {\"webhook_url_env\": \"SLACK_WEBHOOK_URL\"}}")
+
+var notifiers []notify.Notifier
+
+func setupNotifiers(path string) error {
+	cfg, err := notify.ReadConfig(path)
+	if err != nil {
+		return err
+	}
+	for _, name := range cfg.SecretEnv() {
+		redact.Add(os.Getenv(name), "<"+name+">")
+	}
+	notifiers, err = cfg.Notifiers()
+	return err
+}
+
+// notifyResult sends msg to all configured backends. Notification failures
+// are logged, but do not change the outcome of the boot test.
+func notifyResult(ctx context.Context, msg notify.Message) {
+	msg.Text = redact.String(msg.Text)
+	for _, n := range notifiers {
+		if err := n.Notify(ctx, msg); err != nil {
+			log.Printf("notifying %T: %v", n, err)
+		}
+	}
+}
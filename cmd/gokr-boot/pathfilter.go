@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/gokrazy/autoupdate/internal/pathmatch"
+	"github.com/google/go-github/v35/github"
+)
+
+var (
+	pathsInclude = flag.String("paths_include",
+		"",
+		"if non-empty, comma-separated list of path patterns (path.Match syntax, or a directory prefix ending in /) relevant to the boot test. Pull requests changing no relevant file are not boot tested")
+
+	pathsExclude = flag.String("paths_exclude",
+		"",
+		"comma-separated list of path patterns (see -paths_include) which are not relevant to the boot test, e.g. docs/,*.md")
+)
+
+// errIrrelevant is returned (wrapping errSkipped) when no relevant file changed.
+var errIrrelevant = fmt.Errorf("%w: no file relevant to the boot test changed", errSkipped)
+
+// relevantChange returns whether the pull request changes a file which is
+// relevant to the boot test according to -paths_include and -paths_exclude.
+func relevantChange(ctx context.Context, client *github.Client, owner, repo string, issueNum int) (bool, error) {
+	if *pathsInclude == "" && *pathsExclude == "" {
+		return true, nil
+	}
+	changed, err := changedFiles(ctx, client, owner, repo, issueNum)
+	if err != nil {
+		return false, err
+	}
+	for _, fn := range changed {
+		if *pathsInclude != "" && !pathmatch.Match(*pathsInclude, fn) {
+			continue
+		}
+		if pathmatch.Match(*pathsExclude, fn) {
+			continue
+		}
+		log.Printf("%s is relevant to the boot test", fn)
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gokrazy/autoupdate/pkg/boottest"
+	"github.com/google/go-github/v35/github"
+)
+
+var phaseStatuses = flag.Bool("phase_statuses",
+	false,
+	"publish a commit status per phase as the boot test progresses: -status_context/build and -status_context/boot/<hostname>, so that branch protection can require them")
+
+// onPhase is the boottest.Options.OnPhase hook, which feeds the -junit_xml
+// report and the -phase_statuses.
+func onPhase(hostname, phase string, duration time.Duration, err error) {
+	if *junitXML != "" {
+		junit.record(hostname, phase, duration, err)
+	}
+	if *phaseStatuses {
+		statuses.record(hostname, phase, err)
+	}
+}
+
+// statusReporter publishes the -phase_statuses of the commit under test.
+type statusReporter struct {
+	mu          sync.Mutex
+	client      *github.Client
+	owner, repo string
+	sha         string
+	pending     map[string]bool // status contexts
+	built       map[string]bool // hostnames
+	buildFailed bool
+}
+
+var statuses statusReporter
+
+func buildContext() string { return *statusContext + "/build" }
+
+func bootContext(hostname string) string { return *statusContext + "/boot/" + hostname }
+
+// set publishes a status. Phase statuses are informational, so errors are
+// only logged.
+func (s *statusReporter) set(contextName, state, description string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := setStatus(ctx, newForge(s.client), s.owner, s.repo, s.sha, contextName, state, description, ""); err != nil {
+		log.Printf("setting commit status %s: %v", contextName, err)
+	}
+	if state == "pending" {
+		s.pending[contextName] = true
+	} else {
+		delete(s.pending, contextName)
+	}
+}
+
+// start marks the phases of the boot test of sha on hosts as pending.
+func (s *statusReporter) start(client *github.Client, owner, repo, sha string, hosts []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.client, s.owner, s.repo, s.sha = client, owner, repo, sha
+	s.pending = make(map[string]bool)
+	s.built = make(map[string]bool)
+	s.buildFailed = false
+	s.set(buildContext(), "pending", "building images")
+	for _, host := range hosts {
+		s.set(bootContext(host), "pending", "waiting for the build")
+	}
+}
+
+func (s *statusReporter) record(hostname, phase string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return // not started, e.g. in the upload subcommand
+	}
+	var buildErr *boottest.BuildError
+	switch phase {
+	case boottest.PhaseBuild:
+		s.recordBuild(hostname, err)
+	case boottest.PhaseBoot:
+		if !s.built[hostname] {
+			// With -stream, building is part of the boot phase.
+			if errors.As(err, &buildErr) {
+				s.recordBuild(hostname, err)
+				return
+			}
+			s.recordBuild(hostname, nil)
+		}
+		if err != nil {
+			s.set(bootContext(hostname), "failure", "boot test failed")
+		} else {
+			s.set(bootContext(hostname), "success", "booted")
+		}
+	case boottest.PhaseVerify:
+		if err != nil {
+			s.set(bootContext(hostname), "failure", "verification failed")
+		} else {
+			s.set(bootContext(hostname), "success", "booted and verified")
+		}
+	}
+}
+
+func (s *statusReporter) recordBuild(hostname string, err error) {
+	s.built[hostname] = true
+	if err != nil {
+		s.buildFailed = true
+		s.set(buildContext(), "failure", fmt.Sprintf("building images for %s failed", hostname))
+		s.set(bootContext(hostname), "failure", "not booted, the build failed")
+		return
+	}
+	if !s.buildFailed {
+		s.set(buildContext(), "success", fmt.Sprintf("built images for %s", hostname))
+	}
+	s.set(bootContext(hostname), "pending", "booting")
+}
+
+// finish marks the phases which did not complete (e.g. because of an
+// infrastructure error) as errored.
+func (s *statusReporter) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return
+	}
+	for contextName := range s.pending {
+		s.set(contextName, "error", "boot test aborted")
+	}
+	s.client = nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gokrazy/autoupdate/internal/cienv"
+	"github.com/gokrazy/autoupdate/internal/forge"
+	"github.com/gokrazy/autoupdate/pkg/boottest"
+)
+
+var (
+	provenance = flag.Bool("provenance",
+		false,
+		"attach an in-toto statement with SLSA provenance (image digests, source commit, builder, kernel and firmware modules) to the boot log gist. build writes it to -image_dir/provenance.intoto.json, next to the images it describes, from which report picks it up. Not supported with -stream_images or -qemu")
+
+	provenanceBuilderID = flag.String("provenance_builder_id",
+		"https://github.com/gokrazy/autoupdate/cmd/gokr-boot",
+		"builder identity to record in the -provenance statement, e.g. the URL of the CI workflow which runs gokr-boot")
+
+	provenanceCosign = flag.String("provenance_cosign",
+		"",
+		"if non-empty, path to the cosign binary with which to sign the -provenance statement (keyless, using the OIDC identity of the CI run). The Sigstore bundle is attached as provenance.sigstore.json; verify it with cosign verify-blob --bundle")
+)
+
+// provenanceSource describes the commit sha of owner/repo as the source of
+// images built for ref (e.g. refs/pull/12/head, if known) in this CI run.
+func provenanceSource(owner, repo, sha, ref string) boottest.ProvenanceSource {
+	return boottest.ProvenanceSource{
+		Repository:   forge.RepositoryURL(owner, repo),
+		Commit:       sha,
+		Ref:          ref,
+		BuilderID:    *provenanceBuilderID,
+		InvocationID: cienv.RunURL(),
+	}
+}
+
+// provenanceFiles returns the provenance statement of the images of hostname
+// and, with -provenance_cosign, its Sigstore bundle, by file name.
+func provenanceFiles(ctx context.Context, bt *boottest.BootTester, hostname string, digests map[string]string, src boottest.ProvenanceSource) (map[string]string, error) {
+	statement, err := bt.Provenance(hostname, digests, src)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]string{
+		"provenance.intoto.json": string(statement),
+	}
+	if *provenanceCosign == "" {
+		return files, nil
+	}
+	bundle, err := signBlob(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+	files["provenance.sigstore.json"] = string(bundle)
+	return files, nil
+}
+
+// signBlob signs b with cosign sign-blob and returns the Sigstore bundle.
+func signBlob(ctx context.Context, b []byte) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "gokr-boot-provenance")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	blob := filepath.Join(dir, "provenance.intoto.json")
+	if err := ioutil.WriteFile(blob, b, 0644); err != nil {
+		return nil, err
+	}
+	bundle := filepath.Join(dir, "provenance.sigstore.json")
+	cmd := exec.CommandContext(ctx, *provenanceCosign, "sign-blob", "--yes", "--bundle", bundle, blob)
+	cmd.Stderr = os.Stderr
+	// The signature itself is in the bundle.
+	cmd.Stdout = ioutil.Discard
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v: %v", cmd.Args, err)
+	}
+	return ioutil.ReadFile(bundle)
+}
+
+// writeProvenance writes the provenance files of the images in -image_dir,
+// which build just built for -hostname, into -image_dir. The source is the
+// commit under test according to the CI environment.
+func writeProvenance(ctx context.Context, bt *boottest.BootTester) error {
+	digests, err := boottest.ImageDigests(*imageDir)
+	if err != nil {
+		return err
+	}
+	owner, repo, _ := strings.Cut(cienv.MustGetSlug(), "/")
+	var sha, branch string
+	if hp, ok := cienv.Detected().(cienv.HeadProvider); ok {
+		sha, branch = hp.Head()
+	}
+	if sha == "" {
+		return fmt.Errorf("-provenance: commit under test unknown: no CI system detected which provides it")
+	}
+	var ref string
+	if branch != "" {
+		ref = "refs/heads/" + branch
+	}
+	files, err := provenanceFiles(ctx, bt, *hostname, digests, provenanceSource(owner, repo, sha, ref))
+	if err != nil {
+		return err
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(*imageDir, name), []byte(content), 0644); err != nil {
+			return err
+		}
+		log.Printf("wrote %s", filepath.Join(*imageDir, name))
+	}
+	return nil
+}
+
+// readProvenance reads the provenance files which build wrote into dir, by
+// file name. The Sigstore bundle is optional.
+func readProvenance(dir string) (map[string]string, error) {
+	files := make(map[string]string)
+	for _, name := range []string{"provenance.intoto.json", "provenance.sigstore.json"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			if os.IsNotExist(err) && name != "provenance.intoto.json" {
+				continue
+			}
+			return nil, err
+		}
+		files[name] = string(b)
+	}
+	return files, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"strings"
+
+	"github.com/gokrazy/autoupdate/internal/cienv"
+	"github.com/google/go-github/v35/github"
+)
+
+var (
+	gusServer = flag.String("gus_server",
+		"",
+		"URL of the GUS (gokrazy Update System) server to which publish pushes images")
+
+	publishSHA = flag.String("publish_sha",
+		"",
+		"commit of the target branch which publish publishes (default: the commit of the CI environment). It must have been boot tested successfully (-status_context), or be the merge commit of a pull request whose head was")
+)
+
+// bootVerified returns whether sha, or the head of the pull request merged as
+// sha, was boot tested successfully.
+func bootVerified(ctx context.Context, client *github.Client, owner, repo, sha string) (bool, error) {
+	tested, err := alreadyTested(ctx, newForge(client), owner, repo, sha, *statusContext)
+	if err != nil || tested {
+		return tested, err
+	}
+	prs, _, err := client.PullRequests.ListPullRequestsWithCommit(ctx, owner, repo, sha, nil)
+	if err != nil {
+		return false, err
+	}
+	for _, pr := range prs {
+		if pr.GetMergeCommitSHA() != sha {
+			continue
+		}
+		log.Printf("%s is the merge commit of pull request %d", sha, pr.GetNumber())
+		return alreadyTested(ctx, newForge(client), owner, repo, pr.GetHead().GetSHA(), *statusContext)
+	}
+	return false, nil
+}
+
+// publish pushes the images of the -hostname devices (a comma-separated list)
+// to -gus_server, after a successful boot test and merge. It marks the
+// published commit with the -status_context/gus commit status.
+func publish(ctx context.Context) {
+	requireFlags("bootery_url", "hostname", "gus_server")
+	loadCredentials()
+	slug = cienv.MustGetSlug()
+	parts := strings.Split(slug, "/")
+	if got, want := len(parts), 2; got != want {
+		log.Fatalf("unexpected number of /-separated parts in %q: got %d, want %d", slug, got, want)
+	}
+	owner, repo := parts[0], parts[1]
+
+	sha := *publishSHA
+	if sha == "" {
+		if hp, ok := cienv.Detected().(cienv.HeadProvider); ok {
+			sha, _ = hp.Head()
+		}
+	}
+	if sha == "" {
+		log.Fatal("commit to publish unknown, specify -publish_sha")
+	}
+
+	client := newClient()
+	verified, err := bootVerified(ctx, client, owner, repo, sha)
+	if err != nil {
+		fatal(err)
+	}
+	if !verified {
+		log.Fatalf("%s was not boot tested successfully (%s), not publishing", sha, *statusContext)
+	}
+
+	bt := newBootTester()
+	hosts := strings.Split(*hostname, ",")
+	for _, host := range hosts {
+		if err := bt.Publish(ctx, strings.TrimSpace(host), *gusServer); err != nil {
+			fatal(err)
+		}
+	}
+	if err := setStatus(ctx, newForge(client), owner, repo, sha, *statusContext+"/gus", "success", "published to GUS: "+strings.Join(hosts, ", "), *gusServer); err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var uploadRateLimit = flag.String("upload_rate_limit",
+	"",
+	"if non-empty, limit image uploads to the bootery to this rate, e.g. 5MiB/s or 500KB/s (units: B, KB, MB, GB, KiB, MiB, GiB; the /s is optional), so that boot tests against a bakery behind a slow line do not saturate its uplink")
+
+// byteUnits are the units which parseByteRate accepts, longest first so that
+// KiB is not mistaken for B.
+var byteUnits = []struct {
+	suffix string
+	factor float64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"KB", 1e3},
+	{"MB", 1e6},
+	{"GB", 1e9},
+	{"B", 1},
+}
+
+// parseByteRate parses a rate such as 5MiB/s into bytes per second. A number
+// without unit is in bytes per second.
+func parseByteRate(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.TrimSpace(s), "/s")
+	factor := 1.0
+	for _, u := range byteUnits {
+		if strings.HasSuffix(num, u.suffix) {
+			num, factor = strings.TrimSuffix(num, u.suffix), u.factor
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("invalid rate %q, expected e.g. 5MiB/s", s)
+	}
+	return int64(f * factor), nil
+}
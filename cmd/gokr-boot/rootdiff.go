@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/gokrazy/autoupdate/pkg/boottest"
+	"github.com/google/go-github/v35/github"
+)
+
+var (
+	rootfsManifests = flag.String("rootfs_manifests",
+		"",
+		"if non-empty, directory (e.g. a CI cache directory) holding the root file system listings of target branch builds. Pull request comments then summarize which files changed compared to the target branch")
+
+	rootfsBranch = flag.String("rootfs_branch",
+		"",
+		"if non-empty, build records the root file system listing in -rootfs_manifests and the image sizes in -image_sizes as the baseline of this branch (run on target branch builds)")
+)
+
+func rootfsManifestPath(branch, host string) string {
+	return filepath.Join(*rootfsManifests, branch, host+".json")
+}
+
+// recordRootManifest stores the root file system listing of the root image in
+// dir as the -rootfs_branch baseline for host.
+func recordRootManifest(ctx context.Context, dir, host string) error {
+	files, err := boottest.RootManifest(ctx, filepath.Join(dir, "root.img"))
+	if err != nil {
+		return err
+	}
+	return boottest.WriteRootManifest(rootfsManifestPath(*rootfsBranch, host), files)
+}
+
+// rootfsDiff compares files with the baseline of the target branch of the pull
+// request and returns a Markdown summary, or "" if there is no baseline.
+func rootfsDiff(ctx context.Context, client *github.Client, owner, repo string, issueNum int, host string, files []boottest.RootFile) (string, error) {
+	base, err := baseBranch(ctx, newForge(client), owner, repo, issueNum)
+	if err != nil {
+		return "", err
+	}
+	baseline, err := boottest.ReadRootManifest(rootfsManifestPath(base, host))
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Printf("no root file system listing of %s for %s, not comparing", base, host)
+			return "", nil
+		}
+		return "", err
+	}
+	diff := boottest.DiffRoot(baseline, files)
+	return diff.Markdown(fmt.Sprintf("Root file system of %s compared to %s", host, base)), nil
+}
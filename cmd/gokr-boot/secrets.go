@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/gokrazy/autoupdate/internal/redact"
+	"github.com/gokrazy/internal/config"
+)
+
+var redactEnv = flag.String("redact_env",
+	"",
+	"comma-separated list of environment variable names whose values are redacted from all output, in addition to the bootery URL, the GitHub auth token and the instance’s passwords")
+
+// registerSecrets registers everything gokr-boot knows to be secret with the
+// redact package.
+func registerSecrets() error {
+	redact.Add(*booteryURL, "<bootery_url>")
+	redact.Add(strings.TrimSuffix(*booteryURL, "/testboot"), "<bootery_url>")
+	redact.Add(authToken, "<auth_token>")
+	redact.Add(*resultWebhook, "<result_webhook>")
+	if *resultWebhookSecretEnv != "" {
+		redact.Add(os.Getenv(*resultWebhookSecretEnv), "<"+*resultWebhookSecretEnv+">")
+	}
+	if *redactEnv != "" {
+		for _, name := range strings.Split(*redactEnv, ",") {
+			name = strings.TrimSpace(name)
+			redact.Add(os.Getenv(name), "<"+name+">")
+		}
+	}
+
+	cfg, err := config.ReadFromFile()
+	if err != nil {
+		return err
+	}
+	if cfg.Update != nil {
+		redact.Add(cfg.Update.HTTPPassword, "<http_password>")
+	}
+	// Wifi credentials are typically configured as an extra file
+	// (/etc/wifi.json) of the github.com/gokrazy/wifi package.
+	for _, pc := range cfg.PackageConfig {
+		for _, contents := range pc.ExtraFileContents {
+			var wifi struct {
+				PSK string `json:"psk"`
+			}
+			if err := json.Unmarshal([]byte(contents), &wifi); err != nil {
+				continue
+			}
+			redact.Add(wifi.PSK, "<wifi_psk>")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/gokrazy/autoupdate/internal/redact"
+)
+
+var (
+	secretScan = flag.Bool("secret_scan",
+		true,
+		"scan boot and build logs for likely secrets (tokens, private keys, wifi PSKs, passwords in URLs) before uploading them, redact matches and note the redaction in the pull request comment")
+
+	secretPatterns = flag.String("secret_patterns",
+		"",
+		"if non-empty, file with additional -secret_scan patterns, one per line: a name, a tab and a regular expression. If the expression has a group named secret, only the group is redacted")
+)
+
+// patterns are the patterns of -secret_scan, see loadSecretPatterns.
+var patterns []redact.Pattern
+
+// loadSecretPatterns reads -secret_patterns, so that invalid patterns are
+// reported before boot testing instead of when uploading the logs.
+func loadSecretPatterns() error {
+	if !*secretScan {
+		return nil
+	}
+	patterns = redact.DefaultPatterns
+	if *secretPatterns == "" {
+		return nil
+	}
+	f, err := os.Open(*secretPatterns)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	extra, err := redact.ParsePatterns(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", *secretPatterns, err)
+	}
+	patterns = append(append([]redact.Pattern(nil), patterns...), extra...)
+	return nil
+}
+
+// scannedLogs maps the URLs of uploaded logs to what -secret_scan redacted
+// from them, for redactionNote.
+var scannedLogs = struct {
+	sync.Mutex
+	findings map[string][]redact.Finding
+}{findings: make(map[string][]redact.Finding)}
+
+func recordFindings(logURL string, findings []redact.Finding) {
+	if len(findings) == 0 {
+		return
+	}
+	scannedLogs.Lock()
+	defer scannedLogs.Unlock()
+	scannedLogs.findings[logURL] = append(scannedLogs.findings[logURL], findings...)
+}
+
+// redactionNote returns a paragraph to append to comments linking to logURL
+// if -secret_scan redacted anything from the log, or "" otherwise.
+func redactionNote(logURL string) string {
+	scannedLogs.Lock()
+	defer scannedLogs.Unlock()
+	summary := redact.Summary(scannedLogs.findings[logURL])
+	if summary == "" {
+		return ""
+	}
+	return fmt.Sprintf("\n\n:warning: %s were redacted from the log before publishing it. Check the device and instance config for leaked credentials.", summary)
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/workqueue"
+	"github.com/google/go-github/v35/github"
+)
+
+var (
+	listen = flag.String("listen",
+		":8037",
+		"[host]:port on which serve listens for GitHub webhook deliveries (and serves the queued boot tests as JSON at /api/queue), and on which serve and watch serve the -history_db dashboard at /dashboard/")
+
+	queueDB = flag.String("queue_db",
+		"",
+		"if non-empty, path to an SQLite database in which serve keeps the queued boot tests, so that they survive restarts (by default, in memory). Boot tests which were in progress when serve stopped are run again. serve requires gokr-boot to be built with -tags sqlite (requires cgo)")
+
+	repoPriorities = flag.String("repo_priorities",
+		"",
+		"if non-empty, comma-separated list of owner/repo=priority pairs, e.g. gokrazy/kernel=10. serve boot tests pull requests of repositories with a higher priority first (default priority 0), and takes turns among repositories of the same priority")
+
+	priorityAging = flag.Duration("priority_aging",
+		30*time.Minute,
+		"with -repo_priorities, raise the priority of a repository by one for every -priority_aging its oldest queued boot test has been waiting, so that low-priority repositories are not starved. 0 disables aging")
+
+	webhookSecretEnv = flag.String("webhook_secret_env",
+		"",
+		"name of an environment variable holding the secret configured for the GitHub webhook, with which serve validates deliveries")
+)
+
+type pullRequestRef struct {
+	owner, repo string
+	issueNum    int
+	sha         string // head commit of the event or when the comment was received
+
+	// For boot tests requested by a /testboot comment:
+	commentURL string
+	commenter  string
+	hosts      string // space-separated, empty for all bakeries
+}
+
+func (r pullRequestRef) String() string {
+	return fmt.Sprintf("%s/%s#%d", r.owner, r.repo, r.issueNum)
+}
+
+// testKind is the workqueue item kind of boot tests. serve queues nothing
+// else: merges happen outside of serve, when CI runs gokr-merge on the pull
+// request labeled -set_label after its boot test.
+const testKind = "boottest"
+
+// refPayload holds the fields of a pullRequestRef which are not part of its
+// workqueue key.
+type refPayload struct {
+	CommentURL string `json:"comment_url,omitempty"`
+	Commenter  string `json:"commenter,omitempty"`
+}
+
+func refFromItem(it *workqueue.Item) (pullRequestRef, error) {
+	var payload refPayload
+	if err := json.Unmarshal([]byte(it.Payload), &payload); err != nil {
+		return pullRequestRef{}, fmt.Errorf("queue item %d: %v", it.ID, err)
+	}
+	owner, repo, _ := strings.Cut(it.Repo, "/")
+	return pullRequestRef{
+		owner:      owner,
+		repo:       repo,
+		issueNum:   it.PR,
+		sha:        it.SHA,
+		commentURL: payload.CommentURL,
+		commenter:  payload.Commenter,
+		hosts:      it.Device,
+	}, nil
+}
+
+// testQueue holds the pull requests waiting for a boot test, in -queue_db if
+// set. A pull request is queued at most once per head commit and hosts, no
+// matter how many events arrive while it waits.
+type testQueue struct {
+	q *workqueue.Queue
+}
+
+// parsePriorities parses the -repo_priorities flag value s.
+func parsePriorities(s string) (map[string]int, error) {
+	priorities := make(map[string]int)
+	for _, item := range strings.Split(s, ",") {
+		slug, priority, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return nil, fmt.Errorf("-repo_priorities: %q is not of the form owner/repo=priority", item)
+		}
+		n, err := strconv.Atoi(priority)
+		if err != nil {
+			return nil, fmt.Errorf("-repo_priorities: %v", err)
+		}
+		priorities[slug] = n
+	}
+	return priorities, nil
+}
+
+func newTestQueue() (*testQueue, error) {
+	q, err := workqueue.Open(*queueDB)
+	if err != nil {
+		return nil, err
+	}
+	if *repoPriorities != "" {
+		priorities, err := parsePriorities(*repoPriorities)
+		if err != nil {
+			return nil, err
+		}
+		q.SetSchedule(workqueue.Schedule{
+			Priorities: priorities,
+			Aging:      *priorityAging,
+		})
+	}
+	return &testQueue{q: q}, nil
+}
+
+func (q *testQueue) enqueue(ref pullRequestRef) error {
+	payload, err := json.Marshal(refPayload{
+		CommentURL: ref.commentURL,
+		Commenter:  ref.commenter,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = q.q.Enqueue(workqueue.Key{
+		Kind:   testKind,
+		Repo:   ref.owner + "/" + ref.repo,
+		PR:     ref.issueNum,
+		SHA:    ref.sha,
+		Device: ref.hosts,
+	}, string(payload))
+	return err
+}
+
+// next returns the next pull request to boot test and the ID to pass to done
+// once the boot test finished.
+func (q *testQueue) next(ctx context.Context) (pullRequestRef, int64, error) {
+	it, err := q.q.Next(ctx, testKind)
+	if err != nil {
+		return pullRequestRef{}, 0, err
+	}
+	ref, err := refFromItem(it)
+	return ref, it.ID, err
+}
+
+// done removes the boot test returned by next from the queue.
+func (q *testQueue) done(id int64) error {
+	return q.q.Done(id)
+}
+
+// queueEntry describes a queued or running boot test in the /api/queue
+// response.
+type queueEntry struct {
+	Repo      string `json:"repo"` // owner/repo
+	PR        int    `json:"pr"`
+	Hosts     string `json:"hosts,omitempty"`
+	Commenter string `json:"commenter,omitempty"`
+}
+
+// queueHandler serves the queued and running boot tests as JSON, e.g. for
+// gokr-autoupdated.
+func queueHandler(q *testQueue) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		items, err := q.q.List(testKind)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var resp struct {
+			Queued  []queueEntry `json:"queued"`
+			Running *queueEntry  `json:"running"`
+		}
+		for i := range items {
+			ref, err := refFromItem(&items[i])
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			e := queueEntry{
+				Repo:      items[i].Repo,
+				PR:        ref.issueNum,
+				Hosts:     ref.hosts,
+				Commenter: ref.commenter,
+			}
+			if items[i].Running {
+				resp.Running = &e
+			} else {
+				resp.Queued = append(resp.Queued, e)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("encoding queue: %v", err)
+		}
+	})
+}
+
+// wantsTest returns whether ev should trigger a boot test: either
+// -require_label was just added, or new commits were pushed to a pull request
+// carrying -require_label.
+func wantsTest(ev *github.PullRequestEvent) bool {
+	switch ev.GetAction() {
+	case "labeled":
+		return ev.GetLabel().GetName() == *requireLabel
+	case "opened", "reopened", "synchronize":
+		for _, l := range ev.GetPullRequest().Labels {
+			if l.GetName() == *requireLabel {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func webhookHandler(secret []byte, client *github.Client, queue *testQueue) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, err := github.ValidatePayload(r, secret)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		event, err := github.ParseWebHook(github.WebHookType(r), payload)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var (
+			ref       pullRequestRef
+			reason    string
+			commentID int64 // to acknowledge, for /testboot comments
+		)
+		switch ev := event.(type) {
+		case *github.PullRequestEvent:
+			if ev.GetAction() == "synchronize" {
+				owner, repo := ev.GetRepo().GetOwner().GetLogin(), ev.GetRepo().GetName()
+				if err := revokeForkOverride(r.Context(), client, owner, repo, ev.GetPullRequest()); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			if !wantsTest(ev) {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			ref = pullRequestRef{
+				owner:    ev.GetRepo().GetOwner().GetLogin(),
+				repo:     ev.GetRepo().GetName(),
+				issueNum: ev.GetNumber(),
+				sha:      ev.GetPullRequest().GetHead().GetSHA(),
+			}
+			reason = ev.GetAction()
+		case *github.IssueCommentEvent:
+			hosts, ok := wantsTestComment(ev)
+			if !ok {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			// The event does not carry the head commit, which the
+			// comment approves for boot testing.
+			owner, repo := ev.GetRepo().GetOwner().GetLogin(), ev.GetRepo().GetName()
+			pr, _, err := client.PullRequests.Get(r.Context(), owner, repo, ev.GetIssue().GetNumber())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			ref = pullRequestRef{
+				owner:      owner,
+				repo:       repo,
+				issueNum:   ev.GetIssue().GetNumber(),
+				sha:        pr.GetHead().GetSHA(),
+				commentURL: ev.GetComment().GetHTMLURL(),
+				commenter:  ev.GetComment().GetUser().GetLogin(),
+				hosts:      strings.Join(hosts, " "),
+			}
+			reason = testbootCommand + " by " + ref.commenter
+			commentID = ev.GetComment().GetID()
+		default:
+			// Not interested, e.g. a ping event.
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err := queue.enqueue(ref); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("queued %s (%s)", ref, reason)
+		if commentID != 0 {
+			if err := acknowledgeComment(r.Context(), client, ref.owner, ref.repo, commentID); err != nil {
+				log.Printf("acknowledging comment: %v", err)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// listenAndServe serves handler on -listen until ctx is cancelled.
+func listenAndServe(ctx context.Context, handler http.Handler) {
+	srv := &http.Server{
+		Addr:    *listen,
+		Handler: handler,
+	}
+	go func() {
+		<-ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// serve runs an HTTP server receiving GitHub pull_request webhook events (and
+// issue_comment events with /testboot commands) and boot tests the pull
+// requests they refer to, one after the other.
+func serve(ctx context.Context) {
+	requireLabelFlags()
+	requireFlags("webhook_secret_env")
+	secret := os.Getenv(*webhookSecretEnv)
+	if secret == "" {
+		log.Fatalf("environment variable %s (from -webhook_secret_env) is empty", *webhookSecretEnv)
+	}
+	loadCredentials()
+
+	bt := newBootTester()
+	client := newClient()
+	queue, err := newTestQueue()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", webhookHandler([]byte(secret), client, queue))
+	mux.Handle("/api/queue", queueHandler(queue))
+	if *historyDB != "" {
+		mux.Handle("/dashboard/", http.StripPrefix("/dashboard", dashboardHandler(*historyDB)))
+	}
+	log.Printf("listening for webhook deliveries on %s", *listen)
+	go listenAndServe(ctx, mux)
+
+	for {
+		ref, id, err := queue.next(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("boot testing %s", ref)
+		req := testRequest{
+			hosts:   strings.Fields(ref.hosts),
+			comment: ref.commentURL != "",
+			sha:     ref.sha,
+		}
+		err = testPullRequest(ctx, bt, client, ref.owner, ref.repo, ref.issueNum, req)
+		if ctx.Err() != nil {
+			// Interrupted: the boot test stays in -queue_db and
+			// is resumed by the next serve.
+			return
+		}
+		if err := queue.done(id); err != nil {
+			log.Fatal(err)
+		}
+		if err != nil && !errors.Is(err, errSkipped) {
+			log.Printf("boot testing %s: %v", ref, err)
+		}
+		if req.comment {
+			if err := replyToComment(ctx, client, ref, err); err != nil {
+				log.Printf("replying to %s: %v", ref.commentURL, err)
+			}
+		}
+	}
+}
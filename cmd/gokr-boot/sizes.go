@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gokrazy/autoupdate/pkg/boottest"
+	"github.com/google/go-github/v35/github"
+	"github.com/google/renameio/v2"
+)
+
+var (
+	imageSizes = flag.String("image_sizes",
+		"",
+		"if non-empty, directory (e.g. a CI cache directory) holding the boot and root image sizes of target branch builds, against which -size_budget_bytes and -size_budget_percent are enforced")
+
+	sizeBudgetBytes = flag.Int64("size_budget_bytes",
+		0,
+		"if non-zero, by how many bytes a pull request may grow the boot or root image compared to the target branch")
+
+	sizeBudgetPercent = flag.Float64("size_budget_percent",
+		0,
+		"if non-zero, by how many percent a pull request may grow the boot or root image compared to the target branch")
+
+	sizeBudgetFail = flag.Bool("size_budget_fail",
+		false,
+		"fail the boot test if the image size budget is exceeded, instead of only warning in the pull request comment")
+)
+
+func imageSizesPath(branch, host string) string {
+	return filepath.Join(*imageSizes, branch, host+".json")
+}
+
+// recordImageSizes stores the sizes of the images in dir as the -rootfs_branch
+// baseline for host.
+func recordImageSizes(dir, host string) error {
+	sizes, err := boottest.ReadImageSizes(dir)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(sizes)
+	if err != nil {
+		return err
+	}
+	path := imageSizesPath(*rootfsBranch, host)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return renameio.WriteFile(path, b, 0644)
+}
+
+// overBudget returns why growing an image from old to new bytes exceeds the
+// size budget, or "" if it does not.
+func overBudget(name string, old, new int64) string {
+	growth := new - old
+	if growth <= 0 {
+		return ""
+	}
+	if *sizeBudgetBytes > 0 && growth > *sizeBudgetBytes {
+		return fmt.Sprintf("%s image grew by %d bytes, budget is %d bytes", name, growth, *sizeBudgetBytes)
+	}
+	if pct := 100 * float64(growth) / float64(old); old > 0 && *sizeBudgetPercent > 0 && pct > *sizeBudgetPercent {
+		return fmt.Sprintf("%s image grew by %.1f%%, budget is %.1f%%", name, pct, *sizeBudgetPercent)
+	}
+	return ""
+}
+
+// checkImageSizes compares sizes with the baseline of the target branch of the
+// pull request and returns a Markdown summary. With -size_budget_fail, an
+// exceeded budget is returned as error.
+func checkImageSizes(ctx context.Context, client *github.Client, owner, repo string, issueNum int, host string, sizes *boottest.ImageSizes) (string, error) {
+	base, err := baseBranch(ctx, newForge(client), owner, repo, issueNum)
+	if err != nil {
+		// The comparison is informational unless the budget is exceeded.
+		log.Printf("comparing image sizes: %v", err)
+		return "", nil
+	}
+	b, err := ioutil.ReadFile(imageSizesPath(base, host))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("comparing image sizes: %v", err)
+		}
+		return "", nil
+	}
+	var baseline boottest.ImageSizes
+	if err := json.Unmarshal(b, &baseline); err != nil {
+		log.Printf("comparing image sizes: %v", err)
+		return "", nil
+	}
+	var exceeded []string
+	for _, msg := range []string{
+		overBudget("boot", baseline.Boot, sizes.Boot),
+		overBudget("root", baseline.Root, sizes.Root),
+	} {
+		if msg != "" {
+			exceeded = append(exceeded, msg)
+		}
+	}
+	if len(exceeded) > 0 && *sizeBudgetFail {
+		return "", fmt.Errorf("image size budget exceeded on %s: %s", host, strings.Join(exceeded, "; "))
+	}
+	summary := fmt.Sprintf("Image sizes of %s compared to %s: boot %d bytes (%+d), root %d bytes (%+d)",
+		host, base, sizes.Boot, sizes.Boot-baseline.Boot, sizes.Root, sizes.Root-baseline.Root)
+	for _, msg := range exceeded {
+		summary += "\n\n**Warning:** " + msg
+	}
+	return summary, nil
+}
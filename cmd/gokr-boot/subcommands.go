@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+var (
+	hostname = flag.String("hostname",
+		"",
+		"hostname to build images for (build), boot test on (upload), report on (report), bisect on (bisect), show results of (history) or publish (publish, comma-separated list)")
+
+	imageDir = flag.String("image_dir",
+		"",
+		"directory into which build writes images, and from which upload reads them")
+
+	bootLogPath = flag.String("boot_log",
+		"",
+		"file to which upload writes the boot log (default: stdout), and from which report reads it")
+
+	servicesPath = flag.String("services_summary",
+		"",
+		"if non-empty, file to which upload writes the -verify_services summary, and from which report reads it")
+)
+
+func requireFlags(names ...string) {
+	for _, name := range names {
+		if flag.Lookup(name).Value.String() == "" {
+			log.Fatalf("-%s is a required flag", name)
+		}
+	}
+}
+
+// build builds images for -hostname into -image_dir.
+func build(ctx context.Context) {
+	requireFlags("bootery_url", "hostname", "image_dir")
+	bt := newBootTester()
+	if *applianceDir != "" {
+		if err := bt.AddAppliance(ctx); err != nil {
+			fatal(err)
+		}
+	}
+	if err := bt.Build(ctx, *hostname, *imageDir); err != nil {
+		fatal(err)
+	}
+	if *provenance {
+		if err := writeProvenance(ctx, bt); err != nil {
+			fatal(err)
+		}
+	}
+	if *rootfsManifests != "" && *rootfsBranch != "" {
+		if err := recordRootManifest(ctx, *imageDir, *hostname); err != nil {
+			fatal(err)
+		}
+	}
+	if *imageSizes != "" && *rootfsBranch != "" {
+		if err := recordImageSizes(*imageDir, *hostname); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// upload boot tests the images in -image_dir on -hostname.
+func upload(ctx context.Context) {
+	requireFlags("bootery_url", "hostname", "image_dir")
+	bt := newBootTester()
+	result, err := bt.Upload(ctx, *hostname, *imageDir)
+	if err != nil {
+		if ctx.Err() != nil {
+			if err := bt.Cancel(context.Background(), *hostname); err != nil {
+				log.Printf("aborting boot test: %v", err)
+			}
+			os.Exit(1)
+		}
+		fatal(err)
+	}
+	if *bootLogPath == "" {
+		fmt.Print(result.BootLog)
+	} else if err := ioutil.WriteFile(*bootLogPath, []byte(result.BootLog), 0644); err != nil {
+		fatal(err)
+	}
+	if *servicesPath != "" {
+		if err := ioutil.WriteFile(*servicesPath, []byte(result.Services), 0644); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// report publishes the -boot_log of a successful boot test of -hostname on the
+// pull request and marks the pull request as tested.
+func report(ctx context.Context) {
+	requireFlags("hostname", "boot_log", "set_label", "require_label")
+	client, owner, repo, issueNum := pullRequest()
+	f := newForge(client)
+	bootlog, err := ioutil.ReadFile(*bootLogPath)
+	if err != nil {
+		fatal(err)
+	}
+	var services []byte
+	if *servicesPath != "" {
+		services, err = ioutil.ReadFile(*servicesPath)
+		if err != nil {
+			fatal(err)
+		}
+	}
+	headSHA, err := headCommit(ctx, f, owner, repo, issueNum)
+	if err != nil {
+		fatal(err)
+	}
+	attachments := make(map[string]string)
+	if *sbom && *imageDir != "" {
+		sbomJSON, err := ioutil.ReadFile(filepath.Join(*imageDir, "sbom.cdx.json"))
+		if err != nil {
+			fatal(err)
+		}
+		attachments["sbom.cdx.json"] = string(sbomJSON)
+	}
+	if *provenance && *imageDir != "" {
+		files, err := readProvenance(*imageDir)
+		if err != nil {
+			fatal(err)
+		}
+		for name, content := range files {
+			attachments[name] = content
+		}
+	}
+	gistURL, err := createGist(ctx, f, string(bootlog), attachments)
+	if err != nil {
+		fatal(err)
+	}
+	if err := addComment(ctx, f, owner, repo, issueNum, gistURL, string(services)); err != nil {
+		fatal(err)
+	}
+	recordResult(ctx, owner, repo, issueNum, headSHA, *hostname, "success", gistURL, string(bootlog), 0, nil, nil)
+	if err := setStatus(ctx, f, owner, repo, headSHA, *statusContext, "success", "boot test successful", gistURL); err != nil {
+		fatal(err)
+	}
+	if err := updateLabels(ctx, f, owner, repo, issueNum); err != nil {
+		fatal(err)
+	}
+}
+
+// status exits with exit code 0 if the pull request head commit was already
+// boot tested successfully, and 1 otherwise.
+func status(ctx context.Context) {
+	client, owner, repo, issueNum := pullRequest()
+	f := newForge(client)
+	headSHA, err := headCommit(ctx, f, owner, repo, issueNum)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tested, err := alreadyTested(ctx, f, owner, repo, headSHA, *statusContext)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("gokr-boot status %s: tested? %v", headSHA, tested)
+	if tested {
+		os.Exit(0)
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+)
+
+var (
+	watchRepos = flag.String("watch_repos",
+		"",
+		"comma-separated list of owner/repo slugs whose open pull requests watch boot tests")
+
+	pollInterval = flag.Duration("poll_interval",
+		5*time.Minute,
+		"how often watch lists the open pull requests of -watch_repos")
+)
+
+// labeledPullRequests returns the numbers and head commits of all open pull
+// requests of owner/repo which carry label.
+func labeledPullRequests(ctx context.Context, client *github.Client, owner, repo, label string) (map[int]string, error) {
+	prs := make(map[int]string)
+	opts := &github.PullRequestListOptions{
+		State:       "open",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		page, resp, err := client.PullRequests.List(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, pr := range page {
+			for _, l := range pr.Labels {
+				if l.GetName() == label {
+					prs[pr.GetNumber()] = pr.GetHead().GetSHA()
+					break
+				}
+			}
+		}
+		if resp.NextPage == 0 {
+			return prs, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// watch runs until ctx is cancelled, boot testing the open pull requests of
+// -watch_repos which carry -require_label. Pull requests are tested one after
+// the other: gok builds from a single instance directory, and every repository
+// occupies its bakeries for the duration of the test.
+func watch(ctx context.Context) {
+	requireLabelFlags()
+	requireFlags("watch_repos")
+	loadCredentials()
+
+	type repository struct{ owner, repo string }
+	var repos []repository
+	for _, slug := range strings.Split(*watchRepos, ",") {
+		parts := strings.Split(strings.TrimSpace(slug), "/")
+		if got, want := len(parts), 2; got != want {
+			log.Fatalf("unexpected number of /-separated parts in %q: got %d, want %d", slug, got, want)
+		}
+		repos = append(repos, repository{parts[0], parts[1]})
+	}
+
+	bt := newBootTester()
+	client := newClient()
+
+	if *historyDB != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/dashboard/", http.StripPrefix("/dashboard", dashboardHandler(*historyDB)))
+		log.Printf("serving dashboard on %s", *listen)
+		go listenAndServe(ctx, mux)
+	}
+
+	// failed remembers the head commit of pull requests whose boot test
+	// failed, so that they are only tested again once new commits are pushed.
+	failed := make(map[string]string)
+
+	for {
+		for _, r := range repos {
+			prs, err := labeledPullRequests(ctx, client, r.owner, r.repo, *requireLabel)
+			if err != nil {
+				log.Printf("listing pull requests of %s/%s: %v", r.owner, r.repo, err)
+				continue
+			}
+			for issueNum, headSHA := range prs {
+				key := fmt.Sprintf("%s/%s#%d", r.owner, r.repo, issueNum)
+				if failed[key] == headSHA {
+					continue
+				}
+				log.Printf("boot testing %s (commit %s)", key, headSHA)
+				if err := testPullRequest(ctx, bt, client, r.owner, r.repo, issueNum, testRequest{}); err != nil && !errors.Is(err, errSkipped) {
+					if ctx.Err() != nil {
+						return
+					}
+					log.Printf("boot testing %s: %v", key, err)
+					failed[key] = headSHA
+					continue
+				}
+				delete(failed, key)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(*pollInterval):
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/history"
+	"github.com/gokrazy/autoupdate/pkg/boottest"
+)
+
+var (
+	resultWebhook = flag.String("result_webhook",
+		"",
+		"if non-empty, URL to which a JSON payload describing each boot test result is POSTed")
+
+	resultWebhookSecretEnv = flag.String("result_webhook_secret_env",
+		"",
+		"if non-empty, name of an environment variable holding the secret with which -result_webhook payloads are signed (HMAC-SHA256, sent as X-Gokrazy-Signature-256: sha256=<hex>)")
+)
+
+// webhookPayload is the JSON payload POSTed to -result_webhook.
+type webhookPayload struct {
+	Repo            string    `json:"repo"`
+	PR              int       `json:"pr"`
+	Commit          string    `json:"commit"`
+	Device          string    `json:"device"`
+	Outcome         string    `json:"outcome"` // success or failure
+	Time            time.Time `json:"time"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	LogURL          string    `json:"log_url,omitempty"`
+	Error           string    `json:"error,omitempty"`
+
+	// Uploads describe the image uploads to the bootery, also of failed
+	// boot tests, to tell stalled transfers from slow ones.
+	Uploads []uploadPayload `json:"uploads,omitempty"`
+}
+
+type uploadPayload struct {
+	Image           string  `json:"image"`
+	Bytes           int64   `json:"bytes"`
+	TotalBytes      int64   `json:"total_bytes,omitempty"` // if known
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+func postResult(ctx context.Context, rec history.Record, duration time.Duration, uploads []boottest.UploadStats, testErr error) error {
+	payload := webhookPayload{
+		Repo:            rec.Repo,
+		PR:              rec.PR,
+		Commit:          rec.Commit,
+		Device:          rec.Host,
+		Outcome:         rec.Result,
+		Time:            rec.Time,
+		DurationSeconds: duration.Seconds(),
+		LogURL:          rec.LogURL,
+	}
+	if testErr != nil {
+		payload.Error = testErr.Error()
+	}
+	for _, u := range uploads {
+		payload.Uploads = append(payload.Uploads, uploadPayload{
+			Image:           u.Image,
+			Bytes:           u.Bytes,
+			TotalBytes:      u.Total,
+			DurationSeconds: u.Duration.Seconds(),
+		})
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, *resultWebhook, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if *resultWebhookSecretEnv != "" {
+		secret := os.Getenv(*resultWebhookSecretEnv)
+		if secret == "" {
+			return fmt.Errorf("required environment variable %s empty", *resultWebhookSecretEnv)
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(b)
+		req.Header.Set("X-Gokrazy-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected HTTP status code: got %d (%s), want 2xx", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+// gokr-fake-bootery is a bootery (see https://github.com/gokrazy/bakery)
+// without hardware, which answers boot tests with canned results. It is meant
+// for validating gokr-boot configurations and for end-to-end testing
+// gokr-boot itself.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	listen = flag.String("listen",
+		":8037",
+		"[host]:port to listen on")
+
+	hosts = flag.String("hosts",
+		"fake",
+		"comma-separated list of hostnames which /usebakeries returns for every slug")
+
+	arch = flag.String("arch",
+		"arm64",
+		"architecture which /capabilities reports for all -hosts")
+
+	result = flag.String("result",
+		"success",
+		"outcome of every boot test: success, failure or flaky (fails with probability -failure_rate)")
+
+	failureRate = flag.Float64("failure_rate",
+		0.5,
+		"probability with which boot tests fail for -result=flaky")
+
+	latency = flag.Duration("latency",
+		5*time.Second,
+		"how long each boot test takes after the image was received")
+
+	bootLog = flag.String("boot_log",
+		"gokrazy booted (gokr-fake-bootery)",
+		"boot log returned for successful boot tests")
+)
+
+// receive reads the uploaded image and returns its hex-encoded SHA-256, or an
+// error if the checksum sent by gokr-boot in the X-Image-SHA256 trailer does
+// not match.
+func receive(r *http.Request) (string, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r.Body)
+	if err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if want := r.Trailer.Get("X-Image-SHA256"); want != "" && want != sum {
+		return "", fmt.Errorf("image corrupted in transit: got SHA-256 %s, want %s", sum, want)
+	}
+	log.Printf("%s %s: received %d bytes (SHA-256 %s)", r.Method, r.URL, n, sum)
+	return sum, nil
+}
+
+func fail() bool {
+	switch *result {
+	case "failure":
+		return true
+	case "flaky":
+		return rand.Float64() < *failureRate
+	}
+	return false
+}
+
+func testBoot(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		log.Printf("aborting boot test of %s", r.URL.Query().Get("hostname"))
+		return
+	}
+	sum, err := receive(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("X-Image-SHA256", sum)
+	select {
+	case <-r.Context().Done():
+		return
+	case <-time.After(*latency):
+	}
+	hostname := r.URL.Query().Get("hostname")
+	if fail() {
+		log.Printf("boot test of %s: failure", hostname)
+		http.Error(w, fmt.Sprintf("%s did not boot a build newer than %s (gokr-fake-bootery)", hostname, r.URL.Query().Get("boot-newer")), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("boot test of %s: success", hostname)
+	fmt.Fprintln(w, *bootLog)
+}
+
+func updateRoot(w http.ResponseWriter, r *http.Request) {
+	sum, err := receive(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("X-Image-SHA256", sum)
+	fmt.Fprintln(w, "root file system updated (gokr-fake-bootery)")
+}
+
+func useBakeries(w http.ResponseWriter, r *http.Request) {
+	log.Printf("powering on bakeries for %s", r.URL.Query().Get("slug"))
+	b, err := json.Marshal(struct {
+		Hosts []string `json:"hosts"`
+	}{
+		Hosts: strings.Split(*hosts, ","),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// lease is the lease on the (fake) bakeries, see gokr-boot -lease_wait.
+var lease struct {
+	sync.Mutex
+	id      string
+	holder  string
+	expires time.Time
+}
+
+func leaseReply(w http.ResponseWriter, status int, id, holder string) {
+	b, err := json.Marshal(struct {
+		Lease  string `json:"lease,omitempty"`
+		Holder string `json:"holder,omitempty"`
+	}{
+		Lease:  id,
+		Holder: holder,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func acquireLease(w http.ResponseWriter, r *http.Request) {
+	ttl, err := time.ParseDuration(r.URL.Query().Get("ttl"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	holder := r.URL.Query().Get("holder")
+	lease.Lock()
+	defer lease.Unlock()
+	if lease.id != "" && time.Now().Before(lease.expires) {
+		leaseReply(w, http.StatusConflict, "", lease.holder)
+		return
+	}
+	lease.id = fmt.Sprintf("%016x", rand.Uint64())
+	lease.holder = holder
+	lease.expires = time.Now().Add(ttl)
+	log.Printf("lease %s acquired by %s", lease.id, holder)
+	leaseReply(w, http.StatusOK, lease.id, holder)
+}
+
+func renewLease(w http.ResponseWriter, r *http.Request) {
+	ttl, err := time.ParseDuration(r.URL.Query().Get("ttl"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lease.Lock()
+	defer lease.Unlock()
+	if id := r.URL.Query().Get("lease"); id != lease.id || time.Now().After(lease.expires) {
+		http.Error(w, "lease expired", http.StatusGone)
+		return
+	}
+	lease.expires = time.Now().Add(ttl)
+	leaseReply(w, http.StatusOK, lease.id, lease.holder)
+}
+
+func releaseLease(w http.ResponseWriter, r *http.Request) {
+	lease.Lock()
+	defer lease.Unlock()
+	if id := r.URL.Query().Get("lease"); id == lease.id {
+		log.Printf("lease %s released by %s", lease.id, lease.holder)
+		lease.id = ""
+	}
+	leaseReply(w, http.StatusOK, "", "")
+}
+
+func releaseBakeries(w http.ResponseWriter, r *http.Request) {
+	log.Printf("powering off bakeries")
+}
+
+func capabilities(w http.ResponseWriter, r *http.Request) {
+	type device struct {
+		Hostname  string `json:"hostname"`
+		Arch      string `json:"arch"`
+		Available bool   `json:"available"`
+	}
+	var caps struct {
+		Devices  []device `json:"devices"`
+		Features []string `json:"features"`
+	}
+	for _, host := range strings.Split(*hosts, ",") {
+		caps.Devices = append(caps.Devices, device{Hostname: host, Arch: *arch, Available: true})
+	}
+	caps.Features = []string{"update_root", "lease"}
+	b, err := json.Marshal(caps)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func diagnostics(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "no diagnostics for %s (gokr-fake-bootery)\n", r.URL.Query().Get("hostname"))
+}
+
+// newMux returns the bootery API handlers, configured by the flags.
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/testboot", testBoot)
+	mux.HandleFunc("/testboot1", testBoot)
+	mux.HandleFunc("/updateroot", updateRoot)
+	mux.HandleFunc("/usebakeries", useBakeries)
+	mux.HandleFunc("/releasebakeries", releaseBakeries)
+	mux.HandleFunc("/diagnostics", diagnostics)
+	mux.HandleFunc("/capabilities", capabilities)
+	mux.HandleFunc("/acquirelease", acquireLease)
+	mux.HandleFunc("/renewlease", renewLease)
+	mux.HandleFunc("/releaselease", releaseLease)
+	// /updateroot/negotiate is not handled, so gokr-boot -delta_root falls
+	// back to full uploads.
+	return mux
+}
+
+func main() {
+	flag.Parse()
+	switch *result {
+	case "success", "failure", "flaky":
+	default:
+		log.Fatalf("unknown -result %q, expected one of success, failure, flaky", *result)
+	}
+	rand.Seed(time.Now().UnixNano())
+	log.Printf("listening on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, newMux()))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/autoupdate/pkg/boottest"
+)
+
+// writeImages writes the files which boottest.BootTester.Build would write
+// into a temporary directory, for use with Upload.
+func writeImages(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"boot.img": strings.Repeat("boot", 4096),
+		"root.img": strings.Repeat("root", 8192),
+		"newer":    "1614600000\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestBootTester(t *testing.T) {
+	*latency = 0
+	*hosts = "pi4,pi5"
+	dir := writeImages(t)
+	srv := httptest.NewServer(newMux())
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		result  string
+		wantErr string
+	}{
+		{result: "success"},
+		{result: "failure", wantErr: "did not boot a build newer than 1614600000"},
+	} {
+		t.Run(tt.result, func(t *testing.T) {
+			*result = tt.result
+			ctx := context.Background()
+			bt, err := boottest.New(boottest.Options{
+				BooteryURL:          srv.URL + "/testboot",
+				UpdateRoot:          true,
+				LeaseWait:           time.Second,
+				RequireChecksumEcho: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			caps, err := bt.Capabilities(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(caps.Devices), 2; got != want {
+				t.Fatalf("Capabilities() returned %d devices, want %d", got, want)
+			}
+
+			got, err := bt.UseBakeries(ctx, "gokrazy/gokrazy")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"pi4", "pi5"}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("UseBakeries() = %q, want %q", got, want)
+			}
+
+			// A concurrent run must not get the bakeries while the lease is held.
+			other, err := boottest.New(boottest.Options{
+				BooteryURL: srv.URL + "/testboot",
+				LeaseWait:  time.Millisecond,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := other.UseBakeries(ctx, "gokrazy/gokrazy"); err == nil {
+				t.Fatal("UseBakeries() succeeded while another BootTester holds the lease")
+			}
+
+			res, err := bt.Upload(ctx, "pi4", dir)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Upload: got error %v, want error containing %q", err, tt.wantErr)
+				}
+				diag, err := bt.Diagnostics(ctx, "pi4")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(diag, "pi4") {
+					t.Errorf("Diagnostics() = %q, want diagnostics of pi4", diag)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(res.BootLog, *bootLog) {
+					t.Errorf("Upload() boot log = %q, want %q", res.BootLog, *bootLog)
+				}
+				if got, want := len(bt.Uploads()), 2; got != want {
+					t.Errorf("Upload() uploaded %d images, want %d (root and boot)", got, want)
+				}
+			}
+
+			if err := bt.ReleaseBakeries(ctx); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
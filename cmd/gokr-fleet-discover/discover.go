@@ -0,0 +1,117 @@
+// gokr-fleet-discover finds gokrazy devices on the local network by probing
+// the web interface port of all addresses of a subnet, and adds them to a
+// gokr-fleet-update inventory, asking for each new device unless -yes is set.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/fleet"
+)
+
+var (
+	inventoryPath = flag.String("inventory",
+		"",
+		"path to the device inventory (JSON, see fleet.LoadInventory) to add discovered devices to. Created if it does not exist")
+
+	subnets = flag.String("subnets",
+		"",
+		"comma-separated list of IPv4 subnets to probe, e.g. 192.168.1.0/24 (default: the subnets of the local network interfaces)")
+
+	port = flag.Int("port",
+		80,
+		"port of the gokrazy web interface")
+
+	probeTimeout = flag.Duration("probe_timeout",
+		2*time.Second,
+		"how long to wait for each address to answer")
+
+	passwordEnv = flag.String("password_env",
+		"",
+		"if non-empty, name of the environment variable holding the web interface password of added devices (password_env in the inventory)")
+
+	yes = flag.Bool("yes",
+		false,
+		"add all discovered devices without asking")
+)
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	if *inventoryPath == "" {
+		log.Fatal("-inventory is a required flag")
+	}
+	inv := &fleet.Inventory{}
+	if _, err := os.Stat(*inventoryPath); err == nil {
+		inv, err = fleet.LoadInventory(*inventoryPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+
+	var nets []*net.IPNet
+	if *subnets == "" {
+		var err error
+		nets, err = fleet.LocalSubnets()
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		for _, s := range strings.Split(*subnets, ",") {
+			_, n, err := net.ParseCIDR(strings.TrimSpace(s))
+			if err != nil {
+				log.Fatal(err)
+			}
+			nets = append(nets, n)
+		}
+	}
+
+	ctx := context.Background()
+
+	stdin := bufio.NewReader(os.Stdin)
+	added := 0
+	for _, n := range nets {
+		log.Printf("probing %v", n)
+		devices, err := fleet.Discover(ctx, n, *port, *probeTimeout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, d := range devices {
+			d.PasswordEnv = *passwordEnv
+			if !*yes {
+				fmt.Printf("add %s (%s)? [Y/n] ", d.Hostname, d.UpdateURL)
+				answer, err := stdin.ReadString('\n')
+				if err != nil {
+					log.Fatal(err)
+				}
+				if a := strings.ToLower(strings.TrimSpace(answer)); a != "" && a != "y" && a != "yes" {
+					continue
+				}
+			}
+			if !inv.Add(d) {
+				log.Printf("%s is already in the inventory", d.Hostname)
+				continue
+			}
+			added++
+		}
+	}
+	if added == 0 {
+		log.Printf("no devices added")
+		return
+	}
+	if err := inv.Write(*inventoryPath); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("added %d devices to %s", added, *inventoryPath)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gokrazy/autoupdate/internal/fleet"
+)
+
+var (
+	batchSize = flag.Int("batch_size",
+		0,
+		"if positive, the devices of each stage are updated in batches of at most this many devices of the same group (see the group field of the inventory), one batch after the other, so that not all devices providing a service (e.g. DNS or DHCP) reboot at the same time. With 0, devices of groups without -group_batch_sizes are updated in one batch")
+
+	groupBatchSizes = flag.String("group_batch_sizes",
+		"",
+		"comma-separated list of group=size pairs overriding -batch_size for the devices of a group, e.g. dns=1,automation=2")
+
+	batchDelay = flag.Duration("batch_delay",
+		0,
+		"how long to wait between batches (see -batch_size)")
+)
+
+// parseGroupBatchSizes parses the -group_batch_sizes flag value s.
+func parseGroupBatchSizes(s string) (map[string]int, error) {
+	sizes := make(map[string]int)
+	if s == "" {
+		return sizes, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		group, size, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return nil, fmt.Errorf("-group_batch_sizes: %q is not of the form group=size", item)
+		}
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			return nil, fmt.Errorf("-group_batch_sizes: %v", err)
+		}
+		if n < 1 {
+			return nil, fmt.Errorf("-group_batch_sizes: batch size of group %s must be at least 1", group)
+		}
+		sizes[group] = n
+	}
+	return sizes, nil
+}
+
+// batches splits devices into batches, returned as indices into devices. The
+// devices of a group are split into batches of the group's batch size; groups
+// are batched in the order in which they first appear in devices. Devices
+// without a batch size form the first batch.
+func batches(devices []*fleet.Device) ([][]int, error) {
+	sizes, err := parseGroupBatchSizes(*groupBatchSizes)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		unbatched []int
+		groups    []string
+	)
+	members := make(map[string][]int)
+	for i, d := range devices {
+		size, ok := sizes[d.Group]
+		if !ok {
+			size = *batchSize
+		}
+		if size == 0 {
+			unbatched = append(unbatched, i)
+			continue
+		}
+		if _, ok := members[d.Group]; !ok {
+			groups = append(groups, d.Group)
+		}
+		members[d.Group] = append(members[d.Group], i)
+	}
+	var bs [][]int
+	if len(unbatched) > 0 {
+		bs = append(bs, unbatched)
+	}
+	for _, group := range groups {
+		idx := members[group]
+		size, ok := sizes[group]
+		if !ok {
+			size = *batchSize
+		}
+		for len(idx) > 0 {
+			n := size
+			if n > len(idx) {
+				n = len(idx)
+			}
+			bs = append(bs, idx[:n])
+			idx = idx[n:]
+		}
+	}
+	return bs, nil
+}
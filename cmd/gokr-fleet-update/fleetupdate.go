@@ -0,0 +1,310 @@
+// gokr-fleet-update pushes boot-verified images (e.g. written by gokr-boot
+// build and boot tested with gokr-boot upload) to the devices of a fleet via
+// the gokrazy update endpoints, and reports which devices were updated.
+// Rollouts can be staged: canary devices first, then waves of the remaining
+// devices, each checked for health before the next stage starts.
+// Inventory labels select the devices to update (-selector) and their images.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/fleet"
+	"github.com/gokrazy/autoupdate/internal/redact"
+	"github.com/google/renameio/v2"
+)
+
+var (
+	inventoryPath = flag.String("inventory",
+		"",
+		"path to the device inventory (JSON, see fleet.LoadInventory) listing hostnames, update URLs and credentials")
+
+	imageDir = flag.String("image_dir",
+		"",
+		"directory holding the images to push, as written by gokr-boot build: one subdirectory per device hostname, one subdirectory per label (named key=value, e.g. model=rpi4, used for devices carrying the label in the inventory), or boot.img and root.img for all devices. As the hostname is part of the image, sharing images only suits fleets which do not rely on hostnames")
+
+	hosts = flag.String("hosts",
+		"",
+		"if non-empty, comma-separated list of inventory hostnames to update, instead of all devices")
+
+	selector = flag.String("selector",
+		"",
+		"if non-empty, only devices whose inventory labels match this comma-separated list of requirements are updated: key=value, key!=value or key (label present), e.g. role=dns,location!=garage. Combines with -hosts")
+
+	parallel = flag.Int("parallel",
+		1,
+		"how many devices to update at the same time")
+
+	verifyReboot = flag.Bool("verify_reboot",
+		true,
+		"after updating a device, wait up to -reboot_timeout for it to run the new build (compared by build timestamp via the status API) and up to -health_timeout for it to become healthy")
+
+	rebootTimeout = flag.Duration("reboot_timeout",
+		5*time.Minute,
+		"how long -verify_reboot waits for a device to run the new build")
+
+	rollback = flag.Bool("rollback",
+		true,
+		"if a device runs the new build but does not become healthy (-verify_reboot), switch it back to its previous root partition and reboot it")
+
+	reportPath = flag.String("report",
+		"",
+		"if non-empty, file to which the per-device results are written as JSON")
+)
+
+// result is the outcome of updating one device.
+type result struct {
+	Hostname   string  `json:"hostname"`
+	Stage      string  `json:"stage"`
+	Success    bool    `json:"success"`
+	Skipped    bool    `json:"skipped,omitempty"`
+	RolledBack bool    `json:"rolled_back,omitempty"` // to (or stayed on) the previous build, as the new one was unhealthy or did not boot
+	Error      string  `json:"error,omitempty"`
+	Seconds    float64 `json:"duration_seconds"`
+}
+
+// images returns the directory holding the images for d in -image_dir, and
+// the boot and root image. The directory named after the hostname takes
+// precedence over those named after labels (in label key order).
+func images(d *fleet.Device) (dir, boot, root string, _ error) {
+	candidates := []string{d.Hostname}
+	var keys []string
+	for key := range d.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		candidates = append(candidates, key+"="+d.Labels[key])
+	}
+	dir = *imageDir
+	for _, name := range candidates {
+		candidate := filepath.Join(*imageDir, name)
+		if _, err := os.Stat(filepath.Join(candidate, "root.img")); err != nil {
+			if !os.IsNotExist(err) {
+				return "", "", "", err
+			}
+			continue
+		}
+		dir = candidate
+		break
+	}
+	boot, root = filepath.Join(dir, "boot.img"), filepath.Join(dir, "root.img")
+	for _, img := range []string{boot, root} {
+		if _, err := os.Stat(img); err != nil {
+			return "", "", "", err
+		}
+	}
+	return dir, boot, root, nil
+}
+
+// readNewer returns the UNIX timestamp which gokr-boot build recorded in dir
+// before building (the images were built after it), or 0 if there is none.
+func readNewer(dir string) (int64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "newer"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// update updates d and returns the outcome.
+func update(ctx context.Context, d *fleet.Device) result {
+	start := time.Now()
+	res := result{Hostname: d.Hostname}
+	err := func() error {
+		dir, boot, root, err := images(d)
+		if err != nil {
+			return err
+		}
+		if !*verifyReboot {
+			return d.Update(ctx, boot, root)
+		}
+		newer, err := readNewer(dir)
+		if err != nil {
+			return err
+		}
+		before, err := d.Status(ctx)
+		if err != nil {
+			return fmt.Errorf("querying status before the update: %v", err)
+		}
+		if err := d.Update(ctx, boot, root); err != nil {
+			return err
+		}
+		if _, err := d.WaitForBuild(ctx, before.BuildTimestamp, newer, *rebootTimeout); err != nil {
+			fellBack, rerr := recheckBuild(ctx, d, before.BuildTimestamp, newer)
+			if rerr != nil {
+				return fmt.Errorf("%v, and rebooting again failed: %v", err, rerr)
+			}
+			if fellBack {
+				res.RolledBack = true
+				return fmt.Errorf("%v (still on build %s after another reboot)", err, before.BuildTimestamp)
+			}
+			log.Printf("%s: runs the new build after another reboot", d.Hostname)
+		}
+		healthErr := d.WaitHealthy(ctx, *healthTimeout)
+		if healthErr == nil || !*rollback {
+			return healthErr
+		}
+		log.Printf("%s: %v, rolling back", d.Hostname, healthErr)
+		if err := d.Rollback(ctx); err != nil {
+			return fmt.Errorf("%v, and rolling back failed: %v", healthErr, err)
+		}
+		if err := d.WaitForOldBuild(ctx, before.BuildTimestamp, *rebootTimeout); err != nil {
+			return fmt.Errorf("%v, and rolling back failed: %v", healthErr, err)
+		}
+		res.RolledBack = true
+		return fmt.Errorf("%v (rolled back to build %s)", healthErr, before.BuildTimestamp)
+	}()
+	res.Seconds = time.Since(start).Seconds()
+	if err != nil {
+		res.Error = redact.String(err.Error())
+		log.Printf("%s: update failed: %v", d.Hostname, err)
+	} else {
+		res.Success = true
+		log.Printf("%s: updated", d.Hostname)
+	}
+	return res
+}
+
+// recheckBuild reboots d, which still runs the build old after the update,
+// once more. A device which ignored the reboot request still has the new
+// build selected, and must not boot it unverified the next time it reboots:
+// partitions cannot be switched to the running one, so instead, recheckBuild
+// reboots it now and returns whether d fell back to old (the new build is not
+// selected) or runs the new build (which the caller verifies as usual).
+func recheckBuild(ctx context.Context, d *fleet.Device, old string, newer int64) (fellBack bool, _ error) {
+	status, err := d.Status(ctx)
+	if err != nil {
+		return false, err
+	}
+	if status.BuildTimestamp != old {
+		return false, fmt.Errorf("running unexpected build %s", status.BuildTimestamp)
+	}
+	log.Printf("%s: still running build %s, rebooting again", d.Hostname, old)
+	if err := d.Reboot(ctx); err != nil {
+		return false, err
+	}
+	if _, err := d.WaitForBuild(ctx, old, newer, *rebootTimeout); err == nil {
+		return false, nil
+	}
+	// Booting the new build failed: the boot loader fell back to the old
+	// one, which stays selected.
+	if err := d.WaitForOldBuild(ctx, old, *rebootTimeout); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// selectDevices returns the devices of inv which -hosts and -selector select.
+func selectDevices(inv *fleet.Inventory) ([]*fleet.Device, error) {
+	sel, err := fleet.ParseSelector(*selector)
+	if err != nil {
+		return nil, err
+	}
+	candidates := inv.Devices
+	if *hosts != "" {
+		byName := make(map[string]*fleet.Device)
+		for _, d := range inv.Devices {
+			byName[d.Hostname] = d
+		}
+		candidates = nil
+		for _, host := range strings.Split(*hosts, ",") {
+			d, ok := byName[strings.TrimSpace(host)]
+			if !ok {
+				return nil, fmt.Errorf("host %q not found in inventory", host)
+			}
+			candidates = append(candidates, d)
+		}
+	}
+	var devices []*fleet.Device
+	for _, d := range candidates {
+		if sel.Matches(d) {
+			devices = append(devices, d)
+		}
+	}
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no devices selected")
+	}
+	return devices, nil
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	log.SetOutput(redact.NewWriter(os.Stderr))
+
+	if *inventoryPath == "" || *imageDir == "" {
+		log.Fatal("-inventory and -image_dir are required flags")
+	}
+	if *parallel < 1 {
+		log.Fatal("-parallel must be at least 1")
+	}
+	if *batchSize < 0 {
+		log.Fatal("-batch_size must not be negative")
+	}
+	inv, err := fleet.LoadInventory(*inventoryPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	devices, err := selectDevices(inv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, d := range devices {
+		redact.Add(d.Password, "<password of "+d.Hostname+">")
+		if d.PasswordEnv != "" {
+			redact.Add(os.Getenv(d.PasswordEnv), "<"+d.PasswordEnv+">")
+		}
+	}
+
+	stages, err := planStages(devices)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	results, err := rollout(ctx, stages)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	failed := 0
+	for _, res := range results {
+		if res.Success {
+			fmt.Printf("%s: updated (%.0fs)\n", res.Hostname, res.Seconds)
+		} else if res.Skipped {
+			failed++
+			fmt.Printf("%s: skipped: %s\n", res.Hostname, res.Error)
+		} else {
+			failed++
+			fmt.Printf("%s: FAILED: %s\n", res.Hostname, res.Error)
+		}
+	}
+	if *reportPath != "" {
+		b, err := json.MarshalIndent(results, "", "\t")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := renameio.WriteFile(*reportPath, b, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d devices failed to update or were skipped", failed, len(results))
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/fleet"
+)
+
+func TestRecheckBuild(t *testing.T) {
+	const (
+		oldBuild = "2021-03-01T12:00:00Z"
+		newBuild = "2021-03-02T12:00:00Z"
+	)
+	*rebootTimeout = 0
+	for _, tt := range []struct {
+		name         string
+		afterReboot  string // build running after the second reboot
+		wantFellBack bool
+	}{
+		{name: "ignored the first reboot", afterReboot: newBuild},
+		{name: "fell back", afterReboot: oldBuild, wantFellBack: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu      sync.Mutex
+				build   = oldBuild
+				reboots int
+			)
+			mux := http.NewServeMux()
+			mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				json.NewEncoder(w).Encode(fleet.Status{BuildTimestamp: build})
+			})
+			mux.HandleFunc("/reboot", func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				reboots++
+				build = tt.afterReboot
+			})
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			d := &fleet.Device{Hostname: "pi4", UpdateURL: srv.URL + "/"}
+			newer := time.Date(2021, 3, 1, 18, 0, 0, 0, time.UTC).Unix()
+			fellBack, err := recheckBuild(context.Background(), d, oldBuild, newer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fellBack != tt.wantFellBack {
+				t.Errorf("recheckBuild() = %v, want %v", fellBack, tt.wantFellBack)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if reboots != 1 {
+				t.Errorf("device rebooted %d times, want 1", reboots)
+			}
+		})
+	}
+}
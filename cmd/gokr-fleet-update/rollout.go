@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/fleet"
+	"github.com/gokrazy/autoupdate/internal/redact"
+)
+
+var (
+	canaries = flag.String("canaries",
+		"",
+		"comma-separated list of hostnames to update first. The rollout only proceeds to the -waves once all canaries came back healthy")
+
+	waves = flag.String("waves",
+		"",
+		"comma-separated list of cumulative percentages of the remaining (non-canary) devices to update in successive waves, e.g. 10,50,100. Devices beyond the last percentage are not updated. Defaults to all devices in one wave")
+
+	soak = flag.Duration("soak",
+		10*time.Minute,
+		"how long to wait after each stage of a staged rollout (-canaries or -waves) before checking the health of its devices")
+
+	healthTimeout = flag.Duration("health_timeout",
+		5*time.Minute,
+		"how long to wait for the devices of a staged rollout to become healthy (web interface responding, no stopped services) after the -soak time")
+)
+
+// staged returns whether the rollout is staged, i.e. whether stages wait for
+// the health of their devices before the next stage starts.
+func staged() bool {
+	return *canaries != "" || *waves != ""
+}
+
+// stage is a group of devices which are updated at the same time.
+type stage struct {
+	name    string
+	devices []*fleet.Device
+}
+
+func parseWaves(s string) ([]int, error) {
+	if s == "" {
+		return []int{100}, nil
+	}
+	var percentages []int
+	for _, item := range strings.Split(s, ",") {
+		pct, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil {
+			return nil, fmt.Errorf("-waves: %v", err)
+		}
+		if pct <= 0 || pct > 100 {
+			return nil, fmt.Errorf("-waves: percentage %d out of range (0, 100]", pct)
+		}
+		if len(percentages) > 0 && pct <= percentages[len(percentages)-1] {
+			return nil, fmt.Errorf("-waves: percentages must be increasing, %d follows %d", pct, percentages[len(percentages)-1])
+		}
+		percentages = append(percentages, pct)
+	}
+	return percentages, nil
+}
+
+// planStages splits devices into the -canaries stage and the -waves.
+func planStages(devices []*fleet.Device) ([]stage, error) {
+	percentages, err := parseWaves(*waves)
+	if err != nil {
+		return nil, err
+	}
+	isCanary := make(map[string]bool)
+	if *canaries != "" {
+		for _, host := range strings.Split(*canaries, ",") {
+			isCanary[strings.TrimSpace(host)] = true
+		}
+	}
+	var stages []stage
+	canary := stage{name: "canaries"}
+	var rest []*fleet.Device
+	for _, d := range devices {
+		if isCanary[d.Hostname] {
+			canary.devices = append(canary.devices, d)
+			delete(isCanary, d.Hostname)
+		} else {
+			rest = append(rest, d)
+		}
+	}
+	if len(isCanary) > 0 {
+		var missing []string
+		for host := range isCanary {
+			missing = append(missing, host)
+		}
+		sort.Strings(missing)
+		return nil, fmt.Errorf("canaries %q are not among the devices to update", missing)
+	}
+	if len(canary.devices) > 0 {
+		stages = append(stages, canary)
+	}
+	done := 0
+	for i, pct := range percentages {
+		n := (len(rest)*pct + 99) / 100 // rounded up
+		if n > done {
+			stages = append(stages, stage{
+				name:    fmt.Sprintf("wave %d (%d%%)", i+1, pct),
+				devices: rest[done:n],
+			})
+			done = n
+		}
+	}
+	for _, d := range rest[done:] {
+		log.Printf("%s: not updated, beyond the last wave", d.Hostname)
+	}
+	return stages, nil
+}
+
+// runStage updates the devices of st batch by batch (see -batch_size), with
+// -parallel devices at a time, and, in staged rollouts, checks their health
+// after the -soak time.
+func runStage(ctx context.Context, st stage) ([]result, error) {
+	results := make([]result, len(st.devices))
+	bs, err := batches(st.devices)
+	if err != nil {
+		return nil, err
+	}
+	sem := make(chan struct{}, *parallel)
+	var wg sync.WaitGroup
+	for n, batch := range bs {
+		if n > 0 && *batchDelay > 0 {
+			log.Printf("%s: waiting %v before the next batch", st.name, *batchDelay)
+			select {
+			case <-ctx.Done():
+			case <-time.After(*batchDelay):
+			}
+		}
+		if len(bs) > 1 {
+			log.Printf("%s: batch %d of %d: updating %d devices", st.name, n+1, len(bs), len(batch))
+		}
+		for _, i := range batch {
+			wg.Add(1)
+			go func(i int, d *fleet.Device) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				results[i] = update(ctx, d)
+				results[i].Stage = st.name
+			}(i, st.devices[i])
+		}
+		wg.Wait()
+	}
+	if !staged() {
+		return results, nil
+	}
+
+	log.Printf("%s: soaking for %v", st.name, *soak)
+	select {
+	case <-ctx.Done():
+	case <-time.After(*soak):
+	}
+	for i, d := range st.devices {
+		if !results[i].Success {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, d *fleet.Device) {
+			defer wg.Done()
+			if err := d.WaitHealthy(ctx, *healthTimeout); err != nil {
+				results[i].Success = false
+				results[i].Error = redact.String("health check: " + err.Error())
+				log.Printf("%s: %s", d.Hostname, results[i].Error)
+			}
+		}(i, d)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// rollout runs stages one after the other, aborting at the first stage with a
+// failed device. The devices of the remaining stages are reported as skipped.
+func rollout(ctx context.Context, stages []stage) ([]result, error) {
+	var (
+		results []result
+		aborted string
+	)
+	for _, st := range stages {
+		if aborted != "" {
+			for _, d := range st.devices {
+				results = append(results, result{
+					Hostname: d.Hostname,
+					Stage:    st.name,
+					Skipped:  true,
+					Error:    aborted,
+				})
+			}
+			continue
+		}
+		log.Printf("%s: updating %d devices", st.name, len(st.devices))
+		stageResults, err := runStage(ctx, st)
+		if err != nil {
+			return nil, err
+		}
+		for _, res := range stageResults {
+			if !res.Success && aborted == "" {
+				aborted = "rollout aborted after failures in " + st.name
+				log.Print(aborted)
+			}
+		}
+		results = append(results, stageResults...)
+	}
+	return results, nil
+}
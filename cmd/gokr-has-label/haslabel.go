@@ -4,23 +4,22 @@ import (
 	"context"
 	"flag"
 	"log"
-	"net/http"
 	"os"
 	"strconv"
 	"strings"
 
 	"github.com/gokrazy/autoupdate/internal/cienv"
-	"github.com/google/go-github/v35/github"
+	"github.com/gokrazy/autoupdate/internal/forge"
 )
 
-func hasLabel(ctx context.Context, client *github.Client, owner, repo string, issueNum int, label string) bool {
-	labels, _, err := client.Issues.ListLabelsByIssue(ctx, owner, repo, issueNum, nil)
+func hasLabel(ctx context.Context, f forge.Forge, owner, repo string, issueNum int, label string) bool {
+	labels, err := f.Labels(ctx, owner, repo, issueNum)
 	if err != nil {
 		log.Print(err)
 		return false
 	}
 	for _, l := range labels {
-		if *l.Name == label {
+		if l == label {
 			log.Printf("gokr-has-label %s? %v", label, true)
 			return true
 		}
@@ -30,10 +29,8 @@ func hasLabel(ctx context.Context, client *github.Client, owner, repo string, is
 }
 
 var (
-	githubUser        = cienv.MustGetGithubUser()
-	authToken         = cienv.MustGetAuthToken()
-	slug              = cienv.MustGetSlug()
-	travisPullRequest = cienv.MustGetPullRequest()
+	authToken = cienv.MustGetAuthToken()
+	slug      = cienv.MustGetSlug()
 )
 
 func main() {
@@ -49,22 +46,21 @@ func main() {
 		log.Fatalf("unexpected number of /-separated parts in %q: got %d, want %d", slug, got, want)
 	}
 
+	f, err := forge.FromFlags(authToken)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	travisPullRequest := cienv.MustResolvePullRequest(ctx, f, slug)
 	i, err := strconv.ParseInt(travisPullRequest, 0, 64)
 	if err != nil {
 		log.Fatalf("could not parse TRAVIS_PULL_REQUEST=%q as number: %v", os.Getenv("TRAVIS_PULL_REQUEST"), err)
 	}
 	issueNum := int(i)
 
-	client := github.NewClient(&http.Client{
-		Transport: &github.BasicAuthTransport{
-			Username: githubUser,
-			Password: authToken,
-		},
-	})
-
-	ctx := context.Background()
-
-	if hasLabel(ctx, client, parts[0], parts[1], issueNum, flag.Arg(0)) {
+	if hasLabel(ctx, f, parts[0], parts[1], issueNum, flag.Arg(0)) {
 		os.Exit(0)
 	}
 	os.Exit(1)
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+)
+
+var (
+	waitForChecks = flag.Bool("wait_for_checks",
+		false,
+		"before merging, wait until the commit statuses and check runs of the pull request head have completed successfully")
+
+	checksTimeout = flag.Duration("checks_timeout",
+		30*time.Minute,
+		"how long -wait_for_checks waits for pending checks before giving up")
+
+	checksPollInterval = flag.Duration("checks_poll_interval",
+		30*time.Second,
+		"how often -wait_for_checks polls the checks of the pull request head")
+
+	requiredChecks = flag.String("required_checks",
+		"",
+		"if non-empty, comma-separated list of status contexts and check run names which -wait_for_checks requires (e.g. the required checks of the branch protection). Other checks are ignored. By default, all checks are required")
+
+	ignoreChecks = flag.String("ignore_checks",
+		"",
+		"comma-separated list of status contexts and check run names which -wait_for_checks ignores, e.g. the job running gokr-merge, which cannot complete before gokr-merge")
+)
+
+func splitList(s string) map[string]bool {
+	m := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			m[name] = true
+		}
+	}
+	return m
+}
+
+// checkStates returns the state (pending, success or failure) of all commit
+// statuses and check runs of ref, by name.
+func checkStates(ctx context.Context, client *github.Client, owner, repo, ref string) (map[string]string, error) {
+	states := make(map[string]string)
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		combined, resp, err := client.Repositories.GetCombinedStatus(ctx, owner, repo, ref, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range combined.Statuses {
+			switch s.GetState() {
+			case "success", "pending":
+				states[s.GetContext()] = s.GetState()
+			default: // error, failure
+				states[s.GetContext()] = "failure"
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	runOpts := &github.ListCheckRunsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		runs, resp, err := client.Checks.ListCheckRunsForRef(ctx, owner, repo, ref, runOpts)
+		if err != nil {
+			return nil, err
+		}
+		for _, run := range runs.CheckRuns {
+			state := "pending"
+			if run.GetStatus() == "completed" {
+				switch run.GetConclusion() {
+				case "success", "neutral", "skipped":
+					state = "success"
+				default:
+					state = "failure"
+				}
+			}
+			states[run.GetName()] = state
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		runOpts.Page = resp.NextPage
+	}
+	return states, nil
+}
+
+// awaitChecks polls the checks of ref until they all succeeded (returning nil),
+// one of them failed or -checks_timeout expired.
+func awaitChecks(ctx context.Context, client *github.Client, owner, repo, ref string) error {
+	required := splitList(*requiredChecks)
+	ignored := splitList(*ignoreChecks)
+	ctx, cancel := context.WithTimeout(ctx, *checksTimeout)
+	defer cancel()
+	for polls := 0; ; polls++ {
+		states, err := checkStates(ctx, client, owner, repo, ref)
+		if err != nil {
+			return err
+		}
+		var pending, failed []string
+		for name, state := range states {
+			if ignored[name] || (len(required) > 0 && !required[name]) {
+				continue
+			}
+			switch state {
+			case "pending":
+				pending = append(pending, name)
+			case "failure":
+				failed = append(failed, name)
+			}
+		}
+		// Required checks may not have been created yet. Likewise, give
+		// checks of a freshly pushed commit one poll interval to appear.
+		for name := range required {
+			if _, ok := states[name]; !ok {
+				pending = append(pending, name)
+			}
+		}
+		if len(states) == 0 && polls == 0 {
+			pending = append(pending, "(none reported yet)")
+		}
+		sort.Strings(pending)
+		sort.Strings(failed)
+		if len(failed) > 0 {
+			return fmt.Errorf("checks failed on %s: %s", ref, strings.Join(failed, ", "))
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		log.Printf("waiting for checks on %s: %s", ref, strings.Join(pending, ", "))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("checks still pending on %s after %v: %s", ref, *checksTimeout, strings.Join(pending, ", "))
+		case <-time.After(*checksPollInterval):
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/google/go-github/v35/github"
+)
+
+var (
+	deleteBranch = flag.Bool("delete_branch",
+		true,
+		"delete the head branch of the pull request after merging. Branches of pull requests from forks are never deleted")
+
+	removeLabels = flag.String("remove_labels",
+		"",
+		"comma-separated list of labels (e.g. the -require_label) to remove from the pull request after merging")
+
+	summaryComment = flag.Bool("summary_comment",
+		false,
+		"after merging, comment on the pull request with the merge commit and the boot test results (see -boot_status_context)")
+
+	bootStatusContext = flag.String("boot_status_context",
+		"gokr-boot",
+		"context of the commit status which gokr-boot sets after a successful boot test (its -status_context), linked from the -summary_comment")
+)
+
+// bootEvidence returns the URL of the boot test results of sha, or "" if
+// there is no successful boot test status.
+func bootEvidence(ctx context.Context, client *github.Client, owner, repo, sha string) (string, error) {
+	combined, _, err := client.Repositories.GetCombinedStatus(ctx, owner, repo, sha, nil)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range combined.Statuses {
+		if s.GetContext() == *bootStatusContext && s.GetState() == "success" {
+			return s.GetTargetURL(), nil
+		}
+	}
+	return "", nil
+}
+
+// cleanup tidies up after pr was merged as mergeSHA. Failures are logged, as
+// the pull request was merged regardless.
+func cleanup(ctx context.Context, client *github.Client, owner, repo string, pr *github.PullRequest, mergeSHA string) {
+	if *deleteBranch {
+		if got, want := pr.GetHead().GetRepo().GetFullName(), owner+"/"+repo; got != want {
+			log.Printf("not deleting branch %s of fork %s", pr.GetHead().GetRef(), got)
+		} else if err := deleteRef(ctx, client, owner, repo, "heads/"+pr.GetHead().GetRef()); err != nil {
+			log.Printf("deleting branch %s: %v", pr.GetHead().GetRef(), err)
+		}
+	}
+
+	var labels []string
+	for label := range splitList(*removeLabels) {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		if _, err := client.Issues.RemoveLabelForIssue(ctx, owner, repo, pr.GetNumber(), label); err != nil {
+			log.Printf("removing label %q: %v", label, err)
+		}
+	}
+
+	if !*summaryComment {
+		return
+	}
+	body := fmt.Sprintf("Merged automatically as %s.", mergeSHA)
+	evidence, err := bootEvidence(ctx, client, owner, repo, pr.GetHead().GetSHA())
+	if err != nil {
+		log.Printf("looking up boot test results: %v", err)
+	}
+	if evidence != "" {
+		body += fmt.Sprintf(" The boot test of %s succeeded: %s", pr.GetHead().GetSHA(), evidence)
+	}
+	if _, _, err := client.Issues.CreateComment(ctx, owner, repo, pr.GetNumber(), &github.IssueComment{
+		Body: github.String(body),
+	}); err != nil {
+		log.Printf("commenting: %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"text/template"
+
+	"github.com/google/go-github/v35/github"
+)
+
+var (
+	mergeMethod = flag.String("merge_method",
+		"squash",
+		"how to merge the pull request: merge, squash or rebase")
+
+	commitTitle = flag.String("commit_title",
+		"",
+		"if non-empty, text/template for the title of the merge commit, e.g. {{.Title}} (#{{.Number}}), see mergeData for the available fields. Ignored with -merge_method=rebase")
+
+	commitMessage = flag.String("commit_message",
+		"automatically merged",
+		"text/template for the message of the merge commit, see -commit_title. Ignored with -merge_method=rebase")
+
+	repoConfig = flag.String("repo_config",
+		"",
+		`if non-empty, path to a JSON file with per-repository settings keyed by owner/repo, e.g. {"gokrazy/kernel": {"merge_method": "rebase"}}. Supported keys are merge_method, commit_title, commit_message, merge_windows and merge_timezone. Flags specified on the command line take precedence`)
+)
+
+// mergeConfig holds the merge settings of a repository.
+type mergeConfig struct {
+	MergeMethod   string `json:"merge_method"`
+	CommitTitle   string `json:"commit_title"`
+	CommitMessage string `json:"commit_message"`
+	MergeWindows  string `json:"merge_windows"`
+	MergeTimezone string `json:"merge_timezone"`
+}
+
+// loadMergeConfig returns the merge settings for slug: the flags, overridden
+// by the -repo_config entry for slug, overridden by flags specified on the
+// command line.
+func loadMergeConfig(slug string) (*mergeConfig, error) {
+	cfg := &mergeConfig{
+		MergeMethod:   *mergeMethod,
+		CommitTitle:   *commitTitle,
+		CommitMessage: *commitMessage,
+		MergeWindows:  *mergeWindows,
+		MergeTimezone: *mergeTimezone,
+	}
+	if *repoConfig != "" {
+		b, err := ioutil.ReadFile(*repoConfig)
+		if err != nil {
+			return nil, err
+		}
+		var repos map[string]mergeConfig
+		if err := json.Unmarshal(b, &repos); err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", *repoConfig, err)
+		}
+		if rc, ok := repos[slug]; ok {
+			if rc.MergeMethod != "" {
+				cfg.MergeMethod = rc.MergeMethod
+			}
+			if rc.CommitTitle != "" {
+				cfg.CommitTitle = rc.CommitTitle
+			}
+			if rc.CommitMessage != "" {
+				cfg.CommitMessage = rc.CommitMessage
+			}
+			if rc.MergeWindows != "" {
+				cfg.MergeWindows = rc.MergeWindows
+			}
+			if rc.MergeTimezone != "" {
+				cfg.MergeTimezone = rc.MergeTimezone
+			}
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "merge_method":
+			cfg.MergeMethod = *mergeMethod
+		case "commit_title":
+			cfg.CommitTitle = *commitTitle
+		case "commit_message":
+			cfg.CommitMessage = *commitMessage
+		case "merge_windows":
+			cfg.MergeWindows = *mergeWindows
+		case "merge_timezone":
+			cfg.MergeTimezone = *mergeTimezone
+		}
+	})
+	switch cfg.MergeMethod {
+	case "merge", "squash", "rebase":
+	default:
+		return nil, fmt.Errorf("invalid merge method %q, expected one of merge, squash or rebase", cfg.MergeMethod)
+	}
+	return cfg, nil
+}
+
+// mergeData is available in the -commit_title and -commit_message
+// templates.
+type mergeData struct {
+	Number int
+	Title  string
+	Body   string
+	Branch string // head branch
+	Base   string // base branch
+	Repo   string // owner/repo
+}
+
+func execTemplate(name, text string, data *mergeData) (string, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// commitText returns the title and message of the merge commit of pr.
+func (cfg *mergeConfig) commitText(slug string, pr *github.PullRequest) (title, message string, _ error) {
+	return cfg.commitTextFor(&mergeData{
+		Number: pr.GetNumber(),
+		Title:  pr.GetTitle(),
+		Body:   pr.GetBody(),
+		Branch: pr.GetHead().GetRef(),
+		Base:   pr.GetBase().GetRef(),
+		Repo:   slug,
+	})
+}
+
+// commitTextFor returns the title and message of the merge commit of the pull
+// request described by data.
+func (cfg *mergeConfig) commitTextFor(data *mergeData) (title, message string, _ error) {
+	title, err := execTemplate("commit_title", cfg.CommitTitle, data)
+	if err != nil {
+		return "", "", err
+	}
+	message, err = execTemplate("commit_message", cfg.CommitMessage, data)
+	if err != nil {
+		return "", "", err
+	}
+	return title, message, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"sort"
+
+	"github.com/gokrazy/autoupdate/internal/forge"
+)
+
+// githubOnlyFlags lists the flags of features which only GitHub provides.
+var githubOnlyFlags = []string{
+	"wait_for_checks",
+	"required_checks",
+	"ignore_checks",
+	"merge_queue",
+	"update_branch",
+	"allowed_authors",
+	"allowed_paths",
+	"delete_branch",
+	"summary_comment",
+}
+
+// checkForgeFlags fails if flags of GitHub-only features are set for another
+// -forge.
+func checkForgeFlags() {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, name := range githubOnlyFlags {
+		if set[name] {
+			log.Fatalf("-%s is only supported with -forge=github", name)
+		}
+	}
+}
+
+// mergeWithForge merges the pull request on a forge other than GitHub and
+// removes -remove_labels. It returns the merge commit.
+func mergeWithForge(ctx context.Context, f forge.Forge, owner, repo string, issueNum int, cfg *mergeConfig) (string, error) {
+	pr, err := f.PullRequest(ctx, owner, repo, issueNum)
+	if err != nil {
+		return "", err
+	}
+	title, message, err := cfg.commitTextFor(&mergeData{
+		Number: pr.Number,
+		Title:  pr.Title,
+		Body:   pr.Body,
+		Branch: pr.HeadRef,
+		Base:   pr.BaseRef,
+		Repo:   owner + "/" + repo,
+	})
+	if err != nil {
+		return "", err
+	}
+	mergeSHA, err := f.Merge(ctx, owner, repo, issueNum, forge.MergeOptions{
+		Method:  cfg.MergeMethod,
+		Title:   title,
+		Message: message,
+		// Commits pushed in the meantime have not been checked.
+		SHA: pr.HeadSHA,
+	})
+	if err != nil {
+		return "", err
+	}
+	var labels []string
+	for label := range splitList(*removeLabels) {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		if err := f.RemoveLabel(ctx, owner, repo, issueNum, label); err != nil {
+			log.Printf("removing label %q: %v", label, err)
+		}
+	}
+	return mergeSHA, nil
+}
@@ -5,12 +5,14 @@ import (
 	"context"
 	"flag"
 	"log"
-	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gokrazy/autoupdate/internal/cienv"
+	"github.com/gokrazy/autoupdate/internal/forge"
+	"github.com/gokrazy/autoupdate/internal/githubclient"
 	"github.com/google/go-github/v35/github"
 )
 
@@ -20,24 +22,67 @@ var (
 		"name of the required label before the PR will be merged")
 )
 
-func ensureLabel(ctx context.Context, client *github.Client, owner, repo string, issueNum int, label string) (bool, error) {
-	labels, _, err := client.Issues.ListLabelsByIssue(ctx, owner, repo, issueNum, nil)
+func ensureLabel(ctx context.Context, f forge.Forge, owner, repo string, issueNum int, label string) (bool, error) {
+	labels, err := f.Labels(ctx, owner, repo, issueNum)
 	if err != nil {
 		return true, err
 	}
 	for _, l := range labels {
-		if *l.Name == label {
+		if l == label {
 			return true, nil
 		}
 	}
 	return false, nil
 }
 
-func merge(ctx context.Context, client *github.Client, owner, repo string, issueNum int) error {
-	_, _, err := client.PullRequests.Merge(ctx, owner, repo, issueNum, "automatically merged", &github.PullRequestOptions{
-		MergeMethod: "squash",
-	})
-	return err
+// merge merges the pull request (or hands it to the merge queue, see
+// -merge_queue) and returns it together with the merge commit, which is ""
+// if the pull request was queued.
+func merge(ctx context.Context, client *github.Client, owner, repo string, issueNum int, cfg *mergeConfig) (*github.PullRequest, string, error) {
+	pr, _, err := client.PullRequests.Get(ctx, owner, repo, issueNum)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := checkSafety(ctx, client, owner, repo, pr); err != nil {
+		return nil, "", err
+	}
+	opts := &github.PullRequestOptions{
+		MergeMethod: cfg.MergeMethod,
+	}
+	var updated *github.PullRequest
+	if *updateBranch != "" {
+		updated, err = updatePullRequestBranch(ctx, client, owner, repo, pr)
+		if err != nil {
+			return nil, "", err
+		}
+		if updated != nil {
+			pr = updated
+		}
+	}
+	// The checks re-run on the updated branch.
+	if *waitForChecks || updated != nil {
+		head := pr.GetHead().GetSHA()
+		if err := awaitChecks(ctx, client, owner, repo, head); err != nil {
+			return nil, "", err
+		}
+	}
+	// Commits pushed in the meantime have not been checked.
+	opts.SHA = pr.GetHead().GetSHA()
+	var message string
+	if cfg.MergeMethod != "rebase" {
+		opts.CommitTitle, message, err = cfg.commitText(owner+"/"+repo, pr)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if *mergeQueue != "" {
+		return pr, "", enqueue(ctx, client, pr, opts, message)
+	}
+	result, _, err := client.PullRequests.Merge(ctx, owner, repo, issueNum, message, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	return pr, result.GetSHA(), nil
 }
 
 func deleteRef(ctx context.Context, client *github.Client, owner, repo string, ref string) error {
@@ -46,17 +91,22 @@ func deleteRef(ctx context.Context, client *github.Client, owner, repo string, r
 }
 
 var (
-	githubUser              = cienv.MustGetGithubUser()
-	authToken               = cienv.MustGetAuthToken()
-	slug                    = cienv.MustGetSlug()
-	travisPullRequest       = cienv.MustGetPullRequest()
-	travisPullRequestBranch = cienv.MustGetPullRequestBranch()
+	authToken         string
+	slug              string
+	travisPullRequest string
 )
 
+func init() {
+	cienv.RegisterFlags()
+}
+
 func main() {
 	flag.Parse()
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
+	authToken = cienv.MustGetAuthToken()
+	slug = cienv.MustGetSlug()
+
 	if *requireLabel == "" {
 		log.Fatal("-require_label is a required flag")
 	}
@@ -66,21 +116,30 @@ func main() {
 		log.Fatalf("unexpected number of /-separated parts in %q: got %d, want %d", slug, got, want)
 	}
 
+	cfg, err := loadMergeConfig(slug)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	ctx := context.Background()
 
-	client := github.NewClient(&http.Client{
-		Transport: &github.BasicAuthTransport{
-			Username: githubUser,
-			Password: authToken,
-		},
-	})
+	client := githubclient.New(authToken)
+	f, err := forge.FromFlags(authToken)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !forge.IsGitHub() {
+		checkForgeFlags()
+	}
+
+	travisPullRequest = cienv.MustResolvePullRequest(ctx, f, slug)
 
 	issueNum, err := strconv.ParseInt(travisPullRequest, 0, 64)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	found, err := ensureLabel(ctx, client, parts[0], parts[1], int(issueNum), *requireLabel)
+	found, err := ensureLabel(ctx, f, parts[0], parts[1], int(issueNum), *requireLabel)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -88,11 +147,38 @@ func main() {
 		os.Exit(2) // label not present
 	}
 
-	if err := merge(ctx, client, parts[0], parts[1], int(issueNum)); err != nil {
-		log.Fatal(err)
+	if cfg.MergeWindows != "" {
+		ok, err := inMergeWindow(cfg.MergeWindows, cfg.MergeTimezone, time.Now())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ok {
+			log.Printf("outside of the merge windows %q (%s), not merging", cfg.MergeWindows, cfg.MergeTimezone)
+			os.Exit(3)
+		}
+	}
+
+	if !forge.IsGitHub() {
+		mergeSHA, err := mergeWithForge(ctx, f, parts[0], parts[1], int(issueNum), cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if mergeSHA == "" {
+			log.Printf("merge scheduled by %s", f.Name())
+			return
+		}
+		log.Printf("merged as %s", mergeSHA)
+		return
 	}
 
-	if err := deleteRef(ctx, client, parts[0], parts[1], "heads/"+travisPullRequestBranch); err != nil {
+	pr, mergeSHA, err := merge(ctx, client, parts[0], parts[1], int(issueNum), cfg)
+	if err != nil {
 		log.Fatal(err)
 	}
+
+	if *mergeQueue != "" {
+		return // not merged yet
+	}
+
+	cleanup(ctx, client, parts[0], parts[1], pr, mergeSHA)
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gokrazy/autoupdate/internal/githubclient"
+	"github.com/google/go-github/v35/github"
+)
+
+var mergeQueue = flag.String("merge_queue",
+	"",
+	"if non-empty, hand the pull request to GitHub instead of merging it directly, for repositories with a merge queue: enqueue (add it to the merge queue right away, which requires passing checks) or auto (enable auto-merge, which adds it to the merge queue once all requirements are met). The merge queue deletes the branch, if so configured")
+
+const enqueueMutation = `mutation($id: ID!, $head: GitObjectID) {
+  enqueuePullRequest(input: {pullRequestId: $id, expectedHeadOid: $head}) {
+    mergeQueueEntry { position }
+  }
+}`
+
+const autoMergeMutation = `mutation($id: ID!, $head: GitObjectID, $method: PullRequestMergeMethod, $title: String, $body: String) {
+  enablePullRequestAutoMerge(input: {pullRequestId: $id, expectedHeadOid: $head, mergeMethod: $method, commitHeadline: $title, commitBody: $body}) {
+    clientMutationId
+  }
+}`
+
+// enqueue hands pr to the merge queue of its repository as selected by
+// -merge_queue. Merge method and commit text only apply to auto-merge in
+// repositories without a merge queue.
+func enqueue(ctx context.Context, client *github.Client, pr *github.PullRequest, opts *github.PullRequestOptions, message string) error {
+	variables := map[string]interface{}{
+		"id":   pr.GetNodeID(),
+		"head": pr.GetHead().GetSHA(),
+	}
+	switch *mergeQueue {
+	case "enqueue":
+		var data struct {
+			EnqueuePullRequest struct {
+				MergeQueueEntry struct {
+					Position int `json:"position"`
+				} `json:"mergeQueueEntry"`
+			} `json:"enqueuePullRequest"`
+		}
+		if err := githubclient.GraphQL(ctx, client, enqueueMutation, variables, &data); err != nil {
+			return err
+		}
+		log.Printf("pull request %d added to the merge queue at position %d", pr.GetNumber(), data.EnqueuePullRequest.MergeQueueEntry.Position)
+		return nil
+
+	case "auto":
+		variables["method"] = strings.ToUpper(opts.MergeMethod)
+		if opts.CommitTitle != "" {
+			variables["title"] = opts.CommitTitle
+		}
+		if message != "" {
+			variables["body"] = message
+		}
+		if err := githubclient.GraphQL(ctx, client, autoMergeMutation, variables, nil); err != nil {
+			return err
+		}
+		log.Printf("auto-merge enabled for pull request %d", pr.GetNumber())
+		return nil
+
+	default:
+		return fmt.Errorf("invalid -merge_queue=%q, expected enqueue or auto", *mergeQueue)
+	}
+}
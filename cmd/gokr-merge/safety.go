@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/gokrazy/autoupdate/internal/pathmatch"
+	"github.com/google/go-github/v35/github"
+)
+
+var (
+	allowedAuthors = flag.String("allowed_authors",
+		"",
+		"if non-empty, comma-separated list of GitHub users (e.g. gokrazy-bot) whose pull requests may be merged. Pull requests by other users are never merged")
+
+	allowedPaths = flag.String("allowed_paths",
+		"",
+		"if non-empty, comma-separated list of path patterns (path.Match syntax, or a directory prefix ending in /; patterns without a slash match in every directory), e.g. vmlinuz,*.dtb,config.txt. Pull requests changing other files are never merged")
+)
+
+// checkSafety returns an error if pr must not be merged automatically
+// according to -allowed_authors and -allowed_paths. It complements
+// -require_label (typically set by gokr-boot after a successful boot test) as
+// a defense against merging unexpected pull requests.
+func checkSafety(ctx context.Context, client *github.Client, owner, repo string, pr *github.PullRequest) error {
+	if *allowedAuthors != "" {
+		author := pr.GetUser().GetLogin()
+		allowed := false
+		for u := range splitList(*allowedAuthors) {
+			if strings.EqualFold(u, author) {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("refusing to merge: author %s not in -allowed_authors", author)
+		}
+	}
+
+	if *allowedPaths == "" {
+		return nil
+	}
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		files, resp, err := client.PullRequests.ListFiles(ctx, owner, repo, pr.GetNumber(), opts)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			for _, fn := range []string{f.GetFilename(), f.GetPreviousFilename()} {
+				if fn != "" && !pathmatch.Match(*allowedPaths, fn) {
+					return fmt.Errorf("refusing to merge: %s not in -allowed_paths", fn)
+				}
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return nil
+}
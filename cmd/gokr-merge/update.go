@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+)
+
+var updateBranch = flag.String("update_branch",
+	"",
+	"if non-empty, how to update the pull request branch when it is behind its base branch, as required by strict branch protection: merge (merge the base branch into the pull request branch, like the Update branch button) or rebase (recreate the single commit of the pull request on top of the base branch). After updating, gokr-merge waits for the checks of the new head (see -wait_for_checks)")
+
+// updateTimeout bounds how long gokr-merge waits for the pull request to
+// reflect an updated branch.
+const updateTimeout = 2 * time.Minute
+
+// behind returns whether the head of pr lacks commits of its base branch.
+func behind(ctx context.Context, client *github.Client, owner, repo string, pr *github.PullRequest) (bool, error) {
+	cmp, _, err := client.Repositories.CompareCommits(ctx, owner, repo, pr.GetBase().GetRef(), pr.GetHead().GetSHA())
+	if err != nil {
+		return false, err
+	}
+	return cmp.GetBehindBy() > 0, nil
+}
+
+// rebaseBranch recreates the commit of pr on top of its base branch using the
+// Git Data API: the base branch is merged into a temporary branch at the pull
+// request head, and the tree of that merge is committed with the base branch
+// as only parent.
+func rebaseBranch(ctx context.Context, client *github.Client, owner, repo string, pr *github.PullRequest) error {
+	if got, want := pr.GetHead().GetRepo().GetFullName(), owner+"/"+repo; got != want {
+		return fmt.Errorf("cannot rebase pull request branch of repository %s, want %s", got, want)
+	}
+	if got, want := pr.GetCommits(), 1; got != want {
+		return fmt.Errorf("rebasing requires a pull request with %d commit, got %d commits", want, got)
+	}
+	head := pr.GetHead().GetSHA()
+	baseRef, _, err := client.Git.GetRef(ctx, owner, repo, "heads/"+pr.GetBase().GetRef())
+	if err != nil {
+		return err
+	}
+	base := baseRef.GetObject().GetSHA()
+
+	tmp := fmt.Sprintf("gokr-merge/rebase-%d", pr.GetNumber())
+	if _, _, err := client.Git.CreateRef(ctx, owner, repo, &github.Reference{
+		Ref:    github.String("refs/heads/" + tmp),
+		Object: &github.GitObject{SHA: github.String(head)},
+	}); err != nil {
+		return err
+	}
+	defer func() {
+		if err := deleteRef(ctx, client, owner, repo, "heads/"+tmp); err != nil {
+			log.Printf("deleting temporary branch %s: %v", tmp, err)
+		}
+	}()
+	merged, _, err := client.Repositories.Merge(ctx, owner, repo, &github.RepositoryMergeRequest{
+		Base: github.String(tmp),
+		Head: github.String(base),
+	})
+	if err != nil {
+		return fmt.Errorf("merging %s into %s: %v", pr.GetBase().GetRef(), tmp, err)
+	}
+	orig, _, err := client.Git.GetCommit(ctx, owner, repo, head)
+	if err != nil {
+		return err
+	}
+	rebased, _, err := client.Git.CreateCommit(ctx, owner, repo, &github.Commit{
+		Message: orig.Message,
+		Author:  orig.Author,
+		Tree:    merged.GetCommit().GetTree(),
+		Parents: []*github.Commit{{SHA: github.String(base)}},
+	})
+	if err != nil {
+		return err
+	}
+	_, _, err = client.Git.UpdateRef(ctx, owner, repo, &github.Reference{
+		Ref:    github.String("refs/heads/" + pr.GetHead().GetRef()),
+		Object: &github.GitObject{SHA: rebased.SHA},
+	}, true)
+	return err
+}
+
+// updatePullRequestBranch updates the branch of pr if it is behind its base
+// branch, and returns the updated pull request, or nil if it was up to date.
+func updatePullRequestBranch(ctx context.Context, client *github.Client, owner, repo string, pr *github.PullRequest) (*github.PullRequest, error) {
+	isBehind, err := behind(ctx, client, owner, repo, pr)
+	if err != nil {
+		return nil, err
+	}
+	if !isBehind {
+		return nil, nil
+	}
+	head := pr.GetHead().GetSHA()
+	log.Printf("pull request branch %s is behind %s, updating (%s)", pr.GetHead().GetRef(), pr.GetBase().GetRef(), *updateBranch)
+	switch *updateBranch {
+	case "merge":
+		_, _, err := client.PullRequests.UpdateBranch(ctx, owner, repo, pr.GetNumber(), &github.PullRequestBranchUpdateOptions{
+			ExpectedHeadSHA: github.String(head),
+		})
+		// The update is performed asynchronously.
+		var accepted *github.AcceptedError
+		if err != nil && !errors.As(err, &accepted) {
+			return nil, err
+		}
+	case "rebase":
+		if err := rebaseBranch(ctx, client, owner, repo, pr); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid -update_branch=%q, expected merge or rebase", *updateBranch)
+	}
+
+	deadline := time.Now().Add(updateTimeout)
+	for time.Now().Before(deadline) {
+		updated, _, err := client.PullRequests.Get(ctx, owner, repo, pr.GetNumber())
+		if err != nil {
+			return nil, err
+		}
+		if updated.GetHead().GetSHA() != head {
+			log.Printf("pull request head updated from %s to %s", head, updated.GetHead().GetSHA())
+			return updated, nil
+		}
+		time.Sleep(5 * time.Second)
+	}
+	return nil, fmt.Errorf("pull request head still at %s %v after updating the branch", head, updateTimeout)
+}
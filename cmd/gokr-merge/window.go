@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	mergeWindows = flag.String("merge_windows",
+		"",
+		`if non-empty, semicolon-separated list of cron-like expressions (minute hour day-of-month month day-of-week, e.g. "* 9-16 * * 1-4" for Monday to Thursday, 09:00 to 16:59) describing when pull requests may be merged. Outside of all windows, gokr-merge exits with status 3 without merging`)
+
+	mergeTimezone = flag.String("merge_timezone",
+		"Local",
+		"IANA time zone (e.g. Europe/Zurich) in which -merge_windows are interpreted")
+)
+
+// cronField is a set of allowed values, as a bit mask.
+type cronField uint64
+
+// parseCronField parses a cron field (e.g. *, 5, 1-5, */15, 0-30/10, 1,3)
+// with values in [min, max].
+func parseCronField(s string, min, max int) (cronField, error) {
+	var f cronField
+	for _, part := range strings.Split(s, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			lo, err = strconv.Atoi(loStr)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", loStr)
+			}
+			hi = lo
+			if isRange {
+				hi, err = strconv.Atoi(hiStr)
+				if err != nil {
+					return 0, fmt.Errorf("invalid value %q", hiStr)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range [%d, %d]", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			f |= 1 << uint(v)
+		}
+	}
+	return f, nil
+}
+
+func (f cronField) has(v int) bool { return f&(1<<uint(v)) != 0 }
+
+// mergeWindow is a parsed cron-like expression.
+type mergeWindow struct {
+	minute, hour, dom, month, dow cronField
+	domAny, dowAny                bool
+}
+
+func parseMergeWindow(expr string) (*mergeWindow, error) {
+	fields := strings.Fields(expr)
+	if got, want := len(fields), 5; got != want {
+		return nil, fmt.Errorf("merge window %q: got %d fields, want %d", expr, got, want)
+	}
+	w := mergeWindow{
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}
+	for i, f := range []struct {
+		dst      *cronField
+		min, max int
+	}{
+		{&w.minute, 0, 59},
+		{&w.hour, 0, 23},
+		{&w.dom, 1, 31},
+		{&w.month, 1, 12},
+		{&w.dow, 0, 7},
+	} {
+		var err error
+		*f.dst, err = parseCronField(fields[i], f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("merge window %q: %v", expr, err)
+		}
+	}
+	// Like in cron, 7 is Sunday, too.
+	if w.dow.has(7) {
+		w.dow |= 1
+	}
+	return &w, nil
+}
+
+// contains returns whether t (in the window’s time zone) lies in the window.
+func (w *mergeWindow) contains(t time.Time) bool {
+	if !w.minute.has(t.Minute()) || !w.hour.has(t.Hour()) || !w.month.has(int(t.Month())) {
+		return false
+	}
+	domOK, dowOK := w.dom.has(t.Day()), w.dow.has(int(t.Weekday()))
+	// Like in cron, if both day fields are restricted, either may match.
+	if !w.domAny && !w.dowAny {
+		return domOK || dowOK
+	}
+	return domOK && dowOK
+}
+
+// inMergeWindow returns whether t lies in one of the semicolon-separated
+// windows, interpreted in the time zone tz.
+func inMergeWindow(windows, tz string, t time.Time) (bool, error) {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return false, err
+	}
+	t = t.In(loc)
+	for _, expr := range strings.Split(windows, ";") {
+		if strings.TrimSpace(expr) == "" {
+			continue
+		}
+		w, err := parseMergeWindow(expr)
+		if err != nil {
+			return false, err
+		}
+		if w.contains(t) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
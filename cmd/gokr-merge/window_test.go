@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMergeWindow(t *testing.T) {
+	for _, tt := range []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: "* * * * *"},
+		{expr: "* 9-16 * * 1-4"},
+		{expr: "*/15 0-6/2 1,15 * 7"},
+		{expr: "* * * *", wantErr: "got 4 fields, want 5"},
+		{expr: "60 * * * *", wantErr: "out of range [0, 59]"},
+		{expr: "* 16-9 * * *", wantErr: "out of range [0, 23]"},
+		{expr: "* * 0 * *", wantErr: "out of range [1, 31]"},
+		{expr: "*/0 * * * *", wantErr: `invalid step "0"`},
+		{expr: "* * * jan *", wantErr: `invalid value "jan"`},
+	} {
+		_, err := parseMergeWindow(tt.expr)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("parseMergeWindow(%q): %v", tt.expr, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("parseMergeWindow(%q): got error %v, want error containing %q", tt.expr, err, tt.wantErr)
+		}
+	}
+}
+
+func TestInMergeWindow(t *testing.T) {
+	// 2021-03-01 is a Monday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2021, 3, day, hour, minute, 0, 0, time.UTC)
+	}
+	for _, tt := range []struct {
+		windows string
+		t       time.Time
+		want    bool
+	}{
+		{windows: "* 9-16 * * 1-4", t: at(1, 9, 0), want: true},
+		{windows: "* 9-16 * * 1-4", t: at(1, 16, 59), want: true},
+		{windows: "* 9-16 * * 1-4", t: at(1, 17, 0)},
+		{windows: "* 9-16 * * 1-4", t: at(5, 10, 0)}, // Friday
+		{windows: "*/15 * * * *", t: at(1, 3, 45), want: true},
+		{windows: "*/15 * * * *", t: at(1, 3, 46)},
+		// Sunday as 7.
+		{windows: "* * * * 7", t: at(7, 12, 0), want: true},
+		// Either restricted day field matches, like in cron.
+		{windows: "* * 2 * 5", t: at(2, 12, 0), want: true},
+		{windows: "* * 2 * 5", t: at(5, 12, 0), want: true},
+		{windows: "* * 2 * 5", t: at(3, 12, 0)},
+		// Any of several windows.
+		{windows: "* 1 * * *; * 12 * * *", t: at(1, 12, 30), want: true},
+		{windows: ";", t: at(1, 12, 30)},
+	} {
+		got, err := inMergeWindow(tt.windows, "UTC", tt.t)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("inMergeWindow(%q, %v) = %v, want %v", tt.windows, tt.t, got, tt.want)
+		}
+	}
+
+	// The windows are interpreted in the specified time zone.
+	got, err := inMergeWindow("* 9-16 * * *", "America/New_York", at(1, 9, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got {
+		t.Errorf("09:00 UTC is in the 09:00-16:59 America/New_York window")
+	}
+}
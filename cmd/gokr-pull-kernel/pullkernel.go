@@ -2,17 +2,17 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
 	"flag"
-	"fmt"
 	"log"
-	"net/http"
 	"path"
 	"regexp"
 	"strings"
 
+	"github.com/gokrazy/autoupdate/internal/changelog"
 	"github.com/gokrazy/autoupdate/internal/cienv"
+	"github.com/gokrazy/autoupdate/internal/githubclient"
+	"github.com/gokrazy/autoupdate/internal/kernelorg"
+	"github.com/gokrazy/autoupdate/internal/updatepr"
 	"github.com/google/go-github/v35/github"
 )
 
@@ -20,151 +20,38 @@ var (
 	updaterPath = flag.String("updater_path",
 		"cmd/gokr-build-kernel/build.go",
 		"build.go path to update")
-)
 
-func getUpstreamURL(ctx context.Context) (string, error) {
-	resp, err := http.Get("https://www.kernel.org/releases.json")
-	if err != nil {
-		return "", err
-	}
-	if got, want := resp.StatusCode, http.StatusOK; got != want {
-		return "", fmt.Errorf("unexpected HTTP status code: got %d, want %d", got, want)
-	}
-	var releases struct {
-		LatestStable struct {
-			Version string `json:"version"`
-		} `json:"latest_stable"`
-		Releases []struct {
-			Version string `json:"version"`
-			Source  string `json:"source"`
-		} `json:"releases"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
-		return "", err
-	}
-	for _, release := range releases.Releases {
-		if release.Version != releases.LatestStable.Version {
-			continue
-		}
-		return release.Source, nil
-	}
-	return "", fmt.Errorf("malformed releases.json: latest stable release %q not found in releases list", releases.LatestStable.Version)
-}
+	closeSuperseded = flag.Bool("close_superseded",
+		true,
+		"close older open auto-update pull requests (and delete their branches) after creating a new one")
+)
 
 func updateKernel(ctx context.Context, client *github.Client, owner, repo string) error {
-	upstreamURL, err := getUpstreamURL(ctx)
-	if err != nil {
-		return err
-	}
-
-	lastRef, _, err := client.Git.GetRef(ctx, owner, repo, "heads/main")
-	if err != nil {
-		return err
-	}
-
-	lastCommit, _, err := client.Git.GetCommit(ctx, owner, repo, *lastRef.Object.SHA)
-	if err != nil {
-		return err
-	}
-
-	log.Printf("lastCommit = %+v", lastCommit)
-
-	baseTree, _, err := client.Git.GetTree(ctx, owner, repo, *lastCommit.SHA, true)
-	if err != nil {
-		return err
-	}
-	log.Printf("baseTree = %+v", baseTree)
-
-	var updaterSHA string
-	for _, entry := range baseTree.Entries {
-		if *entry.Path == *updaterPath {
-			updaterSHA = *entry.SHA
-			break
-		}
-	}
-
-	if updaterSHA == "" {
-		return fmt.Errorf("%s not found in %s/%s", *updaterPath, owner, repo)
-	}
-
-	updaterBlob, _, err := client.Git.GetBlob(ctx, owner, repo, updaterSHA)
-	if err != nil {
-		return err
-	}
-
-	updaterContent, err := base64.StdEncoding.DecodeString(*updaterBlob.Content)
-	if err != nil {
-		return err
-	}
-
-	kernelURLRe := regexp.MustCompile(`var latest = "([^"]+)"`)
-	matches := kernelURLRe.FindStringSubmatch(string(updaterContent))
-	if matches == nil {
-		return fmt.Errorf("regexp %v resulted in no matches", kernelURLRe)
-	}
-	if matches[1] == upstreamURL {
-		log.Printf("already at latest commit")
-		return nil
-	}
-	newContent := kernelURLRe.ReplaceAllLiteral(updaterContent,
-		[]byte(fmt.Sprintf(`var latest = "%s"`, upstreamURL)))
-
-	entries := []*github.TreeEntry{
-		{
-			Path:    github.String(*updaterPath),
-			Mode:    github.String("100644"),
-			Type:    github.String("blob"),
-			Content: github.String(string(newContent)),
-		},
-	}
-
-	newTree, _, err := client.Git.CreateTree(ctx, owner, repo, *baseTree.SHA, entries)
+	release, err := kernelorg.Latest(ctx, "")
 	if err != nil {
 		return err
 	}
-	log.Printf("newTree = %+v", newTree)
+	upstreamURL := release.Source
 
 	version := path.Base(upstreamURL)
-
-	newCommit, _, err := client.Git.CreateCommit(ctx, owner, repo, &github.Commit{
-		Message: github.String("auto-update to " + version),
-		Tree:    newTree,
-		Parents: []*github.Commit{lastCommit},
-	})
-	if err != nil {
-		return err
-	}
-	log.Printf("newCommit = %+v", newCommit)
-
-	newRef, _, err := client.Git.CreateRef(ctx, owner, repo, &github.Reference{
-		Ref: github.String("refs/heads/pull-" + version),
-		Object: &github.GitObject{
-			SHA: newCommit.SHA,
+	_, err = updatepr.Open(ctx, client, owner, repo, &updatepr.Update{
+		Path:    *updaterPath,
+		Pin:     regexp.MustCompile(`var latest = "([^"]+)"`),
+		Format:  `var latest = "%s"`,
+		Version: upstreamURL,
+		Name:    version,
+		Message: "auto-update to " + version,
+		Body: func(old string) string {
+			return changelog.Kernel(old, upstreamURL)
 		},
+		CloseSuperseded: *closeSuperseded,
 	})
-	if err != nil {
-		return err
-	}
-	log.Printf("newRef = %+v", newRef)
-
-	pr, _, err := client.PullRequests.Create(ctx, owner, repo, &github.NewPullRequest{
-		Title: github.String("auto-update to " + version),
-		Head:  github.String("pull-" + version),
-		Base:  github.String("main"),
-	})
-	if err != nil {
-		return err
-	}
-
-	log.Printf("pr = %+v", pr)
-
-	return nil
+	return err
 }
 
 var (
-	githubUser = cienv.MustGetGithubUser()
-	authToken  = cienv.MustGetAuthToken()
-	slug       = cienv.MustGetSlug()
+	authToken = cienv.MustGetAuthToken()
+	slug      = cienv.MustGetSlug()
 )
 
 func main() {
@@ -178,12 +65,7 @@ func main() {
 
 	ctx := context.Background()
 
-	client := github.NewClient(&http.Client{
-		Transport: &github.BasicAuthTransport{
-			Username: githubUser,
-			Password: authToken,
-		},
-	})
+	client := githubclient.New(authToken)
 
 	if err := updateKernel(ctx, client, parts[0], parts[1]); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/gokrazy/autoupdate/internal/changelog"
+	"github.com/gokrazy/autoupdate/internal/updatepr"
+	"github.com/gokrazy/autoupdate/internal/upstream"
+	"github.com/google/go-github/v35/github"
+)
+
+var watchConfigPath = flag.String("config",
+	"",
+	"if non-empty, path to a watch config file (JSON, which is also valid YAML; see watchConfig) declaring further upstream components to keep up to date, e.g. EEPROM, WiFi firmware or u-boot")
+
+// watchConfig is the -config file, e.g.:
+//
+//	{"watches": [{
+//	  "name": "eeprom",
+//	  "source": {"type": "git", "repo": "raspberrypi/rpi-eeprom", "paths": ["firmware-2711/latest/*.bin"]},
+//	  "target": {
+//	    "repo": "gokrazy/rpi-eeprom",
+//	    "path": "cmd/gokr-update-eeprom/eeprom.go",
+//	    "pin": "const eepromRef = \"([0-9a-f]+)\"",
+//	    "format": "const eepromRef = \"%s\""
+//	  }
+//	}]}
+type watchConfig struct {
+	Watches []*watch `json:"watches"`
+}
+
+// watch describes an upstream component to keep up to date.
+type watch struct {
+	// Name identifies the watch in logs and prefixes the branches of its
+	// pull requests (e.g. eeprom-<version>).
+	Name string `json:"name"`
+
+	Source watchSource `json:"source"`
+
+	// Transform, if non-empty, is a command (program and arguments) which
+	// reads the upstream version on stdin and prints the value to pin, e.g.
+	// after downloading and hashing a file.
+	Transform []string `json:"transform"`
+
+	Target watchTarget `json:"target"`
+}
+
+type watchSource struct {
+	// Type is git (a GitHub repository), http (a file, e.g. a download
+	// page) or feed (an RSS or Atom feed).
+	Type string `json:"type"`
+
+	// Repo (git) is the GitHub repository (owner/repo).
+	Repo string `json:"repo"`
+
+	// Paths (git) are path.Match patterns of the files to track. Without
+	// Paths, the most recent commit of the default branch is tracked.
+	Paths []string `json:"paths"`
+
+	// Tags (git) tracks the most recent tag instead of commits.
+	Tags bool `json:"tags"`
+
+	// URL (http, feed) is the file or feed to poll.
+	URL string `json:"url"`
+
+	// Regexp (http) matches the version as first submatch. For feeds, it
+	// selects the most recent entry whose title matches, with the first
+	// submatch (or the whole title) as version.
+	Regexp string `json:"regexp"`
+
+	re *regexp.Regexp
+}
+
+type watchTarget struct {
+	// Repo is the repository (owner/repo) to open pull requests in.
+	Repo string `json:"repo"`
+
+	// Path is the file pinning the version on the main branch.
+	Path string `json:"path"`
+
+	// Pin matches the pinned version as first submatch.
+	Pin string `json:"pin"`
+
+	// Format is the replacement for the match of Pin, with %s standing for
+	// the new version.
+	Format string `json:"format"`
+
+	// Workflow, if non-empty, is the file name of a GitHub Actions workflow
+	// to dispatch on the branch of new pull requests, e.g. to build the
+	// component and amend the pull request (gokr-amend).
+	Workflow string `json:"workflow"`
+
+	pin *regexp.Regexp
+}
+
+func loadWatchConfig(path string) (*watchConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg watchConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, w := range cfg.Watches {
+		if w.Name == "" {
+			return nil, fmt.Errorf("%s: watch without name", path)
+		}
+		switch w.Source.Type {
+		case "git":
+			if len(strings.Split(w.Source.Repo, "/")) != 2 {
+				return nil, fmt.Errorf("%s: watch %s: source repo %q is not owner/repo", path, w.Name, w.Source.Repo)
+			}
+		case "http", "feed":
+			if w.Source.URL == "" {
+				return nil, fmt.Errorf("%s: watch %s: source url missing", path, w.Name)
+			}
+		default:
+			return nil, fmt.Errorf("%s: watch %s: unknown source type %q, want git, http or feed", path, w.Name, w.Source.Type)
+		}
+		if w.Source.Regexp != "" {
+			if w.Source.re, err = regexp.Compile(w.Source.Regexp); err != nil {
+				return nil, fmt.Errorf("%s: watch %s: %v", path, w.Name, err)
+			}
+		} else if w.Source.Type == "http" {
+			return nil, fmt.Errorf("%s: watch %s: source regexp missing", path, w.Name)
+		}
+		if len(strings.Split(w.Target.Repo, "/")) != 2 {
+			return nil, fmt.Errorf("%s: watch %s: target repo %q is not owner/repo", path, w.Name, w.Target.Repo)
+		}
+		if w.Target.Path == "" || w.Target.Format == "" {
+			return nil, fmt.Errorf("%s: watch %s: target path and format are required", path, w.Name)
+		}
+		if w.Target.pin, err = regexp.Compile(w.Target.Pin); err != nil {
+			return nil, fmt.Errorf("%s: watch %s: %v", path, w.Name, err)
+		}
+	}
+	return &cfg, nil
+}
+
+// branchUnsafe matches characters which are not allowed (or unwieldy) in
+// branch names, e.g. in feed entry titles.
+var branchUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// transform runs the Transform command of w on version.
+func (w *watch) transform(ctx context.Context, version string) (string, error) {
+	cmd := exec.CommandContext(ctx, w.Transform[0], w.Transform[1:]...)
+	cmd.Stdin = strings.NewReader(version + "\n")
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%v: %v", cmd.Args, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// run opens a pull request if the source of w has a new version.
+func (w *watch) run(ctx context.Context, client *github.Client) error {
+	u := &updatepr.Update{
+		Path:            w.Target.Path,
+		Pin:             w.Target.pin,
+		Format:          w.Target.Format,
+		BranchPrefix:    w.Name + "-",
+		CloseSuperseded: *closeSuperseded,
+	}
+	var link string
+	switch w.Source.Type {
+	case "git":
+		parts := strings.Split(w.Source.Repo, "/")
+		switch {
+		case w.Source.Tags:
+			tag, sha, err := upstream.LatestTag(ctx, client, parts[0], parts[1])
+			if err != nil {
+				return err
+			}
+			u.Version, u.Name = sha, tag
+			if len(w.Source.Paths) > 0 && len(w.Transform) == 0 {
+				u.Relevant = func(old string) (bool, error) {
+					return upstream.Touches(ctx, client, parts[0], parts[1], old, sha, w.Source.Paths)
+				}
+			}
+		case len(w.Source.Paths) > 0:
+			sha, err := upstream.LatestCommit(ctx, client, parts[0], parts[1], w.Source.Paths)
+			if err != nil {
+				return err
+			}
+			u.Version, u.Name = sha, sha
+		default:
+			r, _, err := client.Repositories.Get(ctx, parts[0], parts[1])
+			if err != nil {
+				return err
+			}
+			branch, _, err := client.Repositories.GetBranch(ctx, parts[0], parts[1], r.GetDefaultBranch())
+			if err != nil {
+				return err
+			}
+			u.Version, u.Name = branch.GetCommit().GetSHA(), branch.GetCommit().GetSHA()
+		}
+		link = "https://github.com/" + w.Source.Repo + "/commit/" + u.Version
+		if len(w.Transform) == 0 {
+			upstreamSHA := u.Version
+			u.Body = func(old string) string {
+				// The changelog is informational, a failure does not
+				// prevent the update.
+				body, err := changelog.Commits(ctx, client, parts[0], parts[1], old, upstreamSHA)
+				if err != nil {
+					log.Printf("changelog: %v", err)
+				}
+				return body
+			}
+		}
+
+	case "http":
+		version, err := upstream.Regexp(ctx, w.Source.URL, w.Source.re)
+		if err != nil {
+			return err
+		}
+		u.Version, u.Name = version, version
+		link = w.Source.URL
+
+	case "feed":
+		entry, err := upstream.Feed(ctx, w.Source.URL, w.Source.re)
+		if err != nil {
+			return err
+		}
+		version := entry.Title
+		if w.Source.re != nil {
+			if m := w.Source.re.FindStringSubmatch(entry.Title); len(m) > 1 {
+				version = m[1]
+			}
+		}
+		u.Version, u.Name = version, version
+		link = entry.Link
+	}
+	u.Name = branchUnsafe.ReplaceAllString(u.Name, "-")
+
+	if len(w.Transform) > 0 {
+		pinned, err := w.transform(ctx, u.Version)
+		if err != nil {
+			return err
+		}
+		u.Version = pinned
+	}
+	u.Message = "auto-update " + w.Name + " to " + u.Name
+	if link != "" {
+		u.Message += "\n\n" + link
+	}
+	if u.Body == nil {
+		upstreamName := u.Name
+		u.Body = func(old string) string {
+			return fmt.Sprintf("%s %s → %s\n\n%s\n", w.Name, old, upstreamName, link)
+		}
+	}
+
+	parts := strings.Split(w.Target.Repo, "/")
+	pr, err := updatepr.Open(ctx, client, parts[0], parts[1], u)
+	if err != nil {
+		return err
+	}
+	if pr == nil || w.Target.Workflow == "" {
+		return nil
+	}
+	log.Printf("dispatching %s on %s", w.Target.Workflow, u.Branch())
+	_, err = client.Actions.CreateWorkflowDispatchEventByFileName(ctx, parts[0], parts[1], w.Target.Workflow, github.CreateWorkflowDispatchEventRequest{
+		Ref: u.Branch(),
+	})
+	return err
+}
@@ -0,0 +1,295 @@
+// gokr-watch is a daemon which polls kernel.org for new releases of the
+// tracked Linux series (and optionally github.com/raspberrypi/firmware for
+// new firmware and go.dev for new Go releases) and opens update pull requests
+// for them (like gokr-pull-kernel and gokr-pull-firmware), so that updates do
+// not depend on scheduled CI runs. It can also update the Go modules of
+// gokrazy instance repositories, like Dependabot, and watch further
+// components declared in a -config file.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/changelog"
+	"github.com/gokrazy/autoupdate/internal/cienv"
+	"github.com/gokrazy/autoupdate/internal/githubclient"
+	"github.com/gokrazy/autoupdate/internal/kernelorg"
+	"github.com/gokrazy/autoupdate/internal/updatepr"
+	"github.com/gokrazy/autoupdate/internal/upstream"
+	"github.com/google/go-github/v35/github"
+)
+
+var (
+	series = flag.String("series",
+		"",
+		"Linux series to track, e.g. 6.1 for a longterm kernel. If empty, the latest stable release is tracked")
+
+	pollInterval = flag.Duration("poll_interval",
+		1*time.Hour,
+		"how often to poll kernel.org for new releases")
+
+	updaterPath = flag.String("updater_path",
+		"cmd/gokr-build-kernel/build.go",
+		"build.go path to update")
+
+	rebuildWorkflow = flag.String("rebuild_workflow",
+		"",
+		"if non-empty, file name of a GitHub Actions workflow (e.g. build.yml) to dispatch on the branch of a new pull request, which rebuilds the kernel. Needed when the token is a GitHub Actions token, whose pushes do not trigger workflows")
+
+	firmwareSlug = flag.String("firmware_slug",
+		"",
+		"if non-empty, repository (owner/repo, e.g. gokrazy/firmware) in which to open pull requests updating to new github.com/raspberrypi/firmware commits")
+
+	firmwarePaths = flag.String("firmware_paths",
+		"boot/*.elf,boot/*.bin,boot/*.dat",
+		"comma-separated list of path.Match patterns of the github.com/raspberrypi/firmware files to track. Upstream changes which touch none of them are ignored")
+
+	firmwareTags = flag.Bool("firmware_tags",
+		false,
+		"track the most recent github.com/raspberrypi/firmware tag instead of the most recent commit of the default branch")
+
+	firmwareUpdaterPath = flag.String("firmware_updater_path",
+		"cmd/gokr-update-firmware/firmware.go",
+		"path of the file pinning the firmware commit in -firmware_slug")
+
+	goSlugs = flag.String("go_slugs",
+		"",
+		"comma-separated list of repositories (owner/repo) in which to open pull requests updating the Go toolchain to new Go releases, e.g. gokrazy instance repositories and the repositories of tooling images")
+
+	goUpdaterPath = flag.String("go_updater_path",
+		"go.mod",
+		"path of the file pinning the Go version in the -go_slugs repositories")
+
+	goPin = flag.String("go_pin",
+		`toolchain go([0-9.]+)`,
+		"regular expression matching the Go version in -go_updater_path, as first submatch")
+
+	goFormat = flag.String("go_format",
+		"toolchain go%s",
+		"replacement for the match of -go_pin, with %s standing for the new Go version")
+
+	goWorkflow = flag.String("go_workflow",
+		"",
+		"if non-empty, file name of a GitHub Actions workflow (e.g. boottest.yml) to dispatch on the branch of new Go update pull requests. It should build the images with the new toolchain and boot test them (gokr-boot), so that gokr-merge only merges working updates")
+
+	closeSuperseded = flag.Bool("close_superseded",
+		true,
+		"close older open auto-update pull requests (and delete their branches) after creating a new one")
+)
+
+// watchKernel opens a pull request if there is a new release of -series, and
+// triggers the kernel rebuild for it.
+func watchKernel(ctx context.Context, client *github.Client, owner, repo string) error {
+	release, err := kernelorg.Latest(ctx, *series)
+	if err != nil {
+		return err
+	}
+	upstreamURL := release.Source
+	version := path.Base(upstreamURL)
+	u := &updatepr.Update{
+		Path:    *updaterPath,
+		Pin:     regexp.MustCompile(`var latest = "([^"]+)"`),
+		Format:  `var latest = "%s"`,
+		Version: upstreamURL,
+		Name:    version,
+		Message: "auto-update to " + version,
+		Body: func(old string) string {
+			return changelog.Kernel(old, upstreamURL)
+		},
+		CloseSuperseded: *closeSuperseded,
+	}
+	pr, err := updatepr.Open(ctx, client, owner, repo, u)
+	if err != nil {
+		return err
+	}
+	if pr == nil || *rebuildWorkflow == "" {
+		return nil
+	}
+	log.Printf("dispatching %s on %s", *rebuildWorkflow, u.Branch())
+	_, err = client.Actions.CreateWorkflowDispatchEventByFileName(ctx, owner, repo, *rebuildWorkflow, github.CreateWorkflowDispatchEventRequest{
+		Ref: u.Branch(),
+	})
+	return err
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// watchFirmware opens a pull request in -firmware_slug if there are relevant
+// github.com/raspberrypi/firmware changes.
+func watchFirmware(ctx context.Context, client *github.Client, owner, repo string) error {
+	patterns := splitList(*firmwarePaths)
+	u := &updatepr.Update{
+		Path:            *firmwareUpdaterPath,
+		Pin:             regexp.MustCompile(`const firmwareRef = "([0-9a-f]+)"`),
+		Format:          `const firmwareRef = "%s"`,
+		CloseSuperseded: *closeSuperseded,
+	}
+	if *firmwareTags {
+		tag, sha, err := upstream.LatestTag(ctx, client, "raspberrypi", "firmware")
+		if err != nil {
+			return err
+		}
+		u.Version, u.Name = sha, tag
+		u.Relevant = func(old string) (bool, error) {
+			return upstream.Touches(ctx, client, "raspberrypi", "firmware", old, sha, patterns)
+		}
+	} else {
+		sha, err := upstream.LatestCommit(ctx, client, "raspberrypi", "firmware", patterns)
+		if err != nil {
+			return err
+		}
+		u.Version, u.Name = sha, sha
+	}
+	u.Message = "auto-update to https://github.com/raspberrypi/firmware/commit/" + u.Version
+	u.Body = func(old string) string {
+		// The changelog is informational, a failure does not prevent
+		// the update.
+		body, err := changelog.Commits(ctx, client, "raspberrypi", "firmware", old, u.Version)
+		if err != nil {
+			log.Printf("changelog: %v", err)
+		}
+		return body
+	}
+	_, err := updatepr.Open(ctx, client, owner, repo, u)
+	return err
+}
+
+// watchGo opens a pull request in owner/repo if there is a new Go release.
+func watchGo(ctx context.Context, client *github.Client, owner, repo string, pin *regexp.Regexp) error {
+	version, err := upstream.LatestGo(ctx)
+	if err != nil {
+		return err
+	}
+	u := &updatepr.Update{
+		Path:         *goUpdaterPath,
+		Pin:          pin,
+		Format:       *goFormat,
+		Version:      version,
+		Name:         "go" + version,
+		BranchPrefix: "gotoolchain-",
+		Message:      "auto-update to go" + version,
+		Body: func(old string) string {
+			return changelog.Go(old, version)
+		},
+		CloseSuperseded: *closeSuperseded,
+	}
+	pr, err := updatepr.Open(ctx, client, owner, repo, u)
+	if err != nil {
+		return err
+	}
+	if pr == nil || *goWorkflow == "" {
+		return nil
+	}
+	log.Printf("dispatching %s on %s", *goWorkflow, u.Branch())
+	_, err = client.Actions.CreateWorkflowDispatchEventByFileName(ctx, owner, repo, *goWorkflow, github.CreateWorkflowDispatchEventRequest{
+		Ref: u.Branch(),
+	})
+	return err
+}
+
+var authToken string
+
+func init() {
+	cienv.RegisterFlags()
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	authToken = cienv.MustGetAuthToken()
+	slug := cienv.MustGetSlug()
+
+	parts := strings.Split(slug, "/")
+	if got, want := len(parts), 2; got != want {
+		log.Fatalf("unexpected number of /-separated parts in %q: got %d, want %d", slug, got, want)
+	}
+
+	ctx := context.Background()
+
+	var firmwareParts []string
+	if *firmwareSlug != "" {
+		firmwareParts = strings.Split(*firmwareSlug, "/")
+		if got, want := len(firmwareParts), 2; got != want {
+			log.Fatalf("unexpected number of /-separated parts in %q: got %d, want %d", *firmwareSlug, got, want)
+		}
+	}
+
+	goRepos := splitList(*goSlugs)
+	for _, goSlug := range goRepos {
+		if got, want := len(strings.Split(goSlug, "/")), 2; got != want {
+			log.Fatalf("unexpected number of /-separated parts in %q: got %d, want %d", goSlug, got, want)
+		}
+	}
+	pin, err := regexp.Compile(*goPin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	modulesRepos := splitList(*modulesSlugs)
+	for _, modulesSlug := range modulesRepos {
+		if got, want := len(strings.Split(modulesSlug, "/")), 2; got != want {
+			log.Fatalf("unexpected number of /-separated parts in %q: got %d, want %d", modulesSlug, got, want)
+		}
+	}
+	groups, err := parseModuleGroups(*moduleGroups)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var lastModulesUpdate time.Time
+	cfg := &watchConfig{}
+	if *watchConfigPath != "" {
+		if cfg, err = loadWatchConfig(*watchConfigPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	client := githubclient.New(authToken)
+
+	for {
+		// Errors (e.g. kernel.org or GitHub being unavailable) are
+		// transient for a daemon: retry at the next poll.
+		if err := watchKernel(ctx, client, parts[0], parts[1]); err != nil {
+			log.Printf("watching kernel releases: %v", err)
+		}
+		if firmwareParts != nil {
+			if err := watchFirmware(ctx, client, firmwareParts[0], firmwareParts[1]); err != nil {
+				log.Printf("watching firmware: %v", err)
+			}
+		}
+		for _, goSlug := range goRepos {
+			goParts := strings.Split(goSlug, "/")
+			if err := watchGo(ctx, client, goParts[0], goParts[1], pin); err != nil {
+				log.Printf("watching Go releases for %s: %v", goSlug, err)
+			}
+		}
+		for _, w := range cfg.Watches {
+			if err := w.run(ctx, client); err != nil {
+				log.Printf("watch %s: %v", w.Name, err)
+			}
+		}
+		if time.Since(lastModulesUpdate) >= *modulesInterval {
+			lastModulesUpdate = time.Now()
+			for _, modulesSlug := range modulesRepos {
+				modulesParts := strings.Split(modulesSlug, "/")
+				if err := updateModules(ctx, client, modulesParts[0], modulesParts[1], groups); err != nil {
+					log.Printf("updating Go modules of %s: %v", modulesSlug, err)
+				}
+			}
+		}
+		time.Sleep(*pollInterval)
+	}
+}
@@ -3,13 +3,17 @@ module github.com/gokrazy/autoupdate
 go 1.18
 
 require (
+	filippo.io/age v1.0.0
 	github.com/gokrazy/internal v0.0.0-20230225153138-4c2e5af2e920
 	github.com/google/go-github/v35 v35.3.0
 	github.com/google/renameio/v2 v2.0.0
+	github.com/mattn/go-sqlite3 v1.14.16
+	golang.org/x/mod v0.14.0
 )
 
 require (
 	github.com/google/go-querystring v1.0.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	golang.org/x/crypto v0.17.0 // indirect
+	golang.org/x/sys v0.15.0 // indirect
 )
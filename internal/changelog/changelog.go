@@ -0,0 +1,96 @@
+// Package changelog summarizes what changed upstream between two versions,
+// for the body of auto-update pull requests.
+package changelog
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v35/github"
+)
+
+// maxCommits limits how many commits Commits lists individually.
+const maxCommits = 50
+
+// kernelVersion returns the version of a kernel.org source tarball URL, e.g.
+// 6.1.2 for https://cdn.kernel.org/pub/linux/kernel/v6.x/linux-6.1.2.tar.xz.
+func kernelVersion(u string) string {
+	base := path.Base(u)
+	base = strings.TrimPrefix(base, "linux-")
+	if i := strings.Index(base, ".tar"); i > -1 {
+		base = base[:i]
+	}
+	return base
+}
+
+// kernelChangeLog returns the URL of the kernel.org ChangeLog of version.
+func kernelChangeLog(version string) string {
+	major := strings.Split(version, ".")[0]
+	return fmt.Sprintf("https://cdn.kernel.org/pub/linux/kernel/v%s.x/ChangeLog-%s", major, version)
+}
+
+// Kernel returns a Markdown summary of the update from the kernel source
+// tarball at oldURL to the one at newURL. Within a stable series, it links the
+// ChangeLog of every release in between.
+func Kernel(oldURL, newURL string) string {
+	oldVersion, newVersion := kernelVersion(oldURL), kernelVersion(newURL)
+	var b strings.Builder
+	fmt.Fprintf(&b, "Linux %s → %s\n\n", oldVersion, newVersion)
+	oldParts, newParts := strings.Split(oldVersion, "."), strings.Split(newVersion, ".")
+	if len(oldParts) == 3 && len(newParts) == 3 &&
+		oldParts[0] == newParts[0] && oldParts[1] == newParts[1] {
+		from, err1 := strconv.Atoi(oldParts[2])
+		to, err2 := strconv.Atoi(newParts[2])
+		if err1 == nil && err2 == nil && from < to {
+			for patch := from + 1; patch <= to; patch++ {
+				version := fmt.Sprintf("%s.%s.%d", newParts[0], newParts[1], patch)
+				fmt.Fprintf(&b, "* [ChangeLog-%s](%s)\n", version, kernelChangeLog(version))
+			}
+			return b.String()
+		}
+	}
+	// A new series (or a release candidate): link the new release only.
+	fmt.Fprintf(&b, "* [ChangeLog-%s](%s)\n", newVersion, kernelChangeLog(newVersion))
+	return b.String()
+}
+
+// Go returns a Markdown summary of the update from Go version old to new
+// (e.g. 1.21.5 to 1.21.6), linking the release notes.
+func Go(old, new string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Go %s → %s\n\n", old, new)
+	series := strings.Join(strings.SplitN(new, ".", 3)[:2], ".")
+	if strings.HasPrefix(old, series+".") || old == series {
+		// A minor release: link the fixes.
+		fmt.Fprintf(&b, "* [Release history](https://go.dev/doc/devel/release#go%s)\n", new)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "* [Release notes](https://go.dev/doc/go%s)\n", series)
+	return b.String()
+}
+
+// Commits returns a Markdown list of the commits of the GitHub repository
+// owner/repo from old (exclusive) to new (inclusive).
+func Commits(ctx context.Context, client *github.Client, owner, repo, old, new string) (string, error) {
+	cmp, _, err := client.Repositories.CompareCommits(ctx, owner, repo, old, new)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s/%s@%.7s...%.7s](%s): %d commits\n\n", owner, repo, old, new, cmp.GetHTMLURL(), cmp.GetTotalCommits())
+	commits := cmp.Commits
+	if len(commits) > maxCommits {
+		fmt.Fprintf(&b, "Latest %d commits:\n\n", maxCommits)
+		commits = commits[len(commits)-maxCommits:]
+	}
+	// Newest first, like git log.
+	for i := len(commits) - 1; i >= 0; i-- {
+		c := commits[i]
+		subject := strings.SplitN(c.GetCommit().GetMessage(), "\n", 2)[0]
+		fmt.Fprintf(&b, "* [`%.7s`](%s) %s\n", c.GetSHA(), c.GetHTMLURL(), subject)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,62 @@
+package cienv
+
+import (
+	"errors"
+	"os"
+)
+
+// buildkiteProvider reads the Buildkite environment.
+type buildkiteProvider struct{}
+
+func (buildkiteProvider) Name() string { return "Buildkite" }
+
+func (buildkiteProvider) Detect() bool {
+	return os.Getenv("BUILDKITE") == "true"
+}
+
+// Slug derives the repository from the URL the pipeline builds, as
+// BUILDKITE_PIPELINE_SLUG names the pipeline, not the repository. It only
+// falls back to organization/pipeline if the URL is not recognized.
+func (buildkiteProvider) Slug() string {
+	if slug := slugFromURL(os.Getenv("BUILDKITE_REPO")); slug != "" {
+		return slug
+	}
+	org, pipeline := os.Getenv("BUILDKITE_ORGANIZATION_SLUG"), os.Getenv("BUILDKITE_PIPELINE_SLUG")
+	if org == "" || pipeline == "" {
+		return ""
+	}
+	return org + "/" + pipeline
+}
+
+func (buildkiteProvider) PullRequest() (string, error) {
+	pullRequest := os.Getenv("BUILDKITE_PULL_REQUEST")
+	if pullRequest == "" || pullRequest == "false" {
+		return "", errors.New("required environment variable BUILDKITE_PULL_REQUEST empty")
+	}
+	return pullRequest, nil
+}
+
+func (buildkiteProvider) PullRequestBranch() (string, error) {
+	pullRequestBranch := os.Getenv("BUILDKITE_BRANCH")
+	if pullRequestBranch == "" {
+		return "", errors.New("required environment variable BUILDKITE_BRANCH empty")
+	}
+	return pullRequestBranch, nil
+}
+
+func (buildkiteProvider) Head() (sha, branch string) {
+	return os.Getenv("BUILDKITE_COMMIT"), os.Getenv("BUILDKITE_BRANCH")
+}
+
+func (buildkiteProvider) User() string { return "" }
+
+func (buildkiteProvider) Token() string { return "" }
+
+func (buildkiteProvider) EventType() string {
+	if pullRequest := os.Getenv("BUILDKITE_PULL_REQUEST"); pullRequest != "" && pullRequest != "false" {
+		return "pull_request"
+	}
+	return os.Getenv("BUILDKITE_SOURCE")
+}
+
+func (buildkiteProvider) RunURL() string { return os.Getenv("BUILDKITE_BUILD_URL") }
@@ -1,3 +1,5 @@
+// Package cienv reads the repository, pull request and credentials from the
+// environment of the CI system gokrazy/autoupdate runs in.
 package cienv
 
 import (
@@ -5,51 +7,153 @@ import (
 	"os"
 )
 
-func MustGetGithubUser() string {
+// Provider is a CI system.
+type Provider interface {
+	// Name is the human-readable name of the CI system, for error messages.
+	Name() string
+
+	// Detect returns whether the process runs in this CI system.
+	Detect() bool
+
+	// Slug returns the repository (owner/repo), or "" if unknown.
+	Slug() string
+
+	// PullRequest returns the number of the pull request under test.
+	PullRequest() (string, error)
+
+	// PullRequestBranch returns the head branch of the pull request.
+	PullRequestBranch() (string, error)
+
+	// User and Token return the credentials for the code hosting API
+	// provided by the CI system, or "" if it provides none.
+	User() string
+	Token() string
+
+	// EventType returns what triggered the CI run (e.g. pull_request,
+	// push), or "" if unknown.
+	EventType() string
+}
+
+var providers []Provider
+
+// Register adds p to the providers which Detected considers, after the
+// previously registered ones.
+func Register(p Provider) {
+	providers = append(providers, p)
+}
+
+func init() {
+	// GitHub Actions first, as the Travis CI provider is also detected by
+	// Travis variables set by hand, e.g. in migrated workflows.
+	Register(githubActionsProvider{})
+	Register(gitlabProvider{})
+	Register(circleCIProvider{})
+	Register(droneProvider{})
+	Register(buildkiteProvider{})
+	Register(jenkinsProvider{})
+	Register(travisProvider{})
+}
+
+// Detected returns the first registered provider which detects its CI system,
+// or nil if none does.
+func Detected() Provider {
+	for _, p := range providers {
+		if p.Detect() {
+			return p
+		}
+	}
+	return nil
+}
+
+func providerName(p Provider) string {
+	if p == nil {
+		return "no CI system detected"
+	}
+	return p.Name()
+}
+
+// GithubUser returns the GitHub user which the auth token belongs to, or "" if
+// unknown. Token authentication does not require it.
+func GithubUser() string {
+	if override.githubUser != "" {
+		return override.githubUser
+	}
 	githubUser := os.Getenv("GITHUB_USER") // Travis CI
 	if githubUser == "" {
 		githubUser = os.Getenv("GH_USER") // GitHub actions
 	}
-	if githubUser == "" {
-		log.Fatal("required environment variable GITHUB_USER (or GH_USER) empty")
+	if p := Detected(); githubUser == "" && p != nil {
+		githubUser = p.User()
 	}
 	return githubUser
 }
 
+// MustGetAuthToken returns the GitHub token from GITHUB_AUTH_TOKEN (or
+// GH_AUTH_TOKEN), from the token broker at GOKR_TOKEN_BROKER_URL (see
+// brokerToken), or from the CI system, in that order. Brokered tokens are
+// short-lived, which suffices for a CI job.
 func MustGetAuthToken() string {
 	authToken := os.Getenv("GITHUB_AUTH_TOKEN") // Travis CI
 	if authToken == "" {
 		authToken = os.Getenv("GH_AUTH_TOKEN") // GitHub actions
 	}
+	if authToken == "" && os.Getenv("GOKR_TOKEN_BROKER_URL") != "" {
+		var err error
+		authToken, err = brokerToken()
+		if err != nil {
+			log.Fatalf("GOKR_TOKEN_BROKER_URL: %v", err)
+		}
+	}
+	if p := Detected(); authToken == "" && p != nil {
+		authToken = p.Token()
+	}
 	if authToken == "" {
-		log.Fatal("required environment variable GITHUB_AUTH_TOKEN (or GH_AUTH_TOKEN) empty")
+		log.Fatalf("required environment variable GITHUB_AUTH_TOKEN (or GH_AUTH_TOKEN) empty (%s)", providerName(Detected()))
 	}
 	return authToken
 }
 
 func MustGetSlug() string {
-	slug := os.Getenv("TRAVIS_REPO_SLUG") // Travis CI
-	if slug == "" {
-		slug = os.Getenv("GITHUB_REPOSITORY") // GitHub actions
+	if override.slug != "" {
+		return override.slug
 	}
+	p := Detected()
+	if p == nil {
+		log.Fatal("repository unknown: no CI system detected, specify -slug (or GOKR_SLUG)")
+	}
+	slug := p.Slug()
 	if slug == "" {
-		log.Fatal("required environment variable TRAVIS_REPO_SLUG (or GITHUB_REPOSITORY) empty")
+		log.Fatalf("%s: repository unknown", p.Name())
 	}
 	return slug
 }
 
 func MustGetPullRequest() string {
-	pullRequest := os.Getenv("TRAVIS_PULL_REQUEST")
-	if pullRequest == "" {
-		log.Fatal("required environment variable TRAVIS_PULL_REQUEST empty")
+	if override.pullRequest != "" {
+		return override.pullRequest
+	}
+	p := Detected()
+	if p == nil {
+		log.Fatal("pull request unknown: no CI system detected, specify -pr (or GOKR_PULL_REQUEST)")
+	}
+	pullRequest, err := p.PullRequest()
+	if err != nil {
+		log.Fatalf("%s: %v", p.Name(), err)
 	}
 	return pullRequest
 }
 
 func MustGetPullRequestBranch() string {
-	pullRequestBranch := os.Getenv("TRAVIS_PULL_REQUEST_BRANCH")
-	if pullRequestBranch == "" {
-		log.Fatal("required environment variable TRAVIS_PULL_REQUEST_BRANCH empty")
+	if override.pullRequestBranch != "" {
+		return override.pullRequestBranch
+	}
+	p := Detected()
+	if p == nil {
+		log.Fatal("pull request branch unknown: no CI system detected, specify -pr_branch (or GOKR_PULL_REQUEST_BRANCH)")
+	}
+	pullRequestBranch, err := p.PullRequestBranch()
+	if err != nil {
+		log.Fatalf("%s: %v", p.Name(), err)
 	}
 	return pullRequestBranch
 }
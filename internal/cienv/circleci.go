@@ -0,0 +1,62 @@
+package cienv
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+)
+
+// circleCIProvider reads the CircleCI environment.
+type circleCIProvider struct{}
+
+func (circleCIProvider) Name() string { return "CircleCI" }
+
+func (circleCIProvider) Detect() bool {
+	return os.Getenv("CIRCLECI") == "true"
+}
+
+func (circleCIProvider) Slug() string {
+	user, repo := os.Getenv("CIRCLE_PROJECT_USERNAME"), os.Getenv("CIRCLE_PROJECT_REPONAME")
+	if user == "" || repo == "" {
+		return ""
+	}
+	return user + "/" + repo
+}
+
+func (circleCIProvider) PullRequest() (string, error) {
+	// Only set for pull requests from forks.
+	if pullRequest := os.Getenv("CIRCLE_PR_NUMBER"); pullRequest != "" {
+		return pullRequest, nil
+	}
+	// e.g. https://github.com/gokrazy/gokrazy/pull/123
+	if u := os.Getenv("CIRCLE_PULL_REQUEST"); strings.Contains(u, "/pull/") {
+		return path.Base(u), nil
+	}
+	return "", errors.New("neither CIRCLE_PR_NUMBER nor CIRCLE_PULL_REQUEST set, is this a pull request build?")
+}
+
+func (circleCIProvider) PullRequestBranch() (string, error) {
+	pullRequestBranch := os.Getenv("CIRCLE_BRANCH")
+	if pullRequestBranch == "" {
+		return "", errors.New("required environment variable CIRCLE_BRANCH empty")
+	}
+	return pullRequestBranch, nil
+}
+
+func (circleCIProvider) Head() (sha, branch string) {
+	return os.Getenv("CIRCLE_SHA1"), os.Getenv("CIRCLE_BRANCH")
+}
+
+func (circleCIProvider) User() string { return "" }
+
+func (circleCIProvider) Token() string { return "" }
+
+func (circleCIProvider) EventType() string {
+	if os.Getenv("CIRCLE_PR_NUMBER") != "" || os.Getenv("CIRCLE_PULL_REQUEST") != "" {
+		return "pull_request"
+	}
+	return ""
+}
+
+func (circleCIProvider) RunURL() string { return os.Getenv("CIRCLE_BUILD_URL") }
@@ -0,0 +1,45 @@
+package cienv
+
+import (
+	"errors"
+	"os"
+)
+
+// droneProvider reads the Drone CI environment.
+type droneProvider struct{}
+
+func (droneProvider) Name() string { return "Drone" }
+
+func (droneProvider) Detect() bool {
+	return os.Getenv("DRONE") == "true"
+}
+
+func (droneProvider) Slug() string { return os.Getenv("DRONE_REPO") }
+
+func (droneProvider) PullRequest() (string, error) {
+	pullRequest := os.Getenv("DRONE_PULL_REQUEST")
+	if pullRequest == "" {
+		return "", errors.New("required environment variable DRONE_PULL_REQUEST empty, is this a pull_request event?")
+	}
+	return pullRequest, nil
+}
+
+func (droneProvider) PullRequestBranch() (string, error) {
+	pullRequestBranch := os.Getenv("DRONE_SOURCE_BRANCH")
+	if pullRequestBranch == "" {
+		return "", errors.New("required environment variable DRONE_SOURCE_BRANCH empty")
+	}
+	return pullRequestBranch, nil
+}
+
+func (droneProvider) Head() (sha, branch string) {
+	return os.Getenv("DRONE_COMMIT_SHA"), os.Getenv("DRONE_BRANCH")
+}
+
+func (droneProvider) User() string { return "" }
+
+func (droneProvider) Token() string { return "" }
+
+func (droneProvider) EventType() string { return os.Getenv("DRONE_BUILD_EVENT") }
+
+func (droneProvider) RunURL() string { return os.Getenv("DRONE_BUILD_LINK") }
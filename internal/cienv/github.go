@@ -0,0 +1,149 @@
+package cienv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// githubActionsProvider reads the GitHub Actions environment.
+type githubActionsProvider struct{}
+
+func (githubActionsProvider) Name() string { return "GitHub Actions" }
+
+func (githubActionsProvider) Detect() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true"
+}
+
+func (githubActionsProvider) Slug() string { return os.Getenv("GITHUB_REPOSITORY") }
+
+// User returns the user who triggered the workflow run. Token authentication
+// accepts any user name.
+func (githubActionsProvider) User() string { return os.Getenv("GITHUB_ACTOR") }
+
+// Token returns the token of the workflow run, if passed into the environment.
+func (githubActionsProvider) Token() string { return os.Getenv("GITHUB_TOKEN") }
+
+func (githubActionsProvider) EventType() string { return os.Getenv("GITHUB_EVENT_NAME") }
+
+// actionsEvent is the subset of the GitHub Actions event payload (at
+// GITHUB_EVENT_PATH) which identifies the pull request.
+type actionsEvent struct {
+	// Set for pull_request and pull_request_target events.
+	Action      string `json:"action"`
+	PullRequest *struct {
+		Number int `json:"number"`
+		Head   struct {
+			Ref string `json:"ref"`
+			SHA string `json:"sha"`
+		} `json:"head"`
+	} `json:"pull_request"`
+
+	// Set for issue_comment events. Issue.PullRequest is only set for
+	// comments on pull requests.
+	Issue *struct {
+		Number      int              `json:"number"`
+		PullRequest *json.RawMessage `json:"pull_request"`
+	} `json:"issue"`
+
+	// Set for workflow_run events, which run in the context of the
+	// default branch, not of the triggering workflow run.
+	WorkflowRun *struct {
+		HeadSHA    string `json:"head_sha"`
+		HeadBranch string `json:"head_branch"`
+	} `json:"workflow_run"`
+}
+
+func readActionsEvent() (*actionsEvent, error) {
+	path := os.Getenv("GITHUB_EVENT_PATH")
+	if path == "" {
+		return nil, fmt.Errorf("environment variable GITHUB_EVENT_PATH empty")
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ev actionsEvent
+	if err := json.Unmarshal(b, &ev); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return &ev, nil
+}
+
+// PullRequest returns the number of the pull request which triggered the
+// workflow, from the event payload or, failing that, from GITHUB_REF
+// (refs/pull/<number>/merge).
+func (githubActionsProvider) PullRequest() (string, error) {
+	// Workflows migrated from Travis CI set this by hand.
+	if pullRequest := os.Getenv("TRAVIS_PULL_REQUEST"); pullRequest != "" {
+		return pullRequest, nil
+	}
+	ev, err := readActionsEvent()
+	if err != nil {
+		return "", err
+	}
+	if ev.PullRequest != nil {
+		return strconv.Itoa(ev.PullRequest.Number), nil
+	}
+	if ev.Issue != nil && ev.Issue.PullRequest != nil {
+		return strconv.Itoa(ev.Issue.Number), nil
+	}
+	if ref := os.Getenv("GITHUB_REF"); strings.HasPrefix(ref, "refs/pull/") {
+		return strings.Split(strings.TrimPrefix(ref, "refs/pull/"), "/")[0], nil
+	}
+	return "", fmt.Errorf("%s event is not associated with a pull request", os.Getenv("GITHUB_EVENT_NAME"))
+}
+
+// Head returns the commit and branch which the workflow runs for, e.g. after a
+// push to an autoupdate branch or a workflow_dispatch.
+func (githubActionsProvider) Head() (sha, branch string) {
+	if ev, err := readActionsEvent(); err == nil && ev.WorkflowRun != nil {
+		return ev.WorkflowRun.HeadSHA, ev.WorkflowRun.HeadBranch
+	}
+	ref := os.Getenv("GITHUB_REF")
+	if strings.HasPrefix(ref, "refs/heads/") {
+		branch = strings.TrimPrefix(ref, "refs/heads/")
+	}
+	return os.Getenv("GITHUB_SHA"), branch
+}
+
+// PullRequestEvent returns the number, action (e.g. "synchronize") and head
+// commit of the pull_request or pull_request_target event which triggered the
+// GitHub Actions workflow, or zero values if it was not triggered by one.
+func PullRequestEvent() (number int, action, headSHA string) {
+	ev, err := readActionsEvent()
+	if err != nil || ev.PullRequest == nil {
+		return 0, "", ""
+	}
+	return ev.PullRequest.Number, ev.Action, ev.PullRequest.Head.SHA
+}
+
+// PullRequestBranch returns the head branch of the pull request which
+// triggered the workflow.
+func (githubActionsProvider) PullRequestBranch() (string, error) {
+	if pullRequestBranch := os.Getenv("TRAVIS_PULL_REQUEST_BRANCH"); pullRequestBranch != "" {
+		return pullRequestBranch, nil
+	}
+	if ref := os.Getenv("GITHUB_HEAD_REF"); ref != "" {
+		return ref, nil
+	}
+	ev, err := readActionsEvent()
+	if err != nil {
+		return "", err
+	}
+	if ev.PullRequest != nil && ev.PullRequest.Head.Ref != "" {
+		return ev.PullRequest.Head.Ref, nil
+	}
+	return "", fmt.Errorf("%s event does not carry the pull request branch", os.Getenv("GITHUB_EVENT_NAME"))
+}
+
+func (githubActionsProvider) RunURL() string {
+	server, repo, id := os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_RUN_ID")
+	if server == "" || repo == "" || id == "" {
+		return ""
+	}
+	return server + "/" + repo + "/actions/runs/" + id
+}
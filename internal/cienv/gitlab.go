@@ -0,0 +1,59 @@
+package cienv
+
+import (
+	"errors"
+	"os"
+)
+
+// gitlabProvider reads the GitLab CI environment: either a merge request
+// pipeline, or an external pull request pipeline of a GitHub repository
+// mirrored to GitLab.
+type gitlabProvider struct{}
+
+func (gitlabProvider) Name() string { return "GitLab CI" }
+
+func (gitlabProvider) Detect() bool {
+	return os.Getenv("GITLAB_CI") == "true"
+}
+
+func (gitlabProvider) Slug() string {
+	// The GitHub repository of an external pull request.
+	if slug := os.Getenv("CI_EXTERNAL_PULL_REQUEST_TARGET_REPOSITORY"); slug != "" {
+		return slug
+	}
+	return os.Getenv("CI_PROJECT_PATH")
+}
+
+func (gitlabProvider) PullRequest() (string, error) {
+	if iid := os.Getenv("CI_MERGE_REQUEST_IID"); iid != "" {
+		return iid, nil
+	}
+	if iid := os.Getenv("CI_EXTERNAL_PULL_REQUEST_IID"); iid != "" {
+		return iid, nil
+	}
+	return "", errors.New("neither CI_MERGE_REQUEST_IID nor CI_EXTERNAL_PULL_REQUEST_IID set, is this a merge request pipeline?")
+}
+
+func (gitlabProvider) PullRequestBranch() (string, error) {
+	if branch := os.Getenv("CI_MERGE_REQUEST_SOURCE_BRANCH_NAME"); branch != "" {
+		return branch, nil
+	}
+	if branch := os.Getenv("CI_EXTERNAL_PULL_REQUEST_SOURCE_BRANCH_NAME"); branch != "" {
+		return branch, nil
+	}
+	return "", errors.New("neither CI_MERGE_REQUEST_SOURCE_BRANCH_NAME nor CI_EXTERNAL_PULL_REQUEST_SOURCE_BRANCH_NAME set, is this a merge request pipeline?")
+}
+
+func (gitlabProvider) Head() (sha, branch string) {
+	return os.Getenv("CI_COMMIT_SHA"), os.Getenv("CI_COMMIT_BRANCH")
+}
+
+func (gitlabProvider) User() string { return os.Getenv("GITLAB_USER_LOGIN") }
+
+// Token returns the job token, which only authenticates against the GitLab
+// API. Pipelines against GitHub mirrors need GITHUB_AUTH_TOKEN.
+func (gitlabProvider) Token() string { return os.Getenv("CI_JOB_TOKEN") }
+
+func (gitlabProvider) EventType() string { return os.Getenv("CI_PIPELINE_SOURCE") }
+
+func (gitlabProvider) RunURL() string { return os.Getenv("CI_JOB_URL") }
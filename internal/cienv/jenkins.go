@@ -0,0 +1,79 @@
+package cienv
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// jenkinsProvider reads the Jenkins environment, as set up by the GitHub Pull
+// Request Builder plugin (ghprb*), multibranch pipelines (CHANGE_*) or the
+// Gerrit Trigger plugin (GERRIT_*), for which changes are pull requests.
+type jenkinsProvider struct{}
+
+func (jenkinsProvider) Name() string { return "Jenkins" }
+
+func (jenkinsProvider) Detect() bool {
+	return os.Getenv("JENKINS_URL") != ""
+}
+
+func (jenkinsProvider) Slug() string {
+	if slug := os.Getenv("ghprbGhRepository"); slug != "" {
+		return slug
+	}
+	if slug := os.Getenv("GERRIT_PROJECT"); slug != "" {
+		return slug
+	}
+	// Multibranch pipelines only provide URLs.
+	if slug := slugFromURL(os.Getenv("CHANGE_URL")); slug != "" {
+		return slug
+	}
+	return slugFromURL(os.Getenv("GIT_URL"))
+}
+
+func (jenkinsProvider) PullRequest() (string, error) {
+	if pullRequest := os.Getenv("ghprbPullId"); pullRequest != "" {
+		return pullRequest, nil
+	}
+	if pullRequest := os.Getenv("CHANGE_ID"); pullRequest != "" {
+		return pullRequest, nil
+	}
+	if change := os.Getenv("GERRIT_CHANGE_NUMBER"); change != "" {
+		return change, nil
+	}
+	return "", errors.New("none of ghprbPullId, CHANGE_ID or GERRIT_CHANGE_NUMBER set, is this a pull request build?")
+}
+
+func (jenkinsProvider) PullRequestBranch() (string, error) {
+	if pullRequestBranch := os.Getenv("ghprbSourceBranch"); pullRequestBranch != "" {
+		return pullRequestBranch, nil
+	}
+	if pullRequestBranch := os.Getenv("CHANGE_BRANCH"); pullRequestBranch != "" {
+		return pullRequestBranch, nil
+	}
+	// Changes have no branch of their own, only a ref.
+	if ref := os.Getenv("GERRIT_REFSPEC"); ref != "" {
+		return ref, nil
+	}
+	return "", errors.New("none of ghprbSourceBranch, CHANGE_BRANCH or GERRIT_REFSPEC set, is this a pull request build?")
+}
+
+func (jenkinsProvider) Head() (sha, branch string) {
+	if sha := os.Getenv("GERRIT_PATCHSET_REVISION"); sha != "" {
+		return sha, os.Getenv("GERRIT_BRANCH")
+	}
+	return os.Getenv("GIT_COMMIT"), strings.TrimPrefix(os.Getenv("GIT_BRANCH"), "origin/")
+}
+
+func (jenkinsProvider) User() string { return "" }
+
+func (jenkinsProvider) Token() string { return "" }
+
+func (jenkinsProvider) EventType() string {
+	if os.Getenv("ghprbPullId") != "" || os.Getenv("CHANGE_ID") != "" || os.Getenv("GERRIT_CHANGE_NUMBER") != "" {
+		return "pull_request"
+	}
+	return ""
+}
+
+func (jenkinsProvider) RunURL() string { return os.Getenv("BUILD_URL") }
@@ -0,0 +1,101 @@
+package cienv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// brokerTimeout bounds the OIDC token request and the exchange.
+const brokerTimeout = 1 * time.Minute
+
+// getJSON sends req and decodes the JSON response into v.
+func getJSON(req *http.Request, v interface{}) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		return fmt.Errorf("%s: unexpected HTTP status code: got %d (%s), want %d", req.URL.Host, got, string(b), want)
+	}
+	return json.Unmarshal(b, v)
+}
+
+// actionsIDToken requests an OIDC token for audience from GitHub Actions,
+// which requires the id-token: write permission in the workflow.
+func actionsIDToken(ctx context.Context, audience string) (string, error) {
+	requestURL := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL")
+	requestToken := os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN")
+	if requestURL == "" || requestToken == "" {
+		return "", fmt.Errorf("ACTIONS_ID_TOKEN_REQUEST_URL or ACTIONS_ID_TOKEN_REQUEST_TOKEN empty, does the workflow have the id-token: write permission?")
+	}
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return "", err
+	}
+	if audience != "" {
+		q := u.Query()
+		q.Set("audience", audience)
+		u.RawQuery = q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+requestToken)
+	var idToken struct {
+		Value string `json:"value"`
+	}
+	if err := getJSON(req, &idToken); err != nil {
+		return "", err
+	}
+	return idToken.Value, nil
+}
+
+// brokerToken exchanges the OIDC token of the GitHub Actions workflow run for
+// a short-lived GitHub token at the token broker GOKR_TOKEN_BROKER_URL, so
+// that repositories do not need to store a long-lived personal access token.
+//
+// The broker receives the OIDC token as bearer token in a POST request, and
+// must reply with a JSON object whose token field holds the GitHub token.
+// Typically, the broker verifies the repository and workflow claims and
+// returns an installation token of a GitHub App. The OIDC token audience is
+// GOKR_TOKEN_BROKER_AUDIENCE, or the broker URL if empty.
+func brokerToken() (string, error) {
+	brokerURL := os.Getenv("GOKR_TOKEN_BROKER_URL")
+	audience := os.Getenv("GOKR_TOKEN_BROKER_AUDIENCE")
+	if audience == "" {
+		audience = brokerURL
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+	defer cancel()
+	idToken, err := actionsIDToken(ctx, audience)
+	if err != nil {
+		return "", fmt.Errorf("requesting OIDC token: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, brokerURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+idToken)
+	var exchanged struct {
+		Token string `json:"token"`
+	}
+	if err := getJSON(req, &exchanged); err != nil {
+		return "", fmt.Errorf("exchanging OIDC token: %v", err)
+	}
+	if exchanged.Token == "" {
+		return "", fmt.Errorf("exchanging OIDC token: %s returned no token", brokerURL)
+	}
+	return exchanged.Token, nil
+}
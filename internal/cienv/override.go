@@ -0,0 +1,37 @@
+package cienv
+
+import (
+	"flag"
+	"os"
+)
+
+// Explicitly specified values, which take precedence over the CI environment.
+var override struct {
+	slug              string
+	pullRequest       string
+	pullRequestBranch string
+	githubUser        string
+}
+
+// RegisterFlags registers the -slug, -pr, -pr_branch and -github_user flags
+// on the default flag set. They (and the GOKR_SLUG, GOKR_PULL_REQUEST and
+// GOKR_PULL_REQUEST_BRANCH environment variables) take precedence over the CI
+// environment, so that the programs can be run manually, e.g. for one-off
+// tests or recovery. Call the MustGet functions after flag.Parse.
+func RegisterFlags() {
+	flag.StringVar(&override.slug, "slug",
+		os.Getenv("GOKR_SLUG"),
+		"repository (owner/repo) to operate on, instead of the one of the CI environment (default $GOKR_SLUG)")
+
+	flag.StringVar(&override.pullRequest, "pr",
+		os.Getenv("GOKR_PULL_REQUEST"),
+		"pull request number to operate on, instead of the one of the CI environment (default $GOKR_PULL_REQUEST)")
+
+	flag.StringVar(&override.pullRequestBranch, "pr_branch",
+		os.Getenv("GOKR_PULL_REQUEST_BRANCH"),
+		"head branch of the -pr pull request, instead of the one of the CI environment (default $GOKR_PULL_REQUEST_BRANCH)")
+
+	flag.StringVar(&override.githubUser, "github_user",
+		"",
+		"GitHub user which the token belongs to, instead of the one of the CI environment (default $GITHUB_USER). Only needed where the user matters, e.g. as default -author of gokr-backfill. The token is always read from $GITHUB_AUTH_TOKEN")
+}
@@ -0,0 +1,71 @@
+package cienv
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gokrazy/autoupdate/internal/forge"
+)
+
+// HeadProvider is implemented by providers which know the commit and branch
+// under test, which identify the pull request when the CI run was not
+// triggered by one (e.g. a push to an autoupdate branch).
+type HeadProvider interface {
+	// Head returns the commit and branch under test, either of which may
+	// be "" if unknown.
+	Head() (sha, branch string)
+}
+
+// MustResolvePullRequest is like MustGetPullRequest, but if the CI run was
+// not triggered by a pull request, it looks up the open pull request of the
+// commit or branch under test on forge f.
+func MustResolvePullRequest(ctx context.Context, f forge.Forge, slug string) string {
+	if override.pullRequest != "" {
+		return override.pullRequest
+	}
+	p := Detected()
+	if p == nil {
+		log.Fatal("pull request unknown: no CI system detected, specify -pr (or GOKR_PULL_REQUEST)")
+	}
+	pullRequest, err := p.PullRequest()
+	if err == nil {
+		return pullRequest
+	}
+	hp, ok := p.(HeadProvider)
+	if !ok {
+		log.Fatalf("%s: %v", p.Name(), err)
+	}
+	sha, branch := hp.Head()
+	owner, repo, _ := strings.Cut(slug, "/")
+	pr, ferr := f.FindPullRequest(ctx, owner, repo, sha, branch)
+	if ferr != nil {
+		log.Fatalf("%s: %v, and %v", p.Name(), err, ferr)
+	}
+	log.Printf("%s: %v, resolved pull request %d of commit %q/branch %q on %s", p.Name(), err, pr.Number, sha, branch, f.Name())
+	return strconv.Itoa(pr.Number)
+}
+
+// MustResolvePullRequestBranch is like MustGetPullRequestBranch, but falls
+// back to the head branch of pull request pullRequest on forge f.
+func MustResolvePullRequestBranch(ctx context.Context, f forge.Forge, slug, pullRequest string) string {
+	if override.pullRequestBranch != "" {
+		return override.pullRequestBranch
+	}
+	if p := Detected(); p != nil {
+		if branch, err := p.PullRequestBranch(); err == nil {
+			return branch
+		}
+	}
+	number, err := strconv.Atoi(pullRequest)
+	if err != nil {
+		log.Fatalf("pull request %q: %v", pullRequest, err)
+	}
+	owner, repo, _ := strings.Cut(slug, "/")
+	pr, err := f.PullRequest(ctx, owner, repo, number)
+	if err != nil {
+		log.Fatalf("pull request branch unknown: %v", err)
+	}
+	return pr.HeadRef
+}
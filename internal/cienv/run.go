@@ -0,0 +1,17 @@
+package cienv
+
+// RunProvider is implemented by providers which know the web URL of the CI
+// run, e.g. to identify the invocation which built an image.
+type RunProvider interface {
+	// RunURL returns the URL of the CI run, or "" if unknown.
+	RunURL() string
+}
+
+// RunURL returns the URL of the CI run of the detected provider, or "" if
+// unknown.
+func RunURL() string {
+	if rp, ok := Detected().(RunProvider); ok {
+		return rp.RunURL()
+	}
+	return ""
+}
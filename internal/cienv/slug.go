@@ -0,0 +1,31 @@
+package cienv
+
+import (
+	"strings"
+)
+
+// slugFromURL returns the owner/repo slug of a repository or pull request URL,
+// e.g. git@github.com:gokrazy/gokrazy.git,
+// https://github.com/gokrazy/gokrazy.git or
+// https://github.com/gokrazy/gokrazy/pull/123. It returns "" if u is not
+// recognized.
+func slugFromURL(u string) string {
+	if i := strings.Index(u, "://"); i > -1 {
+		u = u[i+len("://"):]
+		// Strip the host.
+		i = strings.IndexByte(u, '/')
+		if i == -1 {
+			return ""
+		}
+		u = u[i+1:]
+	} else if i := strings.IndexByte(u, ':'); i > -1 {
+		u = u[i+1:] // scp-like syntax
+	} else {
+		return ""
+	}
+	parts := strings.Split(strings.TrimSuffix(u, "/"), "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return ""
+	}
+	return parts[0] + "/" + strings.TrimSuffix(parts[1], ".git")
+}
@@ -0,0 +1,47 @@
+package cienv
+
+import (
+	"errors"
+	"os"
+)
+
+// travisProvider reads the Travis CI environment. As the original CI system
+// of gokrazy/autoupdate, it is also detected when only its variables were set
+// by hand.
+type travisProvider struct{}
+
+func (travisProvider) Name() string { return "Travis CI" }
+
+func (travisProvider) Detect() bool {
+	return os.Getenv("TRAVIS") == "true" || os.Getenv("TRAVIS_REPO_SLUG") != ""
+}
+
+func (travisProvider) Slug() string { return os.Getenv("TRAVIS_REPO_SLUG") }
+
+func (travisProvider) PullRequest() (string, error) {
+	pullRequest := os.Getenv("TRAVIS_PULL_REQUEST")
+	if pullRequest == "" || pullRequest == "false" {
+		return "", errors.New("required environment variable TRAVIS_PULL_REQUEST empty")
+	}
+	return pullRequest, nil
+}
+
+func (travisProvider) PullRequestBranch() (string, error) {
+	pullRequestBranch := os.Getenv("TRAVIS_PULL_REQUEST_BRANCH")
+	if pullRequestBranch == "" {
+		return "", errors.New("required environment variable TRAVIS_PULL_REQUEST_BRANCH empty")
+	}
+	return pullRequestBranch, nil
+}
+
+func (travisProvider) Head() (sha, branch string) {
+	return os.Getenv("TRAVIS_COMMIT"), os.Getenv("TRAVIS_BRANCH")
+}
+
+func (travisProvider) User() string { return "" }
+
+func (travisProvider) Token() string { return "" }
+
+func (travisProvider) EventType() string { return os.Getenv("TRAVIS_EVENT_TYPE") }
+
+func (travisProvider) RunURL() string { return os.Getenv("TRAVIS_BUILD_WEB_URL") }
@@ -0,0 +1,147 @@
+package fleet
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/renameio/v2"
+)
+
+// maxProbes is the maximum number of addresses Discover probes at the same
+// time.
+const maxProbes = 64
+
+// LocalSubnets returns the IPv4 subnets of the non-loopback network
+// interfaces, e.g. 192.168.1.0/24.
+func LocalSubnets() ([]*net.IPNet, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	var subnets []*net.IPNet
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.To4() == nil {
+			continue
+		}
+		subnets = append(subnets, &net.IPNet{
+			IP:   ipnet.IP.Mask(ipnet.Mask).To4(),
+			Mask: ipnet.Mask,
+		})
+	}
+	return subnets, nil
+}
+
+// hosts returns the host addresses of the IPv4 subnet n.
+func hosts(n *net.IPNet) ([]net.IP, error) {
+	ip := n.IP.To4()
+	if ip == nil {
+		return nil, fmt.Errorf("%v: only IPv4 subnets can be probed", n)
+	}
+	ones, bits := n.Mask.Size()
+	if bits-ones > 16 {
+		return nil, fmt.Errorf("%v: subnet too large to probe, use at most a /16", n)
+	}
+	first := binary.BigEndian.Uint32(ip)
+	size := uint32(1) << uint(bits-ones)
+	var ips []net.IP
+	for i := uint32(0); i < size; i++ {
+		if size > 2 && (i == 0 || i == size-1) {
+			continue // network and broadcast address
+		}
+		host := make(net.IP, 4)
+		binary.BigEndian.PutUint32(host, first+i)
+		ips = append(ips, host)
+	}
+	return ips, nil
+}
+
+// isGokrazy returns whether a gokrazy web interface answers at u: it requests
+// HTTP basic authentication with the realm gokrazy.
+func isGokrazy(ctx context.Context, client *http.Client, u string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusUnauthorized &&
+		strings.Contains(resp.Header.Get("WWW-Authenticate"), `realm="gokrazy"`)
+}
+
+// Discover probes the web interface port of all addresses of subnet and
+// returns the gokrazy devices found, sorted by hostname. Hostnames are
+// determined by reverse DNS lookups, falling back to the address.
+func Discover(ctx context.Context, subnet *net.IPNet, port int, timeout time.Duration) ([]*Device, error) {
+	ips, err := hosts(subnet)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: timeout}
+	var (
+		mu      sync.Mutex
+		devices []*Device
+		wg      sync.WaitGroup
+	)
+	sem := make(chan struct{}, maxProbes)
+	for _, ip := range ips {
+		wg.Add(1)
+		go func(ip net.IP) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			hostport := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+			if !isGokrazy(ctx, client, "http://"+hostport+"/") {
+				return
+			}
+			d := &Device{
+				Hostname:  ip.String(),
+				UpdateURL: "http://" + hostport + "/",
+			}
+			if names, err := net.DefaultResolver.LookupAddr(ctx, ip.String()); err == nil && len(names) > 0 {
+				name := strings.TrimSuffix(names[0], ".")
+				d.Hostname = strings.Split(name, ".")[0]
+				d.UpdateURL = "http://" + net.JoinHostPort(name, strconv.Itoa(port)) + "/"
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			devices = append(devices, d)
+		}(ip)
+	}
+	wg.Wait()
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Hostname < devices[j].Hostname })
+	return devices, ctx.Err()
+}
+
+// Add adds d to inv, unless inv has a device of the same hostname already. It
+// returns whether d was added.
+func (inv *Inventory) Add(d *Device) bool {
+	for _, existing := range inv.Devices {
+		if existing.Hostname == d.Hostname {
+			return false
+		}
+	}
+	inv.Devices = append(inv.Devices, d)
+	return true
+}
+
+// Write atomically writes inv to path as JSON.
+func (inv *Inventory) Write(path string) error {
+	b, err := json.MarshalIndent(inv, "", "\t")
+	if err != nil {
+		return err
+	}
+	return renameio.WriteFile(path, append(b, '\n'), 0644)
+}
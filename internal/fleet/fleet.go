@@ -0,0 +1,89 @@
+// Package fleet updates gokrazy devices over the network, via the update
+// endpoints of their web interface (like gok update does).
+package fleet
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Device is an entry of the inventory.
+type Device struct {
+	// Hostname identifies the device and selects its images.
+	Hostname string `json:"hostname"`
+
+	// UpdateURL is the base URL of the gokrazy web interface of the device,
+	// e.g. http://gokrazy:8080/. Defaults to http://<Hostname>/.
+	UpdateURL string `json:"update_url,omitempty"`
+
+	// Password is the HTTP password of the web interface. PasswordEnv, if
+	// non-empty, names an environment variable holding the password
+	// instead, so that the inventory need not contain secrets.
+	Password    string `json:"password,omitempty"`
+	PasswordEnv string `json:"password_env,omitempty"`
+
+	// Group optionally names the group of the device, e.g. dns, so that
+	// devices which provide the same service can be updated in batches of
+	// a group-specific size.
+	Group string `json:"group,omitempty"`
+
+	// Labels classify the device, e.g. role=dns, location=garage or
+	// model=rpi4, for targeting updates at devices with a Selector.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// BaseURL returns the base URL of the web interface of d, ending in a slash.
+func (d *Device) BaseURL() string {
+	u := d.UpdateURL
+	if u == "" {
+		u = "http://" + d.Hostname + "/"
+	}
+	if !strings.HasSuffix(u, "/") {
+		u += "/"
+	}
+	return u
+}
+
+func (d *Device) password() string {
+	if d.PasswordEnv != "" {
+		return os.Getenv(d.PasswordEnv)
+	}
+	return d.Password
+}
+
+// Inventory lists the devices of a fleet.
+type Inventory struct {
+	Devices []*Device `json:"devices"`
+}
+
+// LoadInventory reads an inventory from the JSON file at path, e.g.:
+//
+//	{"devices": [
+//	  {"hostname": "kitchen", "password_env": "KITCHEN_PASSWORD", "group": "dns",
+//	   "labels": {"role": "dns", "model": "rpi4"}},
+//	  {"hostname": "garage", "update_url": "http://10.0.0.7:8080/", "password_env": "GARAGE_PASSWORD"}
+//	]}
+func LoadInventory(path string) (*Inventory, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var inv Inventory
+	if err := json.Unmarshal(b, &inv); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	seen := make(map[string]bool)
+	for _, d := range inv.Devices {
+		if d.Hostname == "" {
+			return nil, fmt.Errorf("%s: device without hostname", path)
+		}
+		if seen[d.Hostname] {
+			return nil, fmt.Errorf("%s: duplicate device %s", path, d.Hostname)
+		}
+		seen[d.Hostname] = true
+	}
+	return &inv, nil
+}
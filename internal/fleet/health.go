@@ -0,0 +1,149 @@
+package fleet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Status is the subset of the gokrazy status API (the web interface root,
+// requested with Accept: application/json) which fleet looks at.
+type Status struct {
+	BuildTimestamp string `json:"BuildTimestamp"`
+	Services       []struct {
+		Path    string `json:"Path"`
+		Stopped bool   `json:"Stopped"`
+	} `json:"Services"`
+}
+
+// Status queries the status API of d.
+func (d *Device) Status(ctx context.Context) (*Status, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.BaseURL(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth("gokrazy", d.password())
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if got := resp.StatusCode; got != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return nil, &statusError{http.MethodGet, "/", got, strings.TrimSpace(string(b))}
+	}
+	var status Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// healthy returns an error if d does not respond or a service is stopped.
+func (d *Device) healthy(ctx context.Context) error {
+	status, err := d.Status(ctx)
+	if err != nil {
+		return err
+	}
+	var stopped []string
+	for _, svc := range status.Services {
+		if svc.Stopped {
+			stopped = append(stopped, svc.Path)
+		}
+	}
+	if len(stopped) > 0 {
+		return fmt.Errorf("services stopped: %s", strings.Join(stopped, ", "))
+	}
+	return nil
+}
+
+// WaitForBuild waits up to timeout for d to run a build other than the one
+// with build timestamp old (the build before Update) and, if newer is non-zero,
+// built after the UNIX timestamp newer. It returns the new build timestamp.
+func (d *Device) WaitForBuild(ctx context.Context, old string, newer int64, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := d.Status(ctx)
+		if err == nil {
+			err = checkBuild(status.BuildTimestamp, old, newer)
+			if err == nil {
+				return status.BuildTimestamp, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("not running the new build within %v: %v", timeout, err)
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// WaitForOldBuild waits up to timeout for d to run the build with build
+// timestamp old again, e.g. after Rollback.
+func (d *Device) WaitForOldBuild(ctx context.Context, old string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := d.Status(ctx)
+		if err == nil && status.BuildTimestamp == old {
+			return nil
+		}
+		if err == nil {
+			err = fmt.Errorf("running build %s", status.BuildTimestamp)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not back on build %s within %v: %v", old, timeout, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// checkBuild returns an error unless the build timestamp ts (RFC 3339, as
+// set by the gokrazy packer) differs from old and is after newer.
+func checkBuild(ts, old string, newer int64) error {
+	if ts == old {
+		return fmt.Errorf("still running build %s", ts)
+	}
+	if newer == 0 {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return fmt.Errorf("parsing build timestamp: %v", err)
+	}
+	if t.Unix() <= newer {
+		return fmt.Errorf("running build %s, which is older than the pushed build", ts)
+	}
+	return nil
+}
+
+// WaitHealthy waits up to timeout for d to respond with all services running,
+// e.g. after rebooting into an update.
+func (d *Device) WaitHealthy(ctx context.Context, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := d.healthy(ctx)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not healthy within %v: %v", timeout, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
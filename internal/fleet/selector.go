@@ -0,0 +1,66 @@
+package fleet
+
+import (
+	"fmt"
+	"strings"
+)
+
+// requirement is one comma-separated element of a Selector.
+type requirement struct {
+	key   string
+	value string
+	op    string // "=", "!=" or "" (label exists)
+}
+
+func (r requirement) matches(labels map[string]string) bool {
+	value, ok := labels[r.key]
+	switch r.op {
+	case "=":
+		return ok && value == r.value
+	case "!=":
+		return !ok || value != r.value
+	default:
+		return ok
+	}
+}
+
+// Selector selects devices by their labels.
+type Selector []requirement
+
+// ParseSelector parses a comma-separated list of label requirements, all of
+// which a device must meet to be selected: key=value, key!=value or key (the
+// device has the label key). The empty selector selects all devices.
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	if strings.TrimSpace(s) == "" {
+		return sel, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		var r requirement
+		if key, value, ok := strings.Cut(item, "!="); ok {
+			r = requirement{key: key, value: value, op: "!="}
+		} else if key, value, ok := strings.Cut(item, "="); ok {
+			r = requirement{key: key, value: value, op: "="}
+		} else {
+			r = requirement{key: item}
+		}
+		r.key = strings.TrimSpace(r.key)
+		r.value = strings.TrimSpace(r.value)
+		if r.key == "" {
+			return nil, fmt.Errorf("selector %q: requirement %q without label key", s, item)
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// Matches returns whether d meets all requirements of sel.
+func (sel Selector) Matches(d *Device) bool {
+	for _, r := range sel {
+		if !r.matches(d.Labels) {
+			return false
+		}
+	}
+	return true
+}
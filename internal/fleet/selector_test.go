@@ -0,0 +1,52 @@
+package fleet
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	devices := map[string]*Device{
+		"kitchen": {Labels: map[string]string{"room": "kitchen", "canary": ""}},
+		"garage":  {Labels: map[string]string{"room": "garage", "model": "pi4"}},
+		"plain":   {},
+	}
+	for _, tt := range []struct {
+		selector string
+		want     string // comma-separated names of the selected devices
+		wantErr  string
+	}{
+		{selector: "", want: "garage,kitchen,plain"},
+		{selector: " ", want: "garage,kitchen,plain"},
+		{selector: "room=kitchen", want: "kitchen"},
+		{selector: "room != kitchen", want: "garage,plain"},
+		{selector: "canary", want: "kitchen"},
+		{selector: "room, model=pi4", want: "garage"},
+		{selector: "room=kitchen,model=pi4", want: ""},
+		{selector: "model!=pi4,room", want: "kitchen"},
+		{selector: "room=", want: ""},
+		{selector: "=kitchen", wantErr: "without label key"},
+		{selector: "room,,canary", wantErr: "without label key"},
+	} {
+		sel, err := ParseSelector(tt.selector)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseSelector(%q): got error %v, want error containing %q", tt.selector, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSelector(%q): %v", tt.selector, err)
+			continue
+		}
+		var selected []string
+		for _, name := range []string{"garage", "kitchen", "plain"} {
+			if sel.Matches(devices[name]) {
+				selected = append(selected, name)
+			}
+		}
+		if got := strings.Join(selected, ","); got != tt.want {
+			t.Errorf("ParseSelector(%q) selects %q, want %q", tt.selector, got, tt.want)
+		}
+	}
+}
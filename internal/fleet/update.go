@@ -0,0 +1,133 @@
+package fleet
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// statusError is returned for unexpected HTTP status codes.
+type statusError struct {
+	method, path string
+	code         int
+	body         string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected HTTP status code: got %d (%s), want %d", e.method, e.path, e.code, e.body, http.StatusOK)
+}
+
+// do sends a request to the web interface of d and returns the response body.
+func (d *Device) do(ctx context.Context, method, path string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, d.BaseURL()+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth("gokrazy", d.password())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if got := resp.StatusCode; got != http.StatusOK {
+		return nil, &statusError{method, path, got, strings.TrimSpace(string(b))}
+	}
+	return b, nil
+}
+
+// features returns the update features (e.g. updatehash) which d supports.
+// Devices running old gokrazy versions support none.
+func (d *Device) features(ctx context.Context) (map[string]bool, error) {
+	b, err := d.do(ctx, http.MethodGet, "update/features", nil)
+	if err != nil {
+		var se *statusError
+		if errors.As(err, &se) && se.code == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	features := make(map[string]bool)
+	for _, f := range strings.Split(strings.TrimSpace(string(b)), ",") {
+		features[f] = true
+	}
+	return features, nil
+}
+
+// write streams the image at path to the update endpoint of partition (boot
+// or root). With the updatehash feature, the device returns the SHA-256 of
+// what it wrote, which is verified.
+func (d *Device) write(ctx context.Context, partition, path string, verify bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	log.Printf("%s: writing %s", d.Hostname, partition)
+	b, err := d.do(ctx, http.MethodPut, "update/"+partition, io.TeeReader(f, h))
+	if err != nil {
+		return err
+	}
+	if verify {
+		if got, want := strings.TrimSpace(string(b)), fmt.Sprintf("%x", h.Sum(nil)); got != want {
+			return fmt.Errorf("%s partition: unexpected SHA-256 hash: got %q, want %q", partition, got, want)
+		}
+	}
+	return nil
+}
+
+// Update writes the boot and root images bootImg and rootImg to d, switches to
+// the new root partition and reboots the device.
+func (d *Device) Update(ctx context.Context, bootImg, rootImg string) error {
+	features, err := d.features(ctx)
+	if err != nil {
+		return err
+	}
+	verify := features["updatehash"]
+	if err := d.write(ctx, "boot", bootImg, verify); err != nil {
+		return err
+	}
+	if err := d.write(ctx, "root", rootImg, verify); err != nil {
+		return err
+	}
+	log.Printf("%s: switching to the new root partition", d.Hostname)
+	if _, err := d.do(ctx, http.MethodPost, "update/switch", nil); err != nil {
+		return err
+	}
+	return d.Reboot(ctx)
+}
+
+// Reboot reboots d. It does not wait for d to come back.
+func (d *Device) Reboot(ctx context.Context) error {
+	log.Printf("%s: rebooting", d.Hostname)
+	// The device may close the connection before responding.
+	rctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if _, err := d.do(rctx, http.MethodPost, "reboot", nil); err != nil && ctx.Err() == nil {
+		log.Printf("%s: reboot: %v (ignored)", d.Hostname, err)
+	}
+	return ctx.Err()
+}
+
+// Rollback switches d back to the root partition it ran before Update and
+// reboots it. Only call it while d runs the build which Update wrote: the
+// switch always selects the partition which is not currently booted.
+func (d *Device) Rollback(ctx context.Context) error {
+	log.Printf("%s: switching back to the previous root partition", d.Hostname)
+	if _, err := d.do(ctx, http.MethodPost, "update/switch", nil); err != nil {
+		return err
+	}
+	return d.Reboot(ctx)
+}
@@ -0,0 +1,220 @@
+// Package forge abstracts the operations of the pull request workflow (labels,
+// comments, commit statuses, merging, file uploads and pastes for logs) over
+// the code forges hosting the repositories: GitHub, Gitea/Forgejo, GitLab and
+// Gerrit.
+package forge
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/gokrazy/autoupdate/internal/githubclient"
+)
+
+// PullRequest is a pull (or merge) request.
+type PullRequest struct {
+	Number   int
+	Title    string
+	Body     string
+	URL      string // web page
+	HeadSHA  string
+	HeadRef  string // head branch
+	BaseRef  string // base branch
+	Merged   bool
+	MergeSHA string // merge commit, if Merged
+}
+
+// Status is a commit status.
+type Status struct {
+	Context     string
+	State       string // pending, success, error or failure
+	Description string
+	TargetURL   string
+}
+
+// MergeOptions configure Merge.
+type MergeOptions struct {
+	Method  string // merge, squash or rebase
+	Title   string // of the merge commit, ignored for rebase
+	Message string // of the merge commit, ignored for rebase
+	SHA     string // if non-empty, the expected head commit
+}
+
+// Forge is a code forge hosting repositories.
+type Forge interface {
+	// Name returns the name of the forge kind, e.g. github.
+	Name() string
+
+	PullRequest(ctx context.Context, owner, repo string, number int) (*PullRequest, error)
+
+	// FindPullRequest returns the open pull request whose head is sha (or
+	// which contains sha) or, failing that, whose head branch is branch.
+	// Either may be empty. It identifies the pull request of a CI run which
+	// was not triggered by one, e.g. by a push to an autoupdate branch.
+	FindPullRequest(ctx context.Context, owner, repo, sha, branch string) (*PullRequest, error)
+
+	// Labels returns the names of the labels of the pull request.
+	Labels(ctx context.Context, owner, repo string, number int) ([]string, error)
+	AddLabel(ctx context.Context, owner, repo string, number int, label string) error
+	RemoveLabel(ctx context.Context, owner, repo string, number int, label string) error
+
+	// Comment adds a comment to the pull request and returns its URL.
+	Comment(ctx context.Context, owner, repo string, number int, body string) (string, error)
+
+	// Merge merges the pull request and returns the merge commit, which is
+	// empty if the forge merges it later (e.g. once a pipeline succeeded).
+	Merge(ctx context.Context, owner, repo string, number int, opts MergeOptions) (string, error)
+
+	SetStatus(ctx context.Context, owner, repo, sha string, st Status) error
+	Statuses(ctx context.Context, owner, repo, sha string) ([]Status, error)
+
+	// PutFile creates or updates the file at path on branch and returns
+	// the URL of its web page.
+	PutFile(ctx context.Context, owner, repo, branch, path, message string, content []byte) (string, error)
+
+	// Paste stores files (e.g. logs) which are not publicly listed and
+	// returns the URL under which they can be viewed.
+	Paste(ctx context.Context, description string, files map[string]string) (string, error)
+}
+
+var (
+	kind = flag.String("forge",
+		"github",
+		"code forge hosting the repositories: github, gitea (also for Forgejo) at -forge_url, gitlab, or gerrit at -forge_url. The API token is read like the GitHub token (e.g. $GITHUB_AUTH_TOKEN); for gerrit, it is username:http-password")
+
+	baseURL = flag.String("forge_url",
+		"",
+		"base URL of the -forge=gitea, -forge=gitlab or -forge=gerrit instance, e.g. https://git.example.net (defaults to https://gitlab.com for gitlab)")
+
+	pasteRepo = flag.String("forge_paste_repo",
+		"",
+		"with -forge=gitea, which has no gists: repository (owner/repo) into which logs are committed instead, one directory per paste. With -forge=gitlab: project (owner/repo) in which logs are stored as snippets (see -forge_gitlab_snippet_visibility) instead of personal snippets")
+
+	snippetVisibility = flag.String("forge_gitlab_snippet_visibility",
+		"private",
+		"with -forge=gitlab: visibility of the snippets in which logs are stored: private (visible to the members of -forge_paste_repo or, without it, only to the token owner), internal (all signed-in users) or public")
+
+	pasteBranch = flag.String("forge_paste_branch",
+		"main",
+		"branch of -forge_paste_repo into which logs are committed")
+
+	mergeWhenPipelineSucceeds = flag.Bool("forge_merge_when_pipeline_succeeds",
+		false,
+		"with -forge=gitlab: schedule merges for when the merge request pipeline succeeds instead of failing while it is running, for projects in which pipelines must succeed")
+
+	verifiedLabel = flag.String("forge_gerrit_verified_label",
+		"Verified",
+		"with -forge=gerrit: label on which boot test results vote +1 (success) or -1 (failure), or empty to only post review messages")
+
+	gerritSubmit = flag.Bool("forge_gerrit_submit",
+		false,
+		"with -forge=gerrit: submit the change once its boot test succeeded (and voted), provided it is submittable, e.g. approved by a reviewer")
+
+	pasteDir = flag.String("forge_paste_dir",
+		"",
+		"with -forge=gerrit, which has no pastes: directory in which logs are stored instead, one directory per paste. It must be served under -forge_paste_url")
+
+	pasteURL = flag.String("forge_paste_url",
+		"",
+		"URL under which -forge_paste_dir is served, e.g. https://logs.example.net/gokrazy")
+)
+
+// IsGitHub returns whether -forge selects GitHub, for features which only
+// GitHub provides (e.g. merge queues, deployments or check runs).
+func IsGitHub() bool {
+	return *kind == "github"
+}
+
+// RepositoryURL returns the web URL of the repository owner/repo on the
+// forge selected by -forge, e.g. to identify the source of a build.
+func RepositoryURL(owner, repo string) string {
+	base := strings.TrimSuffix(*baseURL, "/")
+	switch {
+	case *kind == "github":
+		base = "https://github.com"
+	case *kind == "gitlab" && base == "":
+		base = "https://gitlab.com"
+	}
+	return base + "/" + owner + "/" + repo
+}
+
+// FromFlags returns the forge selected by the -forge flags, authenticating
+// with token.
+func FromFlags(token string) (Forge, error) {
+	switch *kind {
+	case "github":
+		return NewGitHub(githubclient.New(token)), nil
+	case "gitea":
+		if *baseURL == "" {
+			return nil, fmt.Errorf("-forge_url is required with -forge=gitea")
+		}
+		g := &Gitea{
+			BaseURL:     strings.TrimSuffix(*baseURL, "/"),
+			Token:       token,
+			PasteBranch: *pasteBranch,
+		}
+		var err error
+		g.PasteOwner, g.PasteRepo, err = splitPasteRepo()
+		if err != nil {
+			return nil, err
+		}
+		return g, nil
+	case "gitlab":
+		g := &GitLab{
+			BaseURL:                   strings.TrimSuffix(*baseURL, "/"),
+			Token:                     token,
+			MergeWhenPipelineSucceeds: *mergeWhenPipelineSucceeds,
+			SnippetVisibility:         *snippetVisibility,
+		}
+		if g.BaseURL == "" {
+			g.BaseURL = "https://gitlab.com"
+		}
+		switch g.SnippetVisibility {
+		case "private", "internal", "public":
+		default:
+			return nil, fmt.Errorf("unknown -forge_gitlab_snippet_visibility %q, expected private, internal or public", g.SnippetVisibility)
+		}
+		var err error
+		g.SnippetOwner, g.SnippetRepo, err = splitPasteRepo()
+		if err != nil {
+			return nil, err
+		}
+		return g, nil
+	case "gerrit":
+		if *baseURL == "" {
+			return nil, fmt.Errorf("-forge_url is required with -forge=gerrit")
+		}
+		username, password, ok := strings.Cut(token, ":")
+		if !ok {
+			return nil, fmt.Errorf("the token must be username:http-password with -forge=gerrit")
+		}
+		return &Gerrit{
+			BaseURL:       strings.TrimSuffix(*baseURL, "/"),
+			Username:      username,
+			Password:      password,
+			VerifiedLabel: *verifiedLabel,
+			Submit:        *gerritSubmit,
+			PasteDir:      *pasteDir,
+			PasteURL:      *pasteURL,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown -forge %q, expected github, gitea, gitlab or gerrit", *kind)
+	}
+}
+
+func notFound(sha, branch string) error {
+	return fmt.Errorf("no open pull request found for commit %q or branch %q", sha, branch)
+}
+
+func splitPasteRepo() (owner, repo string, _ error) {
+	if *pasteRepo == "" {
+		return "", "", nil
+	}
+	parts := strings.Split(*pasteRepo, "/")
+	if got, want := len(parts), 2; got != want {
+		return "", "", fmt.Errorf("unexpected number of /-separated parts in %q: got %d, want %d", *pasteRepo, got, want)
+	}
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,338 @@
+package forge
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// gerritTagPrefix starts the tag of the review messages which SetStatus
+// posts. The autogenerated: prefix lets the Gerrit UI hide them.
+const gerritTagPrefix = "autogenerated:gokrazy-autoupdate:"
+
+// Gerrit implements Forge with the REST API of Gerrit, where pull requests
+// are changes, labels are hashtags and commit statuses are reviews which vote
+// on the VerifiedLabel. Repositories (owner/repo) are Gerrit projects.
+type Gerrit struct {
+	BaseURL string // e.g. https://review.example.net
+
+	// Username and Password are the HTTP credentials of the (bot) account.
+	Username, Password string
+
+	// VerifiedLabel is the label on which SetStatus votes +1 for successes
+	// and -1 for failures, e.g. Verified.
+	VerifiedLabel string
+
+	// Submit makes SetStatus submit the change after a successful vote,
+	// provided the change is submittable (e.g. approved by a reviewer).
+	Submit bool
+
+	// PasteDir is the directory in which Paste stores files, one directory
+	// per paste, as Gerrit has no pastes. The directory must be served
+	// under PasteURL.
+	PasteDir, PasteURL string
+}
+
+func (g *Gerrit) Name() string { return "gerrit" }
+
+func (g *Gerrit) do(ctx context.Context, method, path string, in, out interface{}) error {
+	auth := base64.StdEncoding.EncodeToString([]byte(g.Username + ":" + g.Password))
+	// The /a/ prefix selects authenticated access.
+	return doJSON(ctx, method, g.BaseURL+"/a", path, "Authorization", "Basic "+auth, in, out)
+}
+
+// changePath returns the API path of the change with the specified number in
+// the project owner/repo.
+func changePath(owner, repo string, number int) string {
+	return "/changes/" + url.PathEscape(owner+"/"+repo) + "~" + strconv.Itoa(number)
+}
+
+type gerritChange struct {
+	Number          int      `json:"_number"`
+	Project         string   `json:"project"`
+	Branch          string   `json:"branch"`
+	Subject         string   `json:"subject"`
+	Status          string   `json:"status"` // NEW, MERGED or ABANDONED
+	Hashtags        []string `json:"hashtags"`
+	CurrentRevision string   `json:"current_revision"`
+	Revisions       map[string]struct {
+		Number int    `json:"_number"`
+		Ref    string `json:"ref"`
+		Commit struct {
+			Message string `json:"message"`
+		} `json:"commit"`
+	} `json:"revisions"`
+	Labels map[string]struct {
+		All []struct {
+			Value int `json:"value"`
+		} `json:"all"`
+	} `json:"labels"`
+	Messages []struct {
+		Message        string `json:"message"`
+		Tag            string `json:"tag"`
+		RevisionNumber int    `json:"_revision_number"`
+	} `json:"messages"`
+}
+
+func (g *Gerrit) change(ctx context.Context, owner, repo string, number int, options ...string) (*gerritChange, error) {
+	query := url.Values{"o": options}
+	var change gerritChange
+	if err := g.do(ctx, http.MethodGet, changePath(owner, repo, number)+"?"+query.Encode(), nil, &change); err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// changeOf returns the change of which sha is a patch set.
+func (g *Gerrit) changeOf(ctx context.Context, owner, repo, sha string, options ...string) (*gerritChange, error) {
+	query := url.Values{
+		"q": []string{"commit:" + sha + " project:" + owner + "/" + repo},
+		"o": append([]string{"ALL_REVISIONS"}, options...),
+	}
+	var changes []gerritChange
+	if err := g.do(ctx, http.MethodGet, "/changes/?"+query.Encode(), nil, &changes); err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return nil, fmt.Errorf("no change found for commit %s in project %s/%s", sha, owner, repo)
+	}
+	return &changes[0], nil
+}
+
+func (g *Gerrit) changeURL(owner, repo string, number int) string {
+	return fmt.Sprintf("%s/c/%s/%s/+/%d", g.BaseURL, owner, repo, number)
+}
+
+func (g *Gerrit) PullRequest(ctx context.Context, owner, repo string, number int) (*PullRequest, error) {
+	change, err := g.change(ctx, owner, repo, number, "CURRENT_REVISION", "CURRENT_COMMIT")
+	if err != nil {
+		return nil, err
+	}
+	rev := change.Revisions[change.CurrentRevision]
+	// The commit message starts with the subject, which is the title.
+	_, body, _ := strings.Cut(rev.Commit.Message, "\n")
+	pr := &PullRequest{
+		Number:  change.Number,
+		Title:   change.Subject,
+		Body:    strings.TrimSpace(body),
+		URL:     g.changeURL(owner, repo, change.Number),
+		HeadSHA: change.CurrentRevision,
+		HeadRef: rev.Ref,
+		BaseRef: change.Branch,
+		Merged:  change.Status == "MERGED",
+	}
+	if pr.Merged {
+		pr.MergeSHA = change.CurrentRevision
+	}
+	return pr, nil
+}
+
+// FindPullRequest returns the open change of which sha is a patch set. Changes
+// have no head branch, so branch is ignored.
+func (g *Gerrit) FindPullRequest(ctx context.Context, owner, repo, sha, branch string) (*PullRequest, error) {
+	if sha != "" {
+		query := url.Values{"q": []string{"commit:" + sha + " project:" + owner + "/" + repo + " status:open"}}
+		var changes []gerritChange
+		if err := g.do(ctx, http.MethodGet, "/changes/?"+query.Encode(), nil, &changes); err != nil {
+			return nil, err
+		}
+		if len(changes) > 0 {
+			return g.PullRequest(ctx, owner, repo, changes[0].Number)
+		}
+	}
+	return nil, notFound(sha, branch)
+}
+
+// Labels returns the hashtags of the change and its votes, formatted as
+// label name and value, e.g. Boot-Test+1, so that both can trigger workflows.
+func (g *Gerrit) Labels(ctx context.Context, owner, repo string, number int) ([]string, error) {
+	change, err := g.change(ctx, owner, repo, number, "DETAILED_LABELS")
+	if err != nil {
+		return nil, err
+	}
+	var votes []string
+	for name, label := range change.Labels {
+		seen := make(map[int]bool)
+		for _, vote := range label.All {
+			if vote.Value == 0 || seen[vote.Value] {
+				continue
+			}
+			seen[vote.Value] = true
+			votes = append(votes, fmt.Sprintf("%s%+d", name, vote.Value))
+		}
+	}
+	sort.Strings(votes)
+	return append(change.Hashtags, votes...), nil
+}
+
+func (g *Gerrit) updateHashtags(ctx context.Context, owner, repo string, number int, field, hashtag string) error {
+	in := map[string][]string{field: {hashtag}}
+	return g.do(ctx, http.MethodPost, changePath(owner, repo, number)+"/hashtags", in, nil)
+}
+
+// AddLabel adds the hashtag to the change. Votes cannot be added.
+func (g *Gerrit) AddLabel(ctx context.Context, owner, repo string, number int, label string) error {
+	return g.updateHashtags(ctx, owner, repo, number, "add", label)
+}
+
+func (g *Gerrit) RemoveLabel(ctx context.Context, owner, repo string, number int, label string) error {
+	return g.updateHashtags(ctx, owner, repo, number, "remove", label)
+}
+
+type gerritReview struct {
+	Message string         `json:"message"`
+	Tag     string         `json:"tag,omitempty"`
+	Labels  map[string]int `json:"labels,omitempty"`
+}
+
+func (g *Gerrit) review(ctx context.Context, owner, repo string, number int, revision string, review gerritReview) error {
+	return g.do(ctx, http.MethodPost, changePath(owner, repo, number)+"/revisions/"+url.PathEscape(revision)+"/review", review, nil)
+}
+
+// Comment posts a review message on the current patch set and returns the
+// URL of the change, as messages have no URL of their own.
+func (g *Gerrit) Comment(ctx context.Context, owner, repo string, number int, body string) (string, error) {
+	if err := g.review(ctx, owner, repo, number, "current", gerritReview{Message: body}); err != nil {
+		return "", err
+	}
+	return g.changeURL(owner, repo, number), nil
+}
+
+func (g *Gerrit) submit(ctx context.Context, owner, repo string, number int) error {
+	return g.do(ctx, http.MethodPost, changePath(owner, repo, number)+"/submit", struct{}{}, nil)
+}
+
+// Merge submits the change. The submit type (and thereby the merge method)
+// and the commit message are configured in Gerrit, so opts.Method, opts.Title
+// and opts.Message are ignored. Merge returns the current patch set, which
+// is the merged commit for all submit types but merge commits.
+func (g *Gerrit) Merge(ctx context.Context, owner, repo string, number int, opts MergeOptions) (string, error) {
+	if opts.SHA != "" {
+		change, err := g.change(ctx, owner, repo, number, "CURRENT_REVISION")
+		if err != nil {
+			return "", err
+		}
+		if got, want := change.CurrentRevision, opts.SHA; got != want {
+			return "", fmt.Errorf("change %d has a new patch set: got %s, want %s", number, got, want)
+		}
+	}
+	if err := g.submit(ctx, owner, repo, number); err != nil {
+		return "", err
+	}
+	change, err := g.change(ctx, owner, repo, number, "CURRENT_REVISION")
+	if err != nil {
+		return "", err
+	}
+	return change.CurrentRevision, nil
+}
+
+// SetStatus posts a review message on the patch set sha, tagged with the
+// context and state, and votes on VerifiedLabel unless the state is pending.
+func (g *Gerrit) SetStatus(ctx context.Context, owner, repo, sha string, st Status) error {
+	var vote int
+	switch st.State {
+	case "pending":
+	case "success":
+		vote = 1
+	case "error", "failure":
+		vote = -1
+	default:
+		return fmt.Errorf("unknown commit status state %q", st.State)
+	}
+	change, err := g.changeOf(ctx, owner, repo, sha)
+	if err != nil {
+		return err
+	}
+	review := gerritReview{
+		Message: st.Context + ": " + st.Description,
+		Tag:     gerritTagPrefix + st.Context + ":" + st.State,
+	}
+	if st.TargetURL != "" {
+		review.Message += "\n\n" + st.TargetURL
+	}
+	if vote != 0 && g.VerifiedLabel != "" {
+		review.Labels = map[string]int{g.VerifiedLabel: vote}
+	}
+	if err := g.review(ctx, owner, repo, change.Number, sha, review); err != nil {
+		return err
+	}
+	if vote > 0 && g.Submit {
+		if err := g.submit(ctx, owner, repo, change.Number); err != nil {
+			return fmt.Errorf("submitting change %d: %v", change.Number, err)
+		}
+	}
+	return nil
+}
+
+// Statuses returns the latest status per context which SetStatus posted on
+// the patch set sha. Descriptions are not recovered from the messages.
+func (g *Gerrit) Statuses(ctx context.Context, owner, repo, sha string) ([]Status, error) {
+	change, err := g.changeOf(ctx, owner, repo, sha, "MESSAGES")
+	if err != nil {
+		return nil, err
+	}
+	patchSet := change.Revisions[sha].Number
+	// Messages are returned oldest first.
+	var contexts []string
+	states := make(map[string]string)
+	for _, msg := range change.Messages {
+		if msg.RevisionNumber != patchSet || !strings.HasPrefix(msg.Tag, gerritTagPrefix) {
+			continue
+		}
+		tag := strings.TrimPrefix(msg.Tag, gerritTagPrefix)
+		idx := strings.LastIndex(tag, ":")
+		if idx == -1 {
+			continue
+		}
+		name, state := tag[:idx], tag[idx+1:]
+		if _, ok := states[name]; !ok {
+			contexts = append(contexts, name)
+		}
+		states[name] = state
+	}
+	var result []Status
+	for _, name := range contexts {
+		result = append(result, Status{
+			Context: name,
+			State:   states[name],
+		})
+	}
+	return result, nil
+}
+
+// PutFile is not supported, as Gerrit only accepts commits by review.
+func (g *Gerrit) PutFile(ctx context.Context, owner, repo, branch, filePath, message string, content []byte) (string, error) {
+	return "", errors.New("committing files is not supported with -forge=gerrit")
+}
+
+// Paste stores files in a new directory of PasteDir and returns its URL
+// below PasteURL.
+func (g *Gerrit) Paste(ctx context.Context, description string, files map[string]string) (string, error) {
+	if g.PasteDir == "" || g.PasteURL == "" {
+		return "", fmt.Errorf("-forge_paste_dir and -forge_paste_url are required to store logs with -forge=gerrit")
+	}
+	dir, err := pasteDirName()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Join(g.PasteDir, dir), 0755); err != nil {
+		return "", err
+	}
+	for name, content := range files {
+		fn := filepath.Join(g.PasteDir, dir, path.Base(name))
+		if err := ioutil.WriteFile(fn, []byte(content), 0644); err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSuffix(g.PasteURL, "/") + "/" + dir + "/", nil
+}
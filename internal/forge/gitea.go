@@ -0,0 +1,307 @@
+package forge
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"time"
+)
+
+// Gitea implements Forge with the API (v1) of Gitea and Forgejo.
+type Gitea struct {
+	BaseURL string // e.g. https://git.example.net
+	Token   string
+
+	// PasteOwner/PasteRepo is the repository into which Paste commits
+	// files, on PasteBranch, as Gitea has no gists.
+	PasteOwner, PasteRepo string
+	PasteBranch           string
+}
+
+func (g *Gitea) Name() string { return "gitea" }
+
+func (g *Gitea) do(ctx context.Context, method, path string, in, out interface{}) error {
+	return doJSON(ctx, method, g.BaseURL+"/api/v1", path, "Authorization", "token "+g.Token, in, out)
+}
+
+func repoPath(owner, repo string) string {
+	return "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(repo)
+}
+
+type giteaPullRequest struct {
+	Number         int    `json:"number"`
+	Title          string `json:"title"`
+	Body           string `json:"body"`
+	HTMLURL        string `json:"html_url"`
+	Merged         bool   `json:"merged"`
+	MergeCommitSHA string `json:"merge_commit_sha"`
+	Head           struct {
+		SHA string `json:"sha"`
+		Ref string `json:"ref"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+func (g *Gitea) PullRequest(ctx context.Context, owner, repo string, number int) (*PullRequest, error) {
+	var pr giteaPullRequest
+	if err := g.do(ctx, http.MethodGet, fmt.Sprintf("%s/pulls/%d", repoPath(owner, repo), number), nil, &pr); err != nil {
+		return nil, err
+	}
+	return &PullRequest{
+		Number:   pr.Number,
+		Title:    pr.Title,
+		Body:     pr.Body,
+		URL:      pr.HTMLURL,
+		HeadSHA:  pr.Head.SHA,
+		HeadRef:  pr.Head.Ref,
+		BaseRef:  pr.Base.Ref,
+		Merged:   pr.Merged,
+		MergeSHA: pr.MergeCommitSHA,
+	}, nil
+}
+
+func (g *Gitea) FindPullRequest(ctx context.Context, owner, repo, sha, branch string) (*PullRequest, error) {
+	// Gitea cannot list the pull requests of a commit, so match the heads
+	// of all open pull requests.
+	var byBranch int
+	for page := 1; ; page++ {
+		var prs []giteaPullRequest
+		if err := g.do(ctx, http.MethodGet, fmt.Sprintf("%s/pulls?state=open&limit=50&page=%d", repoPath(owner, repo), page), nil, &prs); err != nil {
+			return nil, err
+		}
+		if len(prs) == 0 {
+			break
+		}
+		for _, pr := range prs {
+			if sha != "" && pr.Head.SHA == sha {
+				return g.PullRequest(ctx, owner, repo, pr.Number)
+			}
+			if branch != "" && pr.Head.Ref == branch && byBranch == 0 {
+				byBranch = pr.Number
+			}
+		}
+	}
+	if byBranch != 0 {
+		return g.PullRequest(ctx, owner, repo, byBranch)
+	}
+	return nil, notFound(sha, branch)
+}
+
+type giteaLabel struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+func (g *Gitea) Labels(ctx context.Context, owner, repo string, number int) ([]string, error) {
+	var labels []giteaLabel
+	if err := g.do(ctx, http.MethodGet, fmt.Sprintf("%s/issues/%d/labels", repoPath(owner, repo), number), nil, &labels); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, l := range labels {
+		names = append(names, l.Name)
+	}
+	return names, nil
+}
+
+// labelID returns the ID of the repository label with the specified name, as
+// the Gitea API refers to labels by ID.
+func (g *Gitea) labelID(ctx context.Context, owner, repo, name string) (int64, error) {
+	for page := 1; ; page++ {
+		var labels []giteaLabel
+		if err := g.do(ctx, http.MethodGet, fmt.Sprintf("%s/labels?limit=50&page=%d", repoPath(owner, repo), page), nil, &labels); err != nil {
+			return 0, err
+		}
+		for _, l := range labels {
+			if l.Name == name {
+				return l.ID, nil
+			}
+		}
+		if len(labels) == 0 {
+			return 0, fmt.Errorf("label %q not found in %s/%s", name, owner, repo)
+		}
+	}
+}
+
+func (g *Gitea) AddLabel(ctx context.Context, owner, repo string, number int, label string) error {
+	id, err := g.labelID(ctx, owner, repo, label)
+	if err != nil {
+		return err
+	}
+	in := struct {
+		Labels []int64 `json:"labels"`
+	}{[]int64{id}}
+	return g.do(ctx, http.MethodPost, fmt.Sprintf("%s/issues/%d/labels", repoPath(owner, repo), number), in, nil)
+}
+
+func (g *Gitea) RemoveLabel(ctx context.Context, owner, repo string, number int, label string) error {
+	id, err := g.labelID(ctx, owner, repo, label)
+	if err != nil {
+		return err
+	}
+	return g.do(ctx, http.MethodDelete, fmt.Sprintf("%s/issues/%d/labels/%d", repoPath(owner, repo), number, id), nil, nil)
+}
+
+func (g *Gitea) Comment(ctx context.Context, owner, repo string, number int, body string) (string, error) {
+	in := struct {
+		Body string `json:"body"`
+	}{body}
+	var comment struct {
+		HTMLURL string `json:"html_url"`
+	}
+	if err := g.do(ctx, http.MethodPost, fmt.Sprintf("%s/issues/%d/comments", repoPath(owner, repo), number), in, &comment); err != nil {
+		return "", err
+	}
+	return comment.HTMLURL, nil
+}
+
+func (g *Gitea) Merge(ctx context.Context, owner, repo string, number int, opts MergeOptions) (string, error) {
+	in := struct {
+		Do           string `json:"Do"`
+		Title        string `json:"MergeTitleField,omitempty"`
+		Message      string `json:"MergeMessageField,omitempty"`
+		HeadCommitID string `json:"head_commit_id,omitempty"`
+	}{
+		Do:           opts.Method,
+		HeadCommitID: opts.SHA,
+	}
+	if opts.Method != "rebase" {
+		in.Title = opts.Title
+		in.Message = opts.Message
+	}
+	if err := g.do(ctx, http.MethodPost, fmt.Sprintf("%s/pulls/%d/merge", repoPath(owner, repo), number), in, nil); err != nil {
+		return "", err
+	}
+	// The merge endpoint does not return the merge commit.
+	pr, err := g.PullRequest(ctx, owner, repo, number)
+	if err != nil {
+		return "", err
+	}
+	return pr.MergeSHA, nil
+}
+
+type giteaStatus struct {
+	Context     string `json:"context"`
+	State       string `json:"state,omitempty"`  // request field
+	Status      string `json:"status,omitempty"` // response field
+	Description string `json:"description"`
+	TargetURL   string `json:"target_url"`
+}
+
+func (g *Gitea) SetStatus(ctx context.Context, owner, repo, sha string, st Status) error {
+	in := giteaStatus{
+		Context:     st.Context,
+		State:       st.State,
+		Description: st.Description,
+		TargetURL:   st.TargetURL,
+	}
+	return g.do(ctx, http.MethodPost, repoPath(owner, repo)+"/statuses/"+url.PathEscape(sha), in, nil)
+}
+
+func (g *Gitea) Statuses(ctx context.Context, owner, repo, sha string) ([]Status, error) {
+	var statuses []giteaStatus
+	if err := g.do(ctx, http.MethodGet, repoPath(owner, repo)+"/commits/"+url.PathEscape(sha)+"/statuses?limit=50", nil, &statuses); err != nil {
+		return nil, err
+	}
+	// Statuses are returned newest first; keep the latest per context,
+	// like the combined status of GitHub.
+	seen := make(map[string]bool)
+	var result []Status
+	for _, st := range statuses {
+		if seen[st.Context] {
+			continue
+		}
+		seen[st.Context] = true
+		result = append(result, Status{
+			Context:     st.Context,
+			State:       st.Status,
+			Description: st.Description,
+			TargetURL:   st.TargetURL,
+		})
+	}
+	return result, nil
+}
+
+func (g *Gitea) PutFile(ctx context.Context, owner, repo, branch, filePath, message string, content []byte) (string, error) {
+	contentsPath := repoPath(owner, repo) + "/contents/" + (&url.URL{Path: filePath}).EscapedPath()
+	var existing struct {
+		SHA string `json:"sha"`
+	}
+	err := g.do(ctx, http.MethodGet, contentsPath+"?ref="+url.QueryEscape(branch), nil, &existing)
+	if isNotFound(err) {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	in := struct {
+		Content string `json:"content"`
+		Message string `json:"message"`
+		Branch  string `json:"branch"`
+		SHA     string `json:"sha,omitempty"`
+	}{
+		Content: base64.StdEncoding.EncodeToString(content),
+		Message: message,
+		Branch:  branch,
+		SHA:     existing.SHA,
+	}
+	method := http.MethodPost
+	if existing.SHA != "" {
+		method = http.MethodPut
+	}
+	var result struct {
+		Content struct {
+			HTMLURL string `json:"html_url"`
+		} `json:"content"`
+	}
+	if err := g.do(ctx, method, contentsPath, in, &result); err != nil {
+		return "", err
+	}
+	return result.Content.HTMLURL, nil
+}
+
+// Paste commits files into a new directory of PasteRepo and returns the URL
+// of the directory. The directory name is not guessable, but the files are
+// only as private as PasteRepo.
+func (g *Gitea) Paste(ctx context.Context, description string, files map[string]string) (string, error) {
+	if g.PasteRepo == "" {
+		return "", fmt.Errorf("-forge_paste_repo is required to store logs with -forge=gitea")
+	}
+	dir, err := pasteDirName()
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := g.PutFile(ctx, g.PasteOwner, g.PasteRepo, g.PasteBranch, path.Join(dir, path.Base(name)), description, []byte(files[name])); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%s/%s/%s/src/branch/%s/%s",
+		g.BaseURL,
+		url.PathEscape(g.PasteOwner),
+		url.PathEscape(g.PasteRepo),
+		url.PathEscape(g.PasteBranch),
+		dir), nil
+}
+
+// pasteDirName returns a new, not guessable directory name for a paste.
+func pasteDirName() (string, error) {
+	var random [8]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return "", err
+	}
+	return time.Now().UTC().Format("2006-01-02T150405") + "-" + hex.EncodeToString(random[:]), nil
+}
@@ -0,0 +1,204 @@
+package forge
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/go-github/v35/github"
+)
+
+// GitHub implements Forge with the GitHub API.
+type GitHub struct {
+	Client *github.Client
+}
+
+// NewGitHub returns a Forge using client.
+func NewGitHub(client *github.Client) *GitHub {
+	return &GitHub{Client: client}
+}
+
+func (g *GitHub) Name() string { return "github" }
+
+func (g *GitHub) PullRequest(ctx context.Context, owner, repo string, number int) (*PullRequest, error) {
+	pr, _, err := g.Client.PullRequests.Get(ctx, owner, repo, number)
+	if err != nil {
+		return nil, err
+	}
+	return fromGitHub(pr), nil
+}
+
+func fromGitHub(pr *github.PullRequest) *PullRequest {
+	return &PullRequest{
+		Number:   pr.GetNumber(),
+		Title:    pr.GetTitle(),
+		Body:     pr.GetBody(),
+		URL:      pr.GetHTMLURL(),
+		HeadSHA:  pr.GetHead().GetSHA(),
+		HeadRef:  pr.GetHead().GetRef(),
+		BaseRef:  pr.GetBase().GetRef(),
+		Merged:   pr.GetMerged(),
+		MergeSHA: pr.GetMergeCommitSHA(),
+	}
+}
+
+func (g *GitHub) FindPullRequest(ctx context.Context, owner, repo, sha, branch string) (*PullRequest, error) {
+	if sha != "" {
+		prs, _, err := g.Client.PullRequests.ListPullRequestsWithCommit(ctx, owner, repo, sha, nil)
+		if err != nil {
+			return nil, err
+		}
+		var containing *github.PullRequest
+		for _, pr := range prs {
+			if pr.GetState() != "open" {
+				continue
+			}
+			if pr.GetHead().GetSHA() == sha {
+				return fromGitHub(pr), nil
+			}
+			if containing == nil {
+				containing = pr
+			}
+		}
+		if containing != nil {
+			return fromGitHub(containing), nil
+		}
+	}
+	if branch != "" {
+		prs, _, err := g.Client.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{
+			State: "open",
+			Head:  owner + ":" + branch,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(prs) > 0 {
+			return fromGitHub(prs[0]), nil
+		}
+	}
+	return nil, notFound(sha, branch)
+}
+
+func (g *GitHub) Labels(ctx context.Context, owner, repo string, number int) ([]string, error) {
+	var names []string
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		labels, resp, err := g.Client.Issues.ListLabelsByIssue(ctx, owner, repo, number, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range labels {
+			names = append(names, l.GetName())
+		}
+		if resp.NextPage == 0 {
+			return names, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func (g *GitHub) AddLabel(ctx context.Context, owner, repo string, number int, label string) error {
+	_, _, err := g.Client.Issues.AddLabelsToIssue(ctx, owner, repo, number, []string{label})
+	return err
+}
+
+func (g *GitHub) RemoveLabel(ctx context.Context, owner, repo string, number int, label string) error {
+	_, err := g.Client.Issues.RemoveLabelForIssue(ctx, owner, repo, number, label)
+	return err
+}
+
+func (g *GitHub) Comment(ctx context.Context, owner, repo string, number int, body string) (string, error) {
+	comment, _, err := g.Client.Issues.CreateComment(ctx, owner, repo, number, &github.IssueComment{
+		Body: github.String(body),
+	})
+	if err != nil {
+		return "", err
+	}
+	return comment.GetHTMLURL(), nil
+}
+
+func (g *GitHub) Merge(ctx context.Context, owner, repo string, number int, opts MergeOptions) (string, error) {
+	mergeOpts := &github.PullRequestOptions{
+		MergeMethod: opts.Method,
+		SHA:         opts.SHA,
+	}
+	message := ""
+	if opts.Method != "rebase" {
+		mergeOpts.CommitTitle = opts.Title
+		message = opts.Message
+	}
+	result, _, err := g.Client.PullRequests.Merge(ctx, owner, repo, number, message, mergeOpts)
+	if err != nil {
+		return "", err
+	}
+	return result.GetSHA(), nil
+}
+
+func (g *GitHub) SetStatus(ctx context.Context, owner, repo, sha string, st Status) error {
+	status := &github.RepoStatus{
+		State:       github.String(st.State),
+		Description: github.String(st.Description),
+		Context:     github.String(st.Context),
+	}
+	if st.TargetURL != "" {
+		status.TargetURL = github.String(st.TargetURL)
+	}
+	_, _, err := g.Client.Repositories.CreateStatus(ctx, owner, repo, sha, status)
+	return err
+}
+
+func (g *GitHub) Statuses(ctx context.Context, owner, repo, sha string) ([]Status, error) {
+	combined, _, err := g.Client.Repositories.GetCombinedStatus(ctx, owner, repo, sha, nil)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []Status
+	for _, st := range combined.Statuses {
+		statuses = append(statuses, Status{
+			Context:     st.GetContext(),
+			State:       st.GetState(),
+			Description: st.GetDescription(),
+			TargetURL:   st.GetTargetURL(),
+		})
+	}
+	return statuses, nil
+}
+
+func (g *GitHub) PutFile(ctx context.Context, owner, repo, branch, path, message string, content []byte) (string, error) {
+	opts := &github.RepositoryContentFileOptions{
+		Message: github.String(message),
+		Content: content,
+		Branch:  github.String(branch),
+	}
+	existing, _, resp, err := g.Client.Repositories.GetContents(ctx, owner, repo, path, &github.RepositoryContentGetOptions{Ref: branch})
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return "", err
+	}
+	var result *github.RepositoryContentResponse
+	if existing != nil {
+		opts.SHA = existing.SHA
+		result, _, err = g.Client.Repositories.UpdateFile(ctx, owner, repo, path, opts)
+	} else {
+		result, _, err = g.Client.Repositories.CreateFile(ctx, owner, repo, path, opts)
+	}
+	if err != nil {
+		return "", err
+	}
+	return result.GetContent().GetHTMLURL(), nil
+}
+
+// Paste creates a secret gist.
+func (g *GitHub) Paste(ctx context.Context, description string, files map[string]string) (string, error) {
+	gistFiles := make(map[github.GistFilename]github.GistFile)
+	for name, content := range files {
+		gistFiles[github.GistFilename(name)] = github.GistFile{Content: github.String(content)}
+	}
+	gist, _, err := g.Client.Gists.Create(ctx, &github.Gist{
+		Description: github.String(description),
+		Public:      github.Bool(false),
+		Files:       gistFiles,
+	})
+	if err != nil {
+		return "", err
+	}
+	return gist.GetHTMLURL(), nil
+}
@@ -0,0 +1,348 @@
+package forge
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+)
+
+// GitLab implements Forge with the REST API (v4) of GitLab, where pull
+// requests are called merge requests and are numbered by their IID.
+type GitLab struct {
+	BaseURL string // e.g. https://gitlab.com
+	Token   string // personal, group or project access token
+
+	// MergeWhenPipelineSucceeds makes Merge schedule the merge for when
+	// the merge request pipeline succeeds instead of failing while it is
+	// still running, for projects in which pipelines must succeed.
+	MergeWhenPipelineSucceeds bool
+
+	// SnippetOwner/SnippetRepo is the project in which Paste creates
+	// snippets. If empty, Paste creates personal snippets.
+	SnippetOwner, SnippetRepo string
+
+	// SnippetVisibility is the visibility of the snippets Paste creates:
+	// private (the default), internal or public. Private project snippets
+	// are visible to the project members, private personal snippets only
+	// to the owner of Token.
+	SnippetVisibility string
+}
+
+func (g *GitLab) Name() string { return "gitlab" }
+
+func (g *GitLab) do(ctx context.Context, method, path string, in, out interface{}) error {
+	return doJSON(ctx, method, g.BaseURL+"/api/v4", path, "PRIVATE-TOKEN", g.Token, in, out)
+}
+
+// projectPath returns the API path of the project, which is identified by
+// its URL-encoded path.
+func projectPath(owner, repo string) string {
+	return "/projects/" + url.PathEscape(owner+"/"+repo)
+}
+
+type gitlabMergeRequest struct {
+	IID             int      `json:"iid"`
+	Title           string   `json:"title"`
+	Description     string   `json:"description"`
+	WebURL          string   `json:"web_url"`
+	State           string   `json:"state"` // opened, closed, locked or merged
+	SHA             string   `json:"sha"`
+	SourceBranch    string   `json:"source_branch"`
+	TargetBranch    string   `json:"target_branch"`
+	MergeCommitSHA  string   `json:"merge_commit_sha"`
+	SquashCommitSHA string   `json:"squash_commit_sha"`
+	Labels          []string `json:"labels"`
+}
+
+// mergeSHA returns the commit which the merge request resulted in on the
+// target branch: the merge commit, the squashed commit or, for fast-forward
+// merges, the head commit.
+func (mr *gitlabMergeRequest) mergeSHA() string {
+	if mr.State != "merged" {
+		return ""
+	}
+	if mr.MergeCommitSHA != "" {
+		return mr.MergeCommitSHA
+	}
+	if mr.SquashCommitSHA != "" {
+		return mr.SquashCommitSHA
+	}
+	return mr.SHA
+}
+
+func (g *GitLab) mergeRequest(ctx context.Context, owner, repo string, number int) (*gitlabMergeRequest, error) {
+	var mr gitlabMergeRequest
+	if err := g.do(ctx, http.MethodGet, fmt.Sprintf("%s/merge_requests/%d", projectPath(owner, repo), number), nil, &mr); err != nil {
+		return nil, err
+	}
+	return &mr, nil
+}
+
+func (g *GitLab) PullRequest(ctx context.Context, owner, repo string, number int) (*PullRequest, error) {
+	mr, err := g.mergeRequest(ctx, owner, repo, number)
+	if err != nil {
+		return nil, err
+	}
+	return &PullRequest{
+		Number:   mr.IID,
+		Title:    mr.Title,
+		Body:     mr.Description,
+		URL:      mr.WebURL,
+		HeadSHA:  mr.SHA,
+		HeadRef:  mr.SourceBranch,
+		BaseRef:  mr.TargetBranch,
+		Merged:   mr.State == "merged",
+		MergeSHA: mr.mergeSHA(),
+	}, nil
+}
+
+func (g *GitLab) FindPullRequest(ctx context.Context, owner, repo, sha, branch string) (*PullRequest, error) {
+	if sha != "" {
+		var mrs []gitlabMergeRequest
+		if err := g.do(ctx, http.MethodGet, projectPath(owner, repo)+"/repository/commits/"+url.PathEscape(sha)+"/merge_requests", nil, &mrs); err != nil {
+			return nil, err
+		}
+		containing := 0
+		for _, mr := range mrs {
+			if mr.State != "opened" {
+				continue
+			}
+			if mr.SHA == sha {
+				return g.PullRequest(ctx, owner, repo, mr.IID)
+			}
+			if containing == 0 {
+				containing = mr.IID
+			}
+		}
+		if containing != 0 {
+			return g.PullRequest(ctx, owner, repo, containing)
+		}
+	}
+	if branch != "" {
+		var mrs []gitlabMergeRequest
+		if err := g.do(ctx, http.MethodGet, projectPath(owner, repo)+"/merge_requests?state=opened&source_branch="+url.QueryEscape(branch), nil, &mrs); err != nil {
+			return nil, err
+		}
+		if len(mrs) > 0 {
+			return g.PullRequest(ctx, owner, repo, mrs[0].IID)
+		}
+	}
+	return nil, notFound(sha, branch)
+}
+
+func (g *GitLab) Labels(ctx context.Context, owner, repo string, number int) ([]string, error) {
+	mr, err := g.mergeRequest(ctx, owner, repo, number)
+	if err != nil {
+		return nil, err
+	}
+	return mr.Labels, nil
+}
+
+func (g *GitLab) updateLabels(ctx context.Context, owner, repo string, number int, field, label string) error {
+	in := map[string]string{field: label}
+	return g.do(ctx, http.MethodPut, fmt.Sprintf("%s/merge_requests/%d", projectPath(owner, repo), number), in, nil)
+}
+
+func (g *GitLab) AddLabel(ctx context.Context, owner, repo string, number int, label string) error {
+	return g.updateLabels(ctx, owner, repo, number, "add_labels", label)
+}
+
+func (g *GitLab) RemoveLabel(ctx context.Context, owner, repo string, number int, label string) error {
+	return g.updateLabels(ctx, owner, repo, number, "remove_labels", label)
+}
+
+func (g *GitLab) Comment(ctx context.Context, owner, repo string, number int, body string) (string, error) {
+	in := struct {
+		Body string `json:"body"`
+	}{body}
+	var note struct {
+		ID int64 `json:"id"`
+	}
+	if err := g.do(ctx, http.MethodPost, fmt.Sprintf("%s/merge_requests/%d/notes", projectPath(owner, repo), number), in, &note); err != nil {
+		return "", err
+	}
+	// Notes have no URL of their own, only an anchor on the merge request.
+	mr, err := g.mergeRequest(ctx, owner, repo, number)
+	if err != nil {
+		return "", err
+	}
+	return mr.WebURL + "#note_" + strconv.FormatInt(note.ID, 10), nil
+}
+
+// Merge merges the merge request. Whether a merge commit is created or the
+// target branch is fast-forwarded is configured per project on GitLab, so
+// the rebase method is not supported. Merge returns an empty commit if the
+// merge was scheduled (see MergeWhenPipelineSucceeds).
+func (g *GitLab) Merge(ctx context.Context, owner, repo string, number int, opts MergeOptions) (string, error) {
+	in := struct {
+		SHA                       string `json:"sha,omitempty"`
+		Squash                    bool   `json:"squash"`
+		MergeCommitMessage        string `json:"merge_commit_message,omitempty"`
+		SquashCommitMessage       string `json:"squash_commit_message,omitempty"`
+		MergeWhenPipelineSucceeds bool   `json:"merge_when_pipeline_succeeds,omitempty"`
+	}{
+		SHA:                       opts.SHA,
+		MergeWhenPipelineSucceeds: g.MergeWhenPipelineSucceeds,
+	}
+	message := opts.Title
+	if opts.Message != "" {
+		message += "\n\n" + opts.Message
+	}
+	switch opts.Method {
+	case "merge":
+		in.MergeCommitMessage = message
+	case "squash":
+		in.Squash = true
+		in.SquashCommitMessage = message
+	default:
+		return "", fmt.Errorf("merge method %q is not supported with -forge=gitlab (the fast-forward merge method is a project setting), expected merge or squash", opts.Method)
+	}
+	var mr gitlabMergeRequest
+	if err := g.do(ctx, http.MethodPut, fmt.Sprintf("%s/merge_requests/%d/merge", projectPath(owner, repo), number), in, &mr); err != nil {
+		return "", err
+	}
+	return mr.mergeSHA(), nil
+}
+
+// gitlabStates maps the commit status states of the Forge interface to the
+// ones of GitLab, which has no distinction between errors and failures.
+var gitlabStates = map[string]string{
+	"pending": "pending",
+	"success": "success",
+	"error":   "failed",
+	"failure": "failed",
+}
+
+func (g *GitLab) SetStatus(ctx context.Context, owner, repo, sha string, st Status) error {
+	state, ok := gitlabStates[st.State]
+	if !ok {
+		return fmt.Errorf("unknown commit status state %q", st.State)
+	}
+	in := struct {
+		State       string `json:"state"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		TargetURL   string `json:"target_url,omitempty"`
+	}{
+		State:       state,
+		Name:        st.Context,
+		Description: st.Description,
+		TargetURL:   st.TargetURL,
+	}
+	return g.do(ctx, http.MethodPost, projectPath(owner, repo)+"/statuses/"+url.PathEscape(sha), in, nil)
+}
+
+func (g *GitLab) Statuses(ctx context.Context, owner, repo, sha string) ([]Status, error) {
+	// Without all=true, only the latest status per name is returned, like
+	// the combined status of GitHub.
+	var statuses []struct {
+		Name        string `json:"name"`
+		Status      string `json:"status"`
+		Description string `json:"description"`
+		TargetURL   string `json:"target_url"`
+	}
+	if err := g.do(ctx, http.MethodGet, projectPath(owner, repo)+"/repository/commits/"+url.PathEscape(sha)+"/statuses?per_page=100", nil, &statuses); err != nil {
+		return nil, err
+	}
+	var result []Status
+	for _, st := range statuses {
+		state := st.Status
+		switch state {
+		case "created", "waiting_for_resource", "preparing", "running", "scheduled", "manual":
+			state = "pending"
+		case "failed":
+			state = "failure"
+		case "canceled", "skipped":
+			state = "error"
+		}
+		result = append(result, Status{
+			Context:     st.Name,
+			State:       state,
+			Description: st.Description,
+			TargetURL:   st.TargetURL,
+		})
+	}
+	return result, nil
+}
+
+func (g *GitLab) PutFile(ctx context.Context, owner, repo, branch, filePath, message string, content []byte) (string, error) {
+	filesPath := projectPath(owner, repo) + "/repository/files/" + url.PathEscape(filePath)
+	err := g.do(ctx, http.MethodGet, filesPath+"?ref="+url.QueryEscape(branch), nil, nil)
+	exists := err == nil
+	if isNotFound(err) {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	in := struct {
+		Branch        string `json:"branch"`
+		Content       string `json:"content"`
+		Encoding      string `json:"encoding"`
+		CommitMessage string `json:"commit_message"`
+	}{
+		Branch:        branch,
+		Content:       base64.StdEncoding.EncodeToString(content),
+		Encoding:      "base64",
+		CommitMessage: message,
+	}
+	method := http.MethodPost
+	if exists {
+		method = http.MethodPut
+	}
+	if err := g.do(ctx, method, filesPath, in, nil); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s/%s/-/blob/%s/%s",
+		g.BaseURL,
+		owner,
+		repo,
+		url.PathEscape(branch),
+		(&url.URL{Path: filePath}).EscapedPath()), nil
+}
+
+// Paste creates a snippet with the files and returns its URL.
+func (g *GitLab) Paste(ctx context.Context, description string, files map[string]string) (string, error) {
+	type snippetFile struct {
+		FilePath string `json:"file_path"`
+		Content  string `json:"content"`
+	}
+	in := struct {
+		Title      string        `json:"title"`
+		Visibility string        `json:"visibility"`
+		Files      []snippetFile `json:"files"`
+	}{
+		Title:      description,
+		Visibility: g.SnippetVisibility,
+	}
+	if in.Visibility == "" {
+		in.Visibility = "private"
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		in.Files = append(in.Files, snippetFile{
+			FilePath: path.Base(name),
+			Content:  files[name],
+		})
+	}
+	snippetsPath := "/snippets"
+	if g.SnippetRepo != "" {
+		snippetsPath = projectPath(g.SnippetOwner, g.SnippetRepo) + "/snippets"
+	}
+	var snippet struct {
+		WebURL string `json:"web_url"`
+	}
+	if err := g.do(ctx, http.MethodPost, snippetsPath, in, &snippet); err != nil {
+		return "", err
+	}
+	return snippet.WebURL, nil
+}
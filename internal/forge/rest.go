@@ -0,0 +1,75 @@
+package forge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// apiError is returned for unexpected HTTP status codes.
+type apiError struct {
+	method, path string
+	code         int
+	body         string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected HTTP status code: got %d (%s)", e.method, e.path, e.code, e.body)
+}
+
+func isNotFound(err error) bool {
+	var ae *apiError
+	return errors.As(err, &ae) && ae.code == http.StatusNotFound
+}
+
+// doJSON sends a request to the REST API endpoint path below base,
+// authenticated with the header authKey: authValue, with in encoded as JSON
+// (if non-nil), and decodes the JSON response into out (if non-nil). The
+// prefix with which Gerrit guards JSON responses against XSSI is skipped.
+func doJSON(ctx context.Context, method, base, path, authKey, authValue string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, base+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(authKey, authValue)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return &apiError{
+			method: method,
+			path:   path,
+			code:   resp.StatusCode,
+			body:   strings.TrimSpace(string(b)),
+		}
+	}
+	if out == nil {
+		return nil
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes.TrimPrefix(b, []byte(")]}'")), out)
+}
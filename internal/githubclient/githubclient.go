@@ -0,0 +1,71 @@
+// Package githubclient constructs GitHub API clients which authenticate with
+// a token.
+package githubclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v35/github"
+)
+
+// Transport is an http.RoundTripper which authenticates requests with an OAuth2
+// bearer token: a classic or fine-grained personal access token, the
+// GITHUB_TOKEN of GitHub Actions, or an installation token of a GitHub App.
+type Transport struct {
+	Token string
+
+	// Base is the underlying http.RoundTripper. If nil,
+	// http.DefaultTransport is used.
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// A RoundTripper must not modify the request.
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.Token)
+	return base.RoundTrip(req)
+}
+
+// New returns a GitHub API client which authenticates with token.
+func New(token string) *github.Client {
+	return github.NewClient(&http.Client{
+		Transport: &Transport{Token: token},
+	})
+}
+
+// GraphQL sends a GitHub GraphQL query (or mutation) and decodes the data of
+// the response into v.
+func GraphQL(ctx context.Context, client *github.Client, query string, variables map[string]interface{}, v interface{}) error {
+	req, err := client.NewRequest("POST", "graphql", map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	})
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Data   interface{} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	resp.Data = v
+	if _, err := client.Do(ctx, req, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		msgs := make([]string, len(resp.Errors))
+		for i, e := range resp.Errors {
+			msgs[i] = e.Message
+		}
+		return fmt.Errorf("GraphQL: %s", strings.Join(msgs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package history
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS results (
+	time INTEGER NOT NULL,
+	repo TEXT NOT NULL,
+	pr INTEGER NOT NULL,
+	commit_sha TEXT NOT NULL,
+	host TEXT NOT NULL,
+	result TEXT NOT NULL,
+	duration_seconds REAL NOT NULL,
+	log_url TEXT NOT NULL,
+	error TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS results_repo_host_time ON results (repo, host, time);
+CREATE TABLE IF NOT EXISTS boot_logs (
+	result_id INTEGER PRIMARY KEY, -- rowid of results
+	log TEXT NOT NULL
+);
+`
+
+// DB is an SQLite database of boot test results, which can be queried
+// efficiently for flake rates and long-term trends.
+type DB struct {
+	db *sql.DB
+}
+
+// OpenDB opens the SQLite database at path, creating it if needed. The program
+// must be built with SQLite support (see package sqlite).
+func OpenDB(path string) (*DB, error) {
+	db, err := sqlite.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DB{db: db}, nil
+}
+
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Insert adds records to the database.
+func (d *DB) Insert(records ...Record) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, r := range records {
+		res, err := tx.Exec(`INSERT INTO results (time, repo, pr, commit_sha, host, result, duration_seconds, log_url, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			r.Time.UnixNano(), r.Repo, r.PR, r.Commit, r.Host, r.Result, r.DurationSeconds, r.LogURL, r.Error)
+		if err != nil {
+			return err
+		}
+		if r.BootLog == "" {
+			continue
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO boot_logs (result_id, log) VALUES (?, ?)`, id, r.BootLog); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Query selects records from the database. Zero fields match all records.
+type Query struct {
+	Repo  string
+	Host  string
+	Since time.Time
+}
+
+// Select returns the records matching q, oldest first. The BootLog field is
+// not populated, use BootLog to retrieve it.
+func (d *DB) Select(q Query) ([]Record, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	if q.Repo != "" {
+		where = append(where, "repo = ?")
+		args = append(args, q.Repo)
+	}
+	if q.Host != "" {
+		where = append(where, "host = ?")
+		args = append(args, q.Host)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "time >= ?")
+		args = append(args, q.Since.UnixNano())
+	}
+	stmt := `SELECT rowid, time, repo, pr, commit_sha, host, result, duration_seconds, log_url, error FROM results`
+	if len(where) > 0 {
+		stmt += " WHERE " + strings.Join(where, " AND ")
+	}
+	stmt += " ORDER BY time"
+	rows, err := d.db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []Record
+	for rows.Next() {
+		var (
+			r  Record
+			ns int64
+		)
+		if err := rows.Scan(&r.ID, &ns, &r.Repo, &r.PR, &r.Commit, &r.Host, &r.Result, &r.DurationSeconds, &r.LogURL, &r.Error); err != nil {
+			return nil, err
+		}
+		r.Time = time.Unix(0, ns)
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// Stat aggregates the records of one host with one result.
+type Stat struct {
+	Host    string
+	Result  string
+	Count   int
+	Seconds float64 // sum of DurationSeconds
+}
+
+// Stats returns the number and total duration of the records since the
+// specified time (zero for all records), grouped by host and result.
+func (d *DB) Stats(since time.Time) ([]Stat, error) {
+	rows, err := d.db.Query(`SELECT host, result, COUNT(*), SUM(duration_seconds) FROM results WHERE time >= ? GROUP BY host, result ORDER BY host, result`, since.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var stats []Stat
+	for rows.Next() {
+		var s Stat
+		if err := rows.Scan(&s.Host, &s.Result, &s.Count, &s.Seconds); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// BootLog returns the boot log of the record with the specified ID, or
+// sql.ErrNoRows if none was recorded.
+func (d *DB) BootLog(id int64) (string, error) {
+	var log string
+	err := d.db.QueryRow(`SELECT log FROM boot_logs WHERE result_id = ?`, id).Scan(&log)
+	return log, err
+}
@@ -0,0 +1,54 @@
+// Package history stores the results of boot tests in an SQLite database (see
+// DB), so that regression baselines and flake statistics can be derived across
+// CI runs.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Record is the result of one boot test of one host.
+type Record struct {
+	Time   time.Time `json:"time"`
+	Repo   string    `json:"repo"` // owner/repo
+	PR     int       `json:"pr,omitempty"`
+	Commit string    `json:"commit,omitempty"`
+	Host   string    `json:"host,omitempty"`
+	Result string    `json:"result"` // success or failure
+	LogURL string    `json:"log_url,omitempty"`
+
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	Error           string  `json:"error,omitempty"`
+
+	// ID and BootLog are only stored in a DB.
+	ID      int64  `json:"-"`
+	BootLog string `json:"-"`
+}
+
+// Read returns all records of the history file at path, which contains one
+// JSON-encoded Record per line. Earlier versions of gokr-boot wrote such files
+// (-history), Read only remains to import them into a DB. A non-existent file
+// is treated as an empty history.
+func Read(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,62 @@
+// Package kernelorg looks up Linux releases on kernel.org.
+package kernelorg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Release is an entry of https://www.kernel.org/releases.json.
+type Release struct {
+	Moniker string `json:"moniker"` // mainline, stable, longterm or linux-next
+	Version string `json:"version"`
+	Source  string `json:"source"` // URL of the source tarball
+}
+
+// Latest returns the most recent release of series (e.g. 6.1), or the latest
+// stable release if series is empty.
+func Latest(ctx context.Context, series string) (*Release, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://www.kernel.org/releases.json", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		return nil, fmt.Errorf("unexpected HTTP status code: got %d, want %d", got, want)
+	}
+	var releases struct {
+		LatestStable struct {
+			Version string `json:"version"`
+		} `json:"latest_stable"`
+		Releases []Release `json:"releases"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return nil, err
+	}
+	for _, release := range releases.Releases {
+		if release.Source == "" || release.Moniker == "linux-next" {
+			continue
+		}
+		if series == "" {
+			if release.Version == releases.LatestStable.Version {
+				return &release, nil
+			}
+			continue
+		}
+		// releases.json lists one release per series, the most recent one.
+		if release.Version == series || strings.HasPrefix(release.Version, series+".") {
+			return &release, nil
+		}
+	}
+	if series == "" {
+		return nil, fmt.Errorf("malformed releases.json: latest stable release %q not found in releases list", releases.LatestStable.Version)
+	}
+	return nil, fmt.Errorf("no release of series %q found in releases.json", series)
+}
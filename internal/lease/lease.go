@@ -0,0 +1,97 @@
+// Package lease elects a leader among processes (e.g. on different machines)
+// sharing a file system: the leader holds a lease file, which it renews
+// before it expires. Once it expires, another process can take over.
+package lease
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/google/renameio/v2"
+)
+
+// settle is how long TryAcquire waits before confirming that it took over
+// the lease, so that a concurrent takeover by another process is detected.
+// It is a variable for tests.
+var settle = time.Second
+
+// record is the content of the lease file.
+type record struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// Lease is a lease stored in the file Path. TTL must comfortably exceed the
+// clock skew between the machines sharing the lease.
+type Lease struct {
+	Path   string
+	Holder string // identifies this process, e.g. hostname and PID
+	TTL    time.Duration
+}
+
+func (l *Lease) read() (record, error) {
+	var rec record
+	b, err := ioutil.ReadFile(l.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return rec, nil
+		}
+		return rec, err
+	}
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return rec, fmt.Errorf("%s: %v", l.Path, err)
+	}
+	return rec, nil
+}
+
+func (l *Lease) write(rec record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return renameio.WriteFile(l.Path, b, 0644)
+}
+
+// TryAcquire acquires or renews the lease for TTL and returns true, unless
+// another process holds an unexpired lease.
+func (l *Lease) TryAcquire() (bool, error) {
+	rec, err := l.read()
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if rec.Holder != l.Holder && now.Before(rec.Expires) {
+		return false, nil
+	}
+	takeover := rec.Holder != l.Holder
+	if err := l.write(record{Holder: l.Holder, Expires: now.Add(l.TTL)}); err != nil {
+		return false, err
+	}
+	if !takeover {
+		return true, nil
+	}
+	// Processes taking over the expired lease at the same time overwrite
+	// each other's lease file; the last write wins.
+	time.Sleep(settle)
+	rec, err = l.read()
+	if err != nil {
+		return false, err
+	}
+	return rec.Holder == l.Holder, nil
+}
+
+// Release gives up the lease, if held, so that another process can take over
+// right away.
+func (l *Lease) Release() error {
+	rec, err := l.read()
+	if err != nil {
+		return err
+	}
+	if rec.Holder != l.Holder {
+		return nil
+	}
+	return l.write(record{Holder: l.Holder})
+}
@@ -0,0 +1,45 @@
+package lease
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTryAcquire(t *testing.T) {
+	settle = 0
+	path := filepath.Join(t.TempDir(), "lease")
+	a := &Lease{Path: path, Holder: "a", TTL: time.Hour}
+	b := &Lease{Path: path, Holder: "b", TTL: time.Hour}
+	expired := &Lease{Path: path, Holder: "expired", TTL: -time.Second}
+
+	for _, step := range []struct {
+		desc string
+		l    *Lease
+		fn   func(*Lease) (bool, error)
+		want bool
+	}{
+		{"a acquires the free lease", a, (*Lease).TryAcquire, true},
+		{"a renews its lease", a, (*Lease).TryAcquire, true},
+		{"b cannot take over the unexpired lease", b, (*Lease).TryAcquire, false},
+		{"a releases the lease", a, release, true},
+		{"b acquires the released lease", b, (*Lease).TryAcquire, true},
+		{"a does not release the lease of b", a, release, true},
+		{"a still cannot acquire the lease of b", a, (*Lease).TryAcquire, false},
+		{"b releases the lease", b, release, true},
+		{"a lease which expires right away", expired, (*Lease).TryAcquire, true},
+		{"a takes over the expired lease", a, (*Lease).TryAcquire, true},
+	} {
+		got, err := step.fn(step.l)
+		if err != nil {
+			t.Fatalf("%s: %v", step.desc, err)
+		}
+		if got != step.want {
+			t.Fatalf("%s: got %v, want %v", step.desc, got, step.want)
+		}
+	}
+}
+
+func release(l *Lease) (bool, error) {
+	return true, l.Release()
+}
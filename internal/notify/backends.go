@@ -0,0 +1,50 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Slack posts to a Slack incoming webhook.
+type Slack struct {
+	WebhookURL string
+}
+
+func (s *Slack) Notify(ctx context.Context, msg Message) error {
+	return send(ctx, http.MethodPost, s.WebhookURL, nil, map[string]string{
+		"text": msg.String(),
+	})
+}
+
+// Discord posts to a Discord channel webhook.
+type Discord struct {
+	WebhookURL string
+}
+
+func (d *Discord) Notify(ctx context.Context, msg Message) error {
+	return send(ctx, http.MethodPost, d.WebhookURL, nil, map[string]string{
+		"content": msg.String(),
+	})
+}
+
+// Matrix sends a text message to a Matrix room via the client-server API.
+type Matrix struct {
+	Homeserver  string
+	RoomID      string
+	AccessToken string
+}
+
+func (m *Matrix) Notify(ctx context.Context, msg Message) error {
+	// The transaction ID makes retries of the same request idempotent.
+	txnID := "gokr-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	u := m.Homeserver + "/_matrix/client/v3/rooms/" + url.PathEscape(m.RoomID) + "/send/m.room.message/" + txnID
+	return send(ctx, http.MethodPut, u, http.Header{
+		"Authorization": []string{"Bearer " + m.AccessToken},
+	}, map[string]string{
+		"msgtype": "m.text",
+		"body":    msg.String(),
+	})
+}
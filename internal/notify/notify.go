@@ -0,0 +1,151 @@
+// Package notify sends boot test results to chat rooms (Slack, Matrix,
+// Discord), so that they reach maintainers and not only the PR thread.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Message is a boot test result.
+type Message struct {
+	Success bool
+	Text    string // e.g. “gokrazy/kernel#123: boot test successful”
+	URL     string // link to the boot log or pull request, if any
+}
+
+func (m Message) String() string {
+	icon := "✅"
+	if !m.Success {
+		icon = "❌"
+	}
+	s := icon + " " + m.Text
+	if m.URL != "" {
+		s += " " + m.URL
+	}
+	return s
+}
+
+// A Notifier delivers messages to one chat room.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// Config selects and configures the notification backends. Secrets are not
+// stored in the config file, but read from the named environment variables.
+type Config struct {
+	Slack   *WebhookConfig `json:"slack,omitempty"`
+	Discord *WebhookConfig `json:"discord,omitempty"`
+	Matrix  *MatrixConfig  `json:"matrix,omitempty"`
+}
+
+type WebhookConfig struct {
+	// WebhookURLEnv is the name of the environment variable holding the
+	// incoming webhook URL.
+	WebhookURLEnv string `json:"webhook_url_env"`
+}
+
+type MatrixConfig struct {
+	Homeserver     string `json:"homeserver"` // e.g. https://matrix.org
+	RoomID         string `json:"room_id"`
+	AccessTokenEnv string `json:"access_token_env"`
+}
+
+// ReadConfig reads a JSON-encoded Config from path.
+func ReadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// SecretEnv returns the names of all environment variables holding secrets,
+// so that callers can redact their values.
+func (c *Config) SecretEnv() []string {
+	var names []string
+	if c.Slack != nil {
+		names = append(names, c.Slack.WebhookURLEnv)
+	}
+	if c.Discord != nil {
+		names = append(names, c.Discord.WebhookURLEnv)
+	}
+	if c.Matrix != nil {
+		names = append(names, c.Matrix.AccessTokenEnv)
+	}
+	return names
+}
+
+func getenv(name string) (string, error) {
+	val := os.Getenv(name)
+	if val == "" {
+		return "", fmt.Errorf("required environment variable %q empty", name)
+	}
+	return val, nil
+}
+
+// Notifiers returns a Notifier for each configured backend.
+func (c *Config) Notifiers() ([]Notifier, error) {
+	var notifiers []Notifier
+	if c.Slack != nil {
+		u, err := getenv(c.Slack.WebhookURLEnv)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, &Slack{WebhookURL: u})
+	}
+	if c.Discord != nil {
+		u, err := getenv(c.Discord.WebhookURLEnv)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, &Discord{WebhookURL: u})
+	}
+	if c.Matrix != nil {
+		token, err := getenv(c.Matrix.AccessTokenEnv)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, &Matrix{
+			Homeserver:  c.Matrix.Homeserver,
+			RoomID:      c.Matrix.RoomID,
+			AccessToken: token,
+		})
+	}
+	return notifiers, nil
+}
+
+func send(ctx context.Context, method, u string, header http.Header, payload interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected HTTP status code: got %d (%s), want 2xx", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+// Package pathmatch matches file paths of a pull request against the
+// comma-separated path patterns of command line flags.
+package pathmatch
+
+import (
+	"path"
+	"strings"
+)
+
+// Match returns whether fn matches one of the comma-separated patterns. A
+// pattern is either a directory prefix ending in / or uses path.Match syntax.
+// Patterns without a slash match in every directory, like in .gitignore
+// files.
+func Match(patterns, fn string) bool {
+	for _, pattern := range strings.Split(patterns, ",") {
+		if pattern == "" {
+			continue
+		}
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(fn, pattern) {
+			return true
+		}
+		if ok, _ := path.Match(pattern, fn); ok {
+			return true
+		}
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(fn)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
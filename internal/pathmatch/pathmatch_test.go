@@ -0,0 +1,31 @@
+package pathmatch
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	for _, tt := range []struct {
+		patterns string
+		fn       string
+		want     bool
+	}{
+		{patterns: "docs/", fn: "docs/README.md", want: true},
+		{patterns: "docs/", fn: "cmd/docs.go"},
+		{patterns: "firmware/", fn: "cmd/firmware/main.go"},
+		{patterns: "*.md", fn: "README.md", want: true},
+		{patterns: "*.md", fn: "docs/setup/README.md", want: true},
+		{patterns: "*.md", fn: "main.go"},
+		{patterns: "cmd/*.go", fn: "cmd/main.go", want: true},
+		{patterns: "cmd/*.go", fn: "cmd/gokr-boot/boot.go"},
+		{patterns: "vmlinuz,*.dtb,config.txt", fn: "dist/vmlinuz", want: true},
+		{patterns: "vmlinuz,*.dtb,config.txt", fn: "overlays/disable-bt.dtb", want: true},
+		{patterns: "vmlinuz,*.dtb,config.txt", fn: "vmlinuz.go"},
+		{patterns: "go.mod,go.sum", fn: "go.sum", want: true},
+		{patterns: ",", fn: "go.sum"},
+		{patterns: "", fn: "go.sum"},
+		{patterns: "vmlinuz", fn: ""},
+	} {
+		if got := Match(tt.patterns, tt.fn); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.patterns, tt.fn, got, tt.want)
+		}
+	}
+}
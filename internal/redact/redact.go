@@ -0,0 +1,101 @@
+// Package redact scrubs secrets (the bootery URL, auth tokens, wifi
+// credentials, …) from log output, error messages, PR comments and gists.
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type secret struct {
+	value       string
+	placeholder string
+}
+
+var (
+	mu      sync.Mutex
+	secrets []secret
+)
+
+// Add registers value to be replaced with placeholder (e.g. <bootery_url>).
+// Empty values are ignored.
+func Add(value, placeholder string) {
+	if value == "" {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	secrets = append(secrets, secret{value, placeholder})
+	// Replace longer secrets first, so that a secret which contains another
+	// secret (e.g. a URL containing a token) is redacted as a whole.
+	sort.SliceStable(secrets, func(i, j int) bool {
+		return len(secrets[i].value) > len(secrets[j].value)
+	})
+}
+
+// String returns s with all registered secrets replaced.
+func String(s string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, sec := range secrets {
+		s = strings.Replace(s, sec.value, sec.placeholder, -1)
+	}
+	return s
+}
+
+// Error returns err with all registered secrets replaced in its message.
+func Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := String(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return errors.New(msg)
+}
+
+// Writer redacts secrets from everything written to it. Secrets are matched
+// within lines, so incomplete lines are buffered until a newline is written
+// or Flush is called.
+type Writer struct {
+	mu  sync.Mutex
+	w   io.Writer
+	buf []byte
+}
+
+// NewWriter returns a Writer which writes redacted output to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	idx := bytes.LastIndexByte(w.buf, '\n')
+	if idx == -1 {
+		return len(p), nil
+	}
+	if _, err := io.WriteString(w.w, String(string(w.buf[:idx+1]))); err != nil {
+		return 0, err
+	}
+	w.buf = append(w.buf[:0], w.buf[idx+1:]...)
+	return len(p), nil
+}
+
+// Flush writes any buffered incomplete line.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.w, String(string(w.buf)))
+	w.buf = w.buf[:0]
+	return err
+}
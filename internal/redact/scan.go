@@ -0,0 +1,110 @@
+package redact
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Pattern recognizes a kind of secret by its form, for secrets whose values
+// are not known in advance (e.g. keys printed by a misbehaving service). If
+// the regular expression has a group named secret, only that group is
+// replaced, so that the surrounding context (e.g. psk=) stays readable.
+type Pattern struct {
+	Name string // e.g. private key
+	Re   *regexp.Regexp
+}
+
+// DefaultPatterns recognize common tokens, private keys and wifi credentials.
+var DefaultPatterns = []Pattern{
+	{"private key", regexp.MustCompile(`(?s)-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----.*?-----END [A-Z0-9 ]*PRIVATE KEY-----`)},
+	{"age secret key", regexp.MustCompile(`AGE-SECRET-KEY-1[0-9A-Z]{58}`)},
+	{"GitHub token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}|\bgithub_pat_[A-Za-z0-9_]{22,}`)},
+	{"GitLab token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{"AWS access key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"wifi PSK", regexp.MustCompile(`(?i)\b(?:psk|passphrase|wpa_passphrase)"?\s*[=:]\s*"?(?P<secret>[^"\s,}]{8,})`)},
+	{"URL password", regexp.MustCompile(`\b[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:(?P<secret>[^/\s@]+)@`)},
+	{"authorization header", regexp.MustCompile(`(?i)\bauthorization:\s*(?:bearer|token|basic)\s+(?P<secret>[A-Za-z0-9._~+/=-]{8,})`)},
+}
+
+// ParsePatterns reads patterns from r, one per line: a name, a tab and a
+// regular expression. Empty lines and lines starting with # are ignored.
+func ParsePatterns(r io.Reader) ([]Pattern, error) {
+	var patterns []Pattern
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, expr, ok := strings.Cut(text, "\t")
+		if !ok {
+			return nil, fmt.Errorf("line %d: not of the form name<TAB>regexp", line)
+		}
+		re, err := regexp.Compile(strings.TrimSpace(expr))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		patterns = append(patterns, Pattern{Name: strings.TrimSpace(name), Re: re})
+	}
+	return patterns, scanner.Err()
+}
+
+// Finding is the number of matches of one pattern.
+type Finding struct {
+	Pattern string
+	Count   int
+}
+
+// Scan returns s with all matches of patterns replaced with <redacted name>,
+// and the number of matches per pattern, in the order of patterns.
+func Scan(s string, patterns []Pattern) (string, []Finding) {
+	var findings []Finding
+	for _, p := range patterns {
+		matches := p.Re.FindAllStringSubmatchIndex(s, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		group := p.Re.SubexpIndex("secret")
+		placeholder := "<redacted " + p.Name + ">"
+		var b strings.Builder
+		last := 0
+		for _, m := range matches {
+			start, end := m[0], m[1]
+			if group != -1 && m[2*group] != -1 {
+				start, end = m[2*group], m[2*group+1]
+			}
+			b.WriteString(s[last:start])
+			b.WriteString(placeholder)
+			last = end
+		}
+		b.WriteString(s[last:])
+		s = b.String()
+		findings = append(findings, Finding{Pattern: p.Name, Count: len(matches)})
+	}
+	return s, findings
+}
+
+// Summary describes findings in a sentence fragment, e.g. 3 likely secrets
+// (private key, wifi PSK), or returns "" if there are none.
+func Summary(findings []Finding) string {
+	if len(findings) == 0 {
+		return ""
+	}
+	total := 0
+	var names []string
+	for _, f := range findings {
+		total += f.Count
+		names = append(names, f.Pattern)
+	}
+	sort.Strings(names)
+	noun := "secrets"
+	if total == 1 {
+		noun = "secret"
+	}
+	return fmt.Sprintf("%d likely %s (%s)", total, noun, strings.Join(names, ", "))
+}
@@ -0,0 +1,5 @@
+//go:build sqlite
+
+package sqlite
+
+import _ "github.com/mattn/go-sqlite3"
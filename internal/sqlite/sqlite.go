@@ -0,0 +1,36 @@
+// Package sqlite opens SQLite databases.
+//
+// The SQLite driver (github.com/mattn/go-sqlite3) requires cgo. So that
+// programs which only need SQLite for some features (e.g. gokr-boot serve and
+// history) can still be built without cgo, the driver is only linked in with
+// the sqlite build tag (go build -tags sqlite), or if the program imports the
+// driver itself.
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const driver = "sqlite3"
+
+// Available returns an error explaining how to build the program if the
+// SQLite driver is not linked in, so that programs can check their flags
+// before opening a database.
+func Available() error {
+	for _, d := range sql.Drivers() {
+		if d == driver {
+			return nil
+		}
+	}
+	return errors.New("built without SQLite support, rebuild with -tags sqlite (requires cgo)")
+}
+
+// Open opens the SQLite database at path (see Available).
+func Open(path string) (*sql.DB, error) {
+	if err := Available(); err != nil {
+		return nil, fmt.Errorf("opening %s: %v", path, err)
+	}
+	return sql.Open(driver, path)
+}
@@ -0,0 +1,68 @@
+// Package supersede closes automatically created pull requests which a newer
+// pull request replaces, so that obsolete updates do not pile up.
+package supersede
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/go-github/v35/github"
+)
+
+// superseded returns whether old is an open pull request created for the
+// same purpose as pr: by the same author, from a branch of the same
+// repository starting with branchPrefix, against the same base branch.
+func superseded(old, pr *github.PullRequest, branchPrefix string) bool {
+	return old.GetNumber() != pr.GetNumber() &&
+		old.GetState() == "open" &&
+		old.GetUser().GetLogin() == pr.GetUser().GetLogin() &&
+		old.GetBase().GetRef() == pr.GetBase().GetRef() &&
+		old.GetHead().GetRepo().GetFullName() == pr.GetBase().GetRepo().GetFullName() &&
+		strings.HasPrefix(old.GetHead().GetRef(), branchPrefix)
+}
+
+// Close closes the pull requests of owner/repo which pr supersedes (see
+// superseded) with a comment referring to pr, and deletes their branches.
+func Close(ctx context.Context, client *github.Client, owner, repo string, pr *github.PullRequest, branchPrefix string) error {
+	opts := &github.PullRequestListOptions{
+		State:       "open",
+		Base:        pr.GetBase().GetRef(),
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	var old []*github.PullRequest
+	for {
+		prs, resp, err := client.PullRequests.List(ctx, owner, repo, opts)
+		if err != nil {
+			return err
+		}
+		for _, p := range prs {
+			if superseded(p, pr, branchPrefix) {
+				old = append(old, p)
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	for _, p := range old {
+		log.Printf("closing pull request %d, superseded by %d", p.GetNumber(), pr.GetNumber())
+		if _, _, err := client.Issues.CreateComment(ctx, owner, repo, p.GetNumber(), &github.IssueComment{
+			Body: github.String(fmt.Sprintf("Superseded by #%d, closing.", pr.GetNumber())),
+		}); err != nil {
+			return err
+		}
+		if _, _, err := client.PullRequests.Edit(ctx, owner, repo, p.GetNumber(), &github.PullRequest{
+			State: github.String("closed"),
+		}); err != nil {
+			return err
+		}
+		if _, err := client.Git.DeleteRef(ctx, owner, repo, "heads/"+p.GetHead().GetRef()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+// Package updatepr opens auto-update pull requests, which pin a new upstream
+// version in an updater source file of the main branch.
+package updatepr
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/gokrazy/autoupdate/internal/supersede"
+	"github.com/google/go-github/v35/github"
+)
+
+// Update describes an auto-update pull request.
+type Update struct {
+	// Path is the updater source file, e.g. cmd/gokr-build-kernel/build.go.
+	Path string
+
+	// Pin matches the pinned version, as first submatch.
+	Pin *regexp.Regexp
+
+	// Format turns a version into the replacement for the match of Pin,
+	// e.g. `var latest = "%s"`.
+	Format string
+
+	// Version is the upstream version to pin.
+	Version string
+
+	// Name names the pull request “auto-update to <Name>” and its branch
+	// <BranchPrefix><Name>.
+	Name string
+
+	// BranchPrefix prefixes the branch name, pull- if empty. Pull requests
+	// of different kinds of updates in the same repository need different
+	// prefixes, so that CloseSuperseded does not close the other kind.
+	BranchPrefix string
+
+	// Message is the commit message.
+	Message string
+
+	// Relevant, if non-nil, returns whether updating from the pinned version
+	// old to Version is worthwhile, e.g. whether relevant files changed.
+	Relevant func(old string) (bool, error)
+
+	// Body returns the pull request description, given the pinned version.
+	// If nil, the description is empty.
+	Body func(old string) string
+
+	// CloseSuperseded closes older auto-update pull requests (see
+	// supersede.Close) after opening the new one.
+	CloseSuperseded bool
+}
+
+func (u *Update) branchPrefix() string {
+	if u.BranchPrefix == "" {
+		return "pull-"
+	}
+	return u.BranchPrefix
+}
+
+// Branch returns the name of the branch of the pull request.
+func (u *Update) Branch() string {
+	return u.branchPrefix() + u.Name
+}
+
+// branchExists returns whether owner/repo has branch.
+func branchExists(ctx context.Context, client *github.Client, owner, repo, branch string) (bool, error) {
+	_, resp, err := client.Git.GetRef(ctx, owner, repo, "heads/"+branch)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Open commits u to a new branch on top of the main branch of owner/repo and
+// opens a pull request from it. It returns a nil pull request if the main
+// branch already pins u.Version, or if the branch exists already (the update
+// is pending).
+func Open(ctx context.Context, client *github.Client, owner, repo string, u *Update) (*github.PullRequest, error) {
+	lastRef, _, err := client.Git.GetRef(ctx, owner, repo, "heads/main")
+	if err != nil {
+		return nil, err
+	}
+
+	lastCommit, _, err := client.Git.GetCommit(ctx, owner, repo, *lastRef.Object.SHA)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("lastCommit = %+v", lastCommit)
+
+	baseTree, _, err := client.Git.GetTree(ctx, owner, repo, *lastCommit.SHA, true)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("baseTree = %+v", baseTree)
+
+	var updaterSHA string
+	for _, entry := range baseTree.Entries {
+		if *entry.Path == u.Path {
+			updaterSHA = *entry.SHA
+			break
+		}
+	}
+
+	if updaterSHA == "" {
+		return nil, fmt.Errorf("%s not found in %s/%s", u.Path, owner, repo)
+	}
+
+	updaterBlob, _, err := client.Git.GetBlob(ctx, owner, repo, updaterSHA)
+	if err != nil {
+		return nil, err
+	}
+
+	updaterContent, err := base64.StdEncoding.DecodeString(*updaterBlob.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := u.Pin.FindStringSubmatch(string(updaterContent))
+	if matches == nil {
+		return nil, fmt.Errorf("regexp %v resulted in no matches", u.Pin)
+	}
+	if matches[1] == u.Version {
+		log.Printf("already at latest commit")
+		return nil, nil
+	}
+	if u.Relevant != nil {
+		relevant, err := u.Relevant(matches[1])
+		if err != nil {
+			return nil, err
+		}
+		if !relevant {
+			log.Printf("no relevant changes between %s and %s", matches[1], u.Version)
+			return nil, nil
+		}
+	}
+	exists, err := branchExists(ctx, client, owner, repo, u.Branch())
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		log.Printf("branch %s exists, update already pending", u.Branch())
+		return nil, nil
+	}
+	newContent := u.Pin.ReplaceAllLiteral(updaterContent,
+		[]byte(fmt.Sprintf(u.Format, u.Version)))
+
+	entries := []*github.TreeEntry{
+		{
+			Path:    github.String(u.Path),
+			Mode:    github.String("100644"),
+			Type:    github.String("blob"),
+			Content: github.String(string(newContent)),
+		},
+	}
+
+	newTree, _, err := client.Git.CreateTree(ctx, owner, repo, *baseTree.SHA, entries)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("newTree = %+v", newTree)
+
+	newCommit, _, err := client.Git.CreateCommit(ctx, owner, repo, &github.Commit{
+		Message: github.String(u.Message),
+		Tree:    newTree,
+		Parents: []*github.Commit{lastCommit},
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("newCommit = %+v", newCommit)
+
+	newRef, _, err := client.Git.CreateRef(ctx, owner, repo, &github.Reference{
+		Ref: github.String("refs/heads/" + u.Branch()),
+		Object: &github.GitObject{
+			SHA: newCommit.SHA,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("newRef = %+v", newRef)
+
+	var body string
+	if u.Body != nil {
+		body = u.Body(matches[1])
+	}
+	pr, _, err := client.PullRequests.Create(ctx, owner, repo, &github.NewPullRequest{
+		Title: github.String("auto-update to " + u.Name),
+		Head:  github.String(u.Branch()),
+		Base:  github.String("main"),
+		Body:  github.String(body),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("pr = %+v", pr)
+
+	if u.CloseSuperseded {
+		if err := supersede.Close(ctx, client, owner, repo, pr, u.branchPrefix()); err != nil {
+			return nil, err
+		}
+	}
+
+	return pr, nil
+}
@@ -0,0 +1,31 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// LatestGo returns the most recent stable Go version (e.g. 1.21.6), as listed
+// by https://go.dev/dl/.
+func LatestGo(ctx context.Context) (string, error) {
+	b, err := get(ctx, "https://go.dev/dl/?mode=json")
+	if err != nil {
+		return "", err
+	}
+	var releases []struct {
+		Version string `json:"version"`
+		Stable  bool   `json:"stable"`
+	}
+	if err := json.Unmarshal(b, &releases); err != nil {
+		return "", err
+	}
+	// The releases are listed newest first.
+	for _, release := range releases {
+		if release.Stable {
+			return strings.TrimPrefix(release.Version, "go"), nil
+		}
+	}
+	return "", fmt.Errorf("no stable Go release found")
+}
@@ -0,0 +1,95 @@
+package upstream
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+func get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		return nil, fmt.Errorf("unexpected HTTP status code: got %d, want %d", got, want)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Regexp returns the first submatch of the first match of re in the file at
+// url, e.g. a version number in a download page.
+func Regexp(ctx context.Context, url string, re *regexp.Regexp) (string, error) {
+	b, err := get(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	matches := re.FindSubmatch(b)
+	if matches == nil || len(matches) < 2 {
+		return "", fmt.Errorf("regexp %v resulted in no matches in %s", re, url)
+	}
+	return string(matches[1]), nil
+}
+
+// FeedEntry is an entry of an RSS or Atom feed.
+type FeedEntry struct {
+	Title string
+	Link  string
+}
+
+// Feed returns the most recent entry of the RSS or Atom feed at url whose
+// title matches re (if non-nil). Feeds list the most recent entries first.
+func Feed(ctx context.Context, url string, re *regexp.Regexp) (*FeedEntry, error) {
+	b, err := get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	var feed struct {
+		// RSS
+		Items []struct {
+			Title string `xml:"title"`
+			Link  string `xml:"link"`
+		} `xml:"channel>item"`
+		// Atom
+		Entries []struct {
+			Title string `xml:"title"`
+			Links []struct {
+				Href string `xml:"href,attr"`
+				Rel  string `xml:"rel,attr"`
+			} `xml:"link"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(b, &feed); err != nil {
+		return nil, err
+	}
+	var entries []FeedEntry
+	for _, item := range feed.Items {
+		entries = append(entries, FeedEntry{Title: item.Title, Link: item.Link})
+	}
+	for _, entry := range feed.Entries {
+		e := FeedEntry{Title: entry.Title}
+		for _, link := range entry.Links {
+			if link.Rel == "" || link.Rel == "alternate" {
+				e.Link = link.Href
+				break
+			}
+		}
+		entries = append(entries, e)
+	}
+	for _, e := range entries {
+		e.Title = strings.TrimSpace(e.Title)
+		if re == nil || re.MatchString(e.Title) {
+			return &e, nil
+		}
+	}
+	return nil, fmt.Errorf("no entry of %s matches %v", url, re)
+}
@@ -0,0 +1,102 @@
+// Package upstream finds new upstream commits of GitHub repositories, such as
+// github.com/raspberrypi/firmware, which gokrazy repositories pin.
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+
+	"github.com/google/go-github/v35/github"
+)
+
+// matches returns whether the repository path p matches any of patterns
+// (path.Match syntax, e.g. boot/*.elf).
+func matches(p string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// LatestCommit returns the SHA of the most recent commit of the default branch
+// of owner/repo which touches a file matching patterns. Patterns only match
+// files, not directories, and must not contain wildcards in directory names.
+func LatestCommit(ctx context.Context, client *github.Client, owner, repo string, patterns []string) (string, error) {
+	dirs := make(map[string]bool)
+	for _, pattern := range patterns {
+		dirs[path.Dir(pattern)] = true
+	}
+
+	var latestCommit *github.RepositoryCommit
+
+	for dir := range dirs {
+		_, dirContents, _, err := client.Repositories.GetContents(ctx, owner, repo, dir, &github.RepositoryContentGetOptions{})
+		if err != nil {
+			return "", err
+		}
+		for _, c := range dirContents {
+			if !matches(c.GetPath(), patterns) {
+				continue
+			}
+			commits, _, err := client.Repositories.ListCommits(ctx, owner, repo, &github.CommitsListOptions{
+				Path: *c.Path,
+				ListOptions: github.ListOptions{
+					Page:    1,
+					PerPage: 1,
+				},
+			})
+			if err != nil {
+				return "", err
+			}
+			if got, want := len(commits), 1; got != want {
+				return "", fmt.Errorf("unexpected number of commits for file %q: got %d, want %d", *c.Path, got, want)
+			}
+			// NOTE that the assumption is that the upstream repository
+			// uses correct commit dates. In case it stops doing that,
+			// we’ll need to list all commits to find which commit is
+			// newer.
+			if latestCommit == nil || commits[0].Commit.Committer.Date.After(*latestCommit.Commit.Committer.Date) {
+				latestCommit = commits[0]
+			}
+			log.Printf("at %s (%v): %s", *commits[0].SHA, *commits[0].Commit.Committer.Date, *c.Path)
+		}
+	}
+	if latestCommit == nil {
+		return "", fmt.Errorf("no files matching %q found in %s/%s", patterns, owner, repo)
+	}
+
+	log.Printf("picked %s as most recent upstream %s/%s commit", *latestCommit.SHA, owner, repo)
+	return *latestCommit.SHA, nil
+}
+
+// LatestTag returns the name and commit SHA of the most recent tag of
+// owner/repo, as listed first by GitHub.
+func LatestTag(ctx context.Context, client *github.Client, owner, repo string) (name, sha string, _ error) {
+	tags, _, err := client.Repositories.ListTags(ctx, owner, repo, &github.ListOptions{PerPage: 1})
+	if err != nil {
+		return "", "", err
+	}
+	if len(tags) == 0 {
+		return "", "", fmt.Errorf("%s/%s has no tags", owner, repo)
+	}
+	return tags[0].GetName(), tags[0].GetCommit().GetSHA(), nil
+}
+
+// Touches returns whether the commits of owner/repo from old (exclusive) to
+// new (inclusive) change a file matching patterns.
+func Touches(ctx context.Context, client *github.Client, owner, repo, old, new string, patterns []string) (bool, error) {
+	cmp, _, err := client.Repositories.CompareCommits(ctx, owner, repo, old, new)
+	if err != nil {
+		return false, err
+	}
+	for _, f := range cmp.Files {
+		if matches(f.GetFilename(), patterns) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
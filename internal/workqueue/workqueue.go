@@ -0,0 +1,252 @@
+// Package workqueue is a durable queue of work items, stored in SQLite so that
+// queued and in-flight items survive restarts. Items are distinguished by kind;
+// gokr-boot serve queues its boot tests, the only kind so far.
+package workqueue
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS items (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL,
+	repo TEXT NOT NULL,
+	pr INTEGER NOT NULL,
+	sha TEXT NOT NULL,
+	device TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	state TEXT NOT NULL, -- queued or running
+	enqueued INTEGER NOT NULL,
+	started INTEGER NOT NULL,
+	UNIQUE (kind, repo, pr, sha, device)
+);
+`
+
+// Key identifies a work item. An item is queued at most once: enqueueing an
+// item with the key of a queued or running item has no effect.
+type Key struct {
+	Kind   string // e.g. boottest
+	Repo   string // owner/repo
+	PR     int
+	SHA    string // empty if unknown
+	Device string // empty for all devices
+}
+
+// Item is a queued or running work item.
+type Item struct {
+	Key
+	ID       int64
+	Payload  string // opaque to the queue, e.g. JSON
+	Running  bool
+	Enqueued time.Time
+	Started  time.Time // zero unless Running
+}
+
+// Schedule determines which repository's items are handed out first.
+type Schedule struct {
+	// Priorities maps owner/repo to a priority. Repositories with a
+	// higher priority are served first, unlisted repositories have
+	// priority 0.
+	Priorities map[string]int
+
+	// Aging, if positive, raises the priority of a repository by one for
+	// every Aging its oldest item has been waiting, so that repositories
+	// with a low priority are not starved.
+	Aging time.Duration
+}
+
+// Queue is a durable work queue. By default, items are handed out in the
+// order in which they were enqueued; see SetSchedule.
+type Queue struct {
+	db     *sql.DB
+	notify chan struct{}
+
+	mu         sync.Mutex // serializes claiming items
+	schedule   *Schedule
+	lastServed map[string]time.Time // by repo
+}
+
+// Open opens the queue stored in the SQLite database at path, creating it if
+// needed. Items which were running when the queue was last used (e.g. when
+// the process crashed) are queued again, ahead of newer items. An empty path
+// opens a queue which is held in memory only. Either way, the program must be
+// built with SQLite support (see package sqlite).
+func Open(path string) (*Queue, error) {
+	if path == "" {
+		path = ":memory:"
+	}
+	db, err := sqlite.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// A single connection avoids SQLITE_BUSY errors and keeps :memory:
+	// databases from being per-connection.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := db.Exec(`UPDATE items SET state = 'queued', started = 0 WHERE state = 'running'`); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Queue{
+		db:         db,
+		notify:     make(chan struct{}, 1),
+		lastServed: make(map[string]time.Time),
+	}, nil
+}
+
+// SetSchedule makes Next pick the repository with the highest priority (see
+// Schedule), and among repositories of equal priority the one served least
+// recently (round-robin), so that a burst of items in one repository cannot
+// starve the others. Within a repository, items are handed out in the order
+// in which they were enqueued.
+func (q *Queue) SetSchedule(s Schedule) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.schedule = &s
+}
+
+// pick returns the index of the item to hand out next among the queued items
+// (in queue order), according to the schedule.
+func (q *Queue) pick(queued []Item, now time.Time) int {
+	if q.schedule == nil {
+		return 0
+	}
+	var (
+		best         = -1
+		bestPriority int
+		bestServed   time.Time
+		seen         = make(map[string]bool)
+	)
+	for i, it := range queued {
+		if seen[it.Repo] {
+			continue // only the oldest item of each repository competes
+		}
+		seen[it.Repo] = true
+		priority := q.schedule.Priorities[it.Repo]
+		if q.schedule.Aging > 0 {
+			priority += int(now.Sub(it.Enqueued) / q.schedule.Aging)
+		}
+		served := q.lastServed[it.Repo]
+		if best == -1 ||
+			priority > bestPriority ||
+			(priority == bestPriority && served.Before(bestServed)) {
+			best, bestPriority, bestServed = i, priority, served
+		}
+	}
+	return best
+}
+
+func (q *Queue) Close() error {
+	return q.db.Close()
+}
+
+// Enqueue adds an item with the specified key and payload, unless an item
+// with the same key is queued or running already. It returns whether the item
+// was added.
+func (q *Queue) Enqueue(key Key, payload string) (bool, error) {
+	res, err := q.db.Exec(`INSERT OR IGNORE INTO items (kind, repo, pr, sha, device, payload, state, enqueued, started) VALUES (?, ?, ?, ?, ?, ?, 'queued', ?, 0)`,
+		key.Kind, key.Repo, key.PR, key.SHA, key.Device, payload, time.Now().UnixNano())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		select {
+		case q.notify <- struct{}{}:
+		default:
+		}
+	}
+	return n > 0, nil
+}
+
+// claim marks the next queued item of kind as running and returns it, or nil
+// if there is none.
+func (q *Queue) claim(kind string) (*Item, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items, err := q.List(kind)
+	if err != nil {
+		return nil, err
+	}
+	var queued []Item
+	for _, it := range items {
+		if !it.Running {
+			queued = append(queued, it)
+		}
+	}
+	if len(queued) == 0 {
+		return nil, nil
+	}
+	now := time.Now()
+	it := queued[q.pick(queued, now)]
+	if _, err := q.db.Exec(`UPDATE items SET state = 'running', started = ? WHERE id = ?`, now.UnixNano(), it.ID); err != nil {
+		return nil, err
+	}
+	q.lastServed[it.Repo] = now
+	it.Running = true
+	it.Started = now
+	return &it, nil
+}
+
+// Next marks the next queued item of kind (see SetSchedule) as running and returns it,
+// waiting for one to be enqueued if needed. Call Done once the item is
+// processed; until then, the item is queued again when the queue is opened
+// the next time. Only one goroutine may wait in Next at a time.
+func (q *Queue) Next(ctx context.Context, kind string) (*Item, error) {
+	for {
+		it, err := q.claim(kind)
+		if err != nil || it != nil {
+			return it, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-q.notify:
+		}
+	}
+}
+
+// Done removes the item with the specified ID from the queue.
+func (q *Queue) Done(id int64) error {
+	_, err := q.db.Exec(`DELETE FROM items WHERE id = ?`, id)
+	return err
+}
+
+// List returns the queued and running items of kind, in queue order.
+func (q *Queue) List(kind string) ([]Item, error) {
+	rows, err := q.db.Query(`SELECT id, kind, repo, pr, sha, device, payload, state, enqueued, started FROM items WHERE kind = ? ORDER BY id`, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Item
+	for rows.Next() {
+		var (
+			it                Item
+			state             string
+			enqueued, started int64
+		)
+		if err := rows.Scan(&it.ID, &it.Kind, &it.Repo, &it.PR, &it.SHA, &it.Device, &it.Payload, &state, &enqueued, &started); err != nil {
+			return nil, err
+		}
+		it.Running = state == "running"
+		it.Enqueued = time.Unix(0, enqueued)
+		if started != 0 {
+			it.Started = time.Unix(0, started)
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
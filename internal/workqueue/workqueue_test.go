@@ -0,0 +1,135 @@
+package workqueue
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestPick(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	item := func(repo string, waiting time.Duration) Item {
+		return Item{Key: Key{Repo: repo}, Enqueued: now.Add(-waiting)}
+	}
+	queued := []Item{
+		item("gokrazy/gokrazy", 40*time.Minute),
+		item("gokrazy/gokrazy", 30*time.Minute),
+		item("gokrazy/kernel", 20*time.Minute),
+		item("gokrazy/firmware", 10*time.Minute),
+	}
+	for _, tt := range []struct {
+		name       string
+		schedule   *Schedule
+		lastServed map[string]time.Time
+		want       int
+	}{
+		{
+			name: "no schedule: queue order",
+			want: 0,
+		},
+		{
+			name:     "higher priority first",
+			schedule: &Schedule{Priorities: map[string]int{"gokrazy/kernel": 10}},
+			want:     2,
+		},
+		{
+			name:     "round-robin among equal priorities",
+			schedule: &Schedule{},
+			lastServed: map[string]time.Time{
+				"gokrazy/gokrazy": now.Add(-time.Minute),
+				"gokrazy/kernel":  now.Add(-2 * time.Minute),
+			},
+			want: 3, // never served
+		},
+		{
+			name: "least recently served",
+			schedule: &Schedule{
+				Priorities: map[string]int{"gokrazy/firmware": -1},
+			},
+			lastServed: map[string]time.Time{
+				"gokrazy/gokrazy": now.Add(-time.Minute),
+				"gokrazy/kernel":  now.Add(-2 * time.Minute),
+			},
+			want: 2,
+		},
+		{
+			name: "aging overcomes priorities",
+			schedule: &Schedule{
+				Priorities: map[string]int{"gokrazy/kernel": 1},
+				Aging:      15 * time.Minute,
+			},
+			// gokrazy/gokrazy: 0+2, kernel: 1+1, firmware: 0+0; the
+			// tie goes to the least recently served repository.
+			lastServed: map[string]time.Time{
+				"gokrazy/kernel": now.Add(-time.Minute),
+			},
+			want: 0,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &Queue{schedule: tt.schedule, lastServed: tt.lastServed}
+			if got := q.pick(queued, now); got != tt.want {
+				t.Errorf("pick() = %d (%s), want %d (%s)", got, queued[got].Repo, tt.want, queued[tt.want].Repo)
+			}
+		})
+	}
+}
+
+func TestQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.db")
+	q, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := Key{Kind: "boottest", Repo: "gokrazy/gokrazy", PR: 1, SHA: "abc"}
+	for i, want := range []bool{true, false} {
+		added, err := q.Enqueue(key, "payload")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != want {
+			t.Fatalf("Enqueue #%d = %v, want %v", i, added, want)
+		}
+	}
+	ctx := context.Background()
+	it, err := q.Next(ctx, "boottest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if it.Key != key || it.Payload != "payload" || !it.Running {
+		t.Fatalf("Next() = %+v, want running item %+v", it, key)
+	}
+	// Crash without Done: the item is queued again when reopening.
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	q, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	items, err := q.List("boottest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Running {
+		t.Fatalf("List() after reopening = %+v, want one queued item", items)
+	}
+	it, err = q.Next(ctx, "boottest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Done(it.ID); err != nil {
+		t.Fatal(err)
+	}
+	items, err = q.List("boottest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Fatalf("List() after Done = %+v, want none", items)
+	}
+}
@@ -0,0 +1,81 @@
+package boottest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AddAppliance adds the Options.ApplianceDir module to the gokrazy instance.
+// gok add records a replace directive in the instance’s builddir, so that the
+// local checkout is packed instead of the published module version.
+func (bt *BootTester) AddAppliance(ctx context.Context) error {
+	abs, err := filepath.Abs(bt.opts.ApplianceDir)
+	if err != nil {
+		return err
+	}
+	return bt.AddPackage(ctx, abs)
+}
+
+// AddPackage adds pkg to the gokrazy instance, or updates it if it was
+// already added. pkg is anything gok add accepts, e.g. a local directory or
+// an import path with a version suffix like github.com/gokrazy/kernel@<commit>.
+func (bt *BootTester) AddPackage(ctx context.Context, pkg string) error {
+	cmd := exec.CommandContext(ctx, "gok", "add", pkg)
+	flush := redactOutput(cmd, nil)
+	defer flush()
+	defer bt.keepAlive("adding "+pkg, nil)()
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v: %v", cmd.Args, err)
+	}
+	return nil
+}
+
+func probe(ctx context.Context, u string, timeout time.Duration) error {
+	var lastErr error
+	deadline := time.Now().Add(timeout)
+	client := &http.Client{Timeout: 10 * time.Second}
+	for time.Now().Before(deadline) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			b, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			err = fmt.Errorf("unexpected HTTP status code: got %d (%s), want %d", resp.StatusCode, strings.TrimSpace(string(b)), http.StatusOK)
+		}
+		lastErr = err
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+	return fmt.Errorf("probe %s: %v", u, lastErr)
+}
+
+// probeAppliance runs all Options.ApplianceProbes against hostname and
+// returns a summary suitable for appending to the boot log.
+func (bt *BootTester) probeAppliance(ctx context.Context, hostname string) (string, error) {
+	var summary strings.Builder
+	for _, tmpl := range bt.opts.ApplianceProbes {
+		u := strings.Replace(strings.TrimSpace(tmpl), "{hostname}", hostname, -1)
+		log.Printf("probing %s", u)
+		if err := probe(ctx, u, bt.opts.ProbeTimeout); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&summary, "probe %s: OK\n", u)
+	}
+	return summary.String(), nil
+}
@@ -0,0 +1,45 @@
+package boottest
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// saveArtifacts copies images (file name → path) and the gok output into a
+// per-host subdirectory of Options.ArtifactDir. Errors are logged, as the
+// failure which led to saving artifacts is the one to report.
+func (bt *BootTester) saveArtifacts(hostname string, images map[string]string, output []byte) {
+	dir := filepath.Join(bt.opts.ArtifactDir, hostname+"-"+time.Now().Format("20060102-150405"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("saving artifacts: %v", err)
+		return
+	}
+	for name, path := range images {
+		if path == "" {
+			continue
+		}
+		if err := copyFile(filepath.Join(dir, name), path); err != nil {
+			log.Printf("saving artifacts: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "gok-output.log"), output, 0644); err != nil {
+		log.Printf("saving artifacts: %v", err)
+	}
+	log.Printf("saved artifacts of failed boot test in %s", dir)
+
+	if bt.opts.ArtifactHook == "" {
+		return
+	}
+	// Deliberately not bound to the caller’s context: artifacts of a
+	// cancelled run are still worth uploading.
+	cmd := exec.Command(bt.opts.ArtifactHook, dir)
+	flush := redactOutput(cmd, nil)
+	defer flush()
+	if err := cmd.Run(); err != nil {
+		log.Printf("%v: %v", cmd.Args, err)
+	}
+}
@@ -0,0 +1,978 @@
+// Package boottest builds gokrazy images and boot tests them on real hardware
+// via a bootery (see https://github.com/gokrazy/bakery).
+//
+// The gokrazy instance is the one gok would use (see
+// github.com/gokrazy/internal/config). Secrets registered with the redact
+// package are scrubbed from the output of all commands run by boottest.
+package boottest
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/autoupdate/internal/redact"
+	"github.com/gokrazy/internal/config"
+	"github.com/google/renameio/v2"
+)
+
+// Options configures a BootTester. Only BooteryURL (or QEMU) is required.
+type Options struct {
+	// BooteryURL is the /testboot URL of the bootery.
+	BooteryURL string
+
+	// QEMU, if non-empty, is the qemu-system binary (e.g.
+	// qemu-system-aarch64) in which images are boot tested instead of on
+	// the hardware of a bootery. QEMUFirmware is the UEFI firmware to boot
+	// with, and QEMUBootTimeout is how long to wait for the gokrazy web
+	// interface. The bootery-specific options (UpdateRoot, DeltaRoot,
+	// Stream, CaptureDiagnostics) do not apply.
+	QEMU            string
+	QEMUFirmware    string
+	QEMUBootTimeout time.Duration
+
+	// UpdateRoot updates the bakery root file system, too. Required for
+	// gokrazy/kernel with loadable kernel modules.
+	UpdateRoot bool
+
+	// DeltaRoot uploads only those chunks of the root file system which the
+	// bootery does not already have from the previous root image. Falls back
+	// to a full upload if the bootery does not support delta uploads.
+	DeltaRoot bool
+
+	// Stream streams images from gok straight to the bootery instead of
+	// writing them to temporary files first. Incompatible with CacheDir and
+	// DeltaRoot, which need random access to the images.
+	Stream bool
+
+	// CacheDir, if non-empty, is a directory in which built images are
+	// cached. Images are re-used when neither the instance config nor the
+	// pinned package versions changed.
+	CacheDir string
+
+	// CaptureDiagnostics asks the bootery to hold the device after a failed
+	// boot test, so that Diagnostics can capture its state.
+	CaptureDiagnostics bool
+
+	// Board and Arch select a board profile and target architecture (see
+	// Boards). Arch defaults to the architecture of Board.
+	Board string
+	Arch  string
+
+	// ApplianceDir, if non-empty, is a checkout of a gokrazy appliance module
+	// which AddAppliance adds to the instance.
+	ApplianceDir string
+
+	// KernelDir and FirmwareDir, if non-empty, are local working copies of
+	// the kernel and firmware modules, which replace the pinned versions
+	// while building (e.g. to boot test uncommitted changes). Images built
+	// from local working copies are never cached.
+	KernelDir   string
+	FirmwareDir string
+
+	// Kernels, if non-empty, lists kernel packages (e.g. per-SoC kernels)
+	// to test in one run: UseBakeries assigns each device the kernel
+	// matching its board, or the kernel given as package=hostname, and
+	// skips devices without a matching kernel. Not supported with QEMU.
+	Kernels []string
+
+	// CmdlineExtra, if non-empty, is appended to the kernel command line in
+	// the boot image (e.g. "loglevel=7" for debugging boot failures).
+	// Requires mtools and is not supported with Stream or QEMU, which never
+	// write a separate boot image. Such images are never cached.
+	CmdlineExtra string
+
+	// ApplianceProbes are URLs which must return HTTP 200 after booting.
+	// {hostname} is replaced with the hostname of the device.
+	ApplianceProbes []string
+
+	// ProbeTimeout is how long to wait for each of ApplianceProbes.
+	ProbeTimeout time.Duration
+
+	// VerifyServices verifies via the gokrazy status API that all packages
+	// of the instance are running and have been for at least ServicesSettle.
+	VerifyServices bool
+	ServicesSettle time.Duration
+
+	// ArtifactDir, if non-empty, is a directory into which images and gok
+	// output are saved when building or boot testing fails. ArtifactHook, if
+	// non-empty, is run with the per-host artifact directory as argument.
+	ArtifactDir  string
+	ArtifactHook string
+
+	// SigningKey, if non-nil, signs all uploaded images (see LoadSigningKey).
+	SigningKey ed25519.PrivateKey
+
+	// BooterySPKIPins, if non-empty, are the base64-encoded SHA-256 hashes
+	// of the public keys (SubjectPublicKeyInfo) of which the certificate of
+	// an https:// BooteryURL, or one of its verified chain, must have one.
+	// A pinned leaf key is accepted without a trusted CA (self-signed).
+	BooterySPKIPins []string
+
+	// RequireChecksumEcho makes uploads fail if the bootery does not confirm
+	// the checksum of the received image, instead of logging a warning.
+	RequireChecksumEcho bool
+
+	// BooteryProxy, if non-empty, is the URL of the proxy through which
+	// requests to the bootery are sent. By default, the proxy is taken from
+	// the environment (HTTPS_PROXY, HTTP_PROXY and NO_PROXY).
+	BooteryProxy string
+
+	// DialTimeout and TLSHandshakeTimeout, if non-zero, limit how long
+	// connecting to the bootery (or the proxy) may take. Defaults to the
+	// ones of net/http: 30s and 10s.
+	DialTimeout, TLSHandshakeTimeout time.Duration
+
+	// ForceIPFamily, if non-empty, restricts connections to the bootery (or
+	// the proxy) to IPv4 (4) or IPv6 (6). By default, both are tried as
+	// described in RFC 6555 (happy eyeballs).
+	ForceIPFamily string
+
+	// ResponseHeaderTimeout, if non-zero, limits how long the bootery may
+	// take to respond after an upload. Boot tests respond once the device
+	// booted, so it must exceed the boot time by far.
+	ResponseHeaderTimeout time.Duration
+
+	// LeaseWait, if non-zero, makes UseBakeries acquire a lease on the
+	// bakeries first, waiting up to LeaseWait while another holder has it.
+	// The lease is identified by LeaseHolder (e.g. the CI job) and expires
+	// after LeaseTTL unless renewed, so that a crashed run does not block
+	// the bakeries forever.
+	LeaseWait   time.Duration
+	LeaseHolder string
+	LeaseTTL    time.Duration
+
+	// DiscoveryTimeout, if non-zero, makes UseBakeries query the bootery's
+	// capabilities first: it waits up to DiscoveryTimeout for the bootery
+	// and its devices to become available, fails early if requested
+	// features are unsupported, and skips devices of another architecture.
+	DiscoveryTimeout time.Duration
+
+	// RootManifest makes Test list the files of the root image (see
+	// RootManifest) for comparing them with previous builds. Not supported
+	// with Stream or QEMU, which never write a separate root image.
+	RootManifest bool
+
+	// KeepAlive, if non-zero, is the interval in which progress is logged
+	// during long builds, uploads and boots.
+	KeepAlive time.Duration
+
+	// UploadRateLimit, if non-zero, limits image uploads to the bootery to
+	// this many bytes per second, e.g. for bakeries behind slow uplinks.
+	UploadRateLimit int64
+
+	// ProgressInterval, if non-zero, is the interval in which the progress
+	// of image uploads (including rate and estimated time left) is logged.
+	// Defaults to KeepAlive.
+	ProgressInterval time.Duration
+
+	// PackerArgs are appended verbatim to the gok overwrite command line
+	// which builds the images, e.g. for experimental flags.
+	PackerArgs []string
+
+	// OnPhase, if non-nil, is called whenever a phase (PhaseBuild,
+	// PhaseBoot, PhaseVerify) of Test, Build or Upload ends, with its
+	// duration and error.
+	OnPhase func(hostname, phase string, duration time.Duration, err error)
+
+	// SBOM makes Test and Build produce a CycloneDX SBOM of the image (see
+	// SBOM).
+	SBOM bool
+
+	// Provenance makes Test record the digests of the built images in
+	// Result.Digests, for Provenance. Not supported with Stream or QEMU,
+	// which never write separate images.
+	Provenance bool
+
+	// Hooks maps hook points (HookPreBuild etc.) to commands, which are run
+	// with a HookContext as JSON on their standard input. Failing pre-build,
+	// post-build and pre-upload hooks fail the boot test. Images are never
+	// cached with a pre-build hook, which may change their contents.
+	Hooks map[string]string
+
+	// HookData is passed to hooks as HookContext.Data.
+	HookData map[string]string
+}
+
+// BootTester builds and boot tests images via one bootery.
+type BootTester struct {
+	opts   Options
+	base   string       // BooteryURL without the /testboot suffix
+	client *http.Client // for requests to the bootery
+
+	lease     string // non-empty while a lease is held
+	stopRenew context.CancelFunc
+
+	kernels map[string]string // hostname → kernel package, see Options.Kernels
+
+	uploads []UploadStats // of the most recent Test or Upload
+}
+
+// New returns a BootTester for opts.
+func New(opts Options) (*BootTester, error) {
+	if opts.BooteryURL == "" && opts.QEMU == "" {
+		return nil, errors.New("BooteryURL or QEMU is required")
+	}
+	if opts.QEMU != "" && (opts.VerifyServices || len(opts.ApplianceProbes) > 0) {
+		// Both address the device by its hostname, which QEMU does not
+		// resolve.
+		return nil, errors.New("QEMU cannot be combined with VerifyServices or ApplianceProbes")
+	}
+	if opts.LeaseTTL == 0 {
+		opts.LeaseTTL = 2 * time.Minute
+	}
+	if opts.LeaseHolder == "" {
+		host, _ := os.Hostname()
+		opts.LeaseHolder = fmt.Sprintf("%s/%d", host, os.Getpid())
+	}
+	if opts.QEMUBootTimeout == 0 {
+		opts.QEMUBootTimeout = 5 * time.Minute
+	}
+	if opts.Stream && (opts.CacheDir != "" || opts.DeltaRoot) {
+		return nil, errors.New("Stream cannot be combined with CacheDir or DeltaRoot")
+	}
+	if len(opts.Kernels) > 0 && opts.QEMU != "" {
+		return nil, errors.New("Kernels cannot be combined with QEMU")
+	}
+	if opts.CmdlineExtra != "" && (opts.Stream || opts.QEMU != "") {
+		return nil, errors.New("CmdlineExtra cannot be combined with Stream or QEMU")
+	}
+	if opts.RootManifest && (opts.Stream || opts.QEMU != "") {
+		return nil, errors.New("RootManifest cannot be combined with Stream or QEMU")
+	}
+	if opts.Provenance && (opts.Stream || opts.QEMU != "") {
+		return nil, errors.New("Provenance cannot be combined with Stream or QEMU")
+	}
+	if opts.ProbeTimeout == 0 {
+		opts.ProbeTimeout = 2 * time.Minute
+	}
+	if opts.ServicesSettle == 0 {
+		opts.ServicesSettle = 30 * time.Second
+	}
+	for point := range opts.Hooks {
+		if !hookPoints[point] {
+			return nil, fmt.Errorf("unknown hook point %q", point)
+		}
+	}
+	client, err := booteryClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &BootTester{
+		opts:   opts,
+		base:   strings.TrimSuffix(opts.BooteryURL, "/testboot"),
+		client: client,
+	}, nil
+}
+
+// Result is the outcome of a successful boot test.
+type Result struct {
+	// BootLog is the log returned by the bootery, followed by the results
+	// of the appliance probes.
+	BootLog string
+
+	// Services is a Markdown summary of the service states if
+	// Options.VerifyServices is set.
+	Services string
+
+	// RootFiles is the file listing of the root image if
+	// Options.RootManifest is set.
+	RootFiles []RootFile
+
+	// SBOM is the CycloneDX SBOM of the image if Options.SBOM is set.
+	SBOM []byte
+
+	// Sizes are the sizes of the boot and root images. Nil with Stream or
+	// QEMU, which never write separate images.
+	Sizes *ImageSizes
+
+	// Digests are the hex-encoded SHA-256 digests of the boot and root
+	// images (by file name, e.g. boot.img) if Options.Provenance is set.
+	Digests map[string]string
+}
+
+// BuildError is returned when gok fails to build the images, as opposed to a
+// failed upload or boot.
+type BuildError struct {
+	// Output is the (redacted) output of gok.
+	Output string
+	Err    error
+}
+
+func (e *BuildError) Error() string { return e.Err.Error() }
+
+func (e *BuildError) Unwrap() error { return e.Err }
+
+// BootError is returned when the device (or QEMU) did not boot the images, or
+// the booted image failed the appliance probes or service verification, as
+// opposed to a failed build or an infrastructure problem.
+type BootError struct {
+	Err error
+}
+
+func (e *BootError) Error() string { return e.Err.Error() }
+
+func (e *BootError) Unwrap() error { return e.Err }
+
+// statusError is an unexpected HTTP status code in a bootery reply.
+type statusError struct {
+	got, want int
+	body      string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status code: got %d (%s), want %d", e.got, e.body, e.want)
+}
+
+// bootFailed returns err as *BootError if it is the bootery reporting that the
+// test boot failed (HTTP 500), and err otherwise.
+func bootFailed(err error) error {
+	var se *statusError
+	if errors.As(err, &se) && se.got == http.StatusInternalServerError {
+		return &BootError{Err: err}
+	}
+	return err
+}
+
+// ImageSizes are the sizes of a boot and root image in bytes.
+type ImageSizes struct {
+	Boot int64 `json:"boot"`
+	Root int64 `json:"root"`
+}
+
+func imageSizes(bootImg, rootImg string) (*ImageSizes, error) {
+	boot, err := os.Stat(bootImg)
+	if err != nil {
+		return nil, err
+	}
+	root, err := os.Stat(rootImg)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageSizes{Boot: boot.Size(), Root: root.Size()}, nil
+}
+
+// ReadImageSizes returns the sizes of the images which Build wrote into dir.
+func ReadImageSizes(dir string) (*ImageSizes, error) {
+	return imageSizes(filepath.Join(dir, "boot.img"), filepath.Join(dir, "root.img"))
+}
+
+// redactOutput directs the output of cmd to os.Stdout and os.Stderr (and
+// capture, if non-nil) with all secrets redacted. The returned function
+// flushes incomplete lines and must be called once cmd exited.
+func redactOutput(cmd *exec.Cmd, capture io.Writer) (flush func()) {
+	var stdout, stderr *redact.Writer
+	if capture != nil {
+		stdout = redact.NewWriter(io.MultiWriter(os.Stdout, capture))
+		stderr = redact.NewWriter(io.MultiWriter(os.Stderr, capture))
+	} else {
+		stdout = redact.NewWriter(os.Stdout)
+		stderr = redact.NewWriter(os.Stderr)
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return func() {
+		stdout.Flush()
+		stderr.Flush()
+	}
+}
+
+// prepareConfig injects hostname and the board profile into the instance
+// config and runs the pre-build hook. It returns the resulting config file
+// contents and the environment to build with.
+func (bt *BootTester) prepareConfig(ctx context.Context, hostname string) (cfg []byte, env []string, _ error) {
+	c, err := config.ReadFromFile()
+	if err != nil {
+		return nil, nil, err
+	}
+	c.Hostname = hostname
+	env, err = applyProfile(c, bt.opts.Board, bt.opts.Arch)
+	if err != nil {
+		return nil, nil, err
+	}
+	if kernel, ok := bt.kernels[hostname]; ok {
+		c.KernelPackage = &kernel
+	}
+	if bt.opts.QEMU != "" {
+		c.SerialConsole = qemuSerialConsole(env)
+	}
+	b, err := c.FormatForFile()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := renameio.WriteFile(config.InstanceConfigPath(), b, 0644); err != nil {
+		return nil, nil, err
+	}
+	if err := bt.runHook(ctx, HookContext{Hook: HookPreBuild, Hostname: hostname}); err != nil {
+		return nil, nil, err
+	}
+	return b, env, nil
+}
+
+// overwriteCmd returns a gok overwrite command with args and
+// Options.PackerArgs, which builds in the environment env.
+func (bt *BootTester) overwriteCmd(ctx context.Context, env []string, args ...string) *exec.Cmd {
+	args = append(append([]string{"overwrite"}, args...), bt.opts.PackerArgs...)
+	cmd := exec.CommandContext(ctx, "gok", args...)
+	cmd.Env = append(os.Environ(), env...)
+	return cmd
+}
+
+// writeImages builds boot and root images for hostname. When CacheDir is set
+// and a cache entry matches, the cached images are returned instead, along
+// with the boot-newer timestamp that applies to them. The output of gok is
+// additionally written to output, if non-nil, and returned in a *BuildError
+// if gok fails. The returned cleanup function must be called once the images
+// are no longer needed.
+func (bt *BootTester) writeImages(ctx context.Context, hostname, newer string, output io.Writer) (boot string, root string, _ string, cleanup func(), _ error) {
+	log.Printf("writeImages(%s)", hostname)
+	cleanup = func() {}
+	b, env, err := bt.prepareConfig(ctx, hostname)
+	if err != nil {
+		return "", "", "", cleanup, err
+	}
+	var key string
+	// The cache key only covers pinned module versions, not the contents of
+	// local ApplianceDir, KernelDir or FirmwareDir working copies, so such
+	// images are never cached. Neither are images with CmdlineExtra, which
+	// are modified after building, or with a pre-build hook.
+	_, preBuild := bt.opts.Hooks[HookPreBuild]
+	if bt.opts.CacheDir != "" && bt.opts.ApplianceDir == "" && bt.opts.KernelDir == "" && bt.opts.FirmwareDir == "" && bt.opts.CmdlineExtra == "" && !preBuild {
+		key, err = imageCacheKey(b, env, bt.opts.PackerArgs)
+		if err != nil {
+			return "", "", "", cleanup, err
+		}
+		if boot, root, cachedNewer, ok := bt.lookupCache(key); ok {
+			log.Printf("re-using cached images (key %s)", key)
+			return boot, root, cachedNewer, cleanup, nil
+		}
+	}
+	bootf, err := ioutil.TempFile("", "gokr-boot")
+	if err != nil {
+		return "", "", "", cleanup, err
+	}
+	bootf.Close()
+	rootf, err := ioutil.TempFile("", "gokr-root")
+	if err != nil {
+		os.Remove(bootf.Name())
+		return "", "", "", cleanup, err
+	}
+	rootf.Close()
+	cleanup = func() {
+		os.Remove(bootf.Name())
+		os.Remove(rootf.Name())
+	}
+	cmd := bt.overwriteCmd(ctx, env,
+		"--boot="+bootf.Name(),
+		"--root="+rootf.Name())
+	var buildLog bytes.Buffer
+	capture := io.Writer(&buildLog)
+	if output != nil {
+		capture = io.MultiWriter(&buildLog, output)
+	}
+	flush := redactOutput(cmd, capture)
+	defer flush()
+	defer bt.keepAlive("building images for "+hostname, nil)()
+	if err := cmd.Run(); err != nil {
+		flush()
+		return "", "", "", cleanup, &BuildError{
+			Output: buildLog.String(),
+			Err:    fmt.Errorf("%v: %v", cmd.Args, err),
+		}
+	}
+	if bt.opts.CmdlineExtra != "" {
+		if err := appendCmdline(ctx, bootf.Name(), bt.opts.CmdlineExtra); err != nil {
+			return "", "", "", cleanup, err
+		}
+	}
+	if err := bt.runHook(ctx, HookContext{
+		Hook:      HookPostBuild,
+		Hostname:  hostname,
+		BootImage: bootf.Name(),
+		RootImage: rootf.Name(),
+	}); err != nil {
+		return "", "", "", cleanup, err
+	}
+	if key != "" {
+		// A failure to populate the cache only costs time in the next run.
+		if err := bt.storeCache(key, bootf.Name(), rootf.Name(), newer); err != nil {
+			log.Printf("storing images in cache: %v", err)
+		}
+	}
+	return bootf.Name(), rootf.Name(), newer, cleanup, nil
+}
+
+// UseBakeries powers on the bakeries for slug (owner/repo) and returns their
+// hostnames. With Options.LeaseWait, it first acquires a lease on them.
+func (bt *BootTester) UseBakeries(ctx context.Context, slug string) ([]string, error) {
+	if bt.opts.QEMU != "" {
+		return []string{qemuHostname}, nil
+	}
+	discover := bt.opts.DiscoveryTimeout > 0
+	if discover {
+		caps, err := bt.awaitCapabilities(ctx, func(*Capabilities) error { return nil })
+		if err == errCapabilitiesUnsupported {
+			log.Printf("%v, skipping", err)
+			discover = false
+		} else if err != nil {
+			return nil, err
+		} else if err := bt.checkFeatures(caps); err != nil {
+			return nil, err
+		}
+	}
+	if bt.opts.LeaseWait > 0 {
+		if err := bt.acquireLease(ctx, slug); err != nil {
+			return nil, err
+		}
+	}
+	hosts, err := bt.useBakeries(ctx, slug)
+	powered := err == nil
+	if err == nil && discover {
+		hosts, err = bt.selectDevices(ctx, hosts)
+	}
+	if err == nil && len(bt.opts.Kernels) > 0 {
+		hosts, err = bt.assignKernels(ctx, hosts)
+	}
+	if err != nil && powered {
+		if err := bt.releaseBakeries(context.Background()); err != nil {
+			log.Printf("releasing bakeries: %v", err)
+		}
+	}
+	if err != nil {
+		if err := bt.releaseLease(context.Background()); err != nil {
+			log.Printf("releasing lease: %v", err)
+		}
+		return nil, err
+	}
+	return hosts, nil
+}
+
+func (bt *BootTester) useBakeries(ctx context.Context, slug string) ([]string, error) {
+	u, err := url.Parse(bt.base + "/usebakeries")
+	if err != nil {
+		return nil, err
+	}
+	v := u.Query()
+	v.Set("slug", slug)
+	if bt.lease != "" {
+		v.Set("lease", bt.lease)
+	}
+	u.RawQuery = v.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := bt.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected HTTP status code: got %d (%s), want %d", got, strings.TrimSpace(string(b)), want)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var useReply struct {
+		Hosts []string `json:"hosts"`
+	}
+	if err := json.Unmarshal(b, &useReply); err != nil {
+		return nil, err
+	}
+	return useReply.Hosts, nil
+}
+
+// ReleaseBakeries powers off the bakeries and releases the lease, if any.
+func (bt *BootTester) ReleaseBakeries(ctx context.Context) error {
+	if bt.opts.QEMU != "" {
+		return nil
+	}
+	err := bt.releaseBakeries(ctx)
+	if lerr := bt.releaseLease(ctx); err == nil {
+		err = lerr
+	}
+	return err
+}
+
+func (bt *BootTester) releaseBakeries(ctx context.Context) error {
+	u := bt.base + "/releasebakeries"
+	if bt.lease != "" {
+		u += "?lease=" + url.QueryEscape(bt.lease)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := bt.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected HTTP status code: got %d (%s), want %d", got, strings.TrimSpace(string(b)), want)
+	}
+	return nil
+}
+
+func (bt *BootTester) streamTo(ctx context.Context, img, booteryURL, hostname, newer string) (string, error) {
+	f, err := os.Open(img)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	return bt.streamFrom(ctx, f, st.Size(), img, booteryURL, hostname, newer)
+}
+
+// streamFrom uploads the image of size bytes (0 if unknown) read from r
+// (named name in error messages) to the bootery.
+func (bt *BootTester) streamFrom(ctx context.Context, r io.Reader, size int64, name, booteryURL, hostname, newer string) (string, error) {
+	u, err := url.Parse(booteryURL)
+	if err != nil {
+		return "", err
+	}
+	v := u.Query()
+	v.Set("hostname", hostname)
+	if newer != "" {
+		v.Set("boot-newer", newer)
+	}
+	if bt.lease != "" {
+		v.Set("lease", bt.lease)
+	}
+	u.RawQuery = v.Encode()
+	trailer := imageTrailer(bt.opts.SigningKey)
+	progress := newProgressReader(bt.limitUpload(ctx, r), size)
+	defer bt.recordUpload(name, progress)
+	body := &checksumReader{r: progress, h: sha256.New(), key: bt.opts.SigningKey, trailer: trailer}
+	defer bt.uploadProgress(name, hostname, progress)()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Trailer = trailer
+	resp, err := bt.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return "", &statusError{got: got, want: want, body: strings.TrimSpace(string(b))}
+	}
+	if err := bt.verifyChecksum(resp, name, body.sum); err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	return string(b), err
+}
+
+// Diagnostics asks the bootery to capture the final serial console state of a
+// device which was held after a failed boot test (see
+// Options.CaptureDiagnostics), including a sysrq dump if the device still
+// responds to it. The bootery resets the device afterwards.
+func (bt *BootTester) Diagnostics(ctx context.Context, hostname string) (string, error) {
+	if bt.opts.QEMU != "" {
+		return "", errors.New("diagnostics are not supported with QEMU, the boot log contains the serial console")
+	}
+	u, err := url.Parse(bt.base + "/diagnostics")
+	if err != nil {
+		return "", err
+	}
+	v := u.Query()
+	v.Set("hostname", hostname)
+	v.Set("sysrq", "true")
+	u.RawQuery = v.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := bt.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("unexpected HTTP status code: got %d (%s), want %d", got, strings.TrimSpace(string(b)), want)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	return string(b), err
+}
+
+// Cancel asks the bootery to abort the boot test of hostname, so that the
+// device is not left mid-flash.
+func (bt *BootTester) Cancel(ctx context.Context, hostname string) error {
+	if bt.opts.QEMU != "" {
+		// Cancelling the context of Test already killed QEMU.
+		return nil
+	}
+	u, err := url.Parse(bt.base + "/testboot1")
+	if err != nil {
+		return err
+	}
+	v := u.Query()
+	v.Set("hostname", hostname)
+	u.RawQuery = v.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := bt.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected HTTP status code: got %d (%s), want %d", got, strings.TrimSpace(string(b)), want)
+	}
+	return nil
+}
+
+func (bt *BootTester) testBoot(ctx context.Context, bootImg, hostname, newer string) (string, error) {
+	bootlog, err := bt.streamTo(ctx, bootImg, bt.testBootURL(), hostname, newer)
+	return bootlog, bootFailed(err)
+}
+
+func (bt *BootTester) updateRoot(ctx context.Context, rootImg, hostname string) (string, error) {
+	if bt.opts.DeltaRoot {
+		reply, err := bt.updateRootDelta(ctx, rootImg, hostname)
+		if err != errDeltaUnsupported {
+			return reply, err
+		}
+		log.Printf("%v, falling back to full upload", err)
+	}
+	return bt.streamTo(ctx, rootImg, bt.base+"/updateroot", hostname, "")
+}
+
+// testBootURL returns the bootery URL to which boot images are streamed.
+func (bt *BootTester) testBootURL() string {
+	return bt.base + "/testboot1" + fmt.Sprintf("?update_root=%v&hold_on_failure=%v", bt.opts.UpdateRoot, bt.opts.CaptureDiagnostics)
+}
+
+// bootFromFiles builds the images into files (or takes them from the cache)
+// and boot tests them. The image sizes and the root file listing are stored
+// in built.
+func (bt *BootTester) bootFromFiles(ctx context.Context, hostname, newer string, built *Result) (_ string, err error) {
+	var output bytes.Buffer
+	start := time.Now()
+	bootImg, rootImg, newer, cleanup, err := bt.writeImages(ctx, hostname, newer, &output)
+	defer cleanup()
+	defer func() {
+		// Runs before cleanup, which removes the images.
+		if err != nil && bt.opts.ArtifactDir != "" {
+			bt.saveArtifacts(hostname, map[string]string{
+				"boot.img": bootImg,
+				"root.img": rootImg,
+			}, output.Bytes())
+		}
+	}()
+	if err == nil {
+		built.Sizes, err = imageSizes(bootImg, rootImg)
+	}
+	if err == nil && bt.opts.RootManifest {
+		built.RootFiles, err = RootManifest(ctx, rootImg)
+	}
+	if err == nil && bt.opts.Provenance {
+		built.Digests, err = imageDigests(map[string]string{
+			"boot.img": bootImg,
+			"root.img": rootImg,
+		})
+	}
+	bt.phase(hostname, PhaseBuild, start, err)
+	if err != nil {
+		return "", err
+	}
+	start = time.Now()
+	bootlog, err := bt.bootImages(ctx, hostname, bootImg, rootImg, newer)
+	bt.phase(hostname, PhaseBoot, start, err)
+	return bootlog, err
+}
+
+// bootImages uploads the specified images to the bootery and boot tests them.
+func (bt *BootTester) bootImages(ctx context.Context, hostname, bootImg, rootImg, newer string) (string, error) {
+	if err := bt.runHook(ctx, HookContext{
+		Hook:      HookPreUpload,
+		Hostname:  hostname,
+		BootImage: bootImg,
+		RootImage: rootImg,
+	}); err != nil {
+		return "", err
+	}
+	if bt.opts.UpdateRoot {
+		log.Printf("updating root file system")
+		if _, err := bt.updateRoot(ctx, rootImg, hostname); err != nil {
+			return "", redact.Error(err)
+		}
+	}
+
+	log.Printf("testing boot file system")
+	bootlog, err := bt.testBoot(ctx, bootImg, hostname, newer)
+	if err != nil {
+		return "", redact.Error(err)
+	}
+	return bootlog, nil
+}
+
+// Test builds and boot tests the images for hostname. The booted image must
+// have been built after newer (a UNIX timestamp).
+func (bt *BootTester) Test(ctx context.Context, hostname, newer string) (result *Result, err error) {
+	defer func() { bt.afterTest(hostname, result, err) }()
+	bt.uploads = nil
+	restore, err := bt.replaceLocal(hostname)
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+	var (
+		bootlog string
+		built   Result
+	)
+	if bt.opts.QEMU != "" {
+		bootlog, err = bt.qemuBoot(ctx, hostname)
+	} else if bt.opts.Stream {
+		start := time.Now()
+		bootlog, err = bt.streamBoot1(ctx, hostname, newer)
+		bt.phase(hostname, PhaseBoot, start, err)
+	} else {
+		bootlog, err = bt.bootFromFiles(ctx, hostname, newer, &built)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result, err = bt.check(ctx, hostname, bootlog)
+	if err != nil {
+		return nil, err
+	}
+	result.RootFiles = built.RootFiles
+	result.Sizes = built.Sizes
+	result.Digests = built.Digests
+	if bt.opts.SBOM {
+		result.SBOM, err = SBOM(hostname)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// Build builds the images for hostname into dir (boot.img and root.img, and
+// sbom.cdx.json with Options.SBOM), for boot testing them later with Upload.
+func (bt *BootTester) Build(ctx context.Context, hostname, dir string) (err error) {
+	defer func() { bt.afterFailure(hostname, err) }()
+	// Subtract a second to ensure the gokrazy build timestamp is different
+	// (UNIX timestamps use seconds as their granularity).
+	newer := strconv.FormatInt(time.Now().Unix()-1, 10)
+	restore, err := bt.replaceLocal(hostname)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	start := time.Now()
+	bootImg, rootImg, newer, cleanup, err := bt.writeImages(ctx, hostname, newer, nil)
+	defer cleanup()
+	bt.phase(hostname, PhaseBuild, start, err)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := copyFile(filepath.Join(dir, "boot.img"), bootImg); err != nil {
+		return err
+	}
+	if err := copyFile(filepath.Join(dir, "root.img"), rootImg); err != nil {
+		return err
+	}
+	if bt.opts.SBOM {
+		sbom, err := SBOM(hostname)
+		if err != nil {
+			return err
+		}
+		if err := renameio.WriteFile(filepath.Join(dir, "sbom.cdx.json"), sbom, 0644); err != nil {
+			return err
+		}
+	}
+	return renameio.WriteFile(filepath.Join(dir, "newer"), []byte(newer+"\n"), 0644)
+}
+
+// Upload boot tests images which Build wrote into dir on hostname. The same
+// images can be uploaded to several booteries.
+func (bt *BootTester) Upload(ctx context.Context, hostname, dir string) (result *Result, err error) {
+	if bt.opts.QEMU != "" {
+		return nil, errors.New("Upload is not supported with QEMU, use Test")
+	}
+	defer func() { bt.afterTest(hostname, result, err) }()
+	bt.uploads = nil
+	b, err := ioutil.ReadFile(filepath.Join(dir, "newer"))
+	if err != nil {
+		return nil, err
+	}
+	newer := strings.TrimSpace(string(b))
+	start := time.Now()
+	bootlog, err := bt.bootImages(ctx, hostname, filepath.Join(dir, "boot.img"), filepath.Join(dir, "root.img"), newer)
+	bt.phase(hostname, PhaseBoot, start, err)
+	if err != nil {
+		return nil, err
+	}
+	result, err = bt.check(ctx, hostname, bootlog)
+	if err != nil {
+		return nil, err
+	}
+	result.Sizes, err = ReadImageSizes(dir)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// check runs the post-boot appliance probes and service verification.
+func (bt *BootTester) check(ctx context.Context, hostname, bootlog string) (_ *Result, err error) {
+	if len(bt.opts.ApplianceProbes) > 0 || bt.opts.VerifyServices {
+		start := time.Now()
+		defer func() { bt.phase(hostname, PhaseVerify, start, err) }()
+	}
+	if len(bt.opts.ApplianceProbes) > 0 {
+		log.Printf("probing appliance")
+		summary, err := bt.probeAppliance(ctx, hostname)
+		if err != nil {
+			return nil, &BootError{Err: err}
+		}
+		bootlog += "\n" + summary
+	}
+
+	result := &Result{BootLog: bootlog}
+	if bt.opts.VerifyServices {
+		log.Printf("verifying services")
+		services, err := checkServices(ctx, hostname, bt.opts.ServicesSettle)
+		if err != nil {
+			return nil, &BootError{Err: fmt.Errorf("%v\n%s", err, services)}
+		}
+		result.Services = services
+	}
+	return result, nil
+}
@@ -0,0 +1,176 @@
+package boottest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gokrazy/internal/config"
+	"github.com/google/renameio/v2"
+	"golang.org/x/mod/modfile"
+)
+
+// imageCacheKey returns a hash over everything which determines the contents
+// of the images built by gok: the instance config (which lists the packages,
+// kernel package and firmware package), the build environment (which selects
+// the architecture), the extra gok arguments, the go.mod/go.sum files in
+// the instance’s builddir (which pin the package versions) and the contents
+// of the local directories which they replace modules with (which is where
+// changes under test often live).
+func imageCacheKey(cfg []byte, env, args []string) (string, error) {
+	h := sha256.New()
+	h.Write(cfg)
+	io.WriteString(h, "\x00"+strings.Join(env, "\x00"))
+	if len(args) > 0 {
+		// Keeps the keys of existing cache entries valid.
+		io.WriteString(h, "\x00"+strings.Join(args, "\x00"))
+	}
+	builddir := filepath.Join(filepath.Dir(config.InstanceConfigPath()), "builddir")
+	var files []string
+	err := filepath.WalkDir(builddir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.Name() == "go.mod" || d.Name() == "go.sum" {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+	for _, fn := range files {
+		b, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(h, "\x00"+strings.TrimPrefix(fn, builddir)+"\x00")
+		h.Write(b)
+		if filepath.Base(fn) != "go.mod" {
+			continue
+		}
+		f, err := modfile.Parse(fn, b, nil)
+		if err != nil {
+			return "", err
+		}
+		for _, r := range f.Replace {
+			if r.New.Version != "" {
+				continue // a module version, pinned by go.sum
+			}
+			dir := r.New.Path
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(filepath.Dir(fn), dir)
+			}
+			if err := hashTree(h, dir); err != nil {
+				return "", fmt.Errorf("hashing local replacement of %s: %v", r.Old.Path, err)
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashTree writes the names and contents of all files below dir (except for
+// version control metadata) into h.
+func hashTree(h io.Writer, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		io.WriteString(h, "\x00"+rel+"\x00")
+		if d.Type()&fs.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			io.WriteString(h, "-> "+target)
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(h, f)
+		return err
+	})
+}
+
+// lookupCache returns the paths of the cached boot and root images for key,
+// and the boot-newer timestamp which was used when building them.
+func (bt *BootTester) lookupCache(key string) (boot, root, newer string, ok bool) {
+	dir := filepath.Join(bt.opts.CacheDir, key)
+	b, err := ioutil.ReadFile(filepath.Join(dir, "newer"))
+	if err != nil {
+		return "", "", "", false
+	}
+	boot = filepath.Join(dir, "boot.img")
+	root = filepath.Join(dir, "root.img")
+	for _, fn := range []string{boot, root} {
+		if _, err := os.Stat(fn); err != nil {
+			return "", "", "", false
+		}
+	}
+	return boot, root, strings.TrimSpace(string(b)), true
+}
+
+func copyFile(dest, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := renameio.TempFile("", dest)
+	if err != nil {
+		return err
+	}
+	defer out.Cleanup()
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.CloseAtomicallyReplace()
+}
+
+// storeCache copies the specified images into the cache. The newer file is
+// written last, so that partially stored entries are never picked up.
+func (bt *BootTester) storeCache(key, boot, root, newer string) error {
+	dir := filepath.Join(bt.opts.CacheDir, key)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := copyFile(filepath.Join(dir, "boot.img"), boot); err != nil {
+		return err
+	}
+	if err := copyFile(filepath.Join(dir, "root.img"), root); err != nil {
+		return err
+	}
+	if err := renameio.WriteFile(filepath.Join(dir, "newer"), []byte(newer+"\n"), 0644); err != nil {
+		return err
+	}
+	log.Printf("stored images in cache entry %s", dir)
+	return nil
+}
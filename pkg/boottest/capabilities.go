@@ -0,0 +1,162 @@
+package boottest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var errCapabilitiesUnsupported = errors.New("bootery does not support capability discovery")
+
+// Device is a device attached to the bootery.
+type Device struct {
+	Hostname  string `json:"hostname"`
+	Arch      string `json:"arch"`            // e.g. arm64, see Options.Arch
+	Board     string `json:"board,omitempty"` // e.g. rpi4, see Boards
+	Available bool   `json:"available"`       // false while powered off or busy
+}
+
+// Capabilities describes what a bootery supports, as returned by its
+// /capabilities endpoint.
+type Capabilities struct {
+	Devices []Device `json:"devices"`
+
+	// Features lists optional bootery features, e.g. update_root,
+	// delta_root, eeprom or compression.
+	Features []string `json:"features"`
+}
+
+func (c *Capabilities) has(feature string) bool {
+	for _, f := range c.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Capabilities) device(hostname string) (Device, bool) {
+	for _, d := range c.Devices {
+		if d.Hostname == hostname {
+			return d, true
+		}
+	}
+	return Device{}, false
+}
+
+// Capabilities queries which devices and features the bootery supports.
+func (bt *BootTester) Capabilities(ctx context.Context) (*Capabilities, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, bt.base+"/capabilities", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := bt.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errCapabilitiesUnsupported
+	}
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected HTTP status code: got %d (%s), want %d", got, strings.TrimSpace(string(b)), want)
+	}
+	var caps Capabilities
+	if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+		return nil, err
+	}
+	return &caps, nil
+}
+
+// targetArch returns the architecture images are built for, or "" if the
+// instance config decides.
+func (bt *BootTester) targetArch() string {
+	if bt.opts.Arch != "" {
+		return bt.opts.Arch
+	}
+	if p, ok := boardProfiles[bt.opts.Board]; ok {
+		return p.arch
+	}
+	return ""
+}
+
+// awaitCapabilities polls the bootery until it answers and ready returns
+// true, for at most Options.DiscoveryTimeout.
+func (bt *BootTester) awaitCapabilities(ctx context.Context, ready func(*Capabilities) error) (*Capabilities, error) {
+	deadline := time.Now().Add(bt.opts.DiscoveryTimeout)
+	for {
+		caps, err := bt.Capabilities(ctx)
+		if err == errCapabilitiesUnsupported {
+			return nil, err
+		}
+		if err == nil {
+			if err = ready(caps); err == nil {
+				return caps, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("bootery not ready after %v: %v", bt.opts.DiscoveryTimeout, err)
+		}
+		log.Printf("waiting for bootery: %v", err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// checkFeatures verifies that the bootery supports the features requested in
+// Options before anything is uploaded. Optional features which have a
+// fallback are disabled instead.
+func (bt *BootTester) checkFeatures(caps *Capabilities) error {
+	if bt.opts.UpdateRoot && !caps.has("update_root") {
+		return errors.New("UpdateRoot requested, but the bootery does not support update_root")
+	}
+	if bt.opts.DeltaRoot && !caps.has("delta_root") {
+		log.Printf("bootery does not support delta_root, uploading full root images")
+		bt.opts.DeltaRoot = false
+	}
+	return nil
+}
+
+// selectDevices waits until the bootery reports hosts as available and returns
+// those whose architecture matches the images. Hosts unknown to the bootery
+// are kept, as older booteries do not list all devices.
+func (bt *BootTester) selectDevices(ctx context.Context, hosts []string) ([]string, error) {
+	arch := bt.targetArch()
+	var selected []string
+	_, err := bt.awaitCapabilities(ctx, func(caps *Capabilities) error {
+		selected = selected[:0]
+		var busy []string
+		for _, host := range hosts {
+			d, ok := caps.device(host)
+			if ok && arch != "" && d.Arch != "" && d.Arch != arch {
+				log.Printf("skipping %s: device architecture %s does not match %s", host, d.Arch, arch)
+				continue
+			}
+			if ok && !d.Available {
+				busy = append(busy, host)
+			}
+			selected = append(selected, host)
+		}
+		if len(busy) > 0 {
+			return fmt.Errorf("devices %q not available yet", busy)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("none of the devices %q can boot %s images", hosts, arch)
+	}
+	return selected, nil
+}
@@ -0,0 +1,78 @@
+package boottest
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+)
+
+// imageChecksumHeader carries the hex-encoded SHA-256 of an uploaded image. It
+// is sent as an HTTP trailer so that the checksum can be computed while
+// streaming, and the bootery echoes the checksum of what it received as a
+// response header.
+const imageChecksumHeader = "X-Image-SHA256"
+
+// checksumReader hashes everything read through it and stores the final
+// checksum (and its signature, if key is set) in the request trailer before
+// returning io.EOF, as required by net/http.
+type checksumReader struct {
+	r       io.Reader
+	h       hash.Hash
+	key     ed25519.PrivateKey
+	trailer http.Header
+	sum     string
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.h.Write(p[:n])
+	if err == io.EOF {
+		c.sum = hex.EncodeToString(c.h.Sum(nil))
+		setImageTrailer(c.trailer, c.sum, c.key)
+	}
+	return n, err
+}
+
+// verifyChecksum compares the checksum which the bootery echoed in resp with
+// the hex-encoded SHA-256 sum of the image name which was uploaded. Booteries
+// which do not echo checksums are accepted with a warning, unless
+// Options.RequireChecksumEcho is set.
+func (bt *BootTester) verifyChecksum(resp *http.Response, name, sum string) error {
+	got := resp.Header.Get(imageChecksumHeader)
+	if got == "" {
+		if bt.opts.RequireChecksumEcho {
+			return fmt.Errorf("bootery did not confirm the checksum of image %s, cannot verify that it arrived intact", name)
+		}
+		log.Printf("warning: bootery did not confirm the checksum of image %s, cannot verify that it arrived intact (update the bootery to verify images)", name)
+		return nil
+	}
+	if got != sum {
+		return fmt.Errorf("image %s corrupted in transit: bootery received SHA-256 %s, want %s", name, got, sum)
+	}
+	return nil
+}
+
+// imageTrailer returns the trailer to declare in upload requests, before the
+// checksum is known.
+func imageTrailer(key ed25519.PrivateKey) http.Header {
+	trailer := http.Header{http.CanonicalHeaderKey(imageChecksumHeader): nil}
+	if key != nil {
+		trailer[http.CanonicalHeaderKey(imageSignatureHeader)] = nil
+	}
+	return trailer
+}
+
+// setImageTrailer stores the hex-encoded SHA-256 sum of an image (and its
+// signature, if key is set) in trailer.
+func setImageTrailer(trailer http.Header, sum string, key ed25519.PrivateKey) {
+	trailer.Set(imageChecksumHeader, sum)
+	if key != nil {
+		sig := ed25519.Sign(key, signatureMessage(sum))
+		trailer.Set(imageSignatureHeader, base64.StdEncoding.EncodeToString(sig))
+	}
+}
@@ -0,0 +1,96 @@
+package boottest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeImage writes a random image of size bytes and returns its path and
+// hex-encoded SHA-256.
+func writeImage(t *testing.T, size int) (string, string) {
+	t.Helper()
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Join(t.TempDir(), "boot.img")
+	if err := ioutil.WriteFile(fn, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(b)
+	return fn, hex.EncodeToString(sum[:])
+}
+
+func TestChecksumTrailer(t *testing.T) {
+	fn, sum := writeImage(t, 3<<20)
+	for _, tt := range []struct {
+		name    string
+		echo    func(received string) string // "" omits the header
+		require bool
+		wantErr string
+	}{
+		{
+			name: "echo",
+			echo: func(received string) string { return received },
+		},
+		{
+			name:    "corrupted",
+			echo:    func(string) string { return strings.Repeat("0", 64) },
+			wantErr: "corrupted in transit",
+		},
+		{
+			name: "no echo",
+			echo: func(string) string { return "" },
+		},
+		{
+			name:    "no echo required",
+			echo:    func(string) string { return "" },
+			require: true,
+			wantErr: "did not confirm the checksum",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var trailer string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				h := sha256.New()
+				io.Copy(h, r.Body) // populates r.Trailer
+				received := hex.EncodeToString(h.Sum(nil))
+				trailer = r.Trailer.Get(imageChecksumHeader)
+				if echo := tt.echo(received); echo != "" {
+					w.Header().Set(imageChecksumHeader, echo)
+				}
+				w.Write([]byte("boot log"))
+			}))
+			defer srv.Close()
+			bt, err := New(Options{
+				BooteryURL:          srv.URL + "/testboot",
+				RequireChecksumEcho: tt.require,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = bt.streamTo(context.Background(), fn, srv.URL+"/testboot1", "pi5", "")
+			if got, want := trailer, sum; got != want {
+				t.Errorf("%s trailer = %q, want %q", imageChecksumHeader, got, want)
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("streamTo: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("streamTo: got error %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package boottest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// appendCmdline appends extra to the kernel command line (cmdline.txt) in the
+// FAT boot image img. It requires mtype and mcopy from mtools.
+func appendCmdline(ctx context.Context, img, extra string) error {
+	env := append(os.Environ(), "MTOOLS_SKIP_CHECK=1")
+	mtype := exec.CommandContext(ctx, "mtype", "-i", img, "::cmdline.txt")
+	mtype.Env = env
+	b, err := mtype.Output()
+	if err != nil {
+		return fmt.Errorf("%v: %v (does the boot image contain a cmdline.txt?)", mtype.Args, err)
+	}
+	cmdline := strings.TrimSpace(string(b)) + " " + extra
+	f, err := ioutil.TempFile("", "gokr-cmdline")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(cmdline + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	mcopy := exec.CommandContext(ctx, "mcopy", "-o", "-i", img, f.Name(), "::cmdline.txt")
+	mcopy.Env = env
+	if out, err := mcopy.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %v\n%s", mcopy.Args, err, out)
+	}
+	log.Printf("kernel command line: %s", cmdline)
+	return nil
+}
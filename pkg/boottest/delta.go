@@ -0,0 +1,191 @@
+package boottest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// deltaChunkSize is the granularity with which root images are compared. Root
+// images are hundreds of MB, so 1 MiB chunks keep the manifest small while
+// still only transferring the changed parts.
+const deltaChunkSize = 1 << 20
+
+var errDeltaUnsupported = errors.New("bootery does not support delta root uploads")
+
+type deltaManifest struct {
+	ChunkSize int      `json:"chunk_size"`
+	Chunks    []string `json:"chunks"` // hex-encoded SHA-256 per chunk
+	Missing   []int    `json:"missing,omitempty"`
+}
+
+func chunkHashes(img string) ([]string, error) {
+	f, err := os.Open(img)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var hashes []string
+	buf := make([]byte, deltaChunkSize)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			sum := sha256.Sum256(buf[:n])
+			hashes = append(hashes, hex.EncodeToString(sum[:]))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
+
+func (bt *BootTester) deltaURL(endpoint, hostname string) (string, error) {
+	u, err := url.Parse(bt.base + endpoint)
+	if err != nil {
+		return "", err
+	}
+	v := u.Query()
+	v.Set("hostname", hostname)
+	if bt.lease != "" {
+		v.Set("lease", bt.lease)
+	}
+	u.RawQuery = v.Encode()
+	return u.String(), nil
+}
+
+// negotiateChunks sends the chunk manifest to the bootery, which replies with
+// the indices of the chunks it does not have.
+func (bt *BootTester) negotiateChunks(ctx context.Context, hostname string, manifest *deltaManifest) ([]int, error) {
+	u, err := bt.deltaURL("/updateroot/negotiate", hostname)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := bt.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errDeltaUnsupported
+	}
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected HTTP status code: got %d (%s), want %d", got, strings.TrimSpace(string(b)), want)
+	}
+	var reply struct {
+		Missing []int `json:"missing"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return nil, err
+	}
+	return reply.Missing, nil
+}
+
+// writeDelta writes the manifest as a single JSON line, followed by the
+// contents of all missing chunks in order.
+func writeDelta(w io.Writer, img string, manifest *deltaManifest) error {
+	f, err := os.Open(img)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := json.NewEncoder(w).Encode(manifest); err != nil {
+		return err
+	}
+	for _, idx := range manifest.Missing {
+		if idx < 0 || idx >= len(manifest.Chunks) {
+			return fmt.Errorf("bootery requested chunk %d, but image only has %d chunks", idx, len(manifest.Chunks))
+		}
+		r := io.NewSectionReader(f, int64(idx)*deltaChunkSize, deltaChunkSize)
+		if _, err := io.Copy(w, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bt *BootTester) updateRootDelta(ctx context.Context, rootImg, hostname string) (string, error) {
+	chunks, err := chunkHashes(rootImg)
+	if err != nil {
+		return "", err
+	}
+	sum, err := fileDigest(rootImg)
+	if err != nil {
+		return "", err
+	}
+	manifest := &deltaManifest{
+		ChunkSize: deltaChunkSize,
+		Chunks:    chunks,
+	}
+	missing, err := bt.negotiateChunks(ctx, hostname, manifest)
+	if err != nil {
+		return "", err
+	}
+	manifest.Missing = missing
+	log.Printf("delta root upload: sending %d of %d chunks", len(missing), len(chunks))
+
+	u, err := bt.deltaURL("/updateroot/delta", hostname)
+	if err != nil {
+		return "", err
+	}
+	pr, pw := io.Pipe()
+	// The transport cannot close pr through the rate limiter, and the
+	// writer must not block forever if the request fails.
+	defer pr.Close()
+	go func() {
+		pw.CloseWithError(writeDelta(pw, rootImg, manifest))
+	}()
+	// The total is approximate: the manifest is not counted, the last
+	// chunk may be shorter.
+	progress := newProgressReader(bt.limitUpload(ctx, pr), int64(len(missing))*deltaChunkSize)
+	defer bt.recordUpload("root.img delta", progress)
+	defer bt.uploadProgress("root delta", hostname, progress)()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, progress)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	// Like full uploads, delta uploads carry the checksum (and signature)
+	// of the image, which the bootery verifies after reconstructing it.
+	// As the checksum is known upfront, the trailer is complete already.
+	req.Trailer = imageTrailer(bt.opts.SigningKey)
+	setImageTrailer(req.Trailer, sum, bt.opts.SigningKey)
+	resp, err := bt.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("unexpected HTTP status code: got %d (%s), want %d", got, strings.TrimSpace(string(b)), want)
+	}
+	if err := bt.verifyChecksum(resp, rootImg, sum); err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	return string(b), err
+}
@@ -0,0 +1,116 @@
+package boottest
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateRootDelta(t *testing.T) {
+	// The device has the old image, of which the new one changes the
+	// second chunk. The last chunk is shorter than deltaChunkSize.
+	old := make([]byte, 2*deltaChunkSize+deltaChunkSize/2)
+	if _, err := rand.Read(old); err != nil {
+		t.Fatal(err)
+	}
+	img := append([]byte(nil), old...)
+	img[deltaChunkSize+1] ^= 0xff
+	fn := filepath.Join(t.TempDir(), "root.img")
+	if err := ioutil.WriteFile(fn, img, 0644); err != nil {
+		t.Fatal(err)
+	}
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/updateroot/negotiate", func(w http.ResponseWriter, r *http.Request) {
+		var manifest deltaManifest
+		if err := json.NewDecoder(r.Body).Decode(&manifest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var missing []int
+		for idx, chunk := range manifest.Chunks {
+			end := (idx + 1) * deltaChunkSize
+			if end > len(old) {
+				end = len(old)
+			}
+			sum := sha256.Sum256(old[idx*deltaChunkSize : end])
+			if hex.EncodeToString(sum[:]) != chunk {
+				missing = append(missing, idx)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string][]int{"missing": missing})
+	})
+	mux.HandleFunc("/updateroot/delta", func(w http.ResponseWriter, r *http.Request) {
+		br := bufio.NewReader(r.Body)
+		line, err := br.ReadBytes('\n')
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var manifest deltaManifest
+		if err := json.Unmarshal(line, &manifest); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if got, want := manifest.Missing, []int{1}; len(got) != 1 || got[0] != want[0] {
+			http.Error(w, "unexpected missing chunks", http.StatusBadRequest)
+			return
+		}
+		reconstructed := append([]byte(nil), old...)
+		if _, err := io.ReadFull(br, reconstructed[deltaChunkSize:2*deltaChunkSize]); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		io.Copy(ioutil.Discard, br) // populates r.Trailer
+		sum := sha256.Sum256(reconstructed)
+		hexSum := hex.EncodeToString(sum[:])
+		if got := r.Trailer.Get(imageChecksumHeader); got != hexSum {
+			http.Error(w, "checksum mismatch: "+got, http.StatusBadRequest)
+			return
+		}
+		sig, err := base64.StdEncoding.DecodeString(r.Trailer.Get(imageSignatureHeader))
+		if err != nil || !ed25519.Verify(pub, signatureMessage(hexSum), sig) {
+			http.Error(w, "invalid signature", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set(imageChecksumHeader, hexSum)
+		w.Write([]byte("ok"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	bt, err := New(Options{
+		BooteryURL: srv.URL + "/testboot",
+		DeltaRoot:  true,
+		SigningKey: key,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := bt.updateRootDelta(context.Background(), fn, "pi5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reply, "ok"; got != want {
+		t.Errorf("updateRootDelta() = %q, want %q", got, want)
+	}
+	uploads := bt.Uploads()
+	if len(uploads) != 1 || uploads[0].Bytes < deltaChunkSize {
+		t.Errorf("Uploads() = %+v, want one upload of at least one chunk", uploads)
+	}
+}
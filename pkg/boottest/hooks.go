@@ -0,0 +1,126 @@
+package boottest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+
+	"github.com/gokrazy/internal/config"
+)
+
+// Hook points, see Options.Hooks.
+const (
+	// HookPreBuild runs before the images are built, after the instance
+	// config was prepared for the device (e.g. to add extra files).
+	HookPreBuild = "pre-build"
+
+	// HookPostBuild runs after the boot and root images were built into
+	// files, i.e. neither with Stream nor QEMU, nor for cached images.
+	HookPostBuild = "post-build"
+
+	// HookPreUpload runs before images are uploaded to the bootery, but not
+	// with Stream, which uploads while building.
+	HookPreUpload = "pre-upload"
+
+	// HookPostTest runs after every boot test, successful or not.
+	HookPostTest = "post-test"
+
+	// HookOnFailure runs after a failed build or boot test.
+	HookOnFailure = "on-failure"
+)
+
+var hookPoints = map[string]bool{
+	HookPreBuild:  true,
+	HookPostBuild: true,
+	HookPreUpload: true,
+	HookPostTest:  true,
+	HookOnFailure: true,
+}
+
+// HookContext describes the state of a boot test to a hook, which reads it
+// as JSON from its standard input.
+type HookContext struct {
+	Hook       string `json:"hook"`
+	Hostname   string `json:"hostname"`
+	Kernel     string `json:"kernel,omitempty"` // see Options.Kernels
+	ConfigPath string `json:"config_path"`      // of the instance config
+
+	// BootImage and RootImage are the paths of the built images, if any.
+	BootImage string `json:"boot_image,omitempty"`
+	RootImage string `json:"root_image,omitempty"`
+
+	// BootLog is the boot log of a successful boot test (post-test).
+	BootLog string `json:"boot_log,omitempty"`
+
+	// Error is the error of a failed build or boot test.
+	Error string `json:"error,omitempty"`
+
+	// Data is Options.HookData, e.g. the repository and pull request.
+	Data map[string]string `json:"data,omitempty"`
+}
+
+// runHook runs the command of the hook point hc.Hook, if any, with hc on its
+// standard input. Its output is redacted like that of gok.
+func (bt *BootTester) runHook(ctx context.Context, hc HookContext) error {
+	command, ok := bt.opts.Hooks[hc.Hook]
+	if !ok {
+		return nil
+	}
+	hc.Kernel = bt.kernels[hc.Hostname]
+	hc.ConfigPath = config.InstanceConfigPath()
+	hc.Data = bt.opts.HookData
+	b, err := json.Marshal(hc)
+	if err != nil {
+		return err
+	}
+	log.Printf("running %s hook for %s", hc.Hook, hc.Hostname)
+	cmd := exec.CommandContext(ctx, command)
+	cmd.Stdin = bytes.NewReader(b)
+	flush := redactOutput(cmd, nil)
+	defer flush()
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook: %v: %v", hc.Hook, cmd.Args, err)
+	}
+	return nil
+}
+
+// afterTest runs the post-test and, if testErr is non-nil, the on-failure
+// hooks. Their errors are logged, as the outcome of the boot test is the one
+// to report.
+func (bt *BootTester) afterTest(hostname string, result *Result, testErr error) {
+	// Deliberately not bound to the caller’s context: a cancelled run is
+	// still worth reporting.
+	ctx := context.Background()
+	hc := HookContext{
+		Hook:     HookPostTest,
+		Hostname: hostname,
+	}
+	if result != nil {
+		hc.BootLog = result.BootLog
+	}
+	if testErr != nil {
+		hc.Error = testErr.Error()
+	}
+	if err := bt.runHook(ctx, hc); err != nil {
+		log.Print(err)
+	}
+	bt.afterFailure(hostname, testErr)
+}
+
+// afterFailure runs the on-failure hook if err is non-nil.
+func (bt *BootTester) afterFailure(hostname string, err error) {
+	if err == nil {
+		return
+	}
+	hc := HookContext{
+		Hook:     HookOnFailure,
+		Hostname: hostname,
+		Error:    err.Error(),
+	}
+	if err := bt.runHook(context.Background(), hc); err != nil {
+		log.Print(err)
+	}
+}
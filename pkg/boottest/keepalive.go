@@ -0,0 +1,40 @@
+package boottest
+
+import (
+	"log"
+	"time"
+)
+
+// keepAlive logs a progress line for phase every Options.KeepAlive until the
+// returned function is called, so that CI providers which kill jobs without
+// output for a while (often 10 minutes) do not kill long builds or uploads.
+// progress, if non-nil, describes how far the phase got.
+func (bt *BootTester) keepAlive(phase string, progress func() string) (stop func()) {
+	return bt.keepAliveEvery(bt.opts.KeepAlive, phase, progress)
+}
+
+// keepAliveEvery is like keepAlive, but logs every interval.
+func (bt *BootTester) keepAliveEvery(interval time.Duration, phase string, progress func() string) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			msg := phase + ": still running after " + time.Since(start).Round(time.Second).String()
+			if progress != nil {
+				msg += ", " + progress()
+			}
+			log.Print(msg)
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,65 @@
+package boottest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// assignKernels assigns each of hosts one of Options.Kernels and returns the
+// hosts which were assigned a kernel. Entries of the form package=hostname
+// assign the kernel explicitly, other entries are matched with the board of
+// the device (see Capabilities and Boards).
+func (bt *BootTester) assignKernels(ctx context.Context, hosts []string) ([]string, error) {
+	explicit := make(map[string]string) // hostname → kernel package
+	byBoard := make(map[string]bool)    // kernel packages matched by board
+	for _, k := range bt.opts.Kernels {
+		if pkg, host, ok := strings.Cut(k, "="); ok {
+			explicit[host] = pkg
+		} else {
+			byBoard[k] = true
+		}
+	}
+	var caps *Capabilities
+	if len(byBoard) > 0 {
+		var err error
+		caps, err = bt.Capabilities(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("matching kernels with devices: %v (specify package=hostname instead)", err)
+		}
+	}
+	bt.kernels = make(map[string]string)
+	covered := make(map[string]bool)
+	var selected []string
+	for _, host := range hosts {
+		pkg, ok := explicit[host]
+		if !ok && caps != nil {
+			if d, found := caps.device(host); found {
+				if p, known := boardProfiles[d.Board]; known && byBoard[p.kernelPackage] {
+					pkg, ok = p.kernelPackage, true
+				}
+			}
+		}
+		if !ok {
+			log.Printf("skipping %s: none of the kernels %q matches the device", host, bt.opts.Kernels)
+			continue
+		}
+		bt.kernels[host] = pkg
+		covered[pkg] = true
+		selected = append(selected, host)
+	}
+	for _, k := range bt.opts.Kernels {
+		pkg, _, _ := strings.Cut(k, "=")
+		if !covered[pkg] {
+			return nil, fmt.Errorf("none of the devices %q matches kernel %s", hosts, pkg)
+		}
+	}
+	return selected, nil
+}
+
+// Kernel returns the kernel package assigned to hostname (see
+// Options.Kernels), or "" if the instance config decides.
+func (bt *BootTester) Kernel(hostname string) string {
+	return bt.kernels[hostname]
+}
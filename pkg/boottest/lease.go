@@ -0,0 +1,133 @@
+package boottest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// errLeaseHeld is returned by the bootery (as HTTP 409 Conflict) while
+// another holder has a lease on the bakeries.
+var errLeaseHeld = errors.New("bakeries are leased by another holder")
+
+type leaseReply struct {
+	Lease  string `json:"lease"`
+	Holder string `json:"holder,omitempty"` // current holder, on conflict
+}
+
+func (bt *BootTester) leaseRequest(ctx context.Context, endpoint string, v url.Values) (*leaseReply, error) {
+	u, err := url.Parse(bt.base + endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = v.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := bt.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusConflict:
+		var reply leaseReply
+		if err := json.Unmarshal(b, &reply); err == nil && reply.Holder != "" {
+			return nil, fmt.Errorf("%w (%s)", errLeaseHeld, reply.Holder)
+		}
+		return nil, errLeaseHeld
+	case http.StatusNotFound:
+		return nil, errors.New("bootery does not support leases")
+	default:
+		return nil, fmt.Errorf("unexpected HTTP status code: got %d (%s), want %d", resp.StatusCode, strings.TrimSpace(string(b)), http.StatusOK)
+	}
+	var reply leaseReply
+	if err := json.Unmarshal(b, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// acquireLease waits up to Options.LeaseWait for the lease on the bakeries of
+// slug, so that concurrent CI runs queue up instead of flashing the same
+// devices. The lease is renewed in the background until releaseLease.
+func (bt *BootTester) acquireLease(ctx context.Context, slug string) error {
+	v := url.Values{}
+	v.Set("slug", slug)
+	v.Set("holder", bt.opts.LeaseHolder)
+	v.Set("ttl", bt.opts.LeaseTTL.String())
+	deadline := time.Now().Add(bt.opts.LeaseWait)
+	for {
+		reply, err := bt.leaseRequest(ctx, "/acquirelease", v)
+		if err == nil {
+			bt.lease = reply.Lease
+			break
+		}
+		if !errors.Is(err, errLeaseHeld) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("waited %v for the lease: %v", bt.opts.LeaseWait, err)
+		}
+		log.Printf("%v, waiting", err)
+		wait := 10 * time.Second
+		if remaining := time.Until(deadline); remaining < wait {
+			wait = remaining
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	log.Printf("acquired lease %s", bt.lease)
+
+	renewCtx, cancel := context.WithCancel(context.Background())
+	bt.stopRenew = cancel
+	go func() {
+		v := url.Values{}
+		v.Set("lease", bt.lease)
+		v.Set("ttl", bt.opts.LeaseTTL.String())
+		for {
+			select {
+			case <-renewCtx.Done():
+				return
+			case <-time.After(bt.opts.LeaseTTL / 3):
+			}
+			if _, err := bt.leaseRequest(renewCtx, "/renewlease", v); err != nil && renewCtx.Err() == nil {
+				// The next attempt might succeed before the lease expires.
+				log.Printf("renewing lease: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// releaseLease stops renewing the lease and releases it, if one is held.
+func (bt *BootTester) releaseLease(ctx context.Context) error {
+	if bt.lease == "" {
+		return nil
+	}
+	bt.stopRenew()
+	v := url.Values{}
+	v.Set("lease", bt.lease)
+	if _, err := bt.leaseRequest(ctx, "/releaselease", v); err != nil {
+		return err
+	}
+	log.Printf("released lease %s", bt.lease)
+	bt.lease = ""
+	return nil
+}
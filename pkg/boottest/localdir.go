@@ -0,0 +1,101 @@
+package boottest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+
+	"github.com/gokrazy/internal/config"
+	"github.com/google/renameio/v2"
+	"golang.org/x/mod/modfile"
+)
+
+// replaceLocal points the builddir go.mod files of the kernel and firmware
+// packages at Options.KernelDir and Options.FirmwareDir via replace
+// directives when building for hostname. The returned function restores the
+// original go.mod files.
+func (bt *BootTester) replaceLocal(hostname string) (restore func(), _ error) {
+	var restores []func()
+	restore = func() {
+		for _, r := range restores {
+			r()
+		}
+	}
+	if bt.opts.KernelDir == "" && bt.opts.FirmwareDir == "" {
+		return restore, nil
+	}
+	cfg, err := config.ReadFromFile()
+	if err != nil {
+		return restore, err
+	}
+	if _, err := applyProfile(cfg, bt.opts.Board, bt.opts.Arch); err != nil {
+		return restore, err
+	}
+	kernel, firmware := "github.com/gokrazy/kernel", "github.com/gokrazy/firmware"
+	if k, ok := bt.kernels[hostname]; ok {
+		kernel = k
+	} else if cfg.KernelPackage != nil && *cfg.KernelPackage != "" {
+		kernel = *cfg.KernelPackage
+	}
+	if cfg.FirmwarePackage != nil && *cfg.FirmwarePackage != "" {
+		firmware = *cfg.FirmwarePackage
+	}
+	builddir := filepath.Join(filepath.Dir(config.InstanceConfigPath()), "builddir")
+	for _, r := range []struct{ pkg, dir string }{
+		{kernel, bt.opts.KernelDir},
+		{firmware, bt.opts.FirmwareDir},
+	} {
+		if r.dir == "" {
+			continue
+		}
+		undo, err := replaceModule(filepath.Join(builddir, filepath.FromSlash(r.pkg), "go.mod"), r.dir)
+		if err != nil {
+			restore()
+			return func() {}, fmt.Errorf("replacing %s with %s: %v", r.pkg, r.dir, err)
+		}
+		restores = append(restores, undo)
+	}
+	return restore, nil
+}
+
+// replaceModule adds a replace directive for the module in dir to the go.mod
+// file at gomod and returns a function which restores the original file.
+func replaceModule(gomod, dir string) (restore func(), _ error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+	modulePath := modfile.ModulePath(b)
+	if modulePath == "" {
+		return nil, fmt.Errorf("%s does not declare a module path", filepath.Join(dir, "go.mod"))
+	}
+	orig, err := ioutil.ReadFile(gomod)
+	if err != nil {
+		return nil, fmt.Errorf("%v (build the instance once to populate the builddir)", err)
+	}
+	f, err := modfile.Parse(gomod, orig, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.AddReplace(modulePath, "", dir, ""); err != nil {
+		return nil, err
+	}
+	replaced, err := f.Format()
+	if err != nil {
+		return nil, err
+	}
+	if err := renameio.WriteFile(gomod, replaced, 0644); err != nil {
+		return nil, err
+	}
+	log.Printf("building %s from %s", modulePath, dir)
+	return func() {
+		if err := renameio.WriteFile(gomod, orig, 0644); err != nil {
+			log.Printf("restoring %s: %v", gomod, err)
+		}
+	}, nil
+}
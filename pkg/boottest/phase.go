@@ -0,0 +1,18 @@
+package boottest
+
+import "time"
+
+// Phases of a boot test, as reported to Options.OnPhase.
+const (
+	PhaseBuild  = "build"
+	PhaseBoot   = "boot"   // upload and boot; with Stream, includes the build
+	PhaseVerify = "verify" // appliance probes and service verification
+)
+
+// phase reports the end of phase for hostname, which began at start, to
+// Options.OnPhase.
+func (bt *BootTester) phase(hostname, phase string, start time.Time, err error) {
+	if bt.opts.OnPhase != nil {
+		bt.opts.OnPhase(hostname, phase, time.Since(start), err)
+	}
+}
@@ -0,0 +1,79 @@
+package boottest
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// parseSPKIPins decodes pins, which are base64-encoded SHA-256 hashes of
+// DER-encoded SubjectPublicKeyInfo structures, optionally prefixed with
+// sha256/ (as used by HPKP and curl --pinnedpubkey).
+func parseSPKIPins(pins []string) (map[[sha256.Size]byte]bool, error) {
+	result := make(map[[sha256.Size]byte]bool)
+	for _, pin := range pins {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(pin), "sha256/"))
+		if err != nil {
+			return nil, fmt.Errorf("SPKI pin %q: %v", pin, err)
+		}
+		if got, want := len(b), sha256.Size; got != want {
+			return nil, fmt.Errorf("SPKI pin %q: unexpected length: got %d bytes, want %d", pin, got, want)
+		}
+		var hash [sha256.Size]byte
+		copy(hash[:], b)
+		result[hash] = true
+	}
+	return result, nil
+}
+
+func spkiHash(cert *x509.Certificate) [sha256.Size]byte {
+	return sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+}
+
+// pinnedTLSConfig returns a TLS configuration which only accepts servers
+// whose leaf certificate or any certificate of a verified chain has one of the
+// pinned public keys. Pinning the leaf key accepts self-signed certificates,
+// pinning a CA or intermediate key survives the rotation of leaf certificates.
+func pinnedTLSConfig(pins map[[sha256.Size]byte]bool) *tls.Config {
+	pinned := func(cert *x509.Certificate) bool {
+		return pins[spkiHash(cert)]
+	}
+	return &tls.Config{
+		// The certificate chain is verified in VerifyConnection, as a
+		// pinned leaf certificate need not be signed by a trusted CA.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("bootery presented no certificate")
+			}
+			leaf := cs.PeerCertificates[0]
+			if pinned(leaf) {
+				return nil
+			}
+			intermediates := x509.NewCertPool()
+			for _, cert := range cs.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			chains, err := leaf.Verify(x509.VerifyOptions{
+				DNSName:       cs.ServerName,
+				Intermediates: intermediates,
+			})
+			if err != nil {
+				return fmt.Errorf("bootery certificate does not match the SPKI pins and cannot be verified: %v", err)
+			}
+			for _, chain := range chains {
+				for _, cert := range chain {
+					if pinned(cert) {
+						return nil
+					}
+				}
+			}
+			hash := spkiHash(leaf)
+			return fmt.Errorf("bootery certificate chain does not match the SPKI pins (leaf: sha256/%s)", base64.StdEncoding.EncodeToString(hash[:]))
+		},
+	}
+}
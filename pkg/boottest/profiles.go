@@ -0,0 +1,93 @@
+package boottest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gokrazy/internal/config"
+)
+
+type boardProfile struct {
+	arch            string
+	kernelPackage   string
+	firmwarePackage string // empty if the board needs no firmware
+	serialConsole   string
+}
+
+var boardProfiles = map[string]boardProfile{
+	"rpi3": {
+		arch:            "arm64",
+		kernelPackage:   "github.com/gokrazy/kernel",
+		firmwarePackage: "github.com/gokrazy/firmware",
+		serialConsole:   "serial0,115200",
+	},
+	"rpi4": {
+		arch:            "arm64",
+		kernelPackage:   "github.com/gokrazy/kernel",
+		firmwarePackage: "github.com/gokrazy/firmware",
+		serialConsole:   "serial0,115200",
+	},
+	"rpi5": {
+		arch:            "arm64",
+		kernelPackage:   "github.com/gokrazy/kernel.rpi",
+		firmwarePackage: "github.com/gokrazy/firmware",
+		serialConsole:   "serial0,115200",
+	},
+	"zero2w": {
+		arch:            "arm64",
+		kernelPackage:   "github.com/gokrazy/kernel",
+		firmwarePackage: "github.com/gokrazy/firmware",
+		serialConsole:   "serial0,115200",
+	},
+	"pc": {
+		arch:          "amd64",
+		kernelPackage: "github.com/rtr7/kernel",
+		serialConsole: "ttyS0,115200",
+	},
+}
+
+// archEnv maps -arch values to the Go environment for building.
+var archEnv = map[string][]string{
+	"arm64": {"GOARCH=arm64"},
+	"armhf": {"GOARCH=arm", "GOARM=7"},
+	"amd64": {"GOARCH=amd64"},
+}
+
+// Boards returns the names of all supported board profiles.
+func Boards() []string {
+	names := make([]string, 0, len(boardProfiles))
+	for name := range boardProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyProfile configures cfg for board and returns the additional
+// environment variables for gok to select arch (which defaults to the
+// architecture of board).
+func applyProfile(cfg *config.Struct, board, arch string) ([]string, error) {
+	goarch := arch
+	if board != "" {
+		p, ok := boardProfiles[board]
+		if !ok {
+			return nil, fmt.Errorf("unknown board %q, expected one of %s", board, strings.Join(Boards(), ", "))
+		}
+		kernel, firmware := p.kernelPackage, p.firmwarePackage
+		cfg.KernelPackage = &kernel
+		cfg.FirmwarePackage = &firmware
+		cfg.SerialConsole = p.serialConsole
+		if goarch == "" {
+			goarch = p.arch
+		}
+	}
+	if goarch == "" {
+		return nil, nil
+	}
+	env, ok := archEnv[goarch]
+	if !ok {
+		return nil, fmt.Errorf("unknown arch %q, expected one of arm64, armhf, amd64", goarch)
+	}
+	return env, nil
+}
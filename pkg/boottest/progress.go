@@ -0,0 +1,109 @@
+package boottest
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// UploadStats describes one upload of an image to the bootery, e.g. to tell
+// a stalled transfer from a slow one.
+type UploadStats struct {
+	Image    string // e.g. boot.img
+	Bytes    int64  // sent so far, as the upload may have failed
+	Total    int64  // 0 if unknown, e.g. with Stream
+	Duration time.Duration
+}
+
+// progressReader counts the bytes read through it, for progress reports.
+type progressReader struct {
+	r     io.Reader
+	total int64 // 0 if unknown
+	start time.Time
+
+	// read and eof are accessed atomically, as progress reports run
+	// concurrently with the upload.
+	read int64
+	eof  int32
+
+	// lastRead and lastTime are the state of the previous progress call,
+	// so that the rate reflects the recent past, in which a transfer may
+	// have stalled.
+	lastRead int64
+	lastTime time.Time
+}
+
+func newProgressReader(r io.Reader, total int64) *progressReader {
+	now := time.Now()
+	return &progressReader{r: r, total: total, start: now, lastTime: now}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	atomic.AddInt64(&p.read, int64(n))
+	if err == io.EOF {
+		atomic.StoreInt32(&p.eof, 1)
+	}
+	return n, err
+}
+
+// progress describes how much of the image was uploaded, how fast since the
+// previous call and, if the total is known, how long the rest will take at
+// that rate.
+func (p *progressReader) progress() string {
+	read := atomic.LoadInt64(&p.read)
+	msg := fmt.Sprintf("%d MB", read>>20)
+	if p.total > 0 {
+		msg += fmt.Sprintf(" of %d MB (%d%%)", p.total>>20, read*100/p.total)
+	}
+	msg += " uploaded"
+	if atomic.LoadInt32(&p.eof) != 0 {
+		return msg + ", waiting for the bootery"
+	}
+	now := time.Now()
+	elapsed := now.Sub(p.lastTime).Seconds()
+	sent := read - p.lastRead
+	p.lastRead, p.lastTime = read, now
+	if elapsed <= 0 {
+		return msg
+	}
+	if sent == 0 {
+		return msg + ", stalled"
+	}
+	rate := float64(sent) / elapsed
+	msg += fmt.Sprintf(", %.1f MB/s", rate/(1<<20))
+	if p.total > read {
+		left := time.Duration(float64(p.total-read) / rate * float64(time.Second))
+		msg += fmt.Sprintf(", %v left", left.Round(time.Second))
+	}
+	return msg
+}
+
+// recordUpload appends the statistics of the upload of name to bt.uploads.
+func (bt *BootTester) recordUpload(name string, p *progressReader) {
+	bt.uploads = append(bt.uploads, UploadStats{
+		Image:    filepath.Base(name),
+		Bytes:    atomic.LoadInt64(&p.read),
+		Total:    p.total,
+		Duration: time.Since(p.start),
+	})
+}
+
+// Uploads returns the statistics of the image uploads of the most recent Test
+// or Upload, successful or not.
+func (bt *BootTester) Uploads() []UploadStats {
+	return bt.uploads
+}
+
+// uploadProgress logs the progress of the upload of name to hostname every
+// Options.ProgressInterval (or Options.KeepAlive) until the returned function
+// is called.
+func (bt *BootTester) uploadProgress(name, hostname string, p *progressReader) (stop func()) {
+	interval := bt.opts.ProgressInterval
+	if interval == 0 {
+		interval = bt.opts.KeepAlive
+	}
+	return bt.keepAliveEvery(interval, "uploading "+filepath.Base(name)+" to "+hostname, p.progress)
+}
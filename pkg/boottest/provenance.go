@@ -0,0 +1,169 @@
+package boottest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ProvenanceBuildType identifies how gokr-boot builds images in provenance.
+const ProvenanceBuildType = "https://github.com/gokrazy/autoupdate/gokr-boot@v1"
+
+// ProvenanceSource describes what images were built from, and by whom.
+type ProvenanceSource struct {
+	Repository   string // e.g. https://github.com/gokrazy/kernel
+	Commit       string
+	Ref          string // e.g. refs/pull/12/head, if known
+	BuilderID    string // e.g. https://github.com/gokrazy/autoupdate/cmd/gokr-boot
+	InvocationID string // e.g. the URL of the CI run, if known
+}
+
+// The subset of in-toto statements (https://in-toto.io/Statement/v1) and SLSA
+// provenance (https://slsa.dev/provenance/v1) which Provenance emits.
+type (
+	intotoSubject struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	}
+
+	slsaDependency struct {
+		URI         string            `json:"uri"`
+		Digest      map[string]string `json:"digest,omitempty"`
+		Annotations map[string]string `json:"annotations,omitempty"`
+	}
+
+	slsaProvenance struct {
+		BuildDefinition struct {
+			BuildType            string            `json:"buildType"`
+			ExternalParameters   map[string]string `json:"externalParameters"`
+			InternalParameters   map[string]string `json:"internalParameters,omitempty"`
+			ResolvedDependencies []slsaDependency  `json:"resolvedDependencies"`
+		} `json:"buildDefinition"`
+		RunDetails struct {
+			Builder struct {
+				ID string `json:"id"`
+			} `json:"builder"`
+			Metadata struct {
+				InvocationID string `json:"invocationId,omitempty"`
+				FinishedOn   string `json:"finishedOn"`
+			} `json:"metadata"`
+		} `json:"runDetails"`
+	}
+
+	intotoStatement struct {
+		Type          string          `json:"_type"`
+		Subject       []intotoSubject `json:"subject"`
+		PredicateType string          `json:"predicateType"`
+		Predicate     slsaProvenance  `json:"predicate"`
+	}
+)
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// imageDigests returns the hex-encoded SHA-256 digests of the images (file
+// name → path), by file name.
+func imageDigests(images map[string]string) (map[string]string, error) {
+	digests := make(map[string]string)
+	for name, path := range images {
+		digest, err := fileDigest(path)
+		if err != nil {
+			return nil, err
+		}
+		digests[name] = digest
+	}
+	return digests, nil
+}
+
+// ImageDigests returns the hex-encoded SHA-256 digests of the images which
+// Build wrote into dir, by file name (boot.img and root.img).
+func ImageDigests(dir string) (map[string]string, error) {
+	return imageDigests(map[string]string{
+		"boot.img": filepath.Join(dir, "boot.img"),
+		"root.img": filepath.Join(dir, "root.img"),
+	})
+}
+
+// Provenance returns an in-toto statement (JSON) with SLSA provenance for the
+// images of hostname with the specified digests (see Result.Digests and
+// ImageDigests), built from src. The resolved dependencies are the source
+// commit and the Go modules pinned in the instance’s builddir, annotated with
+// their gokrazy:role (kernel, firmware, eeprom). Call it after building, like
+// SBOM.
+func (bt *BootTester) Provenance(hostname string, digests map[string]string, src ProvenanceSource) ([]byte, error) {
+	modules, err := builddirModules()
+	if err != nil {
+		return nil, err
+	}
+
+	st := intotoStatement{
+		Type:          "https://in-toto.io/Statement/v1",
+		PredicateType: "https://slsa.dev/provenance/v1",
+	}
+	var names []string
+	for name := range digests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		st.Subject = append(st.Subject, intotoSubject{
+			Name:   name,
+			Digest: map[string]string{"sha256": digests[name]},
+		})
+	}
+
+	def := &st.Predicate.BuildDefinition
+	def.BuildType = ProvenanceBuildType
+	def.ExternalParameters = map[string]string{
+		"hostname":   hostname,
+		"repository": src.Repository,
+	}
+	if src.Ref != "" {
+		def.ExternalParameters["ref"] = src.Ref
+	}
+	def.InternalParameters = make(map[string]string)
+	for key, val := range map[string]string{
+		"board":         bt.opts.Board,
+		"arch":          bt.opts.Arch,
+		"kernel":        bt.kernels[hostname],
+		"cmdline_extra": bt.opts.CmdlineExtra,
+	} {
+		if val != "" {
+			def.InternalParameters[key] = val
+		}
+	}
+	def.ResolvedDependencies = append(def.ResolvedDependencies, slsaDependency{
+		URI:    "git+" + src.Repository,
+		Digest: map[string]string{"gitCommit": src.Commit},
+	})
+	for _, m := range modules {
+		dep := slsaDependency{URI: m.PURL}
+		for _, p := range m.Properties {
+			if p.Name == "gokrazy:role" {
+				dep.Annotations = map[string]string{"gokrazy:role": p.Value}
+			}
+		}
+		def.ResolvedDependencies = append(def.ResolvedDependencies, dep)
+	}
+
+	run := &st.Predicate.RunDetails
+	run.Builder.ID = src.BuilderID
+	run.Metadata.InvocationID = src.InvocationID
+	run.Metadata.FinishedOn = time.Now().UTC().Format(time.RFC3339)
+	return json.MarshalIndent(&st, "", "\t")
+}
@@ -0,0 +1,57 @@
+package boottest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Publish builds the full image of hostname as gokrazy archive (GAF) and
+// pushes it to the GUS (gokrazy Update System) server at server with gok
+// push, so that devices updating from GUS receive it. GUS identifies the build
+// by the SBOM contained in the archive. Unlike Test and Build, Publish ignores
+// KernelDir, FirmwareDir and CmdlineExtra: only pinned versions are published.
+func (bt *BootTester) Publish(ctx context.Context, hostname, server string) (err error) {
+	_, env, err := bt.prepareConfig(ctx, hostname)
+	if err != nil {
+		return err
+	}
+	gaf, err := ioutil.TempFile("", "gokr-gaf")
+	if err != nil {
+		return err
+	}
+	gaf.Close()
+	defer os.Remove(gaf.Name())
+
+	start := time.Now()
+	gok := bt.overwriteCmd(ctx, env, "--gaf="+gaf.Name())
+	flush := redactOutput(gok, nil)
+	stop := bt.keepAlive("building archive for "+hostname, nil)
+	err = gok.Run()
+	stop()
+	flush()
+	if err != nil {
+		err = &BuildError{Err: fmt.Errorf("%v: %v", gok.Args, err)}
+	}
+	bt.phase(hostname, PhaseBuild, start, err)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("pushing %s to %s", hostname, server)
+	push := exec.CommandContext(ctx, "gok", "push",
+		"--gaf="+gaf.Name(),
+		"--server="+server)
+	push.Env = append(os.Environ(), env...)
+	flush = redactOutput(push, nil)
+	defer flush()
+	defer bt.keepAlive("pushing "+hostname+" to GUS", nil)()
+	if err := push.Run(); err != nil {
+		return fmt.Errorf("%v: %v", push.Args, err)
+	}
+	return nil
+}
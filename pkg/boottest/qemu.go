@@ -0,0 +1,199 @@
+package boottest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gokrazy/internal/config"
+)
+
+// qemuDiskBytes is the size of the disk image booted in QEMU.
+const qemuDiskBytes = 2 << 30
+
+// qemuHostname is the hostname under which the QEMU backend boots images. As
+// there are no bakeries, UseBakeries returns it as the only host.
+const qemuHostname = "gokr-boot-qemu"
+
+// qemuAMD64 returns whether env selects the amd64 architecture. Everything
+// else boots on the aarch64 virt machine.
+func qemuAMD64(env []string) bool {
+	for _, kv := range env {
+		if kv == "GOARCH=amd64" {
+			return true
+		}
+	}
+	return false
+}
+
+// qemuSerialConsole returns the serial console of the QEMU machine for env.
+func qemuSerialConsole(env []string) string {
+	if qemuAMD64(env) {
+		return "ttyS0,115200"
+	}
+	return "ttyAMA0,115200"
+}
+
+// qemuMachine returns the QEMU arguments selecting the machine for env.
+func qemuMachine(env []string) []string {
+	if qemuAMD64(env) {
+		return []string{"-M", "q35", "-smp", "2"}
+	}
+	return []string{"-M", "virt", "-cpu", "cortex-a72", "-smp", "2"}
+}
+
+// freePort returns a TCP port on localhost which is currently unused.
+func freePort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+// syncBuffer is a bytes.Buffer which QEMU writes the serial console to while
+// qemuBoot reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// qemuBoot builds a full disk image for hostname and boots it in QEMU. Like
+// the bootery, it considers the boot successful once the gokrazy web
+// interface answers. The serial console output is returned as boot log.
+func (bt *BootTester) qemuBoot(ctx context.Context, hostname string) (_ string, err error) {
+	_, env, err := bt.prepareConfig(ctx, hostname)
+	if err != nil {
+		return "", err
+	}
+	cfg, err := config.ReadFromFile()
+	if err != nil {
+		return "", err
+	}
+	password, guestPort := "", "80"
+	if cfg.Update != nil {
+		password = cfg.Update.HTTPPassword
+		if cfg.Update.HTTPPort != "" {
+			guestPort = cfg.Update.HTTPPort
+		}
+	}
+
+	disk, err := ioutil.TempFile("", "gokr-qemu")
+	if err != nil {
+		return "", err
+	}
+	disk.Close()
+	defer os.Remove(disk.Name())
+
+	var output bytes.Buffer
+	defer func() {
+		if err != nil && bt.opts.ArtifactDir != "" {
+			bt.saveArtifacts(hostname, map[string]string{"disk.img": disk.Name()}, output.Bytes())
+		}
+	}()
+	gok := bt.overwriteCmd(ctx, env,
+		"--full="+disk.Name(),
+		"--target_storage_bytes="+strconv.Itoa(qemuDiskBytes))
+	flush := redactOutput(gok, &output)
+	stop := bt.keepAlive("building disk image for "+hostname, nil)
+	start := time.Now()
+	err = gok.Run()
+	stop()
+	flush()
+	if err != nil {
+		err = &BuildError{
+			Output: output.String(),
+			Err:    fmt.Errorf("%v: %v", gok.Args, err),
+		}
+	}
+	bt.phase(hostname, PhaseBuild, start, err)
+	if err != nil {
+		return "", err
+	}
+	start = time.Now()
+	defer func() { bt.phase(hostname, PhaseBoot, start, err) }()
+
+	port, err := freePort()
+	if err != nil {
+		return "", err
+	}
+	args := append(qemuMachine(env),
+		"-m", "1024",
+		"-nographic",
+		"-drive", "file="+disk.Name()+",format=raw,if=virtio",
+		"-netdev", fmt.Sprintf("user,id=net0,hostfwd=tcp:127.0.0.1:%d-:%s", port, guestPort),
+		"-device", "virtio-net-pci,netdev=net0")
+	if bt.opts.QEMUFirmware != "" {
+		args = append(args, "-bios", bt.opts.QEMUFirmware)
+	}
+	qctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var serial syncBuffer
+	qemu := exec.CommandContext(qctx, bt.opts.QEMU, args...)
+	qemu.Stdout = &serial
+	qemu.Stderr = &serial
+	log.Printf("booting %s in %s", disk.Name(), bt.opts.QEMU)
+	if err := qemu.Start(); err != nil {
+		return "", err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- qemu.Wait() }()
+	defer func() {
+		cancel()
+		<-exited
+	}()
+
+	defer bt.keepAlive("waiting for "+hostname+" to boot in QEMU", nil)()
+	u := fmt.Sprintf("http://127.0.0.1:%d/", port)
+	client := &http.Client{Timeout: 10 * time.Second}
+	deadline := time.Now().Add(bt.opts.QEMUBootTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case err := <-exited:
+			return "", &BootError{Err: fmt.Errorf("%s exited before the boot finished: %v\n%s", bt.opts.QEMU, err, serial.String())}
+		case <-time.After(5 * time.Second):
+		}
+		if strings.Contains(serial.String(), "Kernel panic") {
+			return "", &BootError{Err: fmt.Errorf("kernel panic\n%s", serial.String())}
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return "", err
+		}
+		req.SetBasicAuth("gokrazy", password)
+		resp, err := client.Do(req)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return serial.String(), nil
+		}
+	}
+	return "", &BootError{Err: fmt.Errorf("gokrazy web interface did not come up within %v\n%s", bt.opts.QEMUBootTimeout, serial.String())}
+}
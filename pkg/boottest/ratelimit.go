@@ -0,0 +1,67 @@
+package boottest
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// rateLimitedReader limits reads from r to rate bytes per second with a token
+// bucket, which holds at most burst bytes (a tenth of a second’s worth), so
+// that uploads do not saturate slow uplinks.
+type rateLimitedReader struct {
+	ctx    context.Context
+	r      io.Reader
+	rate   float64 // bytes per second
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// limitUpload returns r limited to Options.UploadRateLimit, if set. Waiting
+// for tokens is interrupted when ctx is cancelled.
+func (bt *BootTester) limitUpload(ctx context.Context, r io.Reader) io.Reader {
+	if bt.opts.UploadRateLimit <= 0 {
+		return r
+	}
+	burst := int(bt.opts.UploadRateLimit / 10)
+	if burst < 1024 {
+		burst = 1024
+	}
+	return &rateLimitedReader{
+		ctx:    ctx,
+		r:      r,
+		rate:   float64(bt.opts.UploadRateLimit),
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (l *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > l.burst {
+		p = p[:l.burst]
+	}
+	n, err := l.r.Read(p)
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return n, err
+	}
+	// Pay off the debt before returning the data, so that the consumer
+	// (i.e. the HTTP transport) never sends faster than rate.
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-l.ctx.Done():
+		return n, l.ctx.Err()
+	}
+	return n, err
+}
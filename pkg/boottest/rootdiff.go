@@ -0,0 +1,187 @@
+package boottest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/renameio/v2"
+)
+
+// RootFile is an entry of the file listing of a root image.
+type RootFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"` // empty for directories
+	Link   string `json:"link,omitempty"`   // target of symbolic links
+}
+
+// RootManifest returns the file listing of the squashfs root image at img,
+// sorted by path. It requires unsquashfs from squashfs-tools.
+func RootManifest(ctx context.Context, img string) ([]RootFile, error) {
+	dir, err := ioutil.TempDir("", "gokr-rootfs")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	extracted := filepath.Join(dir, "root")
+	// Device nodes cannot be created without privileges, which
+	// -ignore-errors skips. They are not interesting for the diff anyway.
+	cmd := exec.CommandContext(ctx, "unsquashfs",
+		"-no-progress",
+		"-no-xattrs",
+		"-ignore-errors",
+		"-d", extracted,
+		img)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%v: %v\n%s", cmd.Args, err, out)
+	}
+	var files []RootFile
+	err = filepath.WalkDir(extracted, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := "/" + strings.TrimPrefix(strings.TrimPrefix(path, extracted), "/")
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f := RootFile{Path: rel}
+		switch {
+		case d.IsDir():
+		case info.Mode()&fs.ModeSymlink != 0:
+			f.Link, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			f.Size = info.Size()
+			f.SHA256, err = fileSHA256(path)
+			if err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ReadRootManifest reads a file listing written by WriteRootManifest.
+func ReadRootManifest(path string) ([]RootFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []RootFile
+	if err := json.Unmarshal(b, &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// WriteRootManifest atomically writes files to path as JSON.
+func WriteRootManifest(path string, files []RootFile) error {
+	b, err := json.MarshalIndent(files, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return renameio.WriteFile(path, b, 0644)
+}
+
+// RootDiff is the difference between the file listings of two root images.
+type RootDiff struct {
+	Added, Removed, Changed []RootFile // Changed holds the new entries
+	SizeDelta               int64      // in bytes, over all regular files
+}
+
+// DiffRoot compares the file listings of an old and a new root image.
+func DiffRoot(old, new []RootFile) *RootDiff {
+	var d RootDiff
+	oldByPath := make(map[string]RootFile, len(old))
+	for _, f := range old {
+		oldByPath[f.Path] = f
+		d.SizeDelta -= f.Size
+	}
+	for _, f := range new {
+		d.SizeDelta += f.Size
+		o, ok := oldByPath[f.Path]
+		if !ok {
+			d.Added = append(d.Added, f)
+			continue
+		}
+		delete(oldByPath, f.Path)
+		if o != f {
+			d.Changed = append(d.Changed, f)
+		}
+	}
+	for _, f := range old {
+		if _, ok := oldByPath[f.Path]; ok {
+			d.Removed = append(d.Removed, f)
+		}
+	}
+	return &d
+}
+
+// Markdown returns a summary of d for a pull request comment, with the file
+// lists collapsed.
+func (d *RootDiff) Markdown(title string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<details>\n<summary>%s: %d added, %d removed, %d changed, %+d bytes</summary>\n\n",
+		title, len(d.Added), len(d.Removed), len(d.Changed), d.SizeDelta)
+	if len(d.Added)+len(d.Removed)+len(d.Changed) == 0 {
+		b.WriteString("No changes.\n")
+	}
+	for _, section := range []struct {
+		prefix string
+		files  []RootFile
+	}{
+		{"+", d.Added},
+		{"-", d.Removed},
+		{"~", d.Changed},
+	} {
+		for _, f := range section.files {
+			fmt.Fprintf(&b, "    %s %s", section.prefix, f.Path)
+			if f.Link != "" {
+				fmt.Fprintf(&b, " -> %s", f.Link)
+			} else if f.SHA256 != "" {
+				fmt.Fprintf(&b, " (%d bytes)", f.Size)
+			}
+			b.WriteString("\n")
+		}
+	}
+	b.WriteString("\n</details>\n")
+	return b.String()
+}
@@ -0,0 +1,174 @@
+package boottest
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/internal/config"
+	"golang.org/x/mod/modfile"
+)
+
+// The subset of CycloneDX 1.5 (https://cyclonedx.org/docs/1.5/json/) which
+// SBOM emits.
+type (
+	cdxProperty struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+
+	cdxComponent struct {
+		Type       string        `json:"type"`
+		BOMRef     string        `json:"bom-ref,omitempty"`
+		Name       string        `json:"name"`
+		Version    string        `json:"version,omitempty"`
+		PURL       string        `json:"purl,omitempty"`
+		Properties []cdxProperty `json:"properties,omitempty"`
+	}
+
+	cdxBOM struct {
+		BOMFormat    string `json:"bomFormat"`
+		SpecVersion  string `json:"specVersion"`
+		SerialNumber string `json:"serialNumber"`
+		Version      int    `json:"version"`
+		Metadata     struct {
+			Timestamp string `json:"timestamp"`
+			Tools     []struct {
+				Name string `json:"name"`
+			} `json:"tools"`
+			Component cdxComponent `json:"component"`
+		} `json:"metadata"`
+		Components []cdxComponent `json:"components"`
+	}
+)
+
+func uuidV4() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// SBOM returns a CycloneDX SBOM (JSON) of the gokrazy instance for hostname:
+// the Go modules pinned in the instance’s builddir, with the modules
+// providing the kernel, firmware and EEPROM marked by a gokrazy:role
+// property. Call it after building, so that the builddir is populated.
+func SBOM(hostname string) ([]byte, error) {
+	components, err := builddirModules()
+	if err != nil {
+		return nil, err
+	}
+
+	var bom cdxBOM
+	bom.BOMFormat = "CycloneDX"
+	bom.SpecVersion = "1.5"
+	serial, err := uuidV4()
+	if err != nil {
+		return nil, err
+	}
+	bom.SerialNumber = "urn:uuid:" + serial
+	bom.Version = 1
+	bom.Metadata.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	bom.Metadata.Tools = append(bom.Metadata.Tools, struct {
+		Name string `json:"name"`
+	}{"gokr-boot"})
+	bom.Metadata.Component = cdxComponent{
+		Type: "operating-system",
+		Name: "gokrazy instance " + hostname,
+	}
+	bom.Components = components
+	return json.MarshalIndent(&bom, "", "\t")
+}
+
+// builddirModules returns the Go modules pinned in the instance’s builddir,
+// sorted by bom-ref, with the gokrazy:role property for the modules providing
+// the kernel, firmware and EEPROM.
+func builddirModules() ([]cdxComponent, error) {
+	cfg, err := config.ReadFromFile()
+	if err != nil {
+		return nil, err
+	}
+	roles := make(map[string]string) // package → role
+	for role, pkg := range map[string]*string{
+		"kernel":   cfg.KernelPackage,
+		"firmware": cfg.FirmwarePackage,
+		"eeprom":   cfg.EEPROMPackage,
+	} {
+		if pkg != nil && *pkg != "" {
+			roles[*pkg] = role
+		}
+	}
+
+	builddir := filepath.Join(filepath.Dir(config.InstanceConfigPath()), "builddir")
+	components := make(map[string]*cdxComponent) // by bom-ref
+	err = filepath.WalkDir(builddir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.Name() != "go.mod" {
+			return nil
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		f, err := modfile.Parse(path, b, nil)
+		if err != nil {
+			return err
+		}
+		// Each builddir subdirectory is named after the package it builds.
+		pkg := filepath.ToSlash(strings.TrimPrefix(filepath.Dir(path), builddir+string(filepath.Separator)))
+		replaced := make(map[string]string)
+		for _, r := range f.Replace {
+			replaced[r.Old.Path] = r.New.Path
+			if r.New.Version != "" {
+				replaced[r.Old.Path] += "@" + r.New.Version
+			}
+		}
+		for _, req := range f.Require {
+			purl := "pkg:golang/" + req.Mod.Path + "@" + req.Mod.Version
+			c, ok := components[purl]
+			if !ok {
+				c = &cdxComponent{
+					Type:    "library",
+					BOMRef:  purl,
+					Name:    req.Mod.Path,
+					Version: req.Mod.Version,
+					PURL:    purl,
+				}
+				if r, ok := replaced[req.Mod.Path]; ok {
+					c.Properties = append(c.Properties, cdxProperty{"gokrazy:replaced-by", r})
+				}
+				components[purl] = c
+			}
+			if role, ok := roles[pkg]; ok && (pkg == req.Mod.Path || strings.HasPrefix(pkg, req.Mod.Path+"/")) {
+				c.Properties = append(c.Properties, cdxProperty{"gokrazy:role", role})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var result []cdxComponent
+	for _, c := range components {
+		result = append(result, *c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].BOMRef < result[j].BOMRef
+	})
+	return result, nil
+}
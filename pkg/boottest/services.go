@@ -0,0 +1,113 @@
+package boottest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gokrazy/internal/config"
+)
+
+// serviceStatus is the subset of a service entry in the gokrazy status API
+// (requested with Accept: application/json) which gokr-boot looks at.
+type serviceStatus struct {
+	Path    string    `json:"Path"`
+	Stopped bool      `json:"Stopped"`
+	Started time.Time `json:"Started"`
+}
+
+func fetchServices(ctx context.Context, hostname string) ([]serviceStatus, error) {
+	cfg, err := config.ReadFromFile()
+	if err != nil {
+		return nil, err
+	}
+	var password, port string
+	if cfg.Update != nil {
+		password = cfg.Update.HTTPPassword
+		port = cfg.Update.HTTPPort
+	}
+	host := hostname
+	if port != "" && port != "80" {
+		host += ":" + port
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth("gokrazy", password)
+	resp, err := (&http.Client{Timeout: 30 * time.Second}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected HTTP status code: got %d (%s), want %d", got, strings.TrimSpace(string(b)), want)
+	}
+	var status struct {
+		Services []serviceStatus `json:"Services"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+	return status.Services, nil
+}
+
+// checkServices verifies that every package of the instance is running and has
+// been running for at least settle. It returns a Markdown summary of the
+// service states for the pull request comment.
+func checkServices(ctx context.Context, hostname string, settle time.Duration) (string, error) {
+	cfg, err := config.ReadFromFile()
+	if err != nil {
+		return "", err
+	}
+	log.Printf("waiting %v for services on %s to settle", settle, hostname)
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-time.After(settle):
+	}
+	services, err := fetchServices(ctx, hostname)
+	if err != nil {
+		return "", fmt.Errorf("querying gokrazy status API: %v", err)
+	}
+	byName := make(map[string]serviceStatus)
+	for _, svc := range services {
+		byName[path.Base(svc.Path)] = svc
+	}
+	var (
+		summary strings.Builder
+		failed  []string
+	)
+	fmt.Fprintf(&summary, "| service | state |\n|---|---|\n")
+	for _, pkg := range cfg.Packages {
+		name := path.Base(pkg)
+		svc, ok := byName[name]
+		var state string
+		switch {
+		case !ok:
+			state = "missing"
+		case svc.Stopped:
+			state = "stopped"
+		case time.Since(svc.Started) < settle:
+			state = "restarted (crash-looping?)"
+		default:
+			state = "running"
+		}
+		if state != "running" {
+			failed = append(failed, name+": "+state)
+		}
+		fmt.Fprintf(&summary, "| %s | %s |\n", name, state)
+	}
+	if len(failed) > 0 {
+		return summary.String(), fmt.Errorf("services not healthy on %s: %s", hostname, strings.Join(failed, ", "))
+	}
+	return summary.String(), nil
+}
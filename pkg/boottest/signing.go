@@ -0,0 +1,40 @@
+package boottest
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"os"
+)
+
+// imageSignatureHeader carries the base64-encoded ed25519 signature of
+// signatureMessage. Like imageChecksumHeader, it is sent as an HTTP trailer.
+const imageSignatureHeader = "X-Image-Signature"
+
+// LoadSigningKey reads a base64-encoded ed25519 private key (or seed) from the
+// environment variable env, for use as Options.SigningKey.
+func LoadSigningKey(env string) (ed25519.PrivateKey, error) {
+	val := os.Getenv(env)
+	if val == "" {
+		return nil, fmt.Errorf("required environment variable %s empty", env)
+	}
+	b, err := base64.StdEncoding.DecodeString(val)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", env, err)
+	}
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	default:
+		return nil, fmt.Errorf("%s: unexpected key length: got %d, want %d or %d", env, len(b), ed25519.SeedSize, ed25519.PrivateKeySize)
+	}
+}
+
+// signatureMessage returns the message which is signed for an image with the
+// specified hex-encoded SHA-256 checksum. Signing the checksum instead of the
+// image allows signing while streaming.
+func signatureMessage(sum string) []byte {
+	return []byte("gokrazy-image-sha256:" + sum)
+}
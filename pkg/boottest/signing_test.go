@@ -0,0 +1,99 @@
+package boottest
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoadSigningKey(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		val     string
+		wantErr string
+	}{
+		{name: "seed", val: base64.StdEncoding.EncodeToString(key.Seed())},
+		{name: "private key", val: base64.StdEncoding.EncodeToString(key)},
+		{name: "empty", val: "", wantErr: "empty"},
+		{name: "not base64", val: "!", wantErr: "decoding"},
+		{name: "short", val: base64.StdEncoding.EncodeToString(key[:16]), wantErr: "unexpected key length"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GOKR_TEST_SIGNING_KEY", tt.val)
+			got, err := LoadSigningKey("GOKR_TEST_SIGNING_KEY")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadSigningKey: got error %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(key) {
+				t.Errorf("LoadSigningKey returned a different key")
+			}
+		})
+	}
+}
+
+func TestSignatureTrailer(t *testing.T) {
+	fn, sum := writeImage(t, 1<<20)
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		key  ed25519.PrivateKey
+	}{
+		{name: "signed", key: key},
+		{name: "unsigned"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var signature string
+			var declared bool
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, declared = r.Trailer[http.CanonicalHeaderKey(imageSignatureHeader)]
+				io.Copy(ioutil.Discard, r.Body) // populates r.Trailer
+				signature = r.Trailer.Get(imageSignatureHeader)
+				w.Header().Set(imageChecksumHeader, r.Trailer.Get(imageChecksumHeader))
+			}))
+			defer srv.Close()
+			bt, err := New(Options{
+				BooteryURL: srv.URL + "/testboot",
+				SigningKey: tt.key,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := bt.streamTo(context.Background(), fn, srv.URL+"/testboot1", "pi5", ""); err != nil {
+				t.Fatal(err)
+			}
+			if tt.key == nil {
+				if declared || signature != "" {
+					t.Fatalf("%s trailer sent without signing key: %q", imageSignatureHeader, signature)
+				}
+				return
+			}
+			sig, err := base64.StdEncoding.DecodeString(signature)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ed25519.Verify(pub, signatureMessage(sum), sig) {
+				t.Errorf("%s trailer %q does not verify for SHA-256 %s", imageSignatureHeader, signature, sum)
+			}
+		})
+	}
+}
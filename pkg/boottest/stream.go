@@ -0,0 +1,86 @@
+package boottest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/gokrazy/autoupdate/internal/redact"
+)
+
+// packAndStream runs gok to build the specified partition (boot or root) and
+// hands the image to upload while it is being written. gok writes into a pipe
+// which is passed as file descriptor 3, so that its regular output is not
+// mixed into the image, but into output.
+func (bt *BootTester) packAndStream(ctx context.Context, partition string, env []string, output *bytes.Buffer, upload func(io.Reader) (string, error)) (string, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	cmd := bt.overwriteCmd(ctx, env, "--"+partition+"=/dev/fd/3")
+	cmd.ExtraFiles = []*os.File{w}
+	flush := redactOutput(cmd, output)
+	defer flush()
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return "", err
+	}
+	// Close our copy of the write end so that the upload sees EOF once gok
+	// exits.
+	w.Close()
+	reply, uploadErr := upload(r)
+	if uploadErr != nil {
+		// Do not leave gok blocked on a pipe nobody reads from.
+		cmd.Process.Kill()
+	}
+	if err := cmd.Wait(); err != nil && uploadErr == nil {
+		// The upload might have succeeded with a truncated image, which is
+		// caught by the bootery’s checksum verification, but the build
+		// failure is the more useful error.
+		flush()
+		return "", &BuildError{
+			Output: output.String(),
+			Err:    fmt.Errorf("%v: %v", cmd.Args, err),
+		}
+	}
+	return reply, uploadErr
+}
+
+// streamBoot1 is the Options.Stream variant of bootFromFiles.
+func (bt *BootTester) streamBoot1(ctx context.Context, hostname, newer string) (_ string, err error) {
+	log.Printf("streaming images for %s", hostname)
+	var output bytes.Buffer
+	defer func() {
+		// The images were never written to disk, so only the gok output
+		// can be saved.
+		if err != nil && bt.opts.ArtifactDir != "" {
+			bt.saveArtifacts(hostname, nil, output.Bytes())
+		}
+	}()
+	_, env, err := bt.prepareConfig(ctx, hostname)
+	if err != nil {
+		return "", err
+	}
+	if bt.opts.UpdateRoot {
+		log.Printf("updating root file system")
+		_, err := bt.packAndStream(ctx, "root", env, &output, func(r io.Reader) (string, error) {
+			return bt.streamFrom(ctx, r, 0, "root", bt.base+"/updateroot", hostname, "")
+		})
+		if err != nil {
+			return "", redact.Error(err)
+		}
+	}
+	log.Printf("testing boot file system")
+	bootlog, err := bt.packAndStream(ctx, "boot", env, &output, func(r io.Reader) (string, error) {
+		bootlog, err := bt.streamFrom(ctx, r, 0, "boot", bt.testBootURL(), hostname, newer)
+		return bootlog, bootFailed(err)
+	})
+	if err != nil {
+		return "", redact.Error(err)
+	}
+	return bootlog, nil
+}
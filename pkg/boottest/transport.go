@@ -0,0 +1,149 @@
+package boottest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// parseBooteryURL parses the bootery URL, explaining the most common mistake
+// with IPv6-only booteries: IPv6 literals without brackets.
+func parseBooteryURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("bootery URL %q: unexpected scheme %q, want http or https", s, u.Scheme)
+	}
+	if strings.Count(u.Host, ":") > 1 && !strings.HasPrefix(u.Host, "[") {
+		return nil, fmt.Errorf("bootery URL %q: IPv6 addresses must be enclosed in brackets, e.g. http://[2001:db8::1]:8037/testboot", s)
+	}
+	return u, nil
+}
+
+// familyDialer dials with happy eyeballs (RFC 6555, as implemented by
+// net.Dialer) or, if network is set (tcp4 or tcp6), only with that address
+// family. Errors mention which address families the host has, as a failed
+// connection to an IPv6-only host from an IPv4-only network (or vice versa)
+// otherwise just reports an unreachable network or no suitable address.
+type familyDialer struct {
+	dialer  *net.Dialer
+	network string
+}
+
+func (d *familyDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.network != "" && network == "tcp" {
+		network = d.network
+	}
+	conn, err := d.dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, d.explain(ctx, network, addr, err)
+	}
+	return conn, nil
+}
+
+func (d *familyDialer) explain(ctx context.Context, network, addr string, err error) error {
+	host, _, serr := net.SplitHostPort(addr)
+	if serr != nil || ctx.Err() != nil {
+		return err
+	}
+	addrs, lerr := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if lerr != nil {
+		return err
+	}
+	var v4, v6 int
+	for _, a := range addrs {
+		if a.IP.To4() != nil {
+			v4++
+		} else {
+			v6++
+		}
+	}
+	switch {
+	case network == "tcp4" && v4 == 0:
+		return fmt.Errorf("%s has no IPv4 address, only IPv6 addresses, but IPv4 is forced: %v", host, err)
+	case network == "tcp6" && v6 == 0:
+		return fmt.Errorf("%s has no IPv6 address, only IPv4 addresses, but IPv6 is forced: %v", host, err)
+	case network == "tcp4" || (v4 > 0 && v6 == 0):
+		return fmt.Errorf("%s is unreachable over IPv4 (does this network have IPv4 connectivity?): %v", host, err)
+	case network == "tcp6" || (v6 > 0 && v4 == 0):
+		return fmt.Errorf("%s is unreachable over IPv6 (does this network have IPv6 connectivity?): %v", host, err)
+	}
+	return err
+}
+
+// booteryClient returns the HTTP client for requests to the bootery, which
+// uses Options.BooteryProxy or, by default, the proxy configured in the
+// environment (HTTPS_PROXY, HTTP_PROXY and NO_PROXY), and applies the
+// timeouts and SPKI pins of opts.
+func booteryClient(opts Options) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if opts.BooteryProxy != "" {
+		u, err := url.Parse(opts.BooteryProxy)
+		if err != nil {
+			return nil, fmt.Errorf("BooteryProxy: %v", err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("BooteryProxy: %q is not an absolute URL, e.g. http://proxy.example.net:3128", opts.BooteryProxy)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	dialer := &familyDialer{
+		dialer: &net.Dialer{
+			Timeout:   30 * time.Second, // like http.DefaultTransport
+			KeepAlive: 30 * time.Second,
+		},
+	}
+	if opts.DialTimeout != 0 {
+		dialer.dialer.Timeout = opts.DialTimeout
+	}
+	switch opts.ForceIPFamily {
+	case "":
+	case "4":
+		dialer.network = "tcp4"
+	case "6":
+		dialer.network = "tcp6"
+	default:
+		return nil, fmt.Errorf("ForceIPFamily: unexpected value %q, want 4 or 6", opts.ForceIPFamily)
+	}
+	transport.DialContext = dialer.DialContext
+	if opts.TLSHandshakeTimeout != 0 {
+		transport.TLSHandshakeTimeout = opts.TLSHandshakeTimeout
+	}
+	transport.ResponseHeaderTimeout = opts.ResponseHeaderTimeout
+	if len(opts.BooterySPKIPins) > 0 {
+		pins, err := parseSPKIPins(opts.BooterySPKIPins)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = pinnedTLSConfig(pins)
+	}
+	if opts.BooteryURL != "" {
+		u, err := parseBooteryURL(opts.BooteryURL)
+		if err != nil {
+			return nil, err
+		}
+		if ip := net.ParseIP(u.Hostname()); ip != nil && opts.ForceIPFamily != "" {
+			if is4 := ip.To4() != nil; is4 != (opts.ForceIPFamily == "4") {
+				return nil, fmt.Errorf("bootery URL %q: address %s contradicts ForceIPFamily %s", opts.BooteryURL, ip, opts.ForceIPFamily)
+			}
+		}
+		// Make proxy misconfigurations visible, as the resulting errors
+		// (e.g. timeouts) do not mention the proxy.
+		proxy, err := transport.Proxy(&http.Request{URL: u})
+		if err != nil {
+			return nil, fmt.Errorf("determining the proxy for the bootery: %v", err)
+		}
+		if proxy != nil {
+			log.Printf("connecting to the bootery through proxy %s", proxy.Redacted())
+		}
+	}
+	return &http.Client{Transport: transport}, nil
+}
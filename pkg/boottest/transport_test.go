@@ -0,0 +1,101 @@
+package boottest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseBooteryURL(t *testing.T) {
+	for _, tt := range []struct {
+		url      string
+		wantHost string
+		wantErr  string
+	}{
+		{url: "http://bakery:8037/testboot", wantHost: "bakery"},
+		{url: "https://[2001:db8::1]:8037/testboot", wantHost: "2001:db8::1"},
+		{url: "http://[2001:db8::1]/testboot", wantHost: "2001:db8::1"},
+		{url: "http://2001:db8::1:8037/testboot", wantErr: "must be enclosed in brackets"},
+		{url: "bakery:8037/testboot", wantErr: `unexpected scheme "bakery"`},
+		{url: "ftp://bakery/testboot", wantErr: `unexpected scheme "ftp"`},
+	} {
+		u, err := parseBooteryURL(tt.url)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseBooteryURL(%q): got error %v, want error containing %q", tt.url, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseBooteryURL(%q): %v", tt.url, err)
+			continue
+		}
+		if got := u.Hostname(); got != tt.wantHost {
+			t.Errorf("parseBooteryURL(%q).Hostname() = %q, want %q", tt.url, got, tt.wantHost)
+		}
+	}
+}
+
+func TestFamilyDialerExplain(t *testing.T) {
+	dialErr := errors.New("connect: network is unreachable")
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, tt := range []struct {
+		ctx     context.Context
+		network string
+		addr    string
+		want    string // empty for the unchanged error
+	}{
+		{network: "tcp6", addr: "192.0.2.1:8037", want: "has no IPv6 address, only IPv4 addresses, but IPv6 is forced"},
+		{network: "tcp4", addr: "[2001:db8::1]:8037", want: "has no IPv4 address, only IPv6 addresses, but IPv4 is forced"},
+		{network: "tcp4", addr: "192.0.2.1:8037", want: "unreachable over IPv4"},
+		{network: "tcp6", addr: "[2001:db8::1]:8037", want: "unreachable over IPv6"},
+		{network: "tcp", addr: "192.0.2.1:8037", want: "unreachable over IPv4"},
+		{network: "tcp", addr: "[2001:db8::1]:8037", want: "unreachable over IPv6"},
+		{network: "tcp", addr: "192.0.2.1"},
+		{ctx: cancelled, network: "tcp", addr: "192.0.2.1:8037"},
+	} {
+		ctx := tt.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		d := &familyDialer{}
+		err := d.explain(ctx, tt.network, tt.addr, dialErr)
+		if tt.want == "" {
+			if err != dialErr {
+				t.Errorf("explain(%s, %s) = %v, want %v", tt.network, tt.addr, err, dialErr)
+			}
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) || !strings.Contains(err.Error(), dialErr.Error()) {
+			t.Errorf("explain(%s, %s) = %v, want error containing %q and %q", tt.network, tt.addr, err, tt.want, dialErr)
+		}
+	}
+}
+
+func TestBooteryClientForceIPFamily(t *testing.T) {
+	for _, tt := range []struct {
+		url     string
+		family  string
+		wantErr string
+	}{
+		{url: "http://192.0.2.1:8037/testboot", family: "4"},
+		{url: "http://[2001:db8::1]:8037/testboot", family: "6"},
+		{url: "http://bakery:8037/testboot", family: "6"},
+		{url: "http://192.0.2.1:8037/testboot", family: "6", wantErr: "contradicts ForceIPFamily 6"},
+		{url: "http://[2001:db8::1]:8037/testboot", family: "4", wantErr: "contradicts ForceIPFamily 4"},
+		{url: "http://bakery:8037/testboot", family: "5", wantErr: `unexpected value "5"`},
+	} {
+		_, err := booteryClient(Options{BooteryURL: tt.url, ForceIPFamily: tt.family})
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("booteryClient(%s, ForceIPFamily %s): %v", tt.url, tt.family, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("booteryClient(%s, ForceIPFamily %s): got error %v, want error containing %q", tt.url, tt.family, err, tt.wantErr)
+		}
+	}
+}